@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/fterrag/simple-sqsd/supervisor"
+	log "github.com/sirupsen/logrus"
+)
+
+// adminWorker is the status of a queue to worker mapping reported by the
+// admin server. Worker numbers start at 1, like in the effective
+// configuration log.
+type adminWorker struct {
+	Worker     int              `json:"worker"`
+	NumWorkers int              `json:"numWorkers"`
+	Stats      supervisor.Stats `json:"stats"`
+}
+
+// newAdminHandler serves the admin API, inspecting and controlling the
+// supervisors of the group returned by current. Every request must carry
+// token as a bearer token.
+//
+//	GET  /config            the effective configuration of every mapping
+//	GET  /stats             the status of every mapping
+//	POST /pause             stop receiving messages
+//	POST /resume            receive messages again
+//	POST /drain             pause, then wait for in-flight messages
+//	POST /workers?count=N   run N workers
+//
+// The POST endpoints apply to every mapping, or to the one selected with
+// ?worker=N, and respond with the status of the mappings like /stats.
+func newAdminHandler(current func() *supervisorGroup, token string) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeAdminJSON(w, http.StatusOK, current().configs)
+	})
+
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeAdminJSON(w, http.StatusOK, adminWorkers(current(), nil))
+	})
+
+	control := func(action func(w http.ResponseWriter, r *http.Request, s *supervisor.Supervisor) bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+
+			g := current()
+			selected, err := selectWorkers(g, r.URL.Query().Get("worker"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			for _, i := range selected {
+				if !action(w, r, g.supervisors[i]) {
+					return
+				}
+			}
+
+			writeAdminJSON(w, http.StatusOK, adminWorkers(g, selected))
+		}
+	}
+
+	mux.HandleFunc("/pause", control(func(w http.ResponseWriter, r *http.Request, s *supervisor.Supervisor) bool {
+		s.Pause()
+		return true
+	}))
+
+	mux.HandleFunc("/resume", control(func(w http.ResponseWriter, r *http.Request, s *supervisor.Supervisor) bool {
+		s.Resume()
+		return true
+	}))
+
+	mux.HandleFunc("/drain", control(func(w http.ResponseWriter, r *http.Request, s *supervisor.Supervisor) bool {
+		if err := s.Drain(r.Context()); err != nil {
+			http.Error(w, "Messages are still being processed", http.StatusServiceUnavailable)
+			return false
+		}
+
+		return true
+	}))
+
+	mux.HandleFunc("/workers", control(func(w http.ResponseWriter, r *http.Request, s *supervisor.Supervisor) bool {
+		count, err := strconv.Atoi(r.URL.Query().Get("count"))
+		if err != nil || count < 1 {
+			http.Error(w, "Invalid count: must be at least 1", http.StatusBadRequest)
+			return false
+		}
+
+		if err := s.SetWorkers(count); err != nil {
+			http.Error(w, fmt.Sprintf("Error while setting the number of workers: %s", err), http.StatusConflict)
+			return false
+		}
+
+		return true
+	}))
+
+	return adminAuth(mux, token)
+}
+
+// adminAuth rejects the requests to next that don't carry token as a bearer
+// token.
+func adminAuth(next http.Handler, token string) http.Handler {
+	expected := []byte("Bearer " + token)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// selectWorkers returns the indexes of the supervisors of g the worker query
+// parameter selects, all of them when it is empty.
+func selectWorkers(g *supervisorGroup, worker string) ([]int, error) {
+	if len(worker) == 0 {
+		selected := make([]int, len(g.supervisors))
+		for i := range selected {
+			selected[i] = i
+		}
+
+		return selected, nil
+	}
+
+	n, err := strconv.Atoi(worker)
+	if err != nil || n < 1 || n > len(g.supervisors) {
+		return nil, fmt.Errorf("Invalid worker %q: must be between 1 and %d", worker, len(g.supervisors))
+	}
+
+	return []int{n - 1}, nil
+}
+
+// adminWorkers returns the status of the supervisors of g at the indexes
+// selected, all of them when selected is nil.
+func adminWorkers(g *supervisorGroup, selected []int) []adminWorker {
+	if selected == nil {
+		selected, _ = selectWorkers(g, "")
+	}
+
+	workers := make([]adminWorker, 0, len(selected))
+	for _, i := range selected {
+		s := g.supervisors[i]
+		workers = append(workers, adminWorker{Worker: i + 1, NumWorkers: s.Workers(), Stats: s.Stats()})
+	}
+
+	return workers
+}
+
+func writeAdminJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Error while writing the admin response: %s", err)
+	}
+}
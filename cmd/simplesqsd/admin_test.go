@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fterrag/simple-sqsd/supervisor"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminHandler(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+
+	group := &supervisorGroup{}
+	for _, url := range []string{"https://queue.url/orders", "https://queue.url/emails"} {
+		s := supervisor.NewSupervisor(logger, idleSQS{}, &http.Client{}, supervisor.WorkerConfig{
+			QueueURL: url,
+			HTTPURL:  "http://worker",
+		})
+		group.add(s, 2)
+		group.configs = append(group.configs, map[string]string{"SQSD_QUEUE_URL": url, "SQSD_ADMIN_TOKEN": "(redacted)"})
+	}
+	group.Start()
+	defer group.Wait()
+	defer group.Shutdown()
+
+	ts := httptest.NewServer(newAdminHandler(func() *supervisorGroup { return group }, "secret"))
+	defer ts.Close()
+
+	do := func(method string, path string, token string, v interface{}) int {
+		req, _ := http.NewRequest(method, ts.URL+path, nil)
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			return 0
+		}
+		defer res.Body.Close()
+
+		if v != nil && res.StatusCode == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(res.Body).Decode(v))
+		}
+
+		return res.StatusCode
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/stats", "", nil))
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/stats", "wrong", nil))
+
+	var configs []map[string]string
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/config", "secret", &configs))
+	assert.Equal(t, group.configs, configs)
+
+	var workers []adminWorker
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/stats", "secret", &workers))
+	if assert.Len(t, workers, 2) {
+		assert.Equal(t, 2, workers[1].Worker)
+		assert.Equal(t, 2, workers[1].NumWorkers)
+		assert.Contains(t, workers[1].Stats.Queues, "https://queue.url/emails")
+	}
+
+	assert.Equal(t, http.StatusMethodNotAllowed, do(http.MethodGet, "/pause", "secret", nil))
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/pause?worker=3", "secret", nil))
+
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/pause?worker=2", "secret", &workers))
+	if assert.Len(t, workers, 1) {
+		assert.True(t, workers[0].Stats.Paused)
+	}
+	assert.False(t, group.supervisors[0].Paused())
+
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/drain", "secret", nil))
+	assert.True(t, group.supervisors[0].Paused())
+
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/resume", "secret", nil))
+	assert.False(t, group.supervisors[0].Paused())
+	assert.False(t, group.supervisors[1].Paused())
+
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/workers?count=0", "secret", nil))
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/workers?worker=1&count=3", "secret", &workers))
+	if assert.Len(t, workers, 1) {
+		assert.Equal(t, 3, workers[0].NumWorkers)
+	}
+	assert.Eventually(t, func() bool {
+		return group.supervisors[0].Stats().Workers == 3
+	}, time.Second, time.Millisecond)
+}
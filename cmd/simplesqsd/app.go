@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// appRestartBackoff is the wait before restarting the app after it
+	// exited, doubled for every exit in a row up to SQSD_APP_RESTART_MAX_BACKOFF.
+	appRestartBackoff = time.Second
+	// appStableRuntime is how long the app must run for its exit not to count
+	// as one in a row.
+	appStableRuntime = time.Minute
+	// appHealthInterval is the wait between checks of the health of the app.
+	appHealthInterval = 500 * time.Millisecond
+)
+
+// errAppStopped is returned when the app is started or waited for after it
+// was stopped.
+var errAppStopped = errors.New("app stopped")
+
+// appProcess runs the app configured by SQSD_APP_COMMAND, restarting it
+// whenever it exits until it is stopped.
+type appProcess struct {
+	command    string
+	maxBackoff time.Duration
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	stopping bool
+	// exited is closed when the current process exits.
+	exited chan struct{}
+
+	stopped chan struct{}
+	done    chan struct{}
+}
+
+// startApp starts command with /bin/sh, in its own process group so that
+// signals sent to the daemon's group don't reach it before in-flight messages
+// are processed.
+func startApp(command string, maxBackoff time.Duration) (*appProcess, error) {
+	a := &appProcess{
+		command:    command,
+		maxBackoff: maxBackoff,
+		stopped:    make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	if err := a.start(); err != nil {
+		return nil, err
+	}
+
+	go a.run()
+
+	return a, nil
+}
+
+// start starts a new process of the app, unless it is stopping.
+func (a *appProcess) start() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.stopping {
+		return errAppStopped
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", a.command)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	setAppProcessGroup(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Error while starting the app: %s", err)
+	}
+	log.WithField("pid", cmd.Process.Pid).Info("Started the app")
+
+	a.cmd = cmd
+	a.exited = make(chan struct{})
+
+	return nil
+}
+
+// run restarts the app every time it exits, with backoff, until it is
+// stopped.
+func (a *appProcess) run() {
+	defer close(a.done)
+
+	exits := 0
+	for {
+		a.mu.Lock()
+		cmd, exited := a.cmd, a.exited
+		a.mu.Unlock()
+
+		startedAt := time.Now()
+		err := cmd.Wait()
+		close(exited)
+
+		a.mu.Lock()
+		stopping := a.stopping
+		a.mu.Unlock()
+		if stopping {
+			log.Infof("The app exited: %s", exitStatus(err))
+			return
+		}
+
+		if time.Since(startedAt) >= appStableRuntime {
+			exits = 0
+		}
+		exits++
+
+		for {
+			backoff := a.restartDelay(exits)
+			log.Errorf("The app exited: %s, restarting it in %s", exitStatus(err), backoff)
+
+			select {
+			case <-a.stopped:
+				return
+			case <-time.After(backoff):
+			}
+
+			if err = a.start(); err == nil {
+				break
+			} else if err == errAppStopped {
+				return
+			}
+			exits++
+		}
+	}
+}
+
+// restartDelay is the wait before restarting the app after it exited exits
+// times in a row.
+func (a *appProcess) restartDelay(exits int) time.Duration {
+	if exits > 30 {
+		return a.maxBackoff
+	}
+
+	backoff := appRestartBackoff << uint(exits-1)
+	if backoff > a.maxBackoff {
+		return a.maxBackoff
+	}
+
+	return backoff
+}
+
+// waitHealthy waits for url to respond without a server error, for up to
+// timeout.
+func (a *appProcess) waitHealthy(url string, timeout time.Duration) error {
+	client := &http.Client{Timeout: appHealthInterval}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		res, err := client.Get(url)
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode < http.StatusInternalServerError {
+				return nil
+			}
+			err = fmt.Errorf("status %d", res.StatusCode)
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("The app was not healthy at %s within %s: %s", url, timeout, err)
+		}
+
+		select {
+		case <-a.stopped:
+			return errAppStopped
+		case <-time.After(appHealthInterval):
+		}
+	}
+}
+
+// stop sends SIGTERM to the app and waits for it to exit, killing it after
+// timeout. The app is not restarted anymore.
+func (a *appProcess) stop(timeout time.Duration) {
+	a.mu.Lock()
+	if a.stopping {
+		a.mu.Unlock()
+		<-a.done
+		return
+	}
+	a.stopping = true
+	cmd, exited := a.cmd, a.exited
+	a.mu.Unlock()
+	close(a.stopped)
+
+	select {
+	case <-exited:
+	default:
+		log.Info("Stopping the app")
+		if err := terminateApp(cmd); err != nil {
+			log.Errorf("Error while stopping the app: %s", err)
+		}
+
+		select {
+		case <-exited:
+		case <-time.After(timeout):
+			log.Warnf("The app did not exit within %s, killing it", timeout)
+			killApp(cmd)
+		}
+	}
+
+	<-a.done
+}
+
+// exitStatus describes the error returned by waiting for a process.
+func exitStatus(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+
+	return err.Error()
+}
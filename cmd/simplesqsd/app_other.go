@@ -0,0 +1,18 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// setAppProcessGroup leaves cmd as it is: only the shell is signaled.
+func setAppProcessGroup(cmd *exec.Cmd) {}
+
+// terminateApp kills cmd, which can't be sent SIGTERM.
+func terminateApp(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// killApp kills cmd.
+func killApp(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
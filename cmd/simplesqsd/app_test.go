@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppRestartDelay(t *testing.T) {
+	a := &appProcess{maxBackoff: 5 * time.Second}
+
+	assert.Equal(t, time.Second, a.restartDelay(1))
+	assert.Equal(t, 4*time.Second, a.restartDelay(3))
+	assert.Equal(t, 5*time.Second, a.restartDelay(4))
+	assert.Equal(t, 5*time.Second, a.restartDelay(100))
+}
+
+func TestAppRestartsAndStops(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	out := filepath.Join(t.TempDir(), "out")
+	t.Setenv("APP_TEST_OUT", out)
+
+	// The app crashes on its first run and waits for SIGTERM on the next.
+	app, err := startApp(`if [ -f "$APP_TEST_OUT" ]; then
+		trap 'echo terminated >> "$APP_TEST_OUT"; exit 0' TERM
+		echo restarted >> "$APP_TEST_OUT"
+		while :; do sleep 0.05; done
+	fi
+	echo crashed >> "$APP_TEST_OUT"
+	exit 1`, 10*time.Millisecond)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Eventually(t, func() bool {
+		b, _ := os.ReadFile(out)
+		return strings.Contains(string(b), "restarted")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	app.stop(5 * time.Second)
+
+	b, _ := os.ReadFile(out)
+	assert.Equal(t, "crashed\nrestarted\nterminated\n", string(b))
+
+	// Stopping again waits for nothing.
+	app.stop(time.Second)
+}
+
+func TestAppKilledAfterStopTimeout(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	app, err := startApp(`trap '' TERM; while :; do sleep 0.05; done`, time.Second)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	start := time.Now()
+	app.stop(100 * time.Millisecond)
+	assert.True(t, time.Since(start) < 2*time.Second)
+}
+
+func TestAppWaitHealthy(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	app, err := startApp(`while :; do sleep 0.05; done`, time.Second)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer app.stop(time.Second)
+
+	assert.NoError(t, app.waitHealthy(ts.URL+"/health", 5*time.Second))
+	assert.Equal(t, 3, requests)
+
+	err = app.waitHealthy("http://127.0.0.1:1/health", 0)
+	assert.Contains(t, err.Error(), "was not healthy")
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setAppProcessGroup runs cmd in its own process group, so that processes
+// started by the shell are signaled along with it.
+func setAppProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminateApp sends SIGTERM to the process group of cmd.
+func terminateApp(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+}
+
+// killApp kills the process group of cmd.
+func killApp(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
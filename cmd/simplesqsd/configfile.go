@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFile holds the variables set by the file named by SQSD_CONFIG_FILE.
+type configFile struct {
+	values map[string]string
+	// workers holds the variables of every entry of SQSD_WORKERS, which
+	// override the other values of the file for that worker.
+	workers []map[string]string
+}
+
+// newConfigEnvs returns the envs of the supervisors to run, reading variables
+// from lookup and, for those lookup doesn't have, from the file named by
+// SQSD_CONFIG_FILE if it is set. A file listing SQSD_WORKERS yields one env
+// per worker, whose own variables take precedence over lookup. So do indexed
+// variables, see indexedWorkers.
+func newConfigEnvs(lookup func(string) (string, bool)) []*env {
+	e := newEnv(lookup)
+
+	shared := lookup
+	var file *configFile
+	if path := e.get("SQSD_CONFIG_FILE"); len(path) > 0 {
+		var err error
+		file, err = loadConfigFile(path)
+		if err != nil {
+			e.invalid("SQSD_CONFIG_FILE", err.Error())
+			return []*env{e}
+		}
+
+		shared = overlayLookup(lookup, mapLookup(file.values))
+	}
+
+	if n := indexedWorkers(shared); n > 0 {
+		if file != nil && len(file.workers) > 0 {
+			e.lookup = shared
+			e.get(indexedKey(1, "SQSD_QUEUE_URL"))
+			e.invalid(indexedKey(1, "SQSD_QUEUE_URL"), "must not be used with SQSD_WORKERS")
+			return []*env{e}
+		}
+
+		envs := make([]*env, 0, n)
+		for i := 1; i <= n; i++ {
+			we := newEnv(overlayLookup(indexedLookup(shared, i), shared))
+			we.get("SQSD_CONFIG_FILE")
+
+			envs = append(envs, we)
+		}
+
+		return envs
+	}
+
+	if file == nil {
+		return []*env{e}
+	}
+
+	if len(file.workers) == 0 {
+		e.lookup = shared
+		return []*env{e}
+	}
+
+	envs := make([]*env, 0, len(file.workers))
+	for _, worker := range file.workers {
+		we := newEnv(overlayLookup(mapLookup(worker), shared))
+		we.get("SQSD_CONFIG_FILE")
+
+		envs = append(envs, we)
+	}
+
+	return envs
+}
+
+// indexedKey returns the variable setting key for the indexed worker i, e.g.
+// SQSD_WORKER_1_QUEUE_URL for SQSD_QUEUE_URL.
+func indexedKey(i int, key string) string {
+	return fmt.Sprintf("SQSD_WORKER_%d_%s", i, strings.TrimPrefix(key, "SQSD_"))
+}
+
+// indexedWorkers returns how many indexed workers lookup configures. They are
+// numbered from 1, and worker i exists while SQSD_WORKER_<i>_QUEUE_URL or
+// SQSD_WORKER_<i>_QUEUES is set.
+func indexedWorkers(lookup func(string) (string, bool)) int {
+	n := 0
+	for {
+		_, url := lookup(indexedKey(n+1, "SQSD_QUEUE_URL"))
+		_, queues := lookup(indexedKey(n+1, "SQSD_QUEUES"))
+		if !url && !queues {
+			return n
+		}
+
+		n++
+	}
+}
+
+// indexedLookup returns a lookup that reads every variable of the indexed
+// worker i from its indexed key.
+func indexedLookup(lookup func(string) (string, bool), i int) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		return lookup(indexedKey(i, key))
+	}
+}
+
+func mapLookup(values map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := values[key]
+		return v, ok
+	}
+}
+
+// overlayLookup returns a lookup that reads from first, falling back to
+// second for variables first doesn't have.
+func overlayLookup(first, second func(string) (string, bool)) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		if v, ok := first(key); ok {
+			return v, true
+		}
+
+		return second(key)
+	}
+}
+
+// loadConfigFile reads a YAML or JSON object whose keys are variable names,
+// e.g. SQSD_QUEUE_URL. Scalar values are used as they are written; lists and
+// objects, such as the queues of SQSD_QUEUES, are turned into JSON.
+// SQSD_WORKERS is a list of such objects, one per worker.
+func loadConfigFile(path string) (*configFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	file := &configFile{}
+
+	if workers, ok := raw["SQSD_WORKERS"]; ok {
+		delete(raw, "SQSD_WORKERS")
+
+		list, ok := workers.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("SQSD_WORKERS must be a list")
+		}
+
+		for i, worker := range list {
+			values, ok := worker.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("worker %d must be an object", i)
+			}
+
+			vars, err := configValues(values)
+			if err != nil {
+				return nil, fmt.Errorf("worker %d: %s", i, err)
+			}
+
+			file.workers = append(file.workers, vars)
+		}
+	}
+
+	file.values, err = configValues(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func configValues(raw map[string]interface{}) (map[string]string, error) {
+	values := make(map[string]string, len(raw))
+
+	for key, value := range raw {
+		switch v := value.(type) {
+		case nil:
+		case string:
+			values[key] = v
+		case []interface{}, map[string]interface{}:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err)
+			}
+			values[key] = string(b)
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+
+	return values, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, name string, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, name)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+
+	return path
+}
+
+func TestConfigFileYAML(t *testing.T) {
+	path := writeConfigFile(t, "sqsd.yaml", `
+SQSD_HTTP_URL: http://file:8080
+SQSD_HTTP_MAX_CONNS: 25
+SQSD_FIFO: true
+SQSD_QUEUES:
+  - url: https://sqs.us-east-1.amazonaws.com/123456789012/orders
+    httpUrl: http://orders/
+`)
+
+	vars := map[string]string{
+		"SQSD_CONFIG_FILE":    path,
+		"SQSD_HTTP_MAX_CONNS": "50",
+	}
+	env := newConfigEnvs(func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	})[0]
+
+	c := loadConfig(env)
+	assert.False(t, env.failed())
+	assert.Equal(t, "http://file:8080", c.HTTPURL)
+	assert.Equal(t, 50, c.HTTPMaxConns)
+	assert.True(t, c.FIFO)
+	assert.Equal(t, 10, c.QueueMaxMessages)
+	if assert.Len(t, c.Queues, 1) {
+		assert.Equal(t, "http://orders/", c.Queues[0].HTTPURL)
+	}
+}
+
+func TestConfigFileJSON(t *testing.T) {
+	path := writeConfigFile(t, "sqsd.json", `{
+		"SQSD_QUEUE_URL": "https://sqs.us-east-1.amazonaws.com/123456789012/queue",
+		"SQSD_HTTP_URL": "http://file:8080",
+		"SQSD_QUEUE_MAX_MSGS": 5
+	}`)
+
+	env := newConfigEnvs(func(key string) (string, bool) {
+		if key == "SQSD_CONFIG_FILE" {
+			return path, true
+		}
+		return "", false
+	})[0]
+
+	c := loadConfig(env)
+	assert.False(t, env.failed())
+	assert.Equal(t, "us-east-1", c.QueueRegion)
+	assert.Equal(t, 5, c.QueueMaxMessages)
+}
+
+func TestConfigFileInvalid(t *testing.T) {
+	for _, path := range []string{
+		filepath.Join(os.TempDir(), "does-not-exist.yaml"),
+		writeConfigFile(t, "invalid.yaml", "SQSD_HTTP_URL: [unterminated"),
+	} {
+		env := newConfigEnvs(func(key string) (string, bool) {
+			if key == "SQSD_CONFIG_FILE" {
+				return path, true
+			}
+			return "", false
+		})[0]
+
+		loadConfig(env)
+		assert.Contains(t, env.problems["SQSD_CONFIG_FILE"], "invalid", path)
+	}
+}
+
+func TestConfigFileWorkers(t *testing.T) {
+	path := writeConfigFile(t, "sqsd.yaml", `
+SQSD_QUEUE_REGION: us-east-1
+SQSD_HTTP_MAX_CONNS: 5
+SQSD_WORKERS:
+  - SQSD_QUEUE_URL: https://sqs.us-east-1.amazonaws.com/123456789012/orders
+    SQSD_HTTP_URL: http://orders/
+  - SQSD_QUEUE_URL: https://sqs.us-east-1.amazonaws.com/123456789012/emails
+    SQSD_HTTP_URL: http://emails/
+    SQSD_HTTP_MAX_CONNS: 2
+`)
+
+	vars := map[string]string{
+		"SQSD_CONFIG_FILE": path,
+		"SQSD_HTTP_URL":    "http://env/",
+		"SQSD_FIFO":        "true",
+	}
+	envs := newConfigEnvs(func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	})
+
+	if !assert.Len(t, envs, 2) {
+		return
+	}
+
+	orders := loadConfig(envs[0])
+	assert.False(t, envs[0].failed())
+	assert.Equal(t, "https://sqs.us-east-1.amazonaws.com/123456789012/orders", orders.QueueURL)
+	assert.Equal(t, "http://orders/", orders.HTTPURL)
+	assert.Equal(t, 5, orders.HTTPMaxConns)
+	assert.True(t, orders.FIFO)
+
+	emails := loadConfig(envs[1])
+	assert.False(t, envs[1].failed())
+	assert.Equal(t, "http://emails/", emails.HTTPURL)
+	assert.Equal(t, 2, emails.HTTPMaxConns)
+}
+
+func TestConfigFileWorkersInvalid(t *testing.T) {
+	for _, content := range []string{
+		"SQSD_WORKERS: orders",
+		"SQSD_WORKERS:\n  - orders",
+	} {
+		path := writeConfigFile(t, "sqsd.yaml", content)
+
+		envs := newConfigEnvs(func(key string) (string, bool) {
+			if key == "SQSD_CONFIG_FILE" {
+				return path, true
+			}
+			return "", false
+		})
+
+		if assert.Len(t, envs, 1) {
+			assert.Contains(t, envs[0].problems["SQSD_CONFIG_FILE"], "invalid", content)
+		}
+	}
+}
+
+func TestConfigIndexedWorkers(t *testing.T) {
+	vars := map[string]string{
+		"SQSD_QUEUE_REGION":            "us-east-1",
+		"SQSD_HTTP_URL":                "http://env/",
+		"SQSD_HTTP_MAX_CONNS":          "5",
+		"SQSD_WORKER_1_QUEUE_URL":      "https://sqs.us-east-1.amazonaws.com/123456789012/orders",
+		"SQSD_WORKER_1_HTTP_URL":       "http://orders/",
+		"SQSD_WORKER_2_QUEUE_URL":      "https://sqs.us-east-1.amazonaws.com/123456789012/emails",
+		"SQSD_WORKER_2_HTTP_MAX_CONNS": "2",
+		"SQSD_WORKER_4_QUEUE_URL":      "https://sqs.us-east-1.amazonaws.com/123456789012/ignored",
+	}
+	envs := newConfigEnvs(func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	})
+
+	if !assert.Len(t, envs, 2) {
+		return
+	}
+
+	orders := loadConfig(envs[0])
+	assert.False(t, envs[0].failed())
+	assert.Equal(t, "https://sqs.us-east-1.amazonaws.com/123456789012/orders", orders.QueueURL)
+	assert.Equal(t, "http://orders/", orders.HTTPURL)
+	assert.Equal(t, 5, orders.HTTPMaxConns)
+
+	emails := loadConfig(envs[1])
+	assert.False(t, envs[1].failed())
+	assert.Equal(t, "http://env/", emails.HTTPURL)
+	assert.Equal(t, 2, emails.HTTPMaxConns)
+}
+
+func TestConfigIndexedWorkersWithFileWorkers(t *testing.T) {
+	path := writeConfigFile(t, "sqsd.yaml", `
+SQSD_WORKERS:
+  - SQSD_QUEUE_URL: https://sqs.us-east-1.amazonaws.com/123456789012/orders
+`)
+
+	vars := map[string]string{
+		"SQSD_CONFIG_FILE":        path,
+		"SQSD_WORKER_1_QUEUE_URL": "https://sqs.us-east-1.amazonaws.com/123456789012/emails",
+	}
+	envs := newConfigEnvs(func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	})
+
+	if assert.Len(t, envs, 1) {
+		assert.Contains(t, envs[0].problems["SQSD_WORKER_1_QUEUE_URL"], "SQSD_WORKERS")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	log "github.com/sirupsen/logrus"
+)
+
+// credentialsExpiryWindow is how long before they expire the credentials of
+// an assumed role are refreshed, so that requests never use expired ones.
+const credentialsExpiryWindow = time.Minute
+
+// staticCredentials returns a copy of cfg using the given access keys. An
+// empty sessionToken is not sent.
+func staticCredentials(cfg aws.Config, accessKeyID string, secretAccessKey string, sessionToken string) aws.Config {
+	cfg = cfg.Copy()
+	cfg.Credentials = credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, sessionToken)
+
+	return cfg
+}
+
+// assumeRole returns a copy of cfg whose credentials are those of roleARN,
+// assumed through STS in region with the credentials of cfg. An empty
+// externalID is not sent.
+func assumeRole(cfg aws.Config, region string, roleARN string, externalID string) aws.Config {
+	stsSvc := sts.NewFromConfig(cfg, func(o *sts.Options) {
+		o.Region = region
+	})
+
+	provider := stscreds.NewAssumeRoleProvider(stsSvc, roleARN, func(o *stscreds.AssumeRoleOptions) {
+		if len(externalID) > 0 {
+			o.ExternalID = aws.String(externalID)
+		}
+	})
+
+	cfg = cfg.Copy()
+	cfg.Credentials = aws.NewCredentialsCache(provider, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = credentialsExpiryWindow
+	})
+
+	return cfg
+}
+
+// assumeRoleWithWebIdentity returns a copy of cfg whose credentials are
+// those of roleARN, assumed through STS in region with the web identity token
+// in tokenFile, such as an EKS service account token. The file is read again
+// every time the role is assumed, picking up rotated tokens.
+func assumeRoleWithWebIdentity(cfg aws.Config, region string, roleARN string, tokenFile string) aws.Config {
+	stsSvc := sts.NewFromConfig(cfg, func(o *sts.Options) {
+		o.Region = region
+	})
+
+	provider := stscreds.NewWebIdentityRoleProvider(stsSvc, roleARN, stscreds.IdentityTokenFile(tokenFile))
+
+	cfg = cfg.Copy()
+	cfg.Credentials = aws.NewCredentialsCache(provider, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = credentialsExpiryWindow
+	})
+
+	return cfg
+}
+
+// credentialsInvalidator is implemented by *aws.CredentialsCache.
+type credentialsInvalidator interface {
+	Invalidate()
+}
+
+// expireCredentials forces creds to be refreshed every interval until done is
+// closed. This works around kube2iam serving credentials that are rotated
+// before the expiry time they advertise.
+func expireCredentials(creds credentialsInvalidator, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			log.Debug("Expiring AWS credentials")
+			creds.Invalidate()
+		}
+	}
+}
+
+// expireCachedCredentials runs expireCredentials on the credentials of cfg
+// when they are cached, static credentials never expiring.
+func expireCachedCredentials(cfg aws.Config, interval time.Duration, done <-chan struct{}) {
+	if cache, ok := cfg.Credentials.(*aws.CredentialsCache); ok {
+		go expireCredentials(cache, interval, done)
+	}
+}
+
+// newAWSConfig returns the configuration AWS clients are created with, with
+// the credentials configured by c. Credentials are expired every
+// SQSD_CRED_EXPIRE_INTERVAL until done is closed.
+func newAWSConfig(c *config, done <-chan struct{}) (aws.Config, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(), awsconfig.WithRegion(c.QueueRegion))
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	if len(c.AWSAccessKeyID) > 0 {
+		cfg = staticCredentials(cfg, c.AWSAccessKeyID, c.AWSSecretAccessKey, c.AWSSessionToken)
+	}
+
+	if c.CredExpireInterval > 0 {
+		expireCachedCredentials(cfg, time.Duration(c.CredExpireInterval)*time.Second, done)
+	}
+
+	if len(c.WebIdentityTokenFile) > 0 {
+		cfg = assumeRoleWithWebIdentity(cfg, c.QueueRegion, c.AssumeRoleARN, c.WebIdentityTokenFile)
+	} else if len(c.AssumeRoleARN) > 0 {
+		cfg = assumeRole(cfg, c.QueueRegion, c.AssumeRoleARN, c.AssumeRoleExtID)
+
+		// The assumed role is refreshed along with the credentials it was
+		// assumed with.
+		if c.CredExpireInterval > 0 {
+			expireCachedCredentials(cfg, time.Duration(c.CredExpireInterval)*time.Second, done)
+		}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/stretchr/testify/assert"
+)
+
+type countingExpirer struct {
+	count int32
+}
+
+func (e *countingExpirer) Invalidate() {
+	atomic.AddInt32(&e.count, 1)
+}
+
+func TestExpireCredentials(t *testing.T) {
+	creds := &countingExpirer{}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		expireCredentials(creds, 20*time.Millisecond, done)
+	}()
+
+	time.Sleep(110 * time.Millisecond)
+	close(done)
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("expireCredentials did not stop")
+	}
+
+	count := atomic.LoadInt32(&creds.count)
+	assert.True(t, count >= 3 && count <= 6, "expired %d times", count)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, count, atomic.LoadInt32(&creds.count))
+}
+
+func TestAssumeRole(t *testing.T) {
+	var form url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+
+		fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASSUMED</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	defer ts.Close()
+
+	cfg := aws.Config{
+		BaseEndpoint: aws.String(ts.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("BASE", "secret", ""),
+	}
+
+	assumed := assumeRole(cfg, "us-east-1", "arn:aws:iam::123456789012:role/sqsd", "external")
+
+	value, err := assumed.Credentials.Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "ASSUMED", value.AccessKeyID)
+	assert.Equal(t, "AssumeRole", form.Get("Action"))
+	assert.Equal(t, "arn:aws:iam::123456789012:role/sqsd", form.Get("RoleArn"))
+	assert.Equal(t, "external", form.Get("ExternalId"))
+
+	value, err = cfg.Credentials.Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "BASE", value.AccessKeyID)
+}
+
+func TestAssumeRoleWithWebIdentity(t *testing.T) {
+	var form url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+
+		fmt.Fprintf(w, `<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>WEBIDENTITY</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	defer ts.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("header.payload.signature"), 0600))
+
+	cfg := staticCredentials(aws.Config{BaseEndpoint: aws.String(ts.URL)}, "STATIC", "secret", "")
+
+	value, err := cfg.Credentials.Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "STATIC", value.AccessKeyID)
+
+	assumed := assumeRoleWithWebIdentity(cfg, "us-east-1", "arn:aws:iam::123456789012:role/sqsd", tokenFile)
+
+	value, err = assumed.Credentials.Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "WEBIDENTITY", value.AccessKeyID)
+	assert.Equal(t, "AssumeRoleWithWebIdentity", form.Get("Action"))
+	assert.Equal(t, "arn:aws:iam::123456789012:role/sqsd", form.Get("RoleArn"))
+	assert.Equal(t, "header.payload.signature", form.Get("WebIdentityToken"))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// devCredential is the access key ID and secret access key used with
+	// SQSD_DEV against SQSD_AWS_ENDPOINT when no credentials are configured.
+	devCredential = "test"
+	// devRegion is the region used with SQSD_DEV when none is configured nor
+	// found in the queue URL.
+	devRegion = "us-east-1"
+	// devMaxReceiveCount is how many times a message of a queue created by
+	// SQSD_CREATE_QUEUE is received before it moves to its dead-letter queue.
+	devMaxReceiveCount = 5
+)
+
+// queueCreator is the part of the SQS API creating queues.
+type queueCreator interface {
+	CreateQueue(ctx context.Context, input *sqs.CreateQueueInput, optFns ...func(*sqs.Options)) (*sqs.CreateQueueOutput, error)
+	GetQueueAttributes(ctx context.Context, input *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error)
+}
+
+// devQueueNames returns the names of the queues configured by configs, by
+// name or by URL.
+func devQueueNames(configs []*config) []string {
+	seen := make(map[string]bool)
+	for _, c := range configs {
+		for _, name := range c.QueueNames {
+			seen[name] = true
+		}
+		for _, queueURL := range c.QueueURLs {
+			if u, err := url.Parse(queueURL); err == nil && len(path.Base(u.Path)) > 1 {
+				seen[path.Base(u.Path)] = true
+			}
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// createDevQueues creates the queues configured by configs, each with a
+// dead-letter queue named after it with a -dlq suffix. Queues that already
+// exist are left as they are.
+func createDevQueues(ctx context.Context, client queueCreator, configs []*config) error {
+	for _, name := range devQueueNames(configs) {
+		if err := createDevQueue(ctx, client, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func createDevQueue(ctx context.Context, client queueCreator, name string) error {
+	logger := log.WithField("queue", name)
+
+	var attributes map[string]string
+	dlqName := name + "-dlq"
+	if strings.HasSuffix(name, ".fifo") {
+		attributes = map[string]string{string(types.QueueAttributeNameFifoQueue): "true"}
+		dlqName = strings.TrimSuffix(name, ".fifo") + "-dlq.fifo"
+	}
+
+	dlq, err := client.CreateQueue(ctx, &sqs.CreateQueueInput{QueueName: aws.String(dlqName), Attributes: attributes})
+	if err != nil {
+		return err
+	}
+
+	output, err := client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       dlq.QueueUrl,
+		AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameQueueArn},
+	})
+	if err != nil {
+		return err
+	}
+
+	redrivePolicy, err := json.Marshal(map[string]string{
+		"deadLetterTargetArn": output.Attributes[string(types.QueueAttributeNameQueueArn)],
+		"maxReceiveCount":     strconv.Itoa(devMaxReceiveCount),
+	})
+	if err != nil {
+		return err
+	}
+
+	queueAttributes := map[string]string{string(types.QueueAttributeNameRedrivePolicy): string(redrivePolicy)}
+	for key, value := range attributes {
+		queueAttributes[key] = value
+	}
+
+	_, err = client.CreateQueue(ctx, &sqs.CreateQueueInput{QueueName: aws.String(name), Attributes: queueAttributes})
+	var exists *types.QueueNameExists
+	if errors.As(err, &exists) {
+		logger.Info("Queue already exists with other attributes, leaving it as it is")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	logger.WithField("deadLetterQueue", dlqName).Info("Created queue")
+
+	return nil
+}
+
+// echoWorkerURL returns the URL of the echo worker listening on addr.
+func echoWorkerURL(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+
+	return "http://" + addr + "/"
+}
+
+// newEchoWorkerHandler is a worker for SQSD_DEV that logs every message it
+// receives and accepts it.
+func newEchoWorkerHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		log.WithFields(log.Fields{
+			"messageId": r.Header.Get("X-Aws-Sqsd-Msgid"),
+			"path":      r.URL.Path,
+		}).Infof("Echo worker received: %s", body)
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeQueueCreator struct {
+	created []*sqs.CreateQueueInput
+	exists  map[string]bool
+}
+
+func (f *fakeQueueCreator) CreateQueue(ctx context.Context, input *sqs.CreateQueueInput, optFns ...func(*sqs.Options)) (*sqs.CreateQueueOutput, error) {
+	f.created = append(f.created, input)
+	if f.exists[aws.ToString(input.QueueName)] {
+		return nil, &types.QueueNameExists{Message: aws.String("queue exists")}
+	}
+
+	return &sqs.CreateQueueOutput{QueueUrl: aws.String("http://localhost:4566/000000000000/" + aws.ToString(input.QueueName))}, nil
+}
+
+func (f *fakeQueueCreator) GetQueueAttributes(ctx context.Context, input *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	name := aws.ToString(input.QueueUrl)[strings.LastIndex(aws.ToString(input.QueueUrl), "/")+1:]
+	return &sqs.GetQueueAttributesOutput{Attributes: map[string]string{
+		string(types.QueueAttributeNameQueueArn): "arn:aws:sqs:us-east-1:000000000000:" + name,
+	}}, nil
+}
+
+func TestDevQueueNames(t *testing.T) {
+	configs := []*config{
+		{QueueURLs: []string{"http://localhost:4566/000000000000/orders", "http://localhost:4566"}},
+		{QueueNames: []string{"events.fifo", "orders"}},
+	}
+
+	assert.Equal(t, []string{"events.fifo", "orders"}, devQueueNames(configs))
+}
+
+func TestCreateDevQueues(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	client := &fakeQueueCreator{exists: map[string]bool{"existing": true}}
+
+	err := createDevQueues(context.Background(), client, []*config{{QueueNames: []string{"orders", "events.fifo", "existing"}}})
+	assert.NoError(t, err)
+
+	if assert.Len(t, client.created, 6) {
+		// Queues are created in name order, each after its dead-letter queue.
+		assert.Equal(t, "events-dlq.fifo", aws.ToString(client.created[0].QueueName))
+		assert.Equal(t, "true", client.created[0].Attributes[string(types.QueueAttributeNameFifoQueue)])
+		assert.Equal(t, "events.fifo", aws.ToString(client.created[1].QueueName))
+		assert.Equal(t, "true", client.created[1].Attributes[string(types.QueueAttributeNameFifoQueue)])
+		assert.JSONEq(t,
+			`{"deadLetterTargetArn": "arn:aws:sqs:us-east-1:000000000000:events-dlq.fifo", "maxReceiveCount": "5"}`,
+			client.created[1].Attributes[string(types.QueueAttributeNameRedrivePolicy)])
+
+		assert.Equal(t, "orders-dlq", aws.ToString(client.created[4].QueueName))
+		assert.Nil(t, client.created[4].Attributes)
+		assert.Equal(t, "orders", aws.ToString(client.created[5].QueueName))
+		assert.NotContains(t, client.created[5].Attributes, string(types.QueueAttributeNameFifoQueue))
+	}
+}
+
+type failingQueueCreator struct {
+	fakeQueueCreator
+}
+
+func (f *failingQueueCreator) GetQueueAttributes(ctx context.Context, input *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	return nil, errors.New("access denied")
+}
+
+func TestCreateDevQueuesError(t *testing.T) {
+	client := &failingQueueCreator{}
+
+	err := createDevQueues(context.Background(), client, []*config{{QueueNames: []string{"orders"}}})
+	assert.EqualError(t, err, "access denied")
+	assert.Len(t, client.created, 1)
+}
+
+func TestEchoWorker(t *testing.T) {
+	assert.Equal(t, "http://localhost:8081/", echoWorkerURL(":8081"))
+	assert.Equal(t, "http://0.0.0.0:8081/", echoWorkerURL("0.0.0.0:8081"))
+
+	log.SetOutput(ioutil.Discard)
+	ts := httptest.NewServer(newEchoWorkerHandler())
+	defer ts.Close()
+
+	res, err := http.Post(ts.URL+"/jobs", "application/json", strings.NewReader(`{"id": 1}`))
+	if assert.NoError(t, err) {
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// queueFinder is the part of the SQS API queues are discovered with.
+type queueFinder interface {
+	GetQueueUrl(ctx context.Context, input *sqs.GetQueueUrlInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error)
+	ListQueues(ctx context.Context, input *sqs.ListQueuesInput, optFns ...func(*sqs.Options)) (*sqs.ListQueuesOutput, error)
+	ListQueueTags(ctx context.Context, input *sqs.ListQueueTagsInput, optFns ...func(*sqs.Options)) (*sqs.ListQueueTagsOutput, error)
+}
+
+// discoversQueues reports whether the queues of c are found by name or by
+// tags rather than set by URL.
+func (c *config) discoversQueues() bool {
+	return len(c.QueueNames) > 0 || len(c.QueueTagFilter) > 0
+}
+
+// parseTagFilter parses tags separated by commas, each a key and a value
+// separated by the first equals sign, e.g. "team=payments,env=prod".
+func parseTagFilter(value string) (map[string]string, error) {
+	tags := make(map[string]string)
+
+	for _, entry := range strings.Split(value, ",") {
+		if len(strings.TrimSpace(entry)) == 0 {
+			continue
+		}
+
+		i := strings.Index(entry, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("tag %q must be of the form key=value", strings.TrimSpace(entry))
+		}
+
+		key := strings.TrimSpace(entry[:i])
+		if len(key) == 0 {
+			return nil, fmt.Errorf("tag %q has no key", strings.TrimSpace(entry))
+		}
+
+		tags[key] = strings.TrimSpace(entry[i+1:])
+	}
+
+	return tags, nil
+}
+
+// discoverQueueURLs returns the sorted URLs of the queues named QueueNames,
+// or of the queues whose name starts with QueueNamePrefix and that carry
+// every tag of QueueTagFilter.
+func discoverQueueURLs(ctx context.Context, client queueFinder, c *config) ([]string, error) {
+	var urls []string
+
+	for _, name := range c.QueueNames {
+		output, err := client.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{QueueName: aws.String(name)})
+		if err != nil {
+			return nil, fmt.Errorf("Error while getting the URL of queue %s: %s", name, err)
+		}
+
+		urls = append(urls, aws.ToString(output.QueueUrl))
+	}
+
+	if len(c.QueueTagFilter) > 0 {
+		input := &sqs.ListQueuesInput{}
+		if len(c.QueueNamePrefix) > 0 {
+			input.QueueNamePrefix = aws.String(c.QueueNamePrefix)
+		}
+
+		var candidates []string
+		paginator := sqs.NewListQueuesPaginator(client, input)
+		for paginator.HasMorePages() {
+			output, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("Error while listing queues: %s", err)
+			}
+
+			candidates = append(candidates, output.QueueUrls...)
+		}
+
+		for _, url := range candidates {
+			output, err := client.ListQueueTags(ctx, &sqs.ListQueueTagsInput{QueueUrl: aws.String(url)})
+			if err != nil {
+				return nil, fmt.Errorf("Error while listing the tags of queue %s: %s", url, err)
+			}
+
+			if hasTags(output.Tags, c.QueueTagFilter) {
+				urls = append(urls, url)
+			}
+		}
+	}
+
+	sort.Strings(urls)
+
+	return urls, nil
+}
+
+// hasTags reports whether tags holds every key of filter with its value.
+func hasTags(tags map[string]string, filter map[string]string) bool {
+	for key, value := range filter {
+		if v, ok := tags[key]; !ok || v != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// queueAttributesGetter is the part of the SQS API queue attributes are read
+// with.
+type queueAttributesGetter interface {
+	GetQueueAttributes(ctx context.Context, input *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error)
+}
+
+// queueVisibilityTimeouts returns the default visibility timeouts of the
+// queues at urls. Queues whose attributes can't be read are left out, their
+// messages are then processed without regard to their visibility.
+func queueVisibilityTimeouts(client queueAttributesGetter, urls []string) map[string]time.Duration {
+	timeouts := make(map[string]time.Duration, len(urls))
+
+	for _, url := range urls {
+		output, err := client.GetQueueAttributes(context.Background(), &sqs.GetQueueAttributesInput{
+			QueueUrl:       aws.String(url),
+			AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameVisibilityTimeout},
+		})
+		if err != nil {
+			log.WithField("queueUrl", url).Warnf("Error while reading the visibility timeout of the queue: %s", err)
+			continue
+		}
+
+		seconds, err := strconv.Atoi(output.Attributes[string(types.QueueAttributeNameVisibilityTimeout)])
+		if err != nil {
+			log.WithField("queueUrl", url).Warnf("Invalid visibility timeout for the queue: %s", err)
+			continue
+		}
+
+		timeouts[url] = time.Duration(seconds) * time.Second
+	}
+
+	return timeouts
+}
+
+// resolveQueueURLs sets the queue URLs of the configs that discover their
+// queues, and returns all of them.
+func resolveQueueURLs(awsCfg aws.Config, configs []*config) ([]string, error) {
+	var all []string
+
+	for _, c := range configs {
+		if !c.discoversQueues() {
+			continue
+		}
+
+		client := sqs.NewFromConfig(awsCfg, sqsOptions(c, log.NewEntry(log.StandardLogger())))
+		urls, err := discoverQueueURLs(context.Background(), client, c)
+		if err != nil {
+			return nil, err
+		}
+		if len(urls) == 0 {
+			return nil, fmt.Errorf("No queue matches SQSD_QUEUE_TAG_FILTER %s", c.QueueTagFilterValue)
+		}
+
+		c.QueueURLs = urls
+		c.QueueURL = strings.Join(urls, ",")
+		log.WithField("queues", c.QueueURL).Debug("Discovered queues")
+		all = append(all, urls...)
+	}
+
+	return all, nil
+}
+
+// watchQueueURLs calls discover every interval until done is closed, and
+// reload when the URLs it returns differ from the last ones, starting from
+// current. Discovery errors are logged and the queues are kept.
+func watchQueueURLs(discover func() ([]string, error), reload func(), current []string, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := strings.Join(current, ",")
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		urls, err := discover()
+		if err != nil {
+			log.Errorf("Error while discovering the queues, keeping the current ones: %s", err)
+			continue
+		}
+
+		if joined := strings.Join(urls, ","); joined != last {
+			log.WithField("queues", joined).Info("Discovered queues changed, reloading")
+			last = joined
+			reload()
+		}
+	}
+}
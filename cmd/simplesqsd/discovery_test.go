@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeQueueFinder struct {
+	urls     map[string]string
+	tags     map[string]map[string]string
+	prefixes []string
+}
+
+func (f *fakeQueueFinder) GetQueueUrl(ctx context.Context, input *sqs.GetQueueUrlInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error) {
+	url, ok := f.urls[aws.ToString(input.QueueName)]
+	if !ok {
+		return nil, errors.New("queue does not exist")
+	}
+
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String(url)}, nil
+}
+
+func (f *fakeQueueFinder) ListQueues(ctx context.Context, input *sqs.ListQueuesInput, optFns ...func(*sqs.Options)) (*sqs.ListQueuesOutput, error) {
+	if input.NextToken == nil {
+		f.prefixes = append(f.prefixes, aws.ToString(input.QueueNamePrefix))
+	}
+
+	// Every queue is on its own page, the token being its index.
+	urls := make([]string, 0, len(f.tags))
+	for url := range f.tags {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+
+	i, _ := strconv.Atoi(aws.ToString(input.NextToken))
+	output := &sqs.ListQueuesOutput{QueueUrls: urls[i : i+1]}
+	if i+1 < len(urls) {
+		output.NextToken = aws.String(strconv.Itoa(i + 1))
+	}
+
+	return output, nil
+}
+
+func (f *fakeQueueFinder) ListQueueTags(ctx context.Context, input *sqs.ListQueueTagsInput, optFns ...func(*sqs.Options)) (*sqs.ListQueueTagsOutput, error) {
+	return &sqs.ListQueueTagsOutput{Tags: f.tags[aws.ToString(input.QueueUrl)]}, nil
+}
+
+func TestDiscoverQueueURLs(t *testing.T) {
+	finder := &fakeQueueFinder{
+		urls: map[string]string{
+			"orders": "https://sqs.us-east-1.amazonaws.com/123456789012/orders",
+			"emails": "https://sqs.us-east-1.amazonaws.com/123456789012/emails",
+		},
+		tags: map[string]map[string]string{
+			"https://sqs.us-east-1.amazonaws.com/123456789012/payments-b": {"team": "payments", "env": "prod"},
+			"https://sqs.us-east-1.amazonaws.com/123456789012/payments-a": {"team": "payments", "env": "prod", "tier": "1"},
+			"https://sqs.us-east-1.amazonaws.com/123456789012/payments-c": {"team": "payments", "env": "staging"},
+			"https://sqs.us-east-1.amazonaws.com/123456789012/orders":     {"team": "orders"},
+		},
+	}
+
+	urls, err := discoverQueueURLs(context.Background(), finder, &config{QueueNames: []string{"orders", "emails"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"https://sqs.us-east-1.amazonaws.com/123456789012/emails",
+		"https://sqs.us-east-1.amazonaws.com/123456789012/orders",
+	}, urls)
+
+	_, err = discoverQueueURLs(context.Background(), finder, &config{QueueNames: []string{"missing"}})
+	assert.Error(t, err)
+
+	urls, err = discoverQueueURLs(context.Background(), finder, &config{
+		QueueTagFilter:  map[string]string{"team": "payments", "env": "prod"},
+		QueueNamePrefix: "payments-",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"https://sqs.us-east-1.amazonaws.com/123456789012/payments-a",
+		"https://sqs.us-east-1.amazonaws.com/123456789012/payments-b",
+	}, urls)
+	assert.Equal(t, []string{"payments-"}, finder.prefixes)
+}
+
+func TestWatchQueueURLs(t *testing.T) {
+	var discoveries, reloads int32
+	discover := func() ([]string, error) {
+		switch atomic.AddInt32(&discoveries, 1) {
+		case 1:
+			return []string{"a"}, nil
+		case 2:
+			return nil, errors.New("throttled")
+		default:
+			return []string{"b"}, nil
+		}
+	}
+	reload := func() {
+		atomic.AddInt32(&reloads, 1)
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		watchQueueURLs(discover, reload, []string{"a"}, 10*time.Millisecond, done)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	close(done)
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("watchQueueURLs did not stop")
+	}
+
+	// Unchanged queues and discovery errors don't reload.
+	assert.True(t, atomic.LoadInt32(&discoveries) >= 4)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&reloads))
+}
+
+type fakeQueueAttributes map[string]string
+
+func (f fakeQueueAttributes) GetQueueAttributes(ctx context.Context, input *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	timeout, ok := f[aws.ToString(input.QueueUrl)]
+	if !ok {
+		return nil, errors.New("queue does not exist")
+	}
+
+	return &sqs.GetQueueAttributesOutput{Attributes: map[string]string{
+		string(types.QueueAttributeNameVisibilityTimeout): timeout,
+	}}, nil
+}
+
+func TestQueueVisibilityTimeouts(t *testing.T) {
+	client := fakeQueueAttributes{
+		"https://sqs.us-east-1.amazonaws.com/123456789012/orders":  "120",
+		"https://sqs.us-east-1.amazonaws.com/123456789012/invalid": "two",
+	}
+
+	timeouts := queueVisibilityTimeouts(client, []string{
+		"https://sqs.us-east-1.amazonaws.com/123456789012/orders",
+		"https://sqs.us-east-1.amazonaws.com/123456789012/invalid",
+		"https://sqs.us-east-1.amazonaws.com/123456789012/missing",
+	})
+	assert.Equal(t, map[string]time.Duration{
+		"https://sqs.us-east-1.amazonaws.com/123456789012/orders": 2 * time.Minute,
+	}, timeouts)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// parseFlags returns the variables set by the command line flags in args.
+// --queue-url=URL sets SQSD_QUEUE_URL, and a flag without a value, such as
+// --fifo, sets its variable to true. Repeated flags override each other,
+// except those of repeatableFlags, which are joined with semicolons. -h and
+// --help return flag.ErrHelp.
+func parseFlags(args []string) (map[string]string, error) {
+	values := make(map[string]string, len(args))
+
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			return nil, fmt.Errorf("unexpected argument %q", arg)
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		if name == "h" || name == "help" {
+			return nil, flag.ErrHelp
+		}
+
+		value := "true"
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value = name[:i], name[i+1:]
+		}
+
+		if len(name) == 0 {
+			return nil, fmt.Errorf("invalid flag %q", arg)
+		}
+
+		variable := flagVariable(name)
+		if previous, ok := values[variable]; ok && repeatableFlags[variable] {
+			value = previous + ";" + value
+		}
+
+		values[variable] = value
+	}
+
+	return values, nil
+}
+
+// repeatableFlags are the variables of the flags which may be repeated, e.g.
+// --http-headers=Authorization=Bearer abc --http-headers=X-Tenant=42.
+var repeatableFlags = map[string]bool{
+	"SQSD_HTTP_HEADERS": true,
+}
+
+// flagVariable returns the variable set by the flag name, e.g. SQSD_QUEUE_URL
+// for queue-url.
+func flagVariable(name string) string {
+	return "SQSD_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// checkFlags reports the flags setting variables the configuration of e does
+// not have.
+func checkFlags(e *env, flags map[string]string) {
+	known := make(map[string]bool, len(e.names))
+	for _, name := range e.names {
+		known[name] = true
+	}
+
+	for name := range flags {
+		if !known[name] {
+			e.get(name)
+			e.invalid(name, "set by a flag but not a known variable")
+		}
+	}
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: simplesqsd [--name=value]...")
+	fmt.Fprintln(w, "       simplesqsd redrive --dlq-url=URL [--name=value]...")
+	fmt.Fprintln(w, "       simplesqsd install|uninstall [--name=value]...")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Every SQSD_<NAME> variable can also be set with a --<name> flag, e.g.")
+	fmt.Fprintln(w, "--queue-url=URL for SQSD_QUEUE_URL. Flags take precedence over")
+	fmt.Fprintln(w, "environment variables and SQSD_CONFIG_FILE.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "--dev enables development conveniences, e.g. with LocalStack:")
+	fmt.Fprintln(w, "simplesqsd --dev --aws-endpoint=http://localhost:4566 --create-queue \\")
+	fmt.Fprintln(w, "    --queue-name=jobs --dev-echo-addr=:9000")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFlags(t *testing.T) {
+	flags, err := parseFlags([]string{"--queue-url=https://queue.url/jobs", "-http-max-conns=5", "--fifo", "--http-url=http://worker/?a=b"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"SQSD_QUEUE_URL":      "https://queue.url/jobs",
+		"SQSD_HTTP_MAX_CONNS": "5",
+		"SQSD_FIFO":           "true",
+		"SQSD_HTTP_URL":       "http://worker/?a=b",
+	}, flags)
+
+	flags, err = parseFlags([]string{"--http-url=http://a/", "--http-url=http://b/", "--http-headers=X-Api-Key=abc", "--http-headers=X-Tenant-Id=42"})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://b/", flags["SQSD_HTTP_URL"])
+	assert.Equal(t, "X-Api-Key=abc;X-Tenant-Id=42", flags["SQSD_HTTP_HEADERS"])
+
+	_, err = parseFlags([]string{"--help"})
+	assert.Equal(t, flag.ErrHelp, err)
+
+	_, err = parseFlags([]string{"queue"})
+	assert.Error(t, err)
+
+	_, err = parseFlags([]string{"--=value"})
+	assert.Error(t, err)
+}
+
+func TestConfigFlags(t *testing.T) {
+	flags, err := parseFlags([]string{"--http-url=http://flag/", "--queue-max-msgs=5", "--no-such-setting=1"})
+	assert.NoError(t, err)
+
+	vars := map[string]string{
+		"SQSD_QUEUE_URL": "https://sqs.us-east-1.amazonaws.com/123456789012/queue",
+		"SQSD_HTTP_URL":  "http://env/",
+	}
+	env := newConfigEnvs(overlayLookup(mapLookup(flags), mapLookup(vars)))[0]
+
+	c := loadConfig(env)
+	checkFlags(env, flags)
+	assert.Equal(t, "http://flag/", c.HTTPURL)
+	assert.Equal(t, 5, c.QueueMaxMessages)
+	assert.Len(t, env.problems, 1)
+	assert.Contains(t, env.problems["SQSD_NO_SUCH_SETTING"], "not a known variable")
+
+	var report bytes.Buffer
+	env.report(&report)
+	assert.Contains(t, report.String(), "SQSD_NO_SUCH_SETTING")
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/fterrag/simple-sqsd/supervisor"
+	log "github.com/sirupsen/logrus"
+)
+
+// supervisorGroup runs the supervisors of several queue to worker mappings as
+// one: it is healthy and ready only while all of them are, and shuts them all
+// down together.
+type supervisorGroup struct {
+	supervisors []*supervisor.Supervisor
+	workers     []int
+	// configs holds the effective configuration of every supervisor, with
+	// secrets redacted.
+	configs []map[string]string
+}
+
+// add adds s to the group, to be started with numWorkers workers.
+func (g *supervisorGroup) add(s *supervisor.Supervisor, numWorkers int) {
+	g.supervisors = append(g.supervisors, s)
+	g.workers = append(g.workers, numWorkers)
+}
+
+func (g *supervisorGroup) Start() {
+	for i, s := range g.supervisors {
+		s.Start(g.workers[i])
+	}
+}
+
+func (g *supervisorGroup) Shutdown() {
+	for _, s := range g.supervisors {
+		s.Shutdown()
+	}
+}
+
+// Wait blocks until every supervisor of the group has stopped.
+func (g *supervisorGroup) Wait() {
+	var wg sync.WaitGroup
+	wg.Add(len(g.supervisors))
+
+	for _, s := range g.supervisors {
+		go func(s *supervisor.Supervisor) {
+			defer wg.Done()
+			s.Wait()
+		}(s)
+	}
+
+	wg.Wait()
+}
+
+func (g *supervisorGroup) Healthy() bool {
+	for _, s := range g.supervisors {
+		if !s.Healthy() {
+			return false
+		}
+	}
+
+	return len(g.supervisors) > 0
+}
+
+func (g *supervisorGroup) Ready() bool {
+	for _, s := range g.supervisors {
+		if !s.Ready() {
+			return false
+		}
+	}
+
+	return len(g.supervisors) > 0
+}
+
+// Ack acknowledges the message with ID messageID with the supervisor of the
+// group holding it.
+func (g *supervisorGroup) Ack(messageID string) error {
+	for _, s := range g.supervisors {
+		if err := s.Ack(messageID); err != supervisor.ErrNotAwaitingAck {
+			return err
+		}
+	}
+
+	return supervisor.ErrNotAwaitingAck
+}
+
+// Report merges the reports of every supervisor of the group.
+func (g *supervisorGroup) Report() supervisor.Report {
+	reports := make([]supervisor.Report, 0, len(g.supervisors))
+	for _, s := range g.supervisors {
+		reports = append(reports, s.Report())
+	}
+
+	return supervisor.MergeReports(reports...)
+}
+
+// sharedResources are used by all the supervisors of a process. Event streams
+// and attempt stores are opened once per path, so that supervisors
+// configured with the same path share them.
+type sharedResources struct {
+	metrics *supervisor.Metrics
+
+	eventStreams  map[string]*supervisor.EventStream
+	attemptStores map[string]*supervisor.AttemptStore
+	certificates  map[[2]string]*certReloader
+	tracers       map[string]*supervisor.Tracer
+	memoryDedup   *supervisor.MemoryDedupStore
+	redisDedup    map[string]*redisDedupStore
+	files         []io.Closer
+}
+
+func newSharedResources(metrics *supervisor.Metrics) *sharedResources {
+	return &sharedResources{
+		metrics:       metrics,
+		eventStreams:  make(map[string]*supervisor.EventStream),
+		attemptStores: make(map[string]*supervisor.AttemptStore),
+		certificates:  make(map[[2]string]*certReloader),
+		tracers:       make(map[string]*supervisor.Tracer),
+		redisDedup:    make(map[string]*redisDedupStore),
+	}
+}
+
+// eventStream returns the event stream writing to stdout, or appending to the
+// file at path.
+func (r *sharedResources) eventStream(path string) (*supervisor.EventStream, error) {
+	if stream, ok := r.eventStreams[path]; ok {
+		return stream, nil
+	}
+
+	var w io.Writer = os.Stdout
+	if path != "stdout" {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		r.files = append(r.files, f)
+
+		w = f
+	}
+
+	stream := supervisor.NewEventStream(w)
+	r.eventStreams[path] = stream
+
+	return stream, nil
+}
+
+// attemptStore returns the attempt store at path. maxEntries is ignored if
+// the store is already open.
+func (r *sharedResources) attemptStore(path string, maxEntries int) (*supervisor.AttemptStore, error) {
+	if store, ok := r.attemptStores[path]; ok {
+		return store, nil
+	}
+
+	store, err := supervisor.OpenAttemptStore(path, maxEntries)
+	if err != nil {
+		return nil, err
+	}
+	r.attemptStores[path] = store
+
+	return store, nil
+}
+
+// tracer returns the tracer exporting spans to the OTLP endpoint of c. The
+// headers and service name of c are ignored if the tracer already exists.
+func (r *sharedResources) tracer(c *config) *supervisor.Tracer {
+	if tracer, ok := r.tracers[c.OTelEndpoint]; ok {
+		return tracer
+	}
+
+	logger := log.WithField("otlpEndpoint", c.OTelEndpoint)
+	tracer := supervisor.NewTracer(logger, c.OTelEndpoint, c.OTelServiceName, c.OTelHeaders)
+	r.tracers[c.OTelEndpoint] = tracer
+
+	return tracer
+}
+
+// dedupStore returns the dedup store configured by c. The in-memory store is
+// shared by every supervisor, and its size is set by the first one.
+func (r *sharedResources) dedupStore(c *config) (supervisor.DedupStore, error) {
+	if c.DedupStore != "redis" {
+		if r.memoryDedup == nil {
+			r.memoryDedup = supervisor.NewMemoryDedupStore(c.DedupMaxEntries)
+		}
+
+		return r.memoryDedup, nil
+	}
+
+	key := c.DedupRedisURL + " " + c.DedupRedisKeyPrefix
+	if store, ok := r.redisDedup[key]; ok {
+		return store, nil
+	}
+
+	store, err := newRedisDedupStore(c.DedupRedisURL, c.DedupRedisKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	r.redisDedup[key] = store
+	r.files = append(r.files, store)
+
+	return store, nil
+}
+
+// certificate returns the client certificate loaded from certPath and
+// keyPath.
+func (r *sharedResources) certificate(certPath string, keyPath string) (*certReloader, error) {
+	key := [2]string{certPath, keyPath}
+	if cert, ok := r.certificates[key]; ok {
+		return cert, nil
+	}
+
+	cert, err := newCertReloader(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	r.certificates[key] = cert
+
+	return cert, nil
+}
+
+// certReloaders returns every client certificate opened so far.
+func (r *sharedResources) certReloaders() []*certReloader {
+	reloaders := make([]*certReloader, 0, len(r.certificates))
+	for _, cert := range r.certificates {
+		reloaders = append(reloaders, cert)
+	}
+
+	return reloaders
+}
+
+func (r *sharedResources) close() {
+	for _, tracer := range r.tracers {
+		tracer.Close()
+	}
+	for _, f := range r.files {
+		f.Close()
+	}
+}
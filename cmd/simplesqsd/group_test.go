@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/fterrag/simple-sqsd/supervisor"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// idleSQS receives no messages.
+type idleSQS struct {
+	supervisor.SQSClient
+}
+
+func (idleSQS) ReceiveMessage(context.Context, *sqs.ReceiveMessageInput, ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	time.Sleep(time.Millisecond)
+	return &sqs.ReceiveMessageOutput{}, nil
+}
+
+func TestSupervisorGroup(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+
+	group := &supervisorGroup{}
+	assert.False(t, group.Healthy())
+	assert.False(t, group.Ready())
+
+	for _, url := range []string{"https://queue.url/orders", "https://queue.url/emails"} {
+		s := supervisor.NewSupervisor(logger, idleSQS{}, &http.Client{}, supervisor.WorkerConfig{
+			QueueURL: url,
+			HTTPURL:  "http://worker",
+		})
+		group.add(s, 2)
+	}
+
+	group.Start()
+	assert.Eventually(t, func() bool {
+		return group.Healthy() && group.Ready()
+	}, time.Second, time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		group.Wait()
+		close(stopped)
+	}()
+
+	group.supervisors[0].Shutdown()
+	assert.False(t, group.Ready())
+
+	select {
+	case <-stopped:
+		t.Fatal("Wait returned before every supervisor stopped")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	group.Shutdown()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Wait did not return after shutdown")
+	}
+
+	assert.False(t, group.Healthy())
+
+	report := group.Report()
+	assert.False(t, report.StartedAt.IsZero())
+	assert.Zero(t, report.Received)
+}
+
+func TestSharedResources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shared")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	shared := newSharedResources(nil)
+	defer shared.close()
+
+	events := filepath.Join(dir, "events.log")
+	first, err := shared.eventStream(events)
+	assert.NoError(t, err)
+	second, err := shared.eventStream(events)
+	assert.NoError(t, err)
+	assert.Same(t, first, second)
+
+	other, err := shared.eventStream(filepath.Join(dir, "other.log"))
+	assert.NoError(t, err)
+	assert.NotSame(t, first, other)
+
+	attempts := filepath.Join(dir, "attempts.json")
+	store, err := shared.attemptStore(attempts, 10)
+	assert.NoError(t, err)
+	again, err := shared.attemptStore(attempts, 20)
+	assert.NoError(t, err)
+	assert.Same(t, store, again)
+
+	_, err = shared.eventStream(filepath.Join(dir, "missing", "events.log"))
+	assert.Error(t, err)
+}
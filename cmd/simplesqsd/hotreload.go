@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/fterrag/simple-sqsd/supervisor"
+	log "github.com/sirupsen/logrus"
+)
+
+// loadConfigs loads the configuration of every worker from lookup. The error
+// holds the report of every invalid configuration.
+func loadConfigs(lookup func(string) (string, bool), flags map[string]string) ([]*config, []*env, error) {
+	envs := newConfigEnvs(lookup)
+	configs := make([]*config, 0, len(envs))
+
+	var report bytes.Buffer
+	for i, env := range envs {
+		configs = append(configs, loadConfig(env))
+		checkFlags(env, flags)
+
+		if env.failed() {
+			if len(envs) > 1 {
+				fmt.Fprintf(&report, "Worker %d:\n", i+1)
+			}
+			env.report(&report)
+		}
+	}
+
+	if report.Len() > 0 {
+		return nil, nil, errors.New(report.String())
+	}
+
+	return configs, envs, nil
+}
+
+// logEffectiveConfig logs the effective configuration of every worker.
+func logEffectiveConfig(envs []*env) {
+	for i, env := range envs {
+		log.WithFields(log.Fields{
+			"worker": i + 1,
+			"config": env.effective(),
+		}).Info("Effective configuration")
+	}
+}
+
+// reloadingGroup runs a supervisorGroup that can be replaced by one built from
+// a new configuration. The current group is drained, processing the messages
+// it holds, before the next one starts.
+type reloadingGroup struct {
+	build func() (*supervisorGroup, error)
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	current   *supervisorGroup
+	reloading bool
+	stopping  bool
+	// reports holds the reports of the groups that were replaced.
+	reports []supervisor.Report
+}
+
+func newReloadingGroup(group *supervisorGroup, build func() (*supervisorGroup, error)) *reloadingGroup {
+	r := &reloadingGroup{build: build, current: group}
+	r.cond = sync.NewCond(&r.mu)
+
+	return r
+}
+
+func (r *reloadingGroup) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.current.Start()
+}
+
+func (r *reloadingGroup) Shutdown() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stopping = true
+	r.current.Shutdown()
+}
+
+// Wait blocks until the current group has stopped other than to be replaced.
+func (r *reloadingGroup) Wait() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for {
+		g := r.current
+
+		r.mu.Unlock()
+		g.Wait()
+		r.mu.Lock()
+
+		for r.reloading {
+			r.cond.Wait()
+		}
+
+		if r.current == g {
+			return
+		}
+	}
+}
+
+// Healthy reports the current group as healthy while it is being replaced.
+func (r *reloadingGroup) Healthy() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.reloading || r.current.Healthy()
+}
+
+func (r *reloadingGroup) Ready() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return !r.reloading && r.current.Ready()
+}
+
+// group returns the current group.
+func (r *reloadingGroup) group() *supervisorGroup {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.current
+}
+
+// Ack acknowledges a message held by the current group. Messages held by the
+// groups replaced are no longer awaiting an acknowledgment.
+func (r *reloadingGroup) Ack(messageID string) error {
+	return r.group().Ack(messageID)
+}
+
+// Report merges the reports of every group run so far.
+func (r *reloadingGroup) Report() supervisor.Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return supervisor.MergeReports(append(r.reports[:len(r.reports):len(r.reports)], r.current.Report())...)
+}
+
+// reload builds a group from the new configuration and replaces the current
+// one with it. The current group is kept if the configuration is invalid.
+func (r *reloadingGroup) reload() {
+	r.mu.Lock()
+	if r.stopping || r.reloading {
+		r.mu.Unlock()
+		return
+	}
+	r.reloading = true
+	old := r.current
+	r.mu.Unlock()
+
+	next, err := r.build()
+	if err != nil {
+		log.Errorf("Error while reloading the configuration, keeping the current one: %s", err)
+		r.finishReload(nil)
+		return
+	}
+
+	log.Info("Reloading the configuration, waiting for in-flight messages to be processed")
+	old.Shutdown()
+	old.Wait()
+
+	r.finishReload(next)
+}
+
+func (r *reloadingGroup) finishReload(next *supervisorGroup) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if next != nil && !r.stopping {
+		r.reports = append(r.reports, r.current.Report())
+		r.current = next
+		next.Start()
+
+		log.Info("Configuration reloaded")
+	}
+
+	r.reloading = false
+	r.cond.Broadcast()
+}
+
+// reloadOnHangup reloads r every time the process receives SIGHUP, until done
+// is closed.
+func reloadOnHangup(r *reloadingGroup, done <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-hup:
+			r.reload()
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fterrag/simple-sqsd/supervisor"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newIdleGroup() *supervisorGroup {
+	s := supervisor.NewSupervisor(log.WithFields(log.Fields{}), idleSQS{}, &http.Client{}, supervisor.WorkerConfig{
+		QueueURL: "https://queue.url/jobs",
+		HTTPURL:  "http://worker",
+	})
+
+	group := &supervisorGroup{}
+	group.add(s, 1)
+
+	return group
+}
+
+func TestReloadingGroup(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	first := newIdleGroup()
+	var (
+		next    *supervisorGroup
+		failing bool
+	)
+	group := newReloadingGroup(first, func() (*supervisorGroup, error) {
+		if failing {
+			return nil, errors.New("invalid configuration")
+		}
+
+		next = newIdleGroup()
+		return next, nil
+	})
+
+	group.Start()
+	assert.Eventually(t, group.Ready, time.Second, time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		group.Wait()
+		close(stopped)
+	}()
+
+	group.reload()
+	assert.False(t, first.Healthy())
+	assert.Same(t, next, group.current)
+	assert.Eventually(t, group.Ready, time.Second, time.Millisecond)
+
+	failing = true
+	current := group.current
+	group.reload()
+	assert.Same(t, current, group.current)
+	assert.True(t, group.Healthy())
+
+	select {
+	case <-stopped:
+		t.Fatal("Wait returned after a reload")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	group.Shutdown()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Wait did not return after shutdown")
+	}
+
+	// Reloading after shutdown does nothing.
+	failing = false
+	group.reload()
+	assert.Same(t, current, group.current)
+	assert.False(t, group.Report().StartedAt.IsZero())
+}
+
+func TestLoadConfigs(t *testing.T) {
+	vars := map[string]string{
+		"SQSD_QUEUE_URL": "https://sqs.us-east-1.amazonaws.com/123456789012/queue",
+	}
+
+	_, _, err := loadConfigs(mapLookup(vars), nil)
+	if assert.Error(t, err) {
+		assert.Regexp(t, `SQSD_HTTP_URL\s+\(unset\)\s+missing`, err.Error())
+	}
+
+	vars["SQSD_HTTP_URL"] = "http://localhost:8080"
+	configs, envs, err := loadConfigs(mapLookup(vars), nil)
+	assert.NoError(t, err)
+	assert.Len(t, configs, 1)
+	assert.Len(t, envs, 1)
+}
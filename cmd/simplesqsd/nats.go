@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/fterrag/simple-sqsd/supervisor"
+	"github.com/nats-io/nats.go"
+)
+
+// natsPublisher publishes delivery outcomes as JSON to a NATS subject.
+type natsPublisher struct {
+	conn    *nats.Conn
+	subject string
+}
+
+func newNATSPublisher(url string, subject string) (*natsPublisher, error) {
+	conn, err := nats.Connect(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return &natsPublisher{
+		conn:    conn,
+		subject: subject,
+	}, nil
+}
+
+func (p *natsPublisher) Publish(outcome supervisor.Outcome) error {
+	data, err := json.Marshal(outcome)
+	if err != nil {
+		return err
+	}
+
+	return p.conn.Publish(p.subject, data)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisDedupStore records the keys of delivered messages in Redis, shared by
+// every daemon consuming the same queues.
+type redisDedupStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func newRedisDedupStore(url string, prefix string) (*redisDedupStore, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return &redisDedupStore{
+		client: redis.NewClient(opts),
+		prefix: prefix,
+	}, nil
+}
+
+func (s *redisDedupStore) Seen(ctx context.Context, key string) (bool, error) {
+	n, err := s.client.Exists(ctx, s.prefix+key).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func (s *redisDedupStore) Record(ctx context.Context, key string, ttl time.Duration) error {
+	return s.client.Set(ctx, s.prefix+key, "1", ttl).Err()
+}
+
+func (s *redisDedupStore) Close() error {
+	return s.client.Close()
+}
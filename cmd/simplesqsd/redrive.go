@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/fterrag/simple-sqsd/supervisor"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// redriveVisibilityTimeout is how long the messages being redriven are
+// hidden from other consumers of the dead-letter queue, on top of the time
+// --rate takes to go through a batch.
+const redriveVisibilityTimeout = time.Minute
+
+// redriveOptions are the flags of the redrive subcommand. The other flags set
+// configuration variables like the flags of the daemon.
+type redriveOptions struct {
+	// dlqURL is the URL of the dead-letter queue messages are taken from.
+	dlqURL string
+	// deliver POSTs the messages to the worker instead of sending them to the
+	// queue.
+	deliver bool
+	// maxMessages stops after that many messages when positive.
+	maxMessages int
+	// rate is the maximum number of messages redriven per second when
+	// positive.
+	rate float64
+	// dryRun lists the messages without redriving them.
+	dryRun bool
+}
+
+// parseRedriveArgs splits args between the flags of the redrive subcommand
+// and the variables set by the other flags.
+func parseRedriveArgs(args []string) (redriveOptions, map[string]string, error) {
+	var opts redriveOptions
+	var rest []string
+
+	for _, arg := range args {
+		name := strings.TrimLeft(arg, "-")
+		value := "true"
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value = name[:i], name[i+1:]
+		}
+
+		var err error
+		switch name {
+		case "dlq-url":
+			opts.dlqURL = value
+		case "deliver":
+			opts.deliver, err = strconv.ParseBool(value)
+		case "dry-run":
+			opts.dryRun, err = strconv.ParseBool(value)
+		case "max-messages":
+			opts.maxMessages, err = strconv.Atoi(value)
+			if err == nil && opts.maxMessages < 0 {
+				err = errors.New("must not be negative")
+			}
+		case "rate":
+			opts.rate, err = strconv.ParseFloat(value, 64)
+			if err == nil && opts.rate < 0 {
+				err = errors.New("must not be negative")
+			}
+		default:
+			rest = append(rest, arg)
+			continue
+		}
+		if err != nil {
+			return opts, nil, fmt.Errorf("invalid flag %q: %s", arg, err)
+		}
+	}
+
+	flags, err := parseFlags(rest)
+	if err != nil {
+		return opts, nil, err
+	}
+
+	if len(opts.dlqURL) == 0 {
+		return opts, nil, errors.New("--dlq-url is required")
+	}
+
+	return opts, flags, nil
+}
+
+func printRedriveUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: simplesqsd redrive --dlq-url=URL [--deliver] [--max-messages=N] [--rate=N] [--dry-run] [--name=value]...")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Moves the messages of the dead-letter queue at --dlq-url back to")
+	fmt.Fprintln(w, "SQSD_QUEUE_URL, or POSTs them to the worker with --deliver, then deletes")
+	fmt.Fprintln(w, "them from the dead-letter queue. --max-messages stops after N messages,")
+	fmt.Fprintln(w, "--rate redrives at most N messages per second and --dry-run only lists")
+	fmt.Fprintln(w, "the messages. The configuration is read like the daemon's.")
+}
+
+// runRedrive runs the redrive subcommand with args and returns the exit code
+// of the process.
+func runRedrive(args []string) int {
+	opts, flags, err := parseRedriveArgs(args)
+	if err == flag.ErrHelp {
+		printRedriveUsage(os.Stdout)
+		return 0
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		printRedriveUsage(os.Stderr)
+		return 2
+	}
+
+	lookup := overlayLookup(mapLookup(flags), os.LookupEnv)
+	configs, _, err := loadConfigs(lookup, flags)
+	if err != nil {
+		fmt.Fprint(os.Stderr, err)
+		return 1
+	}
+
+	// The first worker is redriven to.
+	c := configs[0]
+	configureLogging(c)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	awsCfg, err := newAWSConfig(c, done)
+	if err != nil {
+		log.Errorf("Error while loading the AWS configuration: %s", err)
+		return 1
+	}
+	if _, err := resolveQueueURLs(awsCfg, configs); err != nil {
+		log.Errorf("Error while discovering the queues: %s", err)
+		return 1
+	}
+
+	logger := log.WithField("dlqUrl", opts.dlqURL)
+	dlq := sqs.NewFromConfig(awsCfg, sqsOptions(c, logger))
+
+	r := &redriver{dlq: dlq, opts: opts, out: os.Stdout}
+	if opts.deliver {
+		shared := newSharedResources(supervisor.NewMetrics(prometheus.NewRegistry()))
+		defer shared.close()
+
+		r.send = newSupervisor(c, awsCfg, shared).Redeliver
+	} else {
+		forwarder := supervisor.NewSQSForwarder(sqs.NewFromConfig(awsCfg, sqsOptions(c, logger)), c.QueueURLs[0])
+		r.send = func(ctx context.Context, msg *types.Message) error {
+			_, err := forwarder.Deliver(ctx, c.QueueURLs[0], msg)
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	redriven, failed, err := r.run(ctx)
+	if opts.dryRun {
+		fmt.Fprintf(os.Stdout, "%d messages would be redriven\n", redriven)
+	} else {
+		fmt.Fprintf(os.Stdout, "%d messages redriven, %d failed\n", redriven, failed)
+	}
+	if err != nil {
+		log.Errorf("Error while redriving messages: %s", err)
+		return 1
+	}
+	if failed > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// dlqClient is the part of the SQS API messages are redriven from the
+// dead-letter queue with.
+type dlqClient interface {
+	ReceiveMessage(ctx context.Context, input *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(ctx context.Context, input *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+	ChangeMessageVisibilityBatch(ctx context.Context, input *sqs.ChangeMessageVisibilityBatchInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityBatchOutput, error)
+}
+
+// redriver moves the messages of a dead-letter queue with send.
+type redriver struct {
+	dlq  dlqClient
+	send func(ctx context.Context, msg *types.Message) error
+	opts redriveOptions
+	out  io.Writer
+}
+
+// run redrives messages until the dead-letter queue is empty, maxMessages
+// were redriven or ctx is done. It returns how many messages were redriven,
+// or would be in a dry run, and how many failed to be. Messages that failed
+// are left in the dead-letter queue, and become visible again once their
+// visibility timeout expires.
+func (r *redriver) run(ctx context.Context) (int, int, error) {
+	var interval time.Duration
+	if r.opts.rate > 0 {
+		interval = time.Duration(float64(time.Second) / r.opts.rate)
+	}
+
+	visibility := redriveVisibilityTimeout + 10*interval
+
+	// Dry runs keep the messages they listed until the end, so that each is
+	// listed once, then make them visible again.
+	var listed []types.Message
+	defer func() {
+		if len(listed) > 0 {
+			r.releaseMessages(listed)
+		}
+	}()
+
+	redriven, failed := 0, 0
+	next := time.Now()
+	for {
+		want := 10
+		if r.opts.maxMessages > 0 {
+			remaining := r.opts.maxMessages - redriven - failed
+			if remaining <= 0 {
+				return redriven, failed, nil
+			}
+			if remaining < want {
+				want = remaining
+			}
+		}
+
+		output, err := r.dlq.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:                    aws.String(r.opts.dlqURL),
+			MaxNumberOfMessages:         int32(want),
+			WaitTimeSeconds:             1,
+			VisibilityTimeout:           int32(visibility / time.Second),
+			MessageAttributeNames:       []string{"All"},
+			MessageSystemAttributeNames: []types.MessageSystemAttributeName{types.MessageSystemAttributeNameAll},
+		})
+		if ctx.Err() != nil {
+			return redriven, failed, nil
+		}
+		if err != nil {
+			return redriven, failed, err
+		}
+		if len(output.Messages) == 0 {
+			return redriven, failed, nil
+		}
+
+		for i := range output.Messages {
+			msg := &output.Messages[i]
+			if r.opts.dryRun {
+				fmt.Fprintf(r.out, "%s\t%s\n", aws.ToString(msg.MessageId), aws.ToString(msg.Body))
+				listed = append(listed, *msg)
+				redriven++
+				continue
+			}
+
+			if wait := time.Until(next); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return redriven, failed, nil
+				case <-time.After(wait):
+				}
+			}
+			next = time.Now().Add(interval)
+
+			logger := log.WithField("messageId", aws.ToString(msg.MessageId))
+			if err := r.send(ctx, msg); err != nil {
+				logger.Errorf("Error while redriving the message: %s", err)
+				failed++
+				continue
+			}
+
+			_, err := r.dlq.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(r.opts.dlqURL),
+				ReceiptHandle: msg.ReceiptHandle,
+			})
+			if err != nil {
+				// The message was redriven, and will be again once visible.
+				logger.Errorf("Error while deleting the redriven message from the dead-letter queue: %s", err)
+			}
+
+			logger.Debug("Message redriven")
+			redriven++
+		}
+	}
+}
+
+// releaseMessages makes messages visible again in the dead-letter queue.
+func (r *redriver) releaseMessages(messages []types.Message) {
+	for start := 0; start < len(messages); start += 10 {
+		end := start + 10
+		if end > len(messages) {
+			end = len(messages)
+		}
+
+		entries := make([]types.ChangeMessageVisibilityBatchRequestEntry, 0, end-start)
+		for i, msg := range messages[start:end] {
+			entries = append(entries, types.ChangeMessageVisibilityBatchRequestEntry{
+				Id:            aws.String(strconv.Itoa(i)),
+				ReceiptHandle: msg.ReceiptHandle,
+			})
+		}
+
+		_, err := r.dlq.ChangeMessageVisibilityBatch(context.Background(), &sqs.ChangeMessageVisibilityBatchInput{
+			QueueUrl: aws.String(r.opts.dlqURL),
+			Entries:  entries,
+		})
+		if err != nil {
+			log.Errorf("Error while making the listed messages visible again: %s", err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDLQ hands out its messages once each.
+type fakeDLQ struct {
+	messages []types.Message
+	deleted  []string
+	released []string
+}
+
+func (q *fakeDLQ) ReceiveMessage(ctx context.Context, input *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	n := int(input.MaxNumberOfMessages)
+	if n > len(q.messages) {
+		n = len(q.messages)
+	}
+
+	output := &sqs.ReceiveMessageOutput{Messages: q.messages[:n]}
+	q.messages = q.messages[n:]
+
+	return output, nil
+}
+
+func (q *fakeDLQ) DeleteMessage(ctx context.Context, input *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	q.deleted = append(q.deleted, aws.ToString(input.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (q *fakeDLQ) ChangeMessageVisibilityBatch(ctx context.Context, input *sqs.ChangeMessageVisibilityBatchInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	for _, entry := range input.Entries {
+		q.released = append(q.released, aws.ToString(entry.ReceiptHandle))
+	}
+	return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
+}
+
+func dlqMessages(ids ...string) []types.Message {
+	messages := make([]types.Message, len(ids))
+	for i, id := range ids {
+		messages[i] = types.Message{MessageId: aws.String(id), ReceiptHandle: aws.String("r-" + id), Body: aws.String("body " + id)}
+	}
+
+	return messages
+}
+
+func TestParseRedriveArgs(t *testing.T) {
+	opts, flags, err := parseRedriveArgs([]string{"--dlq-url=https://queue.url/dlq", "--dry-run", "--max-messages=5", "--rate=2.5", "--queue-url=https://queue.url/orders"})
+	assert.NoError(t, err)
+	assert.Equal(t, redriveOptions{dlqURL: "https://queue.url/dlq", dryRun: true, maxMessages: 5, rate: 2.5}, opts)
+	assert.Equal(t, map[string]string{"SQSD_QUEUE_URL": "https://queue.url/orders"}, flags)
+
+	_, _, err = parseRedriveArgs([]string{"--queue-url=https://queue.url/orders"})
+	assert.EqualError(t, err, "--dlq-url is required")
+
+	_, _, err = parseRedriveArgs([]string{"--dlq-url=https://queue.url/dlq", "--rate=-1"})
+	assert.Error(t, err)
+
+	_, _, err = parseRedriveArgs([]string{"--help"})
+	assert.Equal(t, flag.ErrHelp, err)
+}
+
+func TestRedriver(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	dlq := &fakeDLQ{messages: dlqMessages("m1", "m2", "m3", "m4")}
+
+	var sent []string
+	r := &redriver{
+		dlq: dlq,
+		send: func(ctx context.Context, msg *types.Message) error {
+			sent = append(sent, aws.ToString(msg.MessageId))
+			if aws.ToString(msg.MessageId) == "m2" {
+				return errors.New("access denied")
+			}
+			return nil
+		},
+		opts: redriveOptions{dlqURL: "https://queue.url/dlq", maxMessages: 3, rate: 50},
+	}
+
+	start := time.Now()
+	redriven, failed, err := r.run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, redriven)
+	assert.Equal(t, 1, failed)
+	assert.Equal(t, []string{"m1", "m2", "m3"}, sent)
+	assert.True(t, time.Since(start) >= 40*time.Millisecond, "redriven faster than --rate")
+
+	// Messages that failed are left in the dead-letter queue.
+	assert.Equal(t, []string{"r-m1", "r-m3"}, dlq.deleted)
+	assert.Len(t, dlq.messages, 1)
+}
+
+func TestRedriverDryRun(t *testing.T) {
+	dlq := &fakeDLQ{messages: dlqMessages("m1", "m2")}
+
+	var out bytes.Buffer
+	r := &redriver{
+		dlq: dlq,
+		send: func(ctx context.Context, msg *types.Message) error {
+			t.Error("message sent in a dry run")
+			return nil
+		},
+		opts: redriveOptions{dlqURL: "https://queue.url/dlq", dryRun: true},
+		out:  &out,
+	}
+
+	redriven, _, err := r.run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, redriven)
+	assert.Equal(t, "m1\tbody m1\nm2\tbody m2\n", out.String())
+	assert.Empty(t, dlq.deleted)
+	assert.Equal(t, []string{"r-m1", "r-m2"}, dlq.released)
+}
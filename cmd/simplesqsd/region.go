@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/fterrag/simple-sqsd/supervisor"
+	log "github.com/sirupsen/logrus"
+)
+
+// sqsAPI is the part of the SQS API the supervisor is run with.
+type sqsAPI interface {
+	supervisor.SQSClient
+	queueAttributesGetter
+}
+
+// regionalSQS sends every call to the client of the region of its queue URL,
+// so that queues mirrored in several regions, e.g. for failover, can be
+// received from by a single supervisor.
+type regionalSQS struct {
+	fallback sqsAPI
+	regions  map[string]sqsAPI
+}
+
+// newRegionalSQS returns the SQS client of the supervisor of c: a single
+// client in SQSD_QUEUE_REGION, unless the queues of c are in several regions.
+func newRegionalSQS(c *config, awsCfg aws.Config, logger *log.Entry) sqsAPI {
+	fallback := sqs.NewFromConfig(awsCfg, sqsOptions(c, logger))
+	if len(c.AWSEndpoint) > 0 {
+		return fallback
+	}
+
+	regions := make(map[string]sqsAPI)
+	for _, queueURL := range append([]string{c.ForwardQueueURL}, c.QueueURLs...) {
+		region := queueRegion("", queueURL)
+		if len(region) == 0 || region == c.QueueRegion || regions[region] != nil {
+			continue
+		}
+
+		regions[region] = sqs.NewFromConfig(awsCfg, sqsOptions(c, logger), sqsRegion(region))
+	}
+	if len(regions) == 0 {
+		return fallback
+	}
+
+	return &regionalSQS{fallback: fallback, regions: regions}
+}
+
+// client returns the client of the region of queueURL.
+func (r *regionalSQS) client(queueURL *string) sqsAPI {
+	if client, ok := r.regions[queueRegion("", aws.ToString(queueURL))]; ok {
+		return client
+	}
+
+	return r.fallback
+}
+
+func (r *regionalSQS) ReceiveMessage(ctx context.Context, input *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	return r.client(input.QueueUrl).ReceiveMessage(ctx, input, optFns...)
+}
+
+func (r *regionalSQS) DeleteMessageBatch(ctx context.Context, input *sqs.DeleteMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageBatchOutput, error) {
+	return r.client(input.QueueUrl).DeleteMessageBatch(ctx, input, optFns...)
+}
+
+func (r *regionalSQS) ChangeMessageVisibility(ctx context.Context, input *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	return r.client(input.QueueUrl).ChangeMessageVisibility(ctx, input, optFns...)
+}
+
+func (r *regionalSQS) ChangeMessageVisibilityBatch(ctx context.Context, input *sqs.ChangeMessageVisibilityBatchInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	return r.client(input.QueueUrl).ChangeMessageVisibilityBatch(ctx, input, optFns...)
+}
+
+func (r *regionalSQS) SendMessage(ctx context.Context, input *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	return r.client(input.QueueUrl).SendMessage(ctx, input, optFns...)
+}
+
+func (r *regionalSQS) GetQueueAttributes(ctx context.Context, input *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	return r.client(input.QueueUrl).GetQueueAttributes(ctx, input, optFns...)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// regionSQS records the queues it was called for.
+type regionSQS struct {
+	sqsAPI
+	queues []string
+}
+
+func (r *regionSQS) ReceiveMessage(ctx context.Context, input *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	r.queues = append(r.queues, aws.ToString(input.QueueUrl))
+	return &sqs.ReceiveMessageOutput{}, nil
+}
+
+func (r *regionSQS) DeleteMessageBatch(ctx context.Context, input *sqs.DeleteMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageBatchOutput, error) {
+	r.queues = append(r.queues, aws.ToString(input.QueueUrl))
+	return &sqs.DeleteMessageBatchOutput{}, nil
+}
+
+func TestRegionalSQS(t *testing.T) {
+	primary, secondary := &regionSQS{}, &regionSQS{}
+	client := &regionalSQS{fallback: primary, regions: map[string]sqsAPI{"us-west-2": secondary}}
+
+	client.ReceiveMessage(context.Background(), &sqs.ReceiveMessageInput{
+		QueueUrl: aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/orders"),
+	})
+	client.ReceiveMessage(context.Background(), &sqs.ReceiveMessageInput{
+		QueueUrl: aws.String("https://sqs.us-west-2.amazonaws.com/123456789012/orders"),
+	})
+	client.DeleteMessageBatch(context.Background(), &sqs.DeleteMessageBatchInput{
+		QueueUrl: aws.String("https://sqs.us-west-2.amazonaws.com/123456789012/orders"),
+	})
+	client.DeleteMessageBatch(context.Background(), &sqs.DeleteMessageBatchInput{
+		QueueUrl: aws.String("http://localhost:9324/queue/orders"),
+	})
+
+	assert.Equal(t, []string{
+		"https://sqs.us-east-1.amazonaws.com/123456789012/orders",
+		"http://localhost:9324/queue/orders",
+	}, primary.queues)
+	assert.Equal(t, []string{
+		"https://sqs.us-west-2.amazonaws.com/123456789012/orders",
+		"https://sqs.us-west-2.amazonaws.com/123456789012/orders",
+	}, secondary.queues)
+}
+
+func TestNewRegionalSQS(t *testing.T) {
+	awsCfg := aws.Config{}
+	logger := log.WithFields(log.Fields{})
+	c := &config{
+		QueueRegion: "us-east-1",
+		QueueURLs:   []string{"https://sqs.us-east-1.amazonaws.com/123456789012/orders"},
+	}
+
+	_, ok := newRegionalSQS(c, awsCfg, logger).(*sqs.Client)
+	assert.True(t, ok)
+
+	c.QueueURLs = append(c.QueueURLs,
+		"https://sqs.us-west-2.amazonaws.com/123456789012/orders",
+		"https://sqs.eu-west-1.amazonaws.com/123456789012/orders",
+	)
+	client, ok := newRegionalSQS(c, awsCfg, logger).(*regionalSQS)
+	if assert.True(t, ok) {
+		assert.Len(t, client.regions, 2)
+		assert.Equal(t, "us-west-2", client.regions["us-west-2"].(*sqs.Client).Options().Region)
+	}
+
+	// Every call goes to the endpoint set by SQSD_AWS_ENDPOINT.
+	c.AWSEndpoint = "http://localhost:9324"
+	_, ok = newRegionalSQS(c, awsCfg, logger).(*sqs.Client)
+	assert.True(t, ok)
+}
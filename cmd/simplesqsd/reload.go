@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/fterrag/simple-sqsd/supervisor"
+	log "github.com/sirupsen/logrus"
+)
+
+// readHTTPURLFile returns the worker URL stored in the file at path.
+func readHTTPURLFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	url := strings.TrimSpace(string(data))
+	if len(url) == 0 {
+		return "", errors.New("file is empty")
+	}
+
+	return url, nil
+}
+
+// reloadHTTPURLOnHangup switches s to the URL in the file at path every time
+// the process receives SIGHUP, until done is closed.
+func reloadHTTPURLOnHangup(s *supervisor.Supervisor, path string, done <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-hup:
+			url, err := readHTTPURLFile(path)
+			if err != nil {
+				log.Errorf("Error while reloading the HTTP URL from %s: %s", path, err)
+				continue
+			}
+
+			s.SwitchHTTPURL(url)
+		}
+	}
+}
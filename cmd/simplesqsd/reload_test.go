@@ -0,0 +1,27 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadHTTPURLFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "http-url")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	_, err = readHTTPURLFile(f.Name())
+	assert.EqualError(t, err, "file is empty")
+
+	assert.NoError(t, ioutil.WriteFile(f.Name(), []byte("http://worker-v2:8080/\n"), 0644))
+
+	url, err := readHTTPURLFile(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, "http://worker-v2:8080/", url)
+
+	_, err = readHTTPURLFile(f.Name() + ".missing")
+	assert.Error(t, err)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/fterrag/simple-sqsd/supervisor"
+	log "github.com/sirupsen/logrus"
+)
+
+type probe interface {
+	Healthy() bool
+	Ready() bool
+}
+
+type acker interface {
+	Ack(messageID string) error
+}
+
+// newServerHandler serves /health, which succeeds while the workers of s are
+// running, /ready, which succeeds while s is ready to process messages, and
+// metrics on /metrics. /healthz and /readyz are aliases following the
+// Kubernetes naming. When acks is not nil, it also serves POST
+// /ack/{messageId}, which acknowledges a message accepted by the worker with
+// acks, to requests carrying ackToken as a bearer token.
+func newServerHandler(s probe, acks acker, ackToken string, metrics http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", probeHandlerFunc(s.Healthy))
+	mux.HandleFunc("/healthz", probeHandlerFunc(s.Healthy))
+	mux.HandleFunc("/ready", probeHandlerFunc(s.Ready))
+	mux.HandleFunc("/readyz", probeHandlerFunc(s.Ready))
+	if acks != nil {
+		mux.Handle("/ack/", adminAuth(ackHandlerFunc(acks), ackToken))
+	}
+	mux.Handle("/metrics", metrics)
+
+	return mux
+}
+
+// newMetricsHandler serves metrics on /metrics alone, for SQSD_METRICS_ADDR.
+func newMetricsHandler(metrics http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+
+	return mux
+}
+
+// newPprofHandler serves the runtime profiles of net/http/pprof under
+// /debug/pprof/, for SQSD_PPROF_ADDR.
+func newPprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return mux
+}
+
+func probeHandlerFunc(check func() bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !check() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func ackHandlerFunc(acks acker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := strings.TrimPrefix(r.URL.Path, "/ack/")
+		if len(id) == 0 || strings.Contains(id, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		if err := acks.Ack(id); err == supervisor.ErrNotAwaitingAck {
+			http.Error(w, "Message is not awaiting an acknowledgment", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.WithField("messageId", id).Errorf("Error while acknowledging the message: %s", err)
+			http.Error(w, "Message could not be deleted", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// serve listens on addr and serves handler in the background.
+func serve(addr string, handler http.Handler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := http.Serve(ln, handler); err != nil {
+			log.Errorf("Error while serving on %s: %s", addr, err)
+		}
+	}()
+
+	return nil
+}
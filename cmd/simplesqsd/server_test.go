@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fterrag/simple-sqsd/supervisor"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeProbe struct {
+	healthy atomic.Bool
+	ready   atomic.Bool
+}
+
+func (p *fakeProbe) Healthy() bool { return p.healthy.Load() }
+func (p *fakeProbe) Ready() bool   { return p.ready.Load() }
+
+// fakeAcker acknowledges the messages in pending, and fails to for the
+// others in failing.
+type fakeAcker struct {
+	pending map[string]bool
+	failing map[string]bool
+}
+
+func (a *fakeAcker) Ack(messageID string) error {
+	if a.failing[messageID] {
+		return errors.New("delete failed")
+	}
+	if !a.pending[messageID] {
+		return supervisor.ErrNotAwaitingAck
+	}
+
+	delete(a.pending, messageID)
+	return nil
+}
+
+func TestServerHandler(t *testing.T) {
+	p := &fakeProbe{}
+	metrics := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	ts := httptest.NewServer(newServerHandler(p, nil, "", metrics))
+	defer ts.Close()
+
+	status := func(path string) int {
+		res, err := http.Get(ts.URL + path)
+		if !assert.NoError(t, err) {
+			return 0
+		}
+		res.Body.Close()
+
+		return res.StatusCode
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, status("/health"))
+	assert.Equal(t, http.StatusServiceUnavailable, status("/ready"))
+
+	p.healthy.Store(true)
+	assert.Equal(t, http.StatusOK, status("/health"))
+	assert.Equal(t, http.StatusOK, status("/healthz"))
+	assert.Equal(t, http.StatusServiceUnavailable, status("/ready"))
+	assert.Equal(t, http.StatusServiceUnavailable, status("/readyz"))
+
+	p.ready.Store(true)
+	assert.Equal(t, http.StatusOK, status("/ready"))
+	assert.Equal(t, http.StatusOK, status("/readyz"))
+
+	assert.Equal(t, http.StatusTeapot, status("/metrics"))
+	assert.Equal(t, http.StatusNotFound, status("/"))
+}
+
+func TestServerHandlerAck(t *testing.T) {
+	acks := &fakeAcker{pending: map[string]bool{"m1": true}, failing: map[string]bool{"m2": true}}
+	ts := httptest.NewServer(newServerHandler(&fakeProbe{}, acks, "s3cr3t", http.NotFoundHandler()))
+	defer ts.Close()
+
+	status := func(method string, path string) int {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		if !assert.NoError(t, err) {
+			return 0
+		}
+		req.Header.Set("Authorization", "Bearer s3cr3t")
+		res, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			return 0
+		}
+		res.Body.Close()
+
+		return res.StatusCode
+	}
+
+	assert.Equal(t, http.StatusMethodNotAllowed, status(http.MethodGet, "/ack/m1"))
+	assert.Equal(t, http.StatusNoContent, status(http.MethodPost, "/ack/m1"))
+	assert.Equal(t, http.StatusNotFound, status(http.MethodPost, "/ack/m1"))
+	assert.Equal(t, http.StatusServiceUnavailable, status(http.MethodPost, "/ack/m2"))
+	assert.Equal(t, http.StatusNotFound, status(http.MethodPost, "/ack/"))
+	assert.Equal(t, http.StatusNotFound, status(http.MethodPost, "/ack/m1/extra"))
+}
+
+func TestServerHandlerAckAuth(t *testing.T) {
+	acks := &fakeAcker{pending: map[string]bool{"m1": true}}
+	ts := httptest.NewServer(newServerHandler(&fakeProbe{}, acks, "s3cr3t", http.NotFoundHandler()))
+	defer ts.Close()
+
+	for _, authorization := range []string{"", "Bearer", "Bearer wrong"} {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/ack/m1", nil)
+		assert.NoError(t, err)
+		if len(authorization) > 0 {
+			req.Header.Set("Authorization", authorization)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if assert.NoError(t, err) {
+			res.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, res.StatusCode, authorization)
+		}
+	}
+	assert.True(t, acks.pending["m1"])
+
+	ts = httptest.NewServer(newServerHandler(&fakeProbe{}, nil, "", http.NotFoundHandler()))
+	defer ts.Close()
+
+	res, err := http.Post(ts.URL+"/ack/m1", "", nil)
+	if assert.NoError(t, err) {
+		res.Body.Close()
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	metrics := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	ts := httptest.NewServer(newMetricsHandler(metrics))
+	defer ts.Close()
+
+	for path, expected := range map[string]int{
+		"/metrics": http.StatusTeapot,
+		"/health":  http.StatusNotFound,
+	} {
+		res, err := http.Get(ts.URL + path)
+		if assert.NoError(t, err) {
+			res.Body.Close()
+			assert.Equal(t, expected, res.StatusCode, path)
+		}
+	}
+}
+
+func TestPprofHandler(t *testing.T) {
+	ts := httptest.NewServer(newPprofHandler())
+	defer ts.Close()
+
+	for path, expected := range map[string]int{
+		"/debug/pprof/":                     http.StatusOK,
+		"/debug/pprof/goroutine?debug=1":    http.StatusOK,
+		"/debug/pprof/heap":                 http.StatusOK,
+		"/debug/pprof/cmdline":              http.StatusOK,
+		"/metrics":                          http.StatusNotFound,
+		"/debug/pprof/no-such-profile-name": http.StatusNotFound,
+	} {
+		res, err := http.Get(ts.URL + path)
+		if assert.NoError(t, err) {
+			res.Body.Close()
+			assert.Equal(t, expected, res.StatusCode, path)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	assert.Error(t, serve("invalid:address:1", http.NotFoundHandler()))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"time"
+)
+
+// defaultServiceName is the name the daemon is installed as when
+// SQSD_SERVICE_NAME is not set.
+const defaultServiceName = "simplesqsd"
+
+// serviceReadyInterval is the wait between checks of the readiness of the
+// workers before the service manager is told the daemon is ready.
+const serviceReadyInterval = 100 * time.Millisecond
+
+// serviceNamePattern matches the names the daemon can be installed as, valid
+// both as a systemd unit name and as a Windows service name.
+var serviceNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.@-]+$`)
+
+// serviceStatus tells the service manager running the daemon, if any, about
+// its state: systemd through sd_notify, or the Windows service control
+// manager.
+type serviceStatus interface {
+	// running reports that the workers started. The daemon is reported ready
+	// once p is, and alive to the watchdog while p is healthy.
+	running(p probe)
+	// stopping reports that the daemon is shutting down.
+	stopping()
+	// stopped reports that the daemon stopped, before the process exits.
+	stopped()
+}
+
+// serviceStopper tells status that the daemon is stopping when the stopper it
+// wraps is shut down.
+type serviceStopper struct {
+	stopper
+	status serviceStatus
+}
+
+func (s serviceStopper) Shutdown() {
+	s.status.stopping()
+	s.stopper.Shutdown()
+}
+
+// waitReady waits for p to be ready and reports whether it became ready
+// before done was closed.
+func waitReady(p probe, done <-chan struct{}) bool {
+	ticker := time.NewTicker(serviceReadyInterval)
+	defer ticker.Stop()
+
+	for !p.Ready() {
+		select {
+		case <-done:
+			return false
+		case <-ticker.C:
+		}
+	}
+
+	return true
+}
+
+// serviceName returns the name the daemon is installed as with the variables
+// set by flags, or in the environment.
+func serviceName(flags map[string]string) (string, error) {
+	name, ok := flags["SQSD_SERVICE_NAME"]
+	if !ok {
+		name = os.Getenv("SQSD_SERVICE_NAME")
+	}
+	if len(name) == 0 {
+		name = defaultServiceName
+	}
+
+	if !serviceNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid SQSD_SERVICE_NAME %q: must only contain letters, digits, -, _, . and @", name)
+	}
+
+	return name, nil
+}
+
+func printServiceUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: simplesqsd install [--name=value]...")
+	fmt.Fprintln(w, "       simplesqsd uninstall [--service-name=NAME]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "install registers the daemon as a systemd unit, or a Windows service,")
+	fmt.Fprintln(w, "named SQSD_SERVICE_NAME and running \"simplesqsd run\" with the same")
+	fmt.Fprintln(w, "flags. uninstall removes it.")
+}
+
+// runServiceCommand runs the install or uninstall subcommand with args and
+// returns the exit code of the process.
+func runServiceCommand(command string, args []string) int {
+	flags, err := parseFlags(args)
+	if err == flag.ErrHelp {
+		printServiceUsage(os.Stdout)
+		return 0
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		printServiceUsage(os.Stderr)
+		return 2
+	}
+
+	name, err := serviceName(flags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if command == "uninstall" {
+		err = uninstallService(name)
+	} else {
+		err = installService(name, args)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error while running %s: %s\n", command, err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,197 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// systemdUnitDir is where install writes the unit of the daemon.
+var systemdUnitDir = "/etc/systemd/system"
+
+// systemdWatchdogSec is the WatchdogSec of the installed unit.
+const systemdWatchdogSec = 30
+
+// systemdNotifier reports the state of the daemon to systemd with sd_notify,
+// when it runs as a unit of Type=notify. Every message is dropped otherwise.
+type systemdNotifier struct {
+	// socket is the NOTIFY_SOCKET set by systemd.
+	socket string
+	// watchdog is the WATCHDOG_USEC set by systemd, 0 without a watchdog.
+	watchdog time.Duration
+
+	once sync.Once
+	done chan struct{}
+}
+
+// startService returns the serviceStatus of the daemon, notifying systemd
+// when it runs the daemon. signals is only used on Windows.
+func startService(name string, signals chan<- os.Signal) serviceStatus {
+	n := &systemdNotifier{
+		socket:   os.Getenv("NOTIFY_SOCKET"),
+		watchdog: systemdWatchdog(),
+		done:     make(chan struct{}),
+	}
+	if len(n.socket) > 0 {
+		log.WithField("service", name).Debug("Notifying systemd")
+	}
+
+	return n
+}
+
+// systemdWatchdog returns the interval the watchdog of systemd expects to be
+// pinged within, or 0 when it doesn't watch this process.
+func systemdWatchdog() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); len(pid) > 0 && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// notify sends state to systemd, e.g. "READY=1".
+func (n *systemdNotifier) notify(state string) {
+	if len(n.socket) == 0 {
+		return
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: n.socket, Net: "unixgram"})
+	if err != nil {
+		log.Errorf("Error while notifying systemd: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Errorf("Error while notifying systemd: %s", err)
+	}
+}
+
+func (n *systemdNotifier) running(p probe) {
+	if len(n.socket) == 0 {
+		return
+	}
+
+	go func() {
+		if waitReady(p, n.done) {
+			n.notify("READY=1\nSTATUS=Processing messages")
+		}
+	}()
+
+	if n.watchdog > 0 {
+		go n.pingWatchdog(p)
+	}
+}
+
+// pingWatchdog pings the watchdog of systemd twice per interval while the
+// workers of p are running, so that systemd restarts the daemon when they
+// stopped or the process hangs.
+func (n *systemdNotifier) pingWatchdog(p probe) {
+	ticker := time.NewTicker(n.watchdog / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+			if p.Healthy() {
+				n.notify("WATCHDOG=1")
+			}
+		}
+	}
+}
+
+func (n *systemdNotifier) stopping() {
+	n.notify("STOPPING=1\nSTATUS=Processing in-flight messages")
+}
+
+func (n *systemdNotifier) stopped() {
+	n.once.Do(func() { close(n.done) })
+}
+
+// systemdUnit returns the unit running executable with args under the
+// service name.
+func systemdUnit(name string, executable string, args []string) string {
+	execStart := []string{systemdQuote(executable), "run"}
+	for _, arg := range args {
+		execStart = append(execStart, systemdQuote(arg))
+	}
+
+	// KillMode=mixed only sends SIGTERM to the daemon, which stops the app
+	// of SQSD_APP_COMMAND once in-flight messages are processed.
+	return fmt.Sprintf(`[Unit]
+Description=simplesqsd (%s)
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=notify
+NotifyAccess=main
+ExecStart=%s
+Restart=on-failure
+WatchdogSec=%d
+KillMode=mixed
+
+[Install]
+WantedBy=multi-user.target
+`, name, strings.Join(execStart, " "), systemdWatchdogSec)
+}
+
+// systemdQuote quotes arg for ExecStart, escaping the specifiers and
+// variables systemd would expand.
+func systemdQuote(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	arg = strings.ReplaceAll(arg, "$", "$$")
+
+	if len(arg) > 0 && !strings.ContainsAny(arg, " \t\n\"'\\;") {
+		return arg
+	}
+
+	return strconv.Quote(arg)
+}
+
+// installService writes the systemd unit running the daemon with args.
+func installService(name string, args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(systemdUnitDir, name+".service")
+	if err := os.WriteFile(path, []byte(systemdUnit(name, executable, args)), 0644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Installed %s. Start it with:\n", path)
+	fmt.Printf("    systemctl daemon-reload && systemctl enable --now %s\n", name)
+
+	return nil
+}
+
+// uninstallService removes the systemd unit of the daemon.
+func uninstallService(name string) error {
+	path := filepath.Join(systemdUnitDir, name+".service")
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed %s. Reload systemd with:\n", path)
+	fmt.Println("    systemctl daemon-reload")
+
+	return nil
+}
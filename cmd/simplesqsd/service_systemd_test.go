@@ -0,0 +1,134 @@
+//go:build !windows
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSystemdNotifier(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	// Unix socket paths are limited to about a hundred bytes, which the
+	// directories of t.TempDir can exceed.
+	dir, err := os.MkdirTemp("", "sd")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	received := make(chan string, 100)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			received <- string(buf[:n])
+		}
+	}()
+	// next returns the next state sent, skipping watchdog pings unless ping.
+	next := func(ping bool) string {
+		for {
+			select {
+			case state := <-received:
+				if ping || state != "WATCHDOG=1" {
+					return state
+				}
+			case <-time.After(2 * time.Second):
+				return ""
+			}
+		}
+	}
+
+	t.Setenv("NOTIFY_SOCKET", socket)
+	t.Setenv("WATCHDOG_USEC", "40000")
+	n := startService("simplesqsd", nil).(*systemdNotifier)
+	assert.Equal(t, 40*time.Millisecond, n.watchdog)
+
+	p := &fakeProbe{}
+	n.running(p)
+
+	// The watchdog is only pinged while the workers are running, and the
+	// daemon reported ready once they are ready.
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, received)
+	p.healthy.Store(true)
+	assert.Equal(t, "WATCHDOG=1", next(true))
+	p.ready.Store(true)
+	assert.Equal(t, "READY=1\nSTATUS=Processing messages", next(false))
+
+	n.stopping()
+	assert.Equal(t, "STOPPING=1\nSTATUS=Processing in-flight messages", next(false))
+
+	n.stopped()
+	time.Sleep(50 * time.Millisecond)
+	for len(received) > 0 {
+		<-received
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Empty(t, received)
+}
+
+func TestSystemdWatchdog(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	assert.Equal(t, time.Duration(0), systemdWatchdog())
+
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	assert.Equal(t, 30*time.Second, systemdWatchdog())
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	assert.Equal(t, time.Duration(0), systemdWatchdog())
+}
+
+func TestSystemdUnit(t *testing.T) {
+	unit := systemdUnit("orders", "/usr/local/bin/simplesqsd", []string{
+		"--queue-url=https://sqs.us-east-1.amazonaws.com/123456789012/orders",
+		"--http-headers=X-Team: payments",
+		"--http-url=http://localhost:8080/$job/100%",
+	})
+
+	assert.Contains(t, unit, "Description=simplesqsd (orders)\n")
+	assert.Contains(t, unit, "Type=notify\n")
+	assert.Contains(t, unit, `ExecStart=/usr/local/bin/simplesqsd run `+
+		`--queue-url=https://sqs.us-east-1.amazonaws.com/123456789012/orders `+
+		`"--http-headers=X-Team: payments" `+
+		`--http-url=http://localhost:8080/$$job/100%%`+"\n")
+}
+
+func TestInstallService(t *testing.T) {
+	dir := t.TempDir()
+	defer func(previous string) { systemdUnitDir = previous }(systemdUnitDir)
+	systemdUnitDir = dir
+
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	os.Stderr = os.Stdout
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+
+	assert.Equal(t, 0, runServiceCommand("install", []string{"--service-name=orders", "--queue-url=https://queue.url/orders"}))
+	b, err := os.ReadFile(filepath.Join(dir, "orders.service"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), " run --service-name=orders --queue-url=https://queue.url/orders\n")
+
+	assert.Equal(t, 0, runServiceCommand("uninstall", []string{"--service-name=orders"}))
+	assert.NoFileExists(t, filepath.Join(dir, "orders.service"))
+	assert.Equal(t, 1, runServiceCommand("uninstall", []string{"--service-name=orders"}))
+}
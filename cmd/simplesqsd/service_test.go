@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceName(t *testing.T) {
+	t.Setenv("SQSD_SERVICE_NAME", "")
+
+	name, err := serviceName(map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, "simplesqsd", name)
+
+	name, err = serviceName(map[string]string{"SQSD_SERVICE_NAME": "orders@us-east-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "orders@us-east-1", name)
+
+	t.Setenv("SQSD_SERVICE_NAME", "../orders")
+	_, err = serviceName(map[string]string{})
+	assert.Error(t, err)
+}
+
+func TestServiceStopper(t *testing.T) {
+	stopper := newFakeStopper()
+	status := &recordingService{}
+
+	serviceStopper{stopper, status}.Shutdown()
+	assert.True(t, status.isStopping)
+	assert.Eventually(t, func() bool {
+		select {
+		case <-stopper.shutdown:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+}
+
+// recordingService records the states it is told about.
+type recordingService struct {
+	isStopping bool
+}
+
+func (s *recordingService) running(probe) {}
+func (s *recordingService) stopping()     { s.isStopping = true }
+func (s *recordingService) stopped()      {}
@@ -0,0 +1,163 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// windowsService reports the state of the daemon to the Windows service
+// control manager, and turns its stop requests into signals.
+type windowsService struct {
+	signals chan<- os.Signal
+
+	readyOnce   sync.Once
+	ready       chan struct{}
+	stoppedOnce sync.Once
+	stop        chan struct{}
+	// done is closed once the control manager was told the service stopped.
+	done chan struct{}
+}
+
+// noService is the serviceStatus of a daemon not run by a service manager.
+type noService struct{}
+
+func (noService) running(probe) {}
+func (noService) stopping()     {}
+func (noService) stopped()      {}
+
+// startService returns the serviceStatus of the daemon, connecting to the
+// service control manager when it runs the daemon as the service name. Stop
+// and shutdown requests are sent to signals as SIGTERM.
+func startService(name string, signals chan<- os.Signal) serviceStatus {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		log.Errorf("Error while detecting the Windows service: %s", err)
+	}
+	if !isService {
+		return noService{}
+	}
+
+	w := &windowsService{
+		signals: signals,
+		ready:   make(chan struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(w.done)
+
+		if err := svc.Run(name, w); err != nil {
+			log.Errorf("Error while running the Windows service: %s", err)
+		}
+	}()
+
+	return w
+}
+
+// Execute reports the daemon starting, running once ready and stopping once
+// asked to, until it stopped.
+func (w *windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	const accepted = svc.AcceptStop | svc.AcceptShutdown
+
+	changes <- svc.Status{State: svc.StartPending}
+
+	ready := w.ready
+	for {
+		select {
+		case <-ready:
+			changes <- svc.Status{State: svc.Running, Accepts: accepted}
+			ready = nil
+		case <-w.stop:
+			changes <- svc.Status{State: svc.StopPending}
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				changes <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+
+				select {
+				case w.signals <- syscall.SIGTERM:
+				default:
+				}
+			}
+		}
+	}
+}
+
+func (w *windowsService) running(p probe) {
+	go func() {
+		if waitReady(p, w.stop) {
+			w.readyOnce.Do(func() { close(w.ready) })
+		}
+	}()
+}
+
+func (w *windowsService) stopping() {}
+
+func (w *windowsService) stopped() {
+	w.stoppedOnce.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+// installService creates the Windows service running the daemon with args,
+// started automatically.
+func installService(name string, args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.CreateService(name, executable, mgr.Config{
+		DisplayName: fmt.Sprintf("simplesqsd (%s)", name),
+		StartType:   mgr.StartAutomatic,
+	}, append([]string{"run"}, args...)...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	fmt.Printf("Installed the %s service. Start it with:\n", name)
+	fmt.Printf("    sc.exe start %s\n", name)
+
+	return nil
+}
+
+// uninstallService deletes the Windows service of the daemon.
+func uninstallService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if err := s.Delete(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed the %s service.\n", name)
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/fterrag/simple-sqsd/supervisor"
+	log "github.com/sirupsen/logrus"
+)
+
+type stopper interface {
+	Shutdown()
+	Wait()
+}
+
+// waitForShutdown waits for s to stop. The first signal shuts it down once the
+// messages in flight are processed; a second signal, or timeout elapsing when
+// it is positive, stops waiting for them. It reports whether shutdown was
+// forced.
+func waitForShutdown(s stopper, signals <-chan os.Signal, timeout time.Duration) bool {
+	stopped := make(chan struct{})
+	go func() {
+		s.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return false
+	case sig := <-signals:
+		log.Infof("Received %s, shutting down after in-flight messages are processed", sig)
+	}
+
+	s.Shutdown()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		expired = timer.C
+	}
+
+	select {
+	case <-stopped:
+		return false
+	case sig := <-signals:
+		log.Warnf("Received %s, abandoning in-flight messages", sig)
+		return true
+	case <-expired:
+		log.Warnf("Workers did not stop within %s, abandoning in-flight messages", timeout)
+		return true
+	}
+}
+
+// logShutdownReport logs a summary of report.
+func logShutdownReport(logger *log.Entry, report supervisor.Report) {
+	var failed int64
+	for _, n := range report.FailureReasons {
+		failed += n
+	}
+
+	logger.WithFields(log.Fields{
+		"uptimeSeconds": report.UptimeSeconds,
+		"received":      report.Received,
+		"processed":     report.Processed,
+		"failed":        failed,
+		"deleted":       report.Deleted,
+		"abandoned":     len(report.Abandoned),
+	}).Info("Shutdown summary")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/fterrag/simple-sqsd/supervisor"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStopper struct {
+	shutdown chan struct{}
+	drained  chan struct{}
+}
+
+func newFakeStopper() *fakeStopper {
+	return &fakeStopper{shutdown: make(chan struct{}), drained: make(chan struct{})}
+}
+
+func (f *fakeStopper) Shutdown() {
+	close(f.shutdown)
+}
+
+func (f *fakeStopper) Wait() {
+	<-f.drained
+}
+
+func TestWaitForShutdown(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	s := newFakeStopper()
+	signals := make(chan os.Signal, 1)
+	forced := make(chan bool)
+	go func() {
+		forced <- waitForShutdown(s, signals, 0)
+	}()
+
+	signals <- syscall.SIGTERM
+	<-s.shutdown
+	close(s.drained)
+
+	assert.False(t, <-forced)
+}
+
+func TestWaitForShutdownForced(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	s := newFakeStopper()
+	signals := make(chan os.Signal, 1)
+	forced := make(chan bool)
+	go func() {
+		forced <- waitForShutdown(s, signals, 0)
+	}()
+
+	signals <- syscall.SIGTERM
+	<-s.shutdown
+	signals <- syscall.SIGINT
+
+	assert.True(t, <-forced)
+}
+
+func TestWaitForShutdownTimeout(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	s := newFakeStopper()
+	signals := make(chan os.Signal, 1)
+	forced := make(chan bool)
+	go func() {
+		forced <- waitForShutdown(s, signals, 10*time.Millisecond)
+	}()
+
+	signals <- syscall.SIGTERM
+	<-s.shutdown
+
+	assert.True(t, <-forced)
+}
+
+func TestLogShutdownReport(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+
+	logShutdownReport(log.NewEntry(logger), supervisor.Report{
+		Received:  10,
+		Processed: 9,
+		Deleted:   6,
+		FailureReasons: map[supervisor.FailureReason]int64{
+			supervisor.FailureHTTP5xx:     2,
+			supervisor.FailureHTTPTimeout: 1,
+		},
+	})
+
+	if assert.NotNil(t, hook.LastEntry()) {
+		assert.Equal(t, "Shutdown summary", hook.LastEntry().Message)
+		assert.Equal(t, int64(9), hook.LastEntry().Data["processed"])
+		assert.Equal(t, int64(3), hook.LastEntry().Data["failed"])
+	}
+}
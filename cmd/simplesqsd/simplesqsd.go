@@ -1,99 +1,263 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
-	"errors"
+	"flag"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
-	"strconv"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/smithy-go/logging"
 	"github.com/fterrag/simple-sqsd/supervisor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http/httpproxy"
+	"google.golang.org/grpc/credentials"
 )
 
-type config struct {
-	QueueRegion      string
-	QueueURL         string
-	QueueMaxMessages int
-	QueueWaitTime    int
+func main() {
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "redrive":
+			os.Exit(runRedrive(args[1:]))
+		case "install", "uninstall":
+			os.Exit(runServiceCommand(args[0], args[1:]))
+		case "run":
+			// run is what installed services start the daemon with.
+			args = args[1:]
+		}
+	}
 
-	HTTPMaxConns    int
-	HTTPURL         string
-	HTTPContentType string
-	HTTPTimeout     int
+	flags, err := parseFlags(args)
+	if err == flag.ErrHelp {
+		printUsage(os.Stdout)
+		os.Exit(0)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
 
-	AWSEndpoint    string
-	HTTPHMACHeader string
-	HMACSecretKey  []byte
+	lookup := overlayLookup(mapLookup(flags), os.LookupEnv)
+	configs, envs, err := loadConfigs(lookup, flags)
+	if err != nil {
+		fmt.Fprint(os.Stderr, err)
+		os.Exit(1)
+	}
 
-	HTTPHealthPath        string
-	HTTPHealthWait        int
-	HTTPHealthInterval    int
-	HTTPHealthSucessCount int
+	// Settings of the whole process are taken from the first worker.
+	c := configs[0]
 
-	SQSHTTPTimeout int
-	SSLVerify      bool
-}
+	configureLogging(c)
+	logEffectiveConfig(envs)
 
-func main() {
+	// The service manager is connected to first, as Windows expects services
+	// to connect to it right after they started. It asks the daemon to stop
+	// through signals.
+	signals := make(chan os.Signal, 2)
+	service := startService(c.ServiceName, signals)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	awsCfg, err := newAWSConfig(c, done)
+	if err != nil {
+		log.Fatalf("Error while loading the AWS configuration: %s", err)
+	}
+
+	if c.Dev {
+		log.Warn("Running in development mode")
+	}
+
+	if c.CreateQueue {
+		logger := log.WithField("awsEndpoint", c.AWSEndpoint)
+		if err := createDevQueues(context.Background(), sqs.NewFromConfig(awsCfg, sqsOptions(c, logger)), configs); err != nil {
+			log.Fatalf("Error while creating the queues: %s", err)
+		}
+	}
+
+	// The echo worker must be up before the supervisors check the health of
+	// their worker.
+	if c.Dev && len(c.DevEchoAddr) > 0 {
+		if err := serve(c.DevEchoAddr, newEchoWorkerHandler()); err != nil {
+			log.Fatalf("Error while starting the echo worker: %s", err)
+		}
+	}
+
+	// The app is started before the supervisors so that they only poll once
+	// it is healthy, and stopped after they processed the messages in flight.
+	var app *appProcess
+	if len(c.AppCommand) > 0 {
+		app, err = startApp(c.AppCommand, time.Duration(c.AppRestartMaxBackoff)*time.Second)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := app.waitHealthy(c.HTTPURL+c.HTTPHealthPath, time.Duration(c.AppHealthTimeout)*time.Second); err != nil {
+			app.stop(time.Duration(c.AppStopTimeout) * time.Second)
+			log.Fatal(err)
+		}
+		log.Info("The app is healthy")
+	}
+
+	discovered, err := resolveQueueURLs(awsCfg, configs)
+	if err != nil {
+		log.Fatalf("Error while discovering the queues: %s", err)
+	}
+	if len(discovered) > 0 {
+		log.WithField("queues", strings.Join(discovered, ",")).Info("Discovered queues")
+	}
 
-	c := &config{}
+	shared := newSharedResources(supervisor.NewMetrics(prometheus.DefaultRegisterer))
+	defer shared.close()
 
-	c.QueueRegion = os.Getenv("SQSD_QUEUE_REGION")
-	c.QueueURL = os.Getenv("SQSD_QUEUE_URL")
-	c.QueueMaxMessages = getEnvInt("SQSD_QUEUE_MAX_MSGS", 10)
-	c.QueueWaitTime = getEnvInt("SQSD_QUEUE_WAIT_TIME", 10)
+	// With a configuration file, SIGHUP reloads the whole configuration,
+	// the HTTP URL files among it. Settings of the whole process, such as
+	// SQSD_HEALTH_ADDR, are not reloaded.
+	_, reloadable := lookup("SQSD_CONFIG_FILE")
 
-	c.HTTPMaxConns = getEnvInt("SQSD_HTTP_MAX_CONNS", 25)
-	c.HTTPURL = os.Getenv("SQSD_HTTP_URL")
-	c.HTTPContentType = os.Getenv("SQSD_HTTP_CONTENT_TYPE")
+	newGroup := func(configs []*config, envs []*env) *supervisorGroup {
+		group := &supervisorGroup{}
+		for _, env := range envs {
+			group.configs = append(group.configs, env.effective())
+		}
+		for _, wc := range configs {
+			s := newSupervisor(wc, awsCfg, shared)
+			if len(wc.HTTPURLFile) > 0 && !reloadable {
+				go reloadHTTPURLOnHangup(s, wc.HTTPURLFile, done)
+			}
+
+			group.add(s, wc.NumWorkers)
+		}
+
+		return group
+	}
+
+	group := newReloadingGroup(newGroup(configs, envs), func() (*supervisorGroup, error) {
+		configs, envs, err := loadConfigs(lookup, flags)
+		if err != nil {
+			return nil, err
+		}
+		logEffectiveConfig(envs)
+
+		if _, err := resolveQueueURLs(awsCfg, configs); err != nil {
+			return nil, err
+		}
 
-	c.HTTPHealthPath = os.Getenv("SQSD_HTTP_HEALTH_PATH")
-	c.HTTPHealthWait = getEnvInt("SQSD_HTTP_HEALTH_WAIT", 5)
-	c.HTTPHealthInterval = getEnvInt("SQSD_HTTP_HEALTH_INTERVAL", 5)
-	c.HTTPHealthSucessCount = getEnvInt("SQSD_HTTP_HEALTH_SUCCESS_COUNT", 1)
-	c.HTTPTimeout = getEnvInt("SQSD_HTTP_TIMEOUT", 15)
+		return newGroup(configs, envs), nil
+	})
+	if reloadable {
+		go reloadOnHangup(group, done)
+	}
 
-	c.AWSEndpoint = os.Getenv("SQSD_AWS_ENDPOINT")
-	c.HTTPHMACHeader = os.Getenv("SQSD_HTTP_HMAC_HEADER")
-	c.HMACSecretKey = []byte(os.Getenv("SQSD_HMAC_SECRET_KEY"))
+	// Discovered queues are resolved again periodically, and the
+	// configuration reloaded when they change, e.g. after a queue was
+	// recreated.
+	if len(discovered) > 0 && c.QueueDiscoveryInterval > 0 {
+		discover := func() ([]string, error) {
+			configs, _, err := loadConfigs(lookup, flags)
+			if err != nil {
+				return nil, err
+			}
 
-	c.SQSHTTPTimeout = getEnvInt("SQSD_SQS_HTTP_TIMEOUT", 15)
-	c.SSLVerify = getenvBool("SQSD_HTTP_SSL_VERIFY", true)
+			return resolveQueueURLs(awsCfg, configs)
+		}
+		go watchQueueURLs(discover, group.reload, discovered, time.Duration(c.QueueDiscoveryInterval)*time.Second, done)
+	}
 
-	if len(c.QueueRegion) == 0 {
-		log.Fatal("SQSD_QUEUE_REGION cannot be empty")
+	if reloaders := shared.certReloaders(); len(reloaders) > 0 {
+		go reloadCertificatesOnHangup(reloaders, done)
 	}
 
-	if len(c.QueueURL) == 0 {
-		log.Fatal("SQSD_QUEUE_URL cannot be empty")
+	// Messages are only acknowledged by the workers of mappings configured
+	// with SQSD_ASYNC_ACK when the server starts.
+	var acks acker
+	if anyAsyncAck(configs) {
+		acks = group
+	}
+	if err := serve(c.HealthAddr, newServerHandler(group, acks, c.AdminToken, promhttp.Handler())); err != nil {
+		log.Fatalf("Error while starting the health server: %s", err)
 	}
 
-	if len(c.HTTPURL) == 0 {
-		log.Fatal("SQSD_HTTP_URL cannot be empty")
+	if len(c.MetricsAddr) > 0 {
+		if err := serve(c.MetricsAddr, newMetricsHandler(promhttp.Handler())); err != nil {
+			log.Fatalf("Error while starting the metrics server: %s", err)
+		}
 	}
 
-	log.SetFormatter(&log.JSONFormatter{})
+	if len(c.AdminAddr) > 0 {
+		if err := serve(c.AdminAddr, newAdminHandler(group.group, c.AdminToken)); err != nil {
+			log.Fatalf("Error while starting the admin server: %s", err)
+		}
+	}
 
-	logLevel := os.Getenv("LOG_LEVEL")
-	if len(logLevel) == 0 {
-		logLevel = "info"
+	if len(c.PprofAddr) > 0 {
+		if err := serve(c.PprofAddr, newPprofHandler()); err != nil {
+			log.Fatalf("Error while starting the pprof server: %s", err)
+		}
 	}
-	if parsedLevel, err := log.ParseLevel(logLevel); err == nil {
-		log.SetLevel(parsedLevel)
+
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	group.Start()
+	service.running(group)
+	forced := waitForShutdown(serviceStopper{group, service}, signals, time.Duration(c.ShutdownTimeout)*time.Second)
+
+	report := group.Report()
+	logShutdownReport(log.NewEntry(log.StandardLogger()), report)
+	if len(c.ShutdownReportFile) > 0 {
+		if err := report.WriteFile(c.ShutdownReportFile); err != nil {
+			log.Errorf("Error while writing the shutdown report: %s", err)
+		}
+	}
+
+	if app != nil {
+		app.stop(time.Duration(c.AppStopTimeout) * time.Second)
+	}
+	service.stopped()
+
+	if forced {
+		os.Exit(1)
+	}
+}
+
+// configureLogging sets the level and format of the standard logger from c.
+// Both were validated by loadConfig.
+func configureLogging(c *config) {
+	if c.LogFormat == "text" {
+		log.SetFormatter(&log.TextFormatter{})
 	} else {
-		log.Fatal(err)
+		log.SetFormatter(&log.JSONFormatter{})
 	}
 
+	level, _ := log.ParseLevel(c.LogLevel)
+	log.SetLevel(level)
+}
+
+// newSupervisor creates the supervisor of the queue to worker mapping
+// configured by c, waiting for the worker to be healthy first if
+// SQSD_HTTP_HEALTH_PATH is set.
+func newSupervisor(c *config, awsCfg aws.Config, shared *sharedResources) *supervisor.Supervisor {
 	logger := log.WithFields(log.Fields{
 		"queueRegion":  c.QueueRegion,
 		"queueUrl":     c.QueueURL,
 		"httpMaxConns": c.HTTPMaxConns,
+		"numWorkers":   c.NumWorkers,
 		"httpPath":     c.HTTPURL,
 	})
 
@@ -118,83 +282,452 @@ func main() {
 		log.Info("Health check succeeded. Starting message processing")
 	}
 
-	awsSess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
-
-	sqsHttpClient := &http.Client{
-		Timeout: time.Duration(c.SQSHTTPTimeout) * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConns:        c.HTTPMaxConns,
-			MaxIdleConnsPerHost: c.HTTPMaxConns,
-		},
-	}
-	sqsConfig := aws.NewConfig().
-		WithRegion(c.QueueRegion).
-		WithHTTPClient(sqsHttpClient)
+	sqsSvc := newRegionalSQS(c, awsCfg, logger)
 
-	if len(c.AWSEndpoint) > 0 {
-		sqsConfig.WithEndpoint(c.AWSEndpoint)
+	// Without SQSD_QUEUE_VISIBILITY_TIMEOUT, messages stay invisible for the
+	// default visibility timeout of their queue.
+	var visibilityTimeouts map[string]time.Duration
+	if c.VisibilityTimeout == 0 {
+		visibilityTimeouts = queueVisibilityTimeouts(sqsSvc, c.QueueURLs)
 	}
 
-	sqsSvc := sqs.New(awsSess, sqsConfig)
-
 	wConf := supervisor.WorkerConfig{
-		QueueURL:         c.QueueURL,
+		QueueURL:         c.QueueURLs[0],
+		QueueURLs:        c.QueueURLs,
+		QueueSchedule:    supervisor.QueueSchedule(c.QueueSchedule),
+		Queues:           c.Queues,
 		QueueMaxMessages: c.QueueMaxMessages,
 		QueueWaitTime:    c.QueueWaitTime,
+		StartupDelay:     time.Duration(c.StartupDelay) * time.Second,
+
+		VisibilityTimeout:        time.Duration(c.VisibilityTimeout) * time.Second,
+		QueueVisibilityTimeouts:  visibilityTimeouts,
+		VisibilityDeadline:       c.VisibilityDeadline,
+		VisibilityDeadlineMargin: time.Duration(c.VisibilityDeadlineMargin) * time.Second,
+
+		ReceiveAttributeNames:        c.ReceiveAttributeNames,
+		ReceiveMessageAttributeNames: c.ReceiveMessageAttributeNames,
+		ReceiveRequestAttemptID:      c.FIFOReceiveAttemptID,
+
+		VisibilityExtensionInterval: time.Duration(c.VisibilityExtensionInterval) * time.Second,
+		VisibilityMax:               time.Duration(c.VisibilityMax) * time.Second,
 
 		HTTPURL:         c.HTTPURL,
 		HTTPContentType: c.HTTPContentType,
+		HTTPTimeout:     time.Duration(c.HTTPTimeout) * time.Second,
+
+		HTTPMaxRetries:      c.HTTPMaxRetries,
+		HTTPRetryBackoff:    time.Duration(c.HTTPRetryBackoff) * time.Millisecond,
+		HTTPRetryMaxBackoff: time.Duration(c.HTTPRetryMaxBackoff) * time.Millisecond,
+		RetryAfterMax:       time.Duration(c.HTTPRetryAfterMax) * time.Second,
+
+		HTTPAccept:       c.HTTPAccept,
+		HTTPAcceptPolicy: supervisor.ContentTypePolicy(c.HTTPAcceptPolicy),
+
+		HTTPRetryHeader: c.HTTPRetryHeader,
+
+		HTTPMaxResponseBody: int64(c.HTTPMaxResponseBody),
+		HTTPErrorBodyLimit:  int64(c.HTTPErrorBodyLimit),
+
+		Routes: c.Routes,
+
+		HTTPPathAttribute: c.HTTPPathAttribute,
+		HTTPPathSanitizer: supervisor.PathSanitizer(c.HTTPPathSanitizer),
+
+		CorrelationIDHeader:     c.CorrelationIDHeader,
+		CorrelationIDAttributes: c.CorrelationIDAttributes,
+		RequestIDHeader:         c.RequestIDHeader,
+		RequestIDAttribute:      c.RequestIDAttribute,
+
+		AttributeHeaderPrefix: c.AttributeHeaderPrefix,
+
+		RedeliveryHeader: c.RedeliveryHeader,
+
+		DeadlineHeader: c.DeadlineHeader,
+
+		ErrorQueueURL:         c.ErrorQueueURL,
+		ErrorQueueMaxReceives: c.ErrorQueueMaxReceives,
+		ErrorQueueStatusCodes: c.ErrorQueueStatusCodes,
+
+		ErrorQueueFailureAttributes: c.ErrorQueueFailureAttributes,
+
+		XRayEnabled:       c.XRayEnabled,
+		XRayDaemonAddress: c.XRayDaemonAddress,
+
+		HTTPHMACHeader:    c.HTTPHMACHeader,
+		HMACSecretKey:     c.HMACSecretKey,
+		HMACSignatureMode: supervisor.SignatureMode(c.HMACSignatureMode),
+		HMACAlgorithm:     supervisor.HMACAlgorithm(c.HMACAlgorithm),
+
+		HMACReplayProtection: c.HMACReplay,
+		HMACSignatureFormat:  c.HMACFormat,
+		HMACHeaderFormat:     c.HMACHeaderFormat,
+
+		HTTPMethod:   c.HTTPMethod,
+		BodyTemplate: c.HTTPBodyTemplate,
+		BodyEnvelope: c.HTTPBodyEnvelope,
+
+		EmitSQSDHeaders: c.EmitSQSDHeaders,
+		ExtraHeaders:    c.HTTPHeaders,
+
+		DeleteMaxRetries: c.DeleteMaxRetries,
+		DeleteRetryDelay: time.Duration(c.DeleteRetryDelay) * time.Millisecond,
+
+		DeleteFailureThreshold: c.DeleteFailureThreshold,
+		DeleteFailureBackoff:   time.Duration(c.DeleteFailureBackoff) * time.Second,
+
+		CircuitFailureThreshold: c.CircuitFailureThreshold,
+		CircuitOpenDuration:     time.Duration(c.CircuitOpenDuration) * time.Second,
+
+		BackpressureLatency:  time.Duration(c.BackpressureLatency) * time.Millisecond,
+		BackpressureInFlight: c.BackpressureInFlight,
+
+		ReceiveErrorMaxBackoff: time.Duration(c.ReceiveErrorMaxBackoff) * time.Second,
+		ReceiveErrorAlertAfter: time.Duration(c.ReceiveErrorAlertAfter) * time.Second,
+
+		VisibilityBatchConcurrency: c.VisibilityBatchConcurrency,
+
+		Metrics: shared.metrics,
+
+		OutcomeBufferSize: c.OutcomeBufferSize,
+
+		FIFO:          c.FIFO,
+		FIFOMaxGroups: c.FIFOMaxGroups,
+
+		BatchConcurrency:    c.BatchConcurrency,
+		MaxInFlightBatches:  c.MaxInFlightBatches,
+		MaxInFlightMessages: c.MaxInFlightMessages,
+
+		DeliveryBatchSize:   c.DeliveryBatchSize,
+		DeliveryBatchWindow: time.Duration(c.DeliveryBatchWindow) * time.Second,
+
+		MinPollers:         c.MinPollers,
+		MaxPollers:         c.MaxPollers,
+		PollerIdleReceives: c.PollerIdleReceives,
+
+		ExitAfterEmptyReceives: exitAfterEmptyReceives(c),
+
+		VerifyMD5: c.VerifyMD5,
+
+		DropOlderThan: time.Duration(c.DropOlderThan) * time.Second,
+
+		MaxBodyBytes:    c.MaxBodyBytes,
+		HTTPMaxBodySize: c.HTTPMaxBodySize,
+		MaxReceiveCount: c.MaxReceiveCount,
+
+		DecodeBase64: c.DecodeBase64,
+		UnwrapSNS:    c.UnwrapSNS,
 
-		HTTPHMACHeader: c.HTTPHMACHeader,
-		HMACSecretKey:  c.HMACSecretKey,
+		BodyEncoding:          supervisor.BodyEncoding(c.BodyEncoding),
+		BodyEncodingAttribute: c.BodyEncodingAttribute,
+
+		ResolveS3Pointers: c.ResolveS3Pointers,
+		DeleteS3Payloads:  c.DeleteS3Payloads,
+
+		BodyFilter:       c.BodyFilter,
+		BodyFilterAction: supervisor.FilterAction(c.BodyFilterAction),
+
+		FilterRules:         c.FilterRules,
+		FilterDefaultAction: supervisor.FilterAction(c.FilterDefaultAction),
+
+		DuplicateWindow: time.Duration(c.DuplicateWindow) * time.Second,
+
+		DedupWindow: time.Duration(c.DedupWindow) * time.Second,
+
+		SuccessStatusCodes: c.SuccessStatusCodes,
+		DiscardStatusCodes: c.DiscardStatusCodes,
+		RetryStatusCodes:   c.RetryStatusCodes,
+		StatusPolicy:       c.StatusPolicy,
+
+		WorkerHealthURL:      c.WorkerHealthURL,
+		WorkerHealthInterval: time.Duration(c.WorkerHealthInterval) * time.Second,
+		ReadyReceiveMaxAge:   time.Duration(c.ReadyReceiveMaxAge) * time.Second,
+		PauseWhenUnhealthy:   c.PauseWhenUnhealthy,
+
+		RampUpDuration: time.Duration(c.RampUpDuration) * time.Second,
+		RampUpStep:     c.RampUpStep,
+
+		MaxRequestsPerSecond:  c.MaxRequestsPerSecond,
+		MaxRequestsBurst:      c.MaxRequestsBurst,
+		MaxConcurrentRequests: c.MaxConcurrentRequests,
+
+		AuditDeletes: c.AuditDeletes,
+
+		MaxInFlight: time.Duration(c.MaxInFlight) * time.Second,
+	}
+
+	if len(c.ForwardQueueURL) > 0 {
+		wConf.Deliverer = supervisor.NewSQSForwarder(sqsSvc, c.ForwardQueueURL)
+	}
+
+	if len(c.ExecCommand) > 0 {
+		wConf.Deliverer = supervisor.NewExecDeliverer(c.ExecCommand, time.Duration(c.ExecTimeout)*time.Second)
+	}
+
+	if len(c.GRPCTarget) > 0 {
+		var creds credentials.TransportCredentials
+		if c.GRPCTLS {
+			tlsConfig := &tls.Config{InsecureSkipVerify: !c.SSLVerify || c.HTTPInsecureSkipVerify}
+			if err := configureTLS(tlsConfig, c, shared); err != nil {
+				log.Fatalf("Error while configuring TLS for the gRPC worker: %s", err)
+			}
+			creds = credentials.NewTLS(tlsConfig)
+		}
+
+		deliverer, err := supervisor.NewGRPCDeliverer(c.GRPCTarget, c.GRPCMethod, creds, time.Duration(c.HTTPTimeout)*time.Second)
+		if err != nil {
+			log.Fatalf("Error while connecting to the gRPC worker: %s", err)
+		}
+
+		wConf.Deliverer = deliverer
+	}
+
+	wConf.Authenticator = newAuthenticator(c, awsCfg)
+
+	if c.AsyncAck {
+		wConf.AsyncAckTimeout = time.Duration(c.AsyncAckTimeout) * time.Second
+	}
+
+	if c.ResolveS3Pointers {
+		wConf.S3 = s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+			o.Region = c.QueueRegion
+		})
+	}
+
+	if len(c.ErrorTopicARN) > 0 {
+		snsSvc := sns.NewFromConfig(awsCfg, snsOptions(c))
+		wConf.ErrorDestination = supervisor.NewSNSForwarder(snsSvc, c.ErrorTopicARN)
+	}
+
+	if len(c.EventStream) > 0 {
+		stream, err := shared.eventStream(c.EventStream)
+		if err != nil {
+			log.Fatalf("Error while opening the event stream: %s", err)
+		}
+
+		wConf.EventStream = stream
+	}
+
+	if c.DedupWindow > 0 {
+		store, err := shared.dedupStore(c)
+		if err != nil {
+			log.Fatalf("Error while opening the dedup store: %s", err)
+		}
+
+		wConf.DedupStore = store
+	}
+
+	if c.OTelEnabled {
+		wConf.Tracer = shared.tracer(c)
+	}
+
+	if len(c.AttemptStorePath) > 0 {
+		store, err := shared.attemptStore(c.AttemptStorePath, c.AttemptStoreMaxEntries)
+		if err != nil {
+			log.Fatalf("Error while opening the attempt store: %s", err)
+		}
+
+		wConf.AttemptStore = store
+	}
+
+	if len(c.CronPath) > 0 {
+		tasks, err := supervisor.LoadCronTasks(c.CronPath)
+		if err != nil {
+			log.Fatalf("Error while loading cron tasks: %s", err)
+		}
+
+		wConf.CronTasks = tasks
+		wConf.CronMode = supervisor.CronMode(c.CronMode)
+
+		if len(c.CronLockTable) > 0 {
+			dynamoSvc := dynamodb.NewFromConfig(awsCfg, func(o *dynamodb.Options) {
+				o.Region = c.QueueRegion
+			})
+			wConf.CronLock = supervisor.NewDynamoDBCronLock(dynamoSvc, c.CronLockTable)
+		}
+	}
+
+	if len(c.OutcomeNATSURL) > 0 {
+		publisher, err := newNATSPublisher(c.OutcomeNATSURL, c.OutcomeNATSSubject)
+		if err != nil {
+			log.Fatalf("Error while connecting to NATS: %s", err)
+		}
+
+		wConf.OutcomePublisher = publisher
+	}
+
+	transport := newHTTPTransport(c)
+	if err := configureTLS(transport.TLSClientConfig, c, shared); err != nil {
+		log.Fatalf("Error while configuring TLS for the worker: %s", err)
 	}
 
 	httpClient := &http.Client{
-		Transport: &http.Transport{
-			MaxIdleConns:        c.HTTPMaxConns,
-			MaxIdleConnsPerHost: c.HTTPMaxConns,
-			TLSClientConfig: &tls.Config{
-				MaxVersion:         tls.VersionTLS11,
-				InsecureSkipVerify: !c.SSLVerify,
-			},
+		Transport: transport,
+	}
+
+	// Redirects can only be told apart from their target when they aren't
+	// followed.
+	if c.SuccessStatusCodes.ContainsRange(300, 399) || c.DiscardStatusCodes.ContainsRange(300, 399) || statusPolicyCoversRedirects(c.StatusPolicy) {
+		httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	return supervisor.NewSupervisor(logger, sqsSvc, httpClient, wConf)
+}
+
+// statusPolicyCoversRedirects reports whether any rule of policy applies to a
+// 3xx status code.
+func statusPolicyCoversRedirects(policy []supervisor.StatusRule) bool {
+	for _, rule := range policy {
+		if rule.StatusCodes.ContainsRange(300, 399) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// anyAsyncAck reports whether any of configs keeps the messages accepted by
+// the worker until they are acknowledged.
+func anyAsyncAck(configs []*config) bool {
+	for _, c := range configs {
+		if c.AsyncAck {
+			return true
+		}
+	}
+
+	return false
+}
+
+// exitAfterEmptyReceives returns the consecutive empty receives after which
+// the supervisor stops with SQSD_DRAIN_AND_EXIT, or 0 to keep it running.
+func exitAfterEmptyReceives(c *config) int {
+	if !c.DrainAndExit {
+		return 0
+	}
+
+	return c.DrainEmptyReceives
+}
+
+// newHTTPTransport returns the transport of requests to the worker. Its
+// timeouts bound each phase of a request, within SQSD_HTTP_TIMEOUT, and
+// requests go through SQSD_HTTP_PROXY unless their host matches NO_PROXY.
+func newHTTPTransport(c *config) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(c.HTTPDialTimeout) * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	dial := dialer.DialContext
+	if len(c.HTTPUnixSocket) > 0 {
+		// The host of the worker URL is only sent in the Host header.
+		dial = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", c.HTTPUnixSocket)
+		}
+	}
+
+	transport := &http.Transport{
+		DialContext:           dial,
+		TLSHandshakeTimeout:   time.Duration(c.HTTPTLSHandshakeTimeout) * time.Second,
+		ResponseHeaderTimeout: time.Duration(c.HTTPResponseHeaderTimeout) * time.Second,
+		MaxIdleConns:          c.HTTPMaxConns,
+		MaxIdleConnsPerHost:   c.HTTPMaxConns,
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: !c.SSLVerify || c.HTTPInsecureSkipVerify,
 		},
-		Timeout: time.Duration(c.HTTPTimeout) * time.Second,
 	}
 
-	s := supervisor.NewSupervisor(logger, sqsSvc, httpClient, wConf)
-	s.Start(c.HTTPMaxConns)
-	s.Wait()
+	if len(c.HTTPProxy) > 0 {
+		transport.Proxy = proxyFunc(c.HTTPProxy, os.Getenv)
+	}
+
+	return transport
 }
 
-func getEnvInt(key string, def int) int {
-	val, err := strconv.Atoi(os.Getenv(key))
-	if err != nil {
-		return def
+// proxyFunc returns the proxy of requests to the worker: proxyURL for both
+// HTTP and HTTPS requests, except to the hosts listed in NO_PROXY, or
+// no_proxy, as read by getenv. Requests to localhost are never proxied.
+func proxyFunc(proxyURL string, getenv func(string) string) func(*http.Request) (*url.URL, error) {
+	noProxy := getenv("NO_PROXY")
+	if len(noProxy) == 0 {
+		noProxy = getenv("no_proxy")
 	}
 
-	return val
+	proxy := (&httpproxy.Config{
+		HTTPProxy:  proxyURL,
+		HTTPSProxy: proxyURL,
+		NoProxy:    noProxy,
+	}).ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
 }
 
-var ErrEnvVarEmpty = errors.New("getenv: environment variable empty")
+// sqsOptions configures the SQS clients of c: in SQSD_QUEUE_REGION, at
+// SQSD_AWS_ENDPOINT when set, and logging requests with SQSD_AWS_DEBUG.
+func sqsOptions(c *config, logger *log.Entry) func(*sqs.Options) {
+	return func(o *sqs.Options) {
+		o.Region = c.QueueRegion
+		o.HTTPClient = &http.Client{
+			Timeout: time.Duration(c.SQSHTTPTimeout) * time.Second,
+			Transport: &http.Transport{
+				MaxIdleConns:        c.HTTPMaxConns,
+				MaxIdleConnsPerHost: c.HTTPMaxConns,
+			},
+		}
 
-func getenvStr(key string) (string, error) {
-	v := os.Getenv(key)
-	if len(v) == 0 {
-		return v, ErrEnvVarEmpty
+		if len(c.AWSEndpoint) > 0 {
+			o.BaseEndpoint = aws.String(c.AWSEndpoint)
+		}
+
+		if c.AWSLogLevel != 0 {
+			sdkLogger := logger.WithField("source", "aws-sdk")
+			o.ClientLogMode = c.AWSLogLevel
+			o.Logger = logging.LoggerFunc(func(classification logging.Classification, format string, v ...interface{}) {
+				sdkLogger.Infof(format, v...)
+			})
+		}
 	}
-	return v, nil
 }
 
-func getenvBool(key string, def bool) bool {
-	s, err := getenvStr(key)
-	if err != nil {
-		return def
+// sqsRegion sends the calls of an SQS client to region.
+func sqsRegion(region string) func(*sqs.Options) {
+	return func(o *sqs.Options) {
+		o.Region = region
 	}
-	v, err := strconv.ParseBool(s)
-	if err != nil {
-		return def
+}
+
+// newAuthenticator returns the Authenticator of SQSD_HTTP_AUTH_MODE, or nil
+// for hmac, whose header is set by the supervisor for each queue.
+func newAuthenticator(c *config, awsCfg aws.Config) supervisor.Authenticator {
+	switch c.HTTPAuthMode {
+	case "sigv4":
+		return supervisor.NewSigV4Authenticator(awsCfg.Credentials, c.HTTPAuthSigV4Service, c.HTTPAuthSigV4Region)
+	case "bearer":
+		return supervisor.NewBearerAuthenticator(c.HTTPAuthBearerToken)
+	case "jwt":
+		return supervisor.NewJWTAuthenticator(c.HTTPAuthJWTSecret, supervisor.JWTClaims{
+			Issuer:   c.HTTPAuthJWTIssuer,
+			Subject:  c.HTTPAuthJWTSubject,
+			Audience: c.HTTPAuthJWTAudience,
+		}, time.Duration(c.HTTPAuthJWTTTL)*time.Second)
+	}
+
+	return nil
+}
+
+// snsOptions configures the client publishing to SQSD_ERROR_TOPIC_ARN, in
+// the region of the topic.
+func snsOptions(c *config) func(*sns.Options) {
+	return func(o *sns.Options) {
+		topic, _ := arn.Parse(c.ErrorTopicARN)
+		o.Region = topic.Region
+		o.HTTPClient = &http.Client{Timeout: time.Duration(c.SQSHTTPTimeout) * time.Second}
+
+		if len(c.AWSEndpoint) > 0 {
+			o.BaseEndpoint = aws.String(c.AWSEndpoint)
+		}
 	}
-	return v
 }
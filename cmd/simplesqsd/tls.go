@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// certReloader holds the client certificate presented to the worker. The
+// certificate is loaded again when its files change, or on reload.
+type certReloader struct {
+	certPath string
+	keyPath  string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+}
+
+func newCertReloader(certPath string, keyPath string) (*certReloader, error) {
+	r := &certReloader{certPath: certPath, keyPath: keyPath}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// reload loads the certificate from its files, keeping the current one if
+// they are invalid.
+func (r *certReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.load()
+}
+
+func (r *certReloader) load() error {
+	modTimes, err := r.stat()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return err
+	}
+
+	r.cert = &cert
+	r.modTimes = modTimes
+
+	return nil
+}
+
+func (r *certReloader) stat() ([2]time.Time, error) {
+	var modTimes [2]time.Time
+	for i, path := range []string{r.certPath, r.keyPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+
+	return modTimes, nil
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate, reloading
+// the certificate first if its files changed. Files that fail to load are
+// not tried again until they change again.
+func (r *certReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if modTimes, err := r.stat(); err == nil && modTimes != r.modTimes {
+		if err := r.load(); err != nil {
+			r.modTimes = modTimes
+			log.Errorf("Error while reloading the TLS certificate %s: %s", r.certPath, err)
+		} else {
+			log.Infof("Reloaded the TLS certificate %s", r.certPath)
+		}
+	}
+
+	return r.cert, nil
+}
+
+// loadCABundle returns a pool of the PEM certificates in the file at path.
+func loadCABundle(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no certificates found")
+	}
+
+	return pool, nil
+}
+
+// configureTLS sets up tlsConfig with the CA bundle and client certificate of
+// c. Client certificates are shared by the workers configured with the same
+// files.
+func configureTLS(tlsConfig *tls.Config, c *config, shared *sharedResources) error {
+	if len(c.HTTPCABundle) > 0 {
+		pool, err := loadCABundle(c.HTTPCABundle)
+		if err != nil {
+			return err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(c.HTTPTLSCert) > 0 {
+		r, err := shared.certificate(c.HTTPTLSCert, c.HTTPTLSKey)
+		if err != nil {
+			return err
+		}
+		tlsConfig.GetClientCertificate = r.GetClientCertificate
+	}
+
+	return nil
+}
+
+// reloadCertificatesOnHangup reloads the client certificates every time the
+// process receives SIGHUP, until done is closed.
+func reloadCertificatesOnHangup(reloaders []*certReloader, done <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-hup:
+			for _, r := range reloaders {
+				if err := r.reload(); err != nil {
+					log.Errorf("Error while reloading the TLS certificate %s: %s", r.certPath, err)
+					continue
+				}
+
+				log.Infof("Reloaded the TLS certificate %s", r.certPath)
+			}
+		}
+	}
+}
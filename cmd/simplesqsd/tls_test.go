@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeClientCert writes a self-signed client certificate named commonName
+// and its key to dir, returning their paths.
+func writeClientCert(t *testing.T, dir string, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certPath := filepath.Join(dir, "client.crt")
+	keyPath := filepath.Join(dir, "client.key")
+	assert.NoError(t, ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	assert.NoError(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return certPath, keyPath
+}
+
+func TestConfigureTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var commonName string
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		commonName = r.TLS.PeerCertificates[0].Subject.CommonName
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	caBundle := filepath.Join(dir, "ca.pem")
+	assert.NoError(t, ioutil.WriteFile(caBundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0644))
+
+	certPath, keyPath := writeClientCert(t, dir, "first")
+
+	c := &config{
+		HTTPCABundle: caBundle,
+		HTTPTLSCert:  certPath,
+		HTTPTLSKey:   keyPath,
+		SSLVerify:    true,
+	}
+	shared := newSharedResources(nil)
+	defer shared.close()
+
+	transport := newHTTPTransport(c)
+	if !assert.NoError(t, configureTLS(transport.TLSClientConfig, c, shared)) {
+		return
+	}
+	transport.DisableKeepAlives = true
+	client := &http.Client{Transport: transport}
+
+	res, err := client.Get(ts.URL)
+	if assert.NoError(t, err) {
+		res.Body.Close()
+		assert.Equal(t, "first", commonName)
+	}
+
+	// The certificate is reloaded once its files change.
+	writeClientCert(t, dir, "second")
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certPath, later, later))
+
+	res, err = client.Get(ts.URL)
+	if assert.NoError(t, err) {
+		res.Body.Close()
+		assert.Equal(t, "second", commonName)
+	}
+
+	assert.Len(t, shared.certReloaders(), 1)
+}
+
+func TestConfigureTLSInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	empty := filepath.Join(dir, "empty.pem")
+	assert.NoError(t, ioutil.WriteFile(empty, nil, 0644))
+
+	shared := newSharedResources(nil)
+	assert.Error(t, configureTLS(&tls.Config{}, &config{HTTPCABundle: empty}, shared))
+	assert.Error(t, configureTLS(&tls.Config{}, &config{HTTPTLSCert: empty, HTTPTLSKey: empty}, shared))
+}
+
+func TestCertReloaderKeepsCertificateOnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certPath, keyPath := writeClientCert(t, dir, "first")
+	r, err := newCertReloader(certPath, keyPath)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NoError(t, ioutil.WriteFile(keyPath, []byte("invalid"), 0600))
+	assert.Error(t, r.reload())
+
+	cert, err := r.GetClientCertificate(nil)
+	assert.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "first", leaf.Subject.CommonName)
+}
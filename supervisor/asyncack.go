@@ -0,0 +1,120 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// ErrNotAwaitingAck is returned by Ack for messages that are not awaiting an
+// acknowledgment: they were not accepted by the worker, were already
+// acknowledged or timed out.
+var ErrNotAwaitingAck = errors.New("message is not awaiting an acknowledgment")
+
+// pendingAck is a message the worker accepted with 202 Accepted, kept
+// invisible in its queue until it is acknowledged or deadline passes.
+type pendingAck struct {
+	q        *queue
+	result   messageResult
+	deadline time.Time
+	timer    *time.Timer
+}
+
+// holdForAck keeps the message of result invisible for AsyncAckTimeout, never
+// past VisibilityMax after it was received, waiting for Ack to be called with
+// its ID.
+func (s *Supervisor) holdForAck(ctx context.Context, q *queue, result messageResult) {
+	receivedAt, ok := ctx.Value(receivedAtKey{}).(time.Time)
+	if !ok {
+		receivedAt = time.Now()
+	}
+
+	timeout := s.workerConfig.AsyncAckTimeout
+	if remaining := time.Until(receivedAt.Add(s.visibilityMax())); remaining < timeout {
+		timeout = remaining
+	}
+
+	s.extendVisibility(q, result.msg, timeout)
+
+	result.disposition = dispositionDelete
+	held := &pendingAck{q: q, result: result, deadline: time.Now().Add(timeout)}
+
+	s.acksMu.Lock()
+	defer s.acksMu.Unlock()
+
+	s.pendingAcks[aws.ToString(result.msg.MessageId)] = held
+	s.scheduleAckTimeout(held)
+}
+
+// scheduleAckTimeout releases held to its queue once its deadline passes,
+// unless it was acknowledged. s.acksMu must be held.
+func (s *Supervisor) scheduleAckTimeout(held *pendingAck) {
+	id := aws.ToString(held.result.msg.MessageId)
+
+	held.timer = time.AfterFunc(time.Until(held.deadline), func() {
+		s.acksMu.Lock()
+		if s.pendingAcks[id] != held {
+			s.acksMu.Unlock()
+			return
+		}
+		delete(s.pendingAcks, id)
+		s.acksMu.Unlock()
+
+		s.messageLogger(held.q, held.result.msg).Warn("Message was not acknowledged in time, releasing it to the queue")
+
+		_, err := s.sqs.ChangeMessageVisibility(context.Background(), &sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          aws.String(held.q.url),
+			ReceiptHandle:     held.result.msg.ReceiptHandle,
+			VisibilityTimeout: 0,
+		})
+		if err != nil {
+			s.logger.Errorf("Error while releasing message %s: %s", id, err)
+		}
+	})
+}
+
+// Ack deletes the message with ID messageID from its queue, once the worker
+// finished processing it after answering it with 202 Accepted. It returns
+// ErrNotAwaitingAck if the message is not awaiting an acknowledgment. A
+// message that could not be deleted is still awaiting one.
+func (s *Supervisor) Ack(messageID string) error {
+	s.acksMu.Lock()
+	held, ok := s.pendingAcks[messageID]
+	if ok {
+		delete(s.pendingAcks, messageID)
+		held.timer.Stop()
+	}
+	s.acksMu.Unlock()
+
+	if !ok {
+		return ErrNotAwaitingAck
+	}
+
+	if undeleted := s.deleteResults(held.q, []messageResult{held.result}); undeleted[messageID] {
+		s.acksMu.Lock()
+		defer s.acksMu.Unlock()
+
+		if _, ok := s.pendingAcks[messageID]; !ok {
+			s.pendingAcks[messageID] = held
+			s.scheduleAckTimeout(held)
+		}
+
+		return errors.New("message could not be deleted")
+	}
+
+	s.messageLogger(held.q, held.result.msg).Debug("Message acknowledged")
+	s.recordDelivered(context.Background(), held.q, held.result.msg)
+
+	return nil
+}
+
+// awaitingAck is how many messages are awaiting an acknowledgment.
+func (s *Supervisor) awaitingAck() int {
+	s.acksMu.Lock()
+	defer s.acksMu.Unlock()
+
+	return len(s.pendingAcks)
+}
@@ -0,0 +1,150 @@
+package supervisor
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorAsyncAck(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	var visibilities []int32
+	var deleted []string
+	mock := &mockSQS{
+		changeMessageVisibilityFunc: func(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+			visibilities = append(visibilities, input.VisibilityTimeout)
+			return &sqs.ChangeMessageVisibilityOutput{}, nil
+		},
+		deleteMessageBatchFunc: func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+			for _, entry := range input.Entries {
+				deleted = append(deleted, aws.ToString(entry.Id))
+			}
+			return &sqs.DeleteMessageBatchOutput{}, nil
+		},
+	}
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mock, &http.Client{}, WorkerConfig{
+		HTTPURL:         ts.URL,
+		AsyncAckTimeout: time.Minute,
+	})
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	msg := &types.Message{Body: aws.String("body"), MessageId: aws.String("m"), ReceiptHandle: aws.String("r")}
+	result := supervisor.processMessage(ctx, supervisor.queues[0], msg)
+	assert.Equal(t, "awaiting-ack", result.status)
+	assert.Equal(t, dispositionRetry, result.disposition)
+	assert.Equal(t, []int32{60}, visibilities)
+	assert.Equal(t, 1, supervisor.Stats().AwaitingAck)
+
+	assert.Equal(t, ErrNotAwaitingAck, supervisor.Ack("other"))
+	assert.NoError(t, supervisor.Ack("m"))
+	assert.Equal(t, []string{"m"}, deleted)
+	assert.Equal(t, 0, supervisor.Stats().AwaitingAck)
+	assert.Equal(t, int64(1), supervisor.Stats().Deleted)
+
+	assert.Equal(t, ErrNotAwaitingAck, supervisor.Ack("m"))
+}
+
+func TestSupervisorAsyncAckTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	released := make(chan string, 1)
+	mock := &mockSQS{
+		changeMessageVisibilityFunc: func(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+			if input.VisibilityTimeout == 0 {
+				released <- aws.ToString(input.ReceiptHandle)
+			}
+			return &sqs.ChangeMessageVisibilityOutput{}, nil
+		},
+	}
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mock, &http.Client{}, WorkerConfig{
+		HTTPURL:         ts.URL,
+		AsyncAckTimeout: 10 * time.Millisecond,
+	})
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	msg := &types.Message{Body: aws.String("body"), MessageId: aws.String("m"), ReceiptHandle: aws.String("r")}
+	supervisor.processMessage(ctx, supervisor.queues[0], msg)
+
+	select {
+	case handle := <-released:
+		assert.Equal(t, "r", handle)
+	case <-time.After(time.Second):
+		t.Fatal("message was not released")
+	}
+
+	assert.Equal(t, ErrNotAwaitingAck, supervisor.Ack("m"))
+}
+
+func TestSupervisorAsyncAckDeleteFailure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	var mu sync.Mutex
+	fail := true
+	mock := &mockSQS{
+		changeMessageVisibilityFunc: func(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+			return &sqs.ChangeMessageVisibilityOutput{}, nil
+		},
+		deleteMessageBatchFunc: func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if fail {
+				return &sqs.DeleteMessageBatchOutput{Failed: []types.BatchResultErrorEntry{
+					{Id: input.Entries[0].Id, Code: aws.String("ReceiptHandleIsInvalid"), SenderFault: true},
+				}}, nil
+			}
+			return &sqs.DeleteMessageBatchOutput{}, nil
+		},
+	}
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mock, &http.Client{}, WorkerConfig{
+		HTTPURL:         ts.URL,
+		AsyncAckTimeout: time.Minute,
+	})
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	msg := &types.Message{Body: aws.String("body"), MessageId: aws.String("m"), ReceiptHandle: aws.String("r")}
+	supervisor.processMessage(ctx, supervisor.queues[0], msg)
+
+	err := supervisor.Ack("m")
+	assert.Error(t, err)
+	assert.NotEqual(t, ErrNotAwaitingAck, err)
+	assert.Equal(t, 1, supervisor.Stats().AwaitingAck)
+
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+
+	assert.NoError(t, supervisor.Ack("m"))
+	assert.Equal(t, 0, supervisor.Stats().AwaitingAck)
+}
@@ -0,0 +1,148 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const defaultAttemptStoreMaxEntries = 10000
+
+// AttemptStore persists the number of delivery attempts of each message to a
+// file so that counts survive restarts. At most maxEntries messages are
+// tracked; the least recently updated ones are forgotten first. A nil
+// *AttemptStore tracks nothing.
+type AttemptStore struct {
+	sync.Mutex
+
+	path       string
+	maxEntries int
+	entries    map[string]attemptEntry
+}
+
+type attemptEntry struct {
+	Attempts  int       `json:"attempts"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// OpenAttemptStore loads the attempts stored at path, which is created on
+// the first update if it doesn't exist.
+func OpenAttemptStore(path string, maxEntries int) (*AttemptStore, error) {
+	if maxEntries <= 0 {
+		maxEntries = defaultAttemptStoreMaxEntries
+	}
+
+	a := &AttemptStore{
+		path:       path,
+		maxEntries: maxEntries,
+		entries:    make(map[string]attemptEntry),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &a.entries); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// attempts returns the number of attempts recorded for a message.
+func (a *AttemptStore) attempts(id string) int {
+	if a == nil {
+		return 0
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	return a.entries[id].Attempts
+}
+
+// record stores the number of attempts made to deliver a message.
+func (a *AttemptStore) record(id string, attempts int) error {
+	if a == nil {
+		return nil
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	a.entries[id] = attemptEntry{Attempts: attempts, UpdatedAt: time.Now()}
+
+	for len(a.entries) > a.maxEntries {
+		a.evictOldest()
+	}
+
+	return a.save()
+}
+
+// forget removes a message, typically once it has been deleted from the
+// queue.
+func (a *AttemptStore) forget(id string) error {
+	if a == nil {
+		return nil
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	if _, ok := a.entries[id]; !ok {
+		return nil
+	}
+
+	delete(a.entries, id)
+
+	return a.save()
+}
+
+func (a *AttemptStore) evictOldest() {
+	var (
+		oldestID string
+		oldest   time.Time
+	)
+
+	for id, entry := range a.entries {
+		if len(oldestID) == 0 || entry.UpdatedAt.Before(oldest) {
+			oldestID = id
+			oldest = entry.UpdatedAt
+		}
+	}
+
+	delete(a.entries, oldestID)
+}
+
+// save writes the entries to a temporary file and renames it over path, so
+// that a crash never leaves a partially written store.
+func (a *AttemptStore) save() error {
+	data, err := json.Marshal(a.entries)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(a.path), filepath.Base(a.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), a.path)
+}
@@ -0,0 +1,95 @@
+package supervisor
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttemptStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "attempts")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "attempts.json")
+
+	store, err := OpenAttemptStore(path, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, store.attempts("m1"))
+
+	assert.NoError(t, store.record("m1", 1))
+	assert.NoError(t, store.record("m2", 3))
+	assert.NoError(t, store.record("m3", 2))
+
+	store, err = OpenAttemptStore(path, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, store.attempts("m1"))
+	assert.Equal(t, 3, store.attempts("m2"))
+	assert.Equal(t, 2, store.attempts("m3"))
+
+	assert.NoError(t, store.forget("m2"))
+
+	store, err = OpenAttemptStore(path, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, store.attempts("m2"))
+	assert.Equal(t, 2, store.attempts("m3"))
+}
+
+func TestSupervisorAttemptsSurviveRestart(t *testing.T) {
+	status := http.StatusInternalServerError
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "attempts")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "attempts.json")
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+
+	run := func() []messageResult {
+		store, err := OpenAttemptStore(path, 0)
+		assert.NoError(t, err)
+
+		mockSQS := &mockSQS{}
+		config := WorkerConfig{
+			HTTPURL:      ts.URL,
+			AttemptStore: store,
+		}
+
+		supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+		msg := types.Message{
+			Body:          aws.String("message 1"),
+			MessageId:     aws.String("m1"),
+			ReceiptHandle: aws.String("r1"),
+		}
+		results := supervisor.processBatch(context.Background(), supervisor.queues[0], []types.Message{msg})
+		supervisor.applyResults(supervisor.queues[0], results)
+
+		return results
+	}
+
+	assert.Equal(t, 1, run()[0].attempts)
+	assert.Equal(t, 2, run()[0].attempts)
+
+	status = http.StatusOK
+	assert.Equal(t, 3, run()[0].attempts)
+
+	store, err := OpenAttemptStore(path, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, store.attempts("m1"))
+}
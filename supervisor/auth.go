@@ -0,0 +1,148 @@
+package supervisor
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// Authenticator authenticates the requests made to the worker. It is called
+// once every other header of a request is set, with the request body.
+type Authenticator interface {
+	Authenticate(req *http.Request, body string) error
+}
+
+type bearerAuthenticator struct {
+	token string
+}
+
+// NewBearerAuthenticator returns an Authenticator sending token in the
+// Authorization header.
+func NewBearerAuthenticator(token string) Authenticator {
+	return &bearerAuthenticator{token: token}
+}
+
+func (a *bearerAuthenticator) Authenticate(req *http.Request, body string) error {
+	req.Header.Set("Authorization", "Bearer "+a.token)
+
+	return nil
+}
+
+type sigV4Authenticator struct {
+	signer  *v4.Signer
+	creds   aws.CredentialsProvider
+	service string
+	region  string
+}
+
+// NewSigV4Authenticator returns an Authenticator signing requests with AWS
+// Signature Version 4 for service in region, e.g. execute-api for API
+// Gateway.
+func NewSigV4Authenticator(creds aws.CredentialsProvider, service string, region string) Authenticator {
+	return &sigV4Authenticator{
+		signer:  v4.NewSigner(),
+		creds:   creds,
+		service: service,
+		region:  region,
+	}
+}
+
+func (a *sigV4Authenticator) Authenticate(req *http.Request, body string) error {
+	creds, err := a.creds.Retrieve(req.Context())
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256([]byte(body))
+
+	return a.signer.SignHTTP(req.Context(), creds, req, hex.EncodeToString(sum[:]), a.service, a.region, time.Now())
+}
+
+// JWTClaims are the claims of the tokens minted by a JWT Authenticator.
+// Empty claims are left out.
+type JWTClaims struct {
+	Issuer   string `json:"iss,omitempty"`
+	Subject  string `json:"sub,omitempty"`
+	Audience string `json:"aud,omitempty"`
+}
+
+type jwtAuthenticator struct {
+	secretKey []byte
+	claims    JWTClaims
+	ttl       time.Duration
+	now       func() time.Time
+
+	mu      sync.Mutex
+	token   string
+	renewAt time.Time
+}
+
+// NewJWTAuthenticator returns an Authenticator sending an HS256 JWT signed
+// with secretKey in the Authorization header. Tokens expire after ttl and are
+// minted again once half of it has passed.
+func NewJWTAuthenticator(secretKey []byte, claims JWTClaims, ttl time.Duration) Authenticator {
+	return &jwtAuthenticator{
+		secretKey: secretKey,
+		claims:    claims,
+		ttl:       ttl,
+		now:       time.Now,
+	}
+}
+
+func (a *jwtAuthenticator) Authenticate(req *http.Request, body string) error {
+	token, err := a.currentToken()
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return nil
+}
+
+// currentToken returns the token minted last, or a new one if it is due for
+// renewal.
+func (a *jwtAuthenticator) currentToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	if len(a.token) > 0 && now.Before(a.renewAt) {
+		return a.token, nil
+	}
+
+	payload := struct {
+		JWTClaims
+		IssuedAt  int64 `json:"iat"`
+		ExpiresAt int64 `json:"exp"`
+	}{
+		JWTClaims: a.claims,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(a.ttl).Unix(),
+	}
+
+	claims, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := jwtEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + jwtEncoding.EncodeToString(claims)
+
+	mac := hmac.New(sha256.New, a.secretKey)
+	mac.Write([]byte(unsigned))
+
+	a.token = unsigned + "." + jwtEncoding.EncodeToString(mac.Sum(nil))
+	a.renewAt = now.Add(a.ttl / 2)
+
+	return a.token, nil
+}
+
+var jwtEncoding = base64.RawURLEncoding
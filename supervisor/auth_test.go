@@ -0,0 +1,103 @@
+package supervisor
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBearerAuthenticator(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "http://worker", nil)
+
+	assert.NoError(t, NewBearerAuthenticator("secret").Authenticate(req, ""))
+	assert.Equal(t, "Bearer secret", req.Header.Get("Authorization"))
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	now := time.Unix(1609459200, 0)
+	auth := NewJWTAuthenticator([]byte("foobar"), JWTClaims{Issuer: "sqsd", Audience: "worker"}, time.Minute).(*jwtAuthenticator)
+	auth.now = func() time.Time { return now }
+
+	token := func() string {
+		req, _ := http.NewRequest(http.MethodPost, "http://worker", nil)
+		assert.NoError(t, auth.Authenticate(req, ""))
+
+		return strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	}
+
+	first := token()
+	parts := strings.Split(first, ".")
+	if !assert.Len(t, parts, 3) {
+		return
+	}
+
+	mac := hmac.New(sha256.New, []byte("foobar"))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	assert.Equal(t, jwtEncoding.EncodeToString(mac.Sum(nil)), parts[2])
+
+	header, _ := jwtEncoding.DecodeString(parts[0])
+	assert.JSONEq(t, `{"alg":"HS256","typ":"JWT"}`, string(header))
+
+	payload, _ := jwtEncoding.DecodeString(parts[1])
+	var claims map[string]interface{}
+	assert.NoError(t, json.Unmarshal(payload, &claims))
+	assert.Equal(t, map[string]interface{}{
+		"iss": "sqsd",
+		"aud": "worker",
+		"iat": float64(1609459200),
+		"exp": float64(1609459260),
+	}, claims)
+
+	now = now.Add(29 * time.Second)
+	assert.Equal(t, first, token())
+
+	now = now.Add(time.Second)
+	assert.NotEqual(t, first, token())
+}
+
+func TestSupervisorSigV4Authenticator(t *testing.T) {
+	var (
+		authorization string
+		body          string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		authorization, body = r.Header.Get("Authorization"), string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	creds := credentials.NewStaticCredentialsProvider("AKID", "SECRET", "")
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:       ts.URL,
+		Authenticator: NewSigV4Authenticator(creds, "execute-api", "eu-west-1"),
+	})
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	result := supervisor.processMessage(ctx, supervisor.queues[0], &types.Message{
+		Body:          aws.String("message"),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+	})
+
+	assert.Equal(t, "delivered", result.status)
+	assert.Equal(t, "message", body)
+	assert.True(t, strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=AKID/"), authorization)
+	assert.Contains(t, authorization, "/eu-west-1/execute-api/aws4_request")
+	assert.Contains(t, authorization, "x-aws-sqsd-msgid")
+}
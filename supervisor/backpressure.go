@@ -0,0 +1,196 @@
+package supervisor
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// backpressureInterval is how often the receive limit is adjusted, and
+	// how long workers wait before checking it again while it is zero.
+	backpressureInterval = time.Second
+	// backpressureWindow is how long the latency of a delivery counts
+	// towards the p99 latency of the worker.
+	backpressureWindow = 10 * time.Second
+	// backpressureRecovery is the fraction of BackpressureLatency the p99
+	// latency must fall under before the receive limit grows again, so that
+	// it doesn't flap around the threshold.
+	backpressureRecovery = 0.8
+)
+
+// latencySample is the latency of a delivery completed at a point in time.
+type latencySample struct {
+	at      time.Time
+	latency time.Duration
+}
+
+// backpressure limits how many messages workers receive at a time while the
+// worker is slow or overwhelmed. Once every backpressureInterval, it halves
+// the limit while the p99 latency of deliveries exceeds latency or inFlight
+// deliveries are made, down to zero which pauses receiving, and grows it by
+// one once they recovered, up to max. A nil *backpressure never limits.
+type backpressure struct {
+	latency  time.Duration
+	inFlight int
+	max      int
+	// changed is called outside the lock on every change of the limit.
+	changed func(from, to int)
+
+	mu         sync.Mutex
+	limit      int
+	adjustedAt time.Time
+	pending    int
+	samples    []latencySample
+}
+
+// newBackpressure returns nil when neither latency nor inFlight is positive.
+func newBackpressure(latency time.Duration, inFlight int, max int, changed func(from, to int)) *backpressure {
+	if latency <= 0 && inFlight <= 0 {
+		return nil
+	}
+
+	if max < 1 {
+		max = 1
+	}
+
+	return &backpressure{
+		latency:  latency,
+		inFlight: inFlight,
+		max:      max,
+		changed:  changed,
+		limit:    max,
+	}
+}
+
+// begin records that a delivery started. Every begin must be followed by an
+// end.
+func (b *backpressure) begin() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pending++
+}
+
+// end records that a delivery started at start ended at now. Its latency is
+// only observed when it completed, rather than being canceled.
+func (b *backpressure) end(start time.Time, now time.Time, completed bool) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pending--
+	if completed {
+		b.samples = append(b.samples, latencySample{at: now, latency: now.Sub(start)})
+	}
+}
+
+// receiveLimit returns how many of n messages a worker may receive at now.
+// When none may be, it returns how long to wait before checking again.
+func (b *backpressure) receiveLimit(now time.Time, n int) (int, time.Duration) {
+	if b == nil {
+		return n, 0
+	}
+
+	b.mu.Lock()
+	from := b.limit
+	if now.Sub(b.adjustedAt) >= backpressureInterval {
+		b.adjust(now)
+	}
+	to := b.limit
+	b.mu.Unlock()
+
+	if from != to && b.changed != nil {
+		b.changed(from, to)
+	}
+
+	if to == 0 {
+		return 0, backpressureInterval
+	}
+	if n > to {
+		n = to
+	}
+
+	return n, 0
+}
+
+// adjust halves the limit if the worker is overwhelmed, and grows it if it
+// recovered. Samples are dropped on every decrease so that the next
+// adjustment only judges the new limit.
+func (b *backpressure) adjust(now time.Time) {
+	b.adjustedAt = now
+
+	cutoff := now.Add(-backpressureWindow)
+	i := 0
+	for i < len(b.samples) && b.samples[i].at.Before(cutoff) {
+		i++
+	}
+	b.samples = b.samples[i:]
+
+	p99, observed := b.p99()
+	overloaded := (b.inFlight > 0 && b.pending >= b.inFlight) ||
+		(b.latency > 0 && observed && p99 > b.latency)
+
+	recovered := !overloaded
+	if b.latency > 0 {
+		if observed {
+			recovered = recovered && float64(p99) <= float64(b.latency)*backpressureRecovery
+		} else {
+			// Without completed deliveries, the worker is only known to
+			// have recovered once nothing is pending.
+			recovered = recovered && b.pending == 0
+		}
+	}
+
+	switch {
+	case overloaded && b.limit > 0:
+		b.limit /= 2
+		b.samples = nil
+	case recovered && b.limit < b.max:
+		b.limit++
+	}
+}
+
+// p99 returns the 99th percentile of the latencies sampled, and whether
+// there are any.
+func (b *backpressure) p99() (time.Duration, bool) {
+	if len(b.samples) == 0 {
+		return 0, false
+	}
+
+	latencies := make([]time.Duration, len(b.samples))
+	for i, sample := range b.samples {
+		latencies[i] = sample.latency
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	return latencies[(len(latencies)*99-1)/100], true
+}
+
+// backpressureChanged logs and records that the receive limit went from one
+// number of messages to another.
+func (s *Supervisor) backpressureChanged(from, to int) {
+	for _, q := range s.queues {
+		s.workerConfig.Metrics.setReceiveLimit(q.url, to)
+	}
+
+	switch {
+	case to == 0:
+		s.logger.Warn("The worker is overwhelmed, pausing receiving")
+	case from == 0:
+		s.logger.Info("The worker is recovering, resuming receiving")
+	case to < from:
+		s.logger.Infof("The worker is slowing down, receiving up to %d messages at a time", to)
+	case to == s.backpressure.max:
+		s.logger.Info("The worker recovered, receiving full batches again")
+	default:
+		s.logger.Debugf("The worker is recovering, receiving up to %d messages at a time", to)
+	}
+}
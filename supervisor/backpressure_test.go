@@ -0,0 +1,125 @@
+package supervisor
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackpressureLatency(t *testing.T) {
+	var changes [][2]int
+	b := newBackpressure(100*time.Millisecond, 0, 10, func(from, to int) {
+		changes = append(changes, [2]int{from, to})
+	})
+
+	now := time.Now()
+	deliver := func(latency time.Duration) {
+		b.begin()
+		b.end(now.Add(-latency), now, true)
+	}
+
+	assert.Equal(t, 10, limitAt(b, now))
+
+	// The limit halves every interval while deliveries are slow, down to
+	// pausing receiving.
+	for _, expected := range []int{5, 2, 1} {
+		deliver(50 * time.Millisecond)
+		deliver(200 * time.Millisecond)
+		now = now.Add(backpressureInterval)
+		assert.Equal(t, expected, limitAt(b, now))
+	}
+
+	deliver(200 * time.Millisecond)
+	now = now.Add(backpressureInterval)
+	n, wait := b.receiveLimit(now, 10)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, backpressureInterval, wait)
+
+	// It is only adjusted once per interval.
+	deliver(time.Millisecond)
+	assert.Equal(t, 0, limitAt(b, now.Add(backpressureInterval/2)))
+
+	// It grows back by one while deliveries are fast enough, but not while
+	// they stay close to the threshold.
+	now = now.Add(backpressureInterval)
+	assert.Equal(t, 1, limitAt(b, now))
+	deliver(90 * time.Millisecond)
+	now = now.Add(backpressureInterval)
+	assert.Equal(t, 1, limitAt(b, now))
+
+	// Slow deliveries age out of the window.
+	now = now.Add(backpressureWindow)
+	assert.Equal(t, 2, limitAt(b, now))
+
+	assert.Equal(t, [][2]int{{10, 5}, {5, 2}, {2, 1}, {1, 0}, {0, 1}, {1, 2}}, changes)
+}
+
+func TestBackpressureInFlight(t *testing.T) {
+	b := newBackpressure(0, 2, 4, nil)
+	now := time.Now()
+
+	b.begin()
+	b.begin()
+	assert.Equal(t, 2, limitAt(b, now))
+
+	// Once deliveries complete, it grows back even without latency samples.
+	b.end(now, now, false)
+	now = now.Add(backpressureInterval)
+	assert.Equal(t, 3, limitAt(b, now))
+
+	assert.Nil(t, newBackpressure(0, 0, 10, nil))
+	var disabled *backpressure
+	n, wait := disabled.receiveLimit(now, 10)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, time.Duration(0), wait)
+}
+
+// limitAt returns the number of messages b lets a worker receive at now, out
+// of 10.
+func limitAt(b *backpressure, now time.Time) int {
+	n, _ := b.receiveLimit(now, 10)
+	return n
+}
+
+func TestSupervisorBackpressure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	metrics := NewMetrics(prometheus.NewRegistry())
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		QueueURL:            "https://queue.url/jobs",
+		HTTPURL:             ts.URL,
+		QueueMaxMessages:    10,
+		BackpressureLatency: 10 * time.Millisecond,
+		Metrics:             metrics,
+	})
+	assert.Equal(t, 10.0, testutil.ToFloat64(metrics.receiveLimit.WithLabelValues("jobs")))
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	supervisor.backpressure.receiveLimit(time.Now(), 10)
+	result := supervisor.processMessage(ctx, supervisor.queues[0], &types.Message{
+		Body:          aws.String("message"),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+	})
+	assert.Equal(t, "delivered", result.status)
+
+	n, _ := supervisor.backpressure.receiveLimit(time.Now().Add(backpressureInterval), 10)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, 5.0, testutil.ToFloat64(metrics.receiveLimit.WithLabelValues("jobs")))
+}
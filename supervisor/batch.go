@@ -0,0 +1,357 @@
+package supervisor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultBatchResponseMax bounds the body of batch responses read when
+// HTTPMaxResponseBody is not set.
+const defaultBatchResponseMax = 1 << 20
+
+// batchItem is a message in the JSON array sent to the worker in batch
+// delivery mode.
+type batchItem struct {
+	ID           string            `json:"id"`
+	Body         string            `json:"body"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	ReceiveCount int               `json:"receiveCount"`
+}
+
+// batchItemResult is what the worker responded for a message of a batch.
+// StatusCode is handled like the status code of a response to a single
+// message, and VisibilityTimeout, in seconds, like the
+// X-Sqsd-Visibility-Timeout header.
+type batchItemResult struct {
+	ID                string `json:"id"`
+	StatusCode        int    `json:"statusCode"`
+	VisibilityTimeout *int64 `json:"visibilityTimeout"`
+}
+
+// batchResponseError is returned when the response to a batch can't be
+// parsed or leaves a message out.
+type batchResponseError struct {
+	err error
+}
+
+func (e *batchResponseError) Error() string {
+	return "Error in the batch response: " + e.err.Error()
+}
+
+// batchDelivery reports whether messages are delivered to the HTTP worker in
+// batches rather than one at a time.
+func (s *Supervisor) batchDelivery() bool {
+	return s.workerConfig.DeliveryBatchSize > 1 && s.workerConfig.Deliverer == nil
+}
+
+// processDeliveryBatches delivers messages in requests of up to
+// DeliveryBatchSize messages. Results are kept in the order the messages
+// were received.
+func (s *Supervisor) processDeliveryBatches(ctx context.Context, q *queue, messages []types.Message) []messageResult {
+	results := make([]messageResult, len(messages))
+	var batch []*messageResult
+	var deliveries []*types.Message
+
+	for i := range messages {
+		results[i].msg = &messages[i]
+
+		delivery := s.prepareMessage(ctx, q, &results[i])
+		if delivery == nil {
+			continue
+		}
+
+		batch = append(batch, &results[i])
+		deliveries = append(deliveries, delivery)
+	}
+
+	size := s.workerConfig.DeliveryBatchSize
+	for start := 0; start < len(batch); start += size {
+		end := start + size
+		if end > len(batch) {
+			end = len(batch)
+		}
+
+		s.deliverBatch(ctx, q, batch[start:end], deliveries[start:end])
+	}
+
+	return results
+}
+
+// deliverBatch delivers deliveries in a single request and decides what
+// happens to the message of each of results from its result in the
+// response.
+func (s *Supervisor) deliverBatch(ctx context.Context, q *queue, results []*messageResult, deliveries []*types.Message) {
+	if ctx.Err() != nil || !s.ramp.acquire(ctx) {
+		for _, result := range results {
+			s.releaseMessage(q, result.msg, s.releaseReason(ctx))
+			result.status = "released"
+		}
+		return
+	}
+	defer s.ramp.release()
+
+	items := make([]batchItem, 0, len(results))
+	var sent []*messageResult
+	for i, result := range results {
+		body, err := s.requestBody(q, deliveries[i])
+		if err != nil {
+			s.recordFailure(q, result, failureReasonForError(err)).Errorf("Error making HTTP request: %s", err)
+			continue
+		}
+
+		items = append(items, s.batchItem(deliveries[i], body))
+		sent = append(sent, result)
+	}
+	if len(items) == 0 {
+		return
+	}
+
+	// Items only hold strings and ints, which always encode.
+	body, _ := json.Marshal(items)
+
+	start := time.Now()
+	stopHeartbeats := make([]func(), len(sent))
+	for i, result := range sent {
+		s.workerConfig.Metrics.observeMessageAge(q.url, result.msg, start)
+		stopHeartbeats[i] = s.startHeartbeat(ctx, q, result.msg)
+	}
+
+	logger := s.logger.WithFields(log.Fields{"queue": q.url, "batchSize": len(sent)})
+	res, err := s.withRetries(ctx, logger, func() (*http.Response, error) {
+		return s.send(ctx, q, func() (*http.Response, error) {
+			return s.batchRequest(ctx, q, string(body))
+		})
+	})
+
+	for _, stop := range stopHeartbeats {
+		stop()
+	}
+
+	var (
+		itemResults  map[string]batchItemResult
+		responseBody string
+	)
+	if err == nil {
+		if s.successful(res.StatusCode) {
+			itemResults, err = parseBatchResponse(res)
+		} else {
+			responseBody = responseExcerpt(res, s.workerConfig.HTTPErrorBodyLimit)
+		}
+	}
+
+	duration := time.Since(start)
+	for _, result := range sent {
+		result.attempts = s.workerConfig.AttemptStore.attempts(*result.msg.MessageId) + 1
+		result.duration = duration
+
+		if err != nil {
+			s.recordOutcome(q, result.msg, nil, err, start)
+			if ctx.Err() != nil {
+				s.releaseMessage(q, result.msg, s.releaseReason(ctx))
+
+				result.status = "released"
+				continue
+			}
+
+			s.recordFailure(q, result, failureReasonForError(err)).Errorf("Error making HTTP request: %s", err)
+			continue
+		}
+
+		if !s.successful(res.StatusCode) {
+			s.recordOutcome(q, result.msg, res, nil, start)
+			result.statusCode = res.StatusCode
+			result.responseBody = responseBody
+			s.handleFailedResponse(q, result, res)
+			continue
+		}
+
+		s.handleBatchItem(ctx, q, result, res, itemResults, start)
+	}
+}
+
+// handleBatchItem decides what happens to the message of result from its
+// result in the successful response res to its batch. Every message is
+// delivered when the response has no body.
+func (s *Supervisor) handleBatchItem(ctx context.Context, q *queue, result *messageResult, res *http.Response, itemResults map[string]batchItemResult, start time.Time) {
+	itemRes := &http.Response{StatusCode: res.StatusCode, Header: http.Header{}}
+
+	if itemResults != nil {
+		item, ok := itemResults[aws.ToString(result.msg.MessageId)]
+		if !ok {
+			err := &batchResponseError{err: fmt.Errorf("no result for message %s", aws.ToString(result.msg.MessageId))}
+			s.recordOutcome(q, result.msg, nil, err, start)
+			s.recordFailure(q, result, failureReasonForError(err)).Error(err)
+			return
+		}
+
+		itemRes.StatusCode = item.StatusCode
+		if item.VisibilityTimeout != nil {
+			itemRes.Header.Set(visibilityTimeoutHeader, strconv.FormatInt(*item.VisibilityTimeout, 10))
+		}
+	}
+
+	s.recordOutcome(q, result.msg, itemRes, nil, start)
+	result.statusCode = itemRes.StatusCode
+
+	if !s.successful(itemRes.StatusCode) {
+		s.handleFailedResponse(q, result, itemRes)
+		return
+	}
+
+	s.resultLogger(q, result).Debug("Message successfully processed")
+	s.recordFirstDelivery()
+	s.recordDelivered(ctx, q, result.msg)
+
+	result.disposition = dispositionDelete
+	result.status = "delivered"
+}
+
+// batchItem returns the entry of msg, with its request body, in a batch.
+func (s *Supervisor) batchItem(msg *types.Message, body string) batchItem {
+	item := batchItem{
+		ID:           aws.ToString(msg.MessageId),
+		Body:         body,
+		ReceiveCount: receiveCount(msg),
+	}
+
+	for name := range msg.MessageAttributes {
+		value, ok := attributeValue(msg, name)
+		if !ok {
+			continue
+		}
+
+		if item.Attributes == nil {
+			item.Attributes = make(map[string]string)
+		}
+		item.Attributes[name] = value
+	}
+
+	return item
+}
+
+// batchRequest POSTs the JSON array body to the worker URL of q. The response
+// body is read in memory, up to HTTPMaxResponseBody bytes.
+func (s *Supervisor) batchRequest(ctx context.Context, q *queue, body string) (*http.Response, error) {
+	ep := q.acquireEndpoint()
+	defer ep.release()
+
+	if s.workerConfig.HTTPTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.workerConfig.HTTPTimeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, s.httpMethod(), ep.url, bytes.NewBufferString(body))
+	if err != nil {
+		return nil, fmt.Errorf("Error while creating HTTP request: %s", err)
+	}
+
+	for name, value := range s.workerConfig.ExtraHeaders {
+		req.Header.Set(name, value)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if len(q.hmacSecretKey) > 0 {
+		if err := s.signRequest(req, ep.url, body, q.hmacSecretKey); err != nil {
+			return nil, &signatureError{err: err}
+		}
+	}
+
+	if len(s.workerConfig.HTTPAccept) > 0 {
+		req.Header.Set("Accept", s.workerConfig.HTTPAccept)
+	}
+
+	if s.workerConfig.Authenticator != nil {
+		if err := s.workerConfig.Authenticator.Authenticate(req, body); err != nil {
+			return nil, &signatureError{err: err}
+		}
+	}
+
+	start := time.Now()
+	res, err := s.httpClient.Do(req)
+	s.workerConfig.Metrics.observeRequestDuration(q.url, time.Since(start))
+	if err != nil {
+		return res, err
+	}
+
+	max := s.workerConfig.HTTPMaxResponseBody
+	if max <= 0 {
+		max = defaultBatchResponseMax
+	}
+	if err := bufferResponseBody(res, max); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// parseBatchResponse returns the results of the JSON array body of res by
+// message ID, or nil when res has no body.
+func parseBatchResponse(res *http.Response) (map[string]batchItemResult, error) {
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, &batchResponseError{err: err}
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+
+	var items []batchItemResult
+	if err := json.Unmarshal(body, &items); err != nil {
+		return nil, &batchResponseError{err: err}
+	}
+
+	results := make(map[string]batchItemResult, len(items))
+	for _, item := range items {
+		if item.StatusCode <= 0 {
+			return nil, &batchResponseError{err: fmt.Errorf("no status code for message %s", item.ID)}
+		}
+
+		results[item.ID] = item
+	}
+
+	return results, nil
+}
+
+// fillBatch keeps receiving messages from q for up to DeliveryBatchWindow
+// after receivedAt, until there are enough to fill a delivery batch.
+func (s *Supervisor) fillBatch(q *queue, receivedAt time.Time, messages []types.Message) []types.Message {
+	for len(messages) < s.workerConfig.DeliveryBatchSize && !s.shutdown.Load() {
+		remaining := time.Until(receivedAt.Add(s.workerConfig.DeliveryBatchWindow))
+		if remaining <= 0 {
+			break
+		}
+
+		want := s.workerConfig.DeliveryBatchSize - len(messages)
+		if want > maxBatchEntries {
+			want = maxBatchEntries
+		}
+
+		reserved := s.capacity.tryAcquire(want)
+		if reserved == 0 {
+			break
+		}
+
+		waitTime := int(remaining / time.Second)
+		more, _, err := s.receiveWait(q, reserved, waitTime)
+		s.capacity.release(reserved - len(more))
+		if err != nil || (len(more) == 0 && waitTime == 0) {
+			break
+		}
+
+		messages = append(messages, more...)
+	}
+
+	return messages
+}
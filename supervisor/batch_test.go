@@ -0,0 +1,154 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func batchMessages(n int) []types.Message {
+	messages := make([]types.Message, n)
+	for i := range messages {
+		id := fmt.Sprintf("m%d", i+1)
+		messages[i] = types.Message{
+			Body:          aws.String("body " + id),
+			MessageId:     aws.String(id),
+			ReceiptHandle: aws.String("r-" + id),
+			Attributes: map[string]string{
+				string(types.MessageSystemAttributeNameApproximateReceiveCount): "1",
+			},
+			MessageAttributes: map[string]types.MessageAttributeValue{
+				"type": {DataType: aws.String("String"), StringValue: aws.String("order")},
+			},
+		}
+	}
+
+	return messages
+}
+
+func TestSupervisorDeliveryBatches(t *testing.T) {
+	var batches [][]batchItem
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var items []batchItem
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&items))
+		batches = append(batches, items)
+
+		statusCodes := map[string]int{"m1": http.StatusOK, "m2": http.StatusInternalServerError, "m3": http.StatusGone}
+		var results []batchItemResult
+		for _, item := range items {
+			result := batchItemResult{ID: item.ID, StatusCode: statusCodes[item.ID]}
+			if item.ID == "m2" {
+				result.VisibilityTimeout = aws.Int64(60)
+			}
+			if item.ID != "m4" {
+				results = append(results, result)
+			}
+		}
+
+		json.NewEncoder(w).Encode(results)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:            ts.URL,
+		DeliveryBatchSize:  2,
+		DiscardStatusCodes: StatusCodes{{Min: http.StatusGone, Max: http.StatusGone}},
+	})
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	results := supervisor.processBatch(ctx, supervisor.queues[0], batchMessages(4))
+
+	if assert.Len(t, batches, 2) {
+		assert.Len(t, batches[0], 2)
+		assert.Equal(t, batchItem{ID: "m1", Body: "body m1", Attributes: map[string]string{"type": "order"}, ReceiveCount: 1}, batches[0][0])
+		assert.Len(t, batches[1], 2)
+	}
+
+	if assert.Len(t, results, 4) {
+		assert.Equal(t, "delivered", results[0].status)
+		assert.Equal(t, dispositionDelete, results[0].disposition)
+
+		assert.Equal(t, http.StatusInternalServerError, results[1].statusCode)
+		assert.Equal(t, dispositionChangeVisibility, results[1].disposition)
+		assert.Equal(t, int64(60), results[1].visibilityTimeout)
+
+		assert.Equal(t, "discarded", results[2].status)
+
+		// Messages missing from the response are retried.
+		assert.Equal(t, dispositionRetry, results[3].disposition)
+		assert.Equal(t, FailureBatchResponse, results[3].reason)
+	}
+}
+
+func TestSupervisorDeliveryBatchResponses(t *testing.T) {
+	statusCode := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statusCode)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:           ts.URL,
+		DeliveryBatchSize: 10,
+	})
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	// A successful response without a body delivers every message.
+	for _, result := range supervisor.processBatch(ctx, supervisor.queues[0], batchMessages(3)) {
+		assert.Equal(t, "delivered", result.status)
+	}
+
+	statusCode = http.StatusBadRequest
+	for _, result := range supervisor.processBatch(ctx, supervisor.queues[0], batchMessages(3)) {
+		assert.Equal(t, dispositionRetry, result.disposition)
+		assert.Equal(t, http.StatusBadRequest, result.statusCode)
+		assert.Equal(t, FailureHTTP4xx, result.reason)
+	}
+}
+
+func TestSupervisorFillBatch(t *testing.T) {
+	mockSQS := &mockSQS{}
+	receives := 0
+	mockSQS.receiveMessageFunc = func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		receives++
+		assert.Equal(t, int32(1), input.MaxNumberOfMessages)
+
+		return &sqs.ReceiveMessageOutput{Messages: batchMessages(1)}, nil
+	}
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+		HTTPURL:             "http://worker",
+		DeliveryBatchSize:   5,
+		DeliveryBatchWindow: time.Minute,
+		MaxInFlightMessages: 4,
+	})
+
+	reserved, _ := supervisor.capacity.acquire(3)
+	messages := supervisor.fillBatch(supervisor.queues[0], time.Now(), batchMessages(3))
+
+	// A single message more could be received within MaxInFlightMessages.
+	assert.Len(t, messages, 4)
+	assert.Equal(t, 1, receives)
+
+	supervisor.capacity.release(reserved + 1)
+	assert.Equal(t, 4, supervisor.capacity.tryAcquire(10))
+}
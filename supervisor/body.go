@@ -0,0 +1,162 @@
+package supervisor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// BodyTemplateData is what a body template is executed with.
+type BodyTemplateData struct {
+	// Body is the message body, unwrapped with UnwrapSNS and decoded with
+	// DecodeBase64.
+	Body      string
+	MessageId string
+	QueueURL  string
+	// Attributes holds the String and Number message attributes.
+	Attributes map[string]string
+	// SystemAttributes holds the system attributes received with the
+	// message, e.g. SentTimestamp.
+	SystemAttributes map[string]string
+}
+
+var bodyTemplateFuncs = template.FuncMap{
+	// json encodes a value as JSON, e.g. to embed the body as a string.
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// ParseBodyTemplate parses a text/template reshaping the body of requests to
+// the worker. Besides the standard functions, json encodes its argument as
+// JSON, e.g. {"id": {{json .MessageId}}, "payload": {{json .Body}}}.
+func ParseBodyTemplate(text string) (*template.Template, error) {
+	return template.New("body").Funcs(bodyTemplateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// templateError is returned when the body template fails for a message.
+type templateError struct {
+	err error
+}
+
+func (e *templateError) Error() string {
+	return "Error while executing the body template: " + e.err.Error()
+}
+
+// decodeError is returned when the body of a message is not valid base64.
+type decodeError struct {
+	err error
+}
+
+func (e *decodeError) Error() string {
+	return "Error while decoding the message body: " + e.err.Error()
+}
+
+// messageBody returns the body of msg, unwrapped from its SNS envelope with
+// UnwrapSNS, then decoded from base64 with DecodeBase64 or according to its
+// encoding attribute with BodyEncoding.
+func (s *Supervisor) messageBody(msg *types.Message) (string, error) {
+	body := aws.ToString(msg.Body)
+	if env := s.snsEnvelope(msg); env != nil {
+		body = *env.Message
+	}
+
+	if !s.workerConfig.DecodeBase64 {
+		return s.decodeBody(msg, body)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return "", &decodeError{err: err}
+	}
+
+	return string(decoded), nil
+}
+
+// requestBody returns the body of the request delivering msg.
+func (s *Supervisor) requestBody(q *queue, msg *types.Message) (string, error) {
+	body, err := s.messageBody(msg)
+	if err != nil {
+		return "", err
+	}
+
+	if s.workerConfig.BodyEnvelope {
+		return envelopeBody(msg, body)
+	}
+
+	if s.workerConfig.BodyTemplate == nil {
+		return body, nil
+	}
+
+	data := BodyTemplateData{
+		Body:             body,
+		MessageId:        aws.ToString(msg.MessageId),
+		QueueURL:         q.url,
+		Attributes:       make(map[string]string, len(msg.MessageAttributes)),
+		SystemAttributes: make(map[string]string, len(msg.Attributes)),
+	}
+
+	for name, attr := range msg.MessageAttributes {
+		if attr.StringValue != nil {
+			data.Attributes[name] = *attr.StringValue
+		}
+	}
+	for name, value := range msg.Attributes {
+		data.SystemAttributes[name] = value
+	}
+
+	var buf bytes.Buffer
+	if err := s.workerConfig.BodyTemplate.Execute(&buf, data); err != nil {
+		return "", &templateError{err: err}
+	}
+
+	return buf.String(), nil
+}
+
+// bodyTooLarge reports whether the body of msg exceeds MaxBodyBytes.
+func (s *Supervisor) bodyTooLarge(msg *types.Message) bool {
+	return s.workerConfig.MaxBodyBytes > 0 && len(aws.ToString(msg.Body)) > s.workerConfig.MaxBodyBytes
+}
+
+// messageSizeHeader carries the size in bytes of the message body as
+// received from the queue.
+const messageSizeHeader = "X-Aws-Sqsd-Msg-Size"
+
+// requestTooLargeError is returned when a request body exceeds
+// HTTPMaxBodySize.
+type requestTooLargeError struct {
+	size int
+	max  int
+}
+
+func (e *requestTooLargeError) Error() string {
+	return fmt.Sprintf("HTTP request body of %d bytes exceeds %d bytes", e.size, e.max)
+}
+
+// dropTooLarge deletes the message of result without delivering it, or moves
+// it to the error queue if there is one.
+func (s *Supervisor) dropTooLarge(q *queue, result *messageResult) {
+	s.workerConfig.Metrics.incDropped(q.url, "too-large")
+
+	result.disposition = dispositionDelete
+	result.status = "too-large"
+	if s.errorQueue != nil {
+		result.disposition = dispositionRetry
+		s.moveToErrorQueue(q, result)
+	}
+}
+
+func (s *Supervisor) httpMethod() string {
+	if len(s.workerConfig.HTTPMethod) == 0 {
+		return http.MethodPost
+	}
+
+	return s.workerConfig.HTTPMethod
+}
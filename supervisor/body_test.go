@@ -0,0 +1,278 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorBodyTemplate(t *testing.T) {
+	var (
+		method string
+		body   string
+		header http.Header
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		method, body, header = r.Method, string(b), r.Header
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	tmpl, err := ParseBodyTemplate(`{"id":{{json .MessageId}},"type":{{json .Attributes.type}},"sent":{{json .SystemAttributes.SentTimestamp}},"queue":{{json .QueueURL}},"payload":{{json .Body}}}`)
+	assert.NoError(t, err)
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		QueueURL:       "https://queue.url/jobs",
+		HTTPURL:        ts.URL,
+		HTTPMethod:     http.MethodPut,
+		BodyTemplate:   tmpl,
+		HTTPHMACHeader: "hmac",
+		HMACSecretKey:  []byte("foobar"),
+	})
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	result := supervisor.processMessage(ctx, supervisor.queues[0], &types.Message{
+		Body:          aws.String(`say "hi"`),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"type": {DataType: aws.String("String"), StringValue: aws.String("greeting")},
+		},
+		Attributes: map[string]string{
+			string(types.MessageSystemAttributeNameSentTimestamp): "1609459200000",
+		},
+	})
+
+	expected := `{"id":"m1","type":"greeting","sent":"1609459200000","queue":"https://queue.url/jobs","payload":"say \"hi\""}`
+	assert.Equal(t, "delivered", result.status)
+	assert.Equal(t, http.MethodPut, method)
+	assert.Equal(t, expected, body)
+
+	hmac, _ := makeHMAC(HMACSHA256, fmt.Sprintf("PUT %s\n%s", ts.URL, expected), []byte("foobar"))
+	assert.Equal(t, hmac, header.Get("hmac"))
+}
+
+func TestSupervisorBodyTemplateFailure(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	tmpl, err := ParseBodyTemplate(`{{index .Attributes "type" "nested"}}`)
+	assert.NoError(t, err)
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:          ts.URL,
+		BodyTemplate:     tmpl,
+		HTTPMaxRetries:   3,
+		HTTPRetryBackoff: time.Millisecond,
+	})
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	result := supervisor.processMessage(ctx, supervisor.queues[0], &types.Message{
+		Body:          aws.String("message"),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+	})
+
+	assert.Equal(t, dispositionRetry, result.disposition)
+	assert.Equal(t, FailureBodyTemplate, result.reason)
+	assert.Zero(t, requests.Load())
+}
+
+func TestParseBodyTemplateInvalid(t *testing.T) {
+	_, err := ParseBodyTemplate("{{.Body")
+	assert.Error(t, err)
+}
+
+func TestSupervisorDefaultHTTPMethod(t *testing.T) {
+	var method string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{HTTPURL: ts.URL})
+	_, err := supervisor.httpRequest(context.Background(), supervisor.queues[0], &types.Message{
+		Body:      aws.String("message"),
+		MessageId: aws.String("m1"),
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.MethodPost, method)
+}
+
+func TestSupervisorDecodeBase64(t *testing.T) {
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:          ts.URL,
+		DecodeBase64:     true,
+		HTTPMaxRetries:   3,
+		HTTPRetryBackoff: time.Millisecond,
+	})
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	result := supervisor.processMessage(ctx, supervisor.queues[0], &types.Message{
+		Body:          aws.String("AAH/"),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+	})
+	assert.Equal(t, "delivered", result.status)
+	assert.Equal(t, []byte{0x00, 0x01, 0xff}, body)
+
+	body = nil
+	result = supervisor.processMessage(ctx, supervisor.queues[0], &types.Message{
+		Body:          aws.String("not base64!"),
+		MessageId:     aws.String("m2"),
+		ReceiptHandle: aws.String("r2"),
+	})
+	assert.Equal(t, dispositionRetry, result.disposition)
+	assert.Equal(t, FailureDecodeError, result.reason)
+	assert.Nil(t, body)
+}
+
+func TestSupervisorMaxBodyBytes(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	mockSQS := &mockSQS{}
+	var sent []string
+	mockSQS.sendMessageFunc = func(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+		sent = append(sent, *input.MessageBody)
+		return &sqs.SendMessageOutput{}, nil
+	}
+
+	message := &types.Message{
+		Body:          aws.String("0123456789"),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+	}
+
+	metrics := NewMetrics(prometheus.NewRegistry())
+	for _, errorQueueURL := range []string{"", "https://error.queue"} {
+		supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+			QueueURL:      "https://queue.url/orders",
+			HTTPURL:       ts.URL,
+			MaxBodyBytes:  9,
+			ErrorQueueURL: errorQueueURL,
+			Metrics:       metrics,
+		})
+
+		ctx, cancel := supervisor.inFlightContext(time.Now())
+		result := supervisor.processMessage(ctx, supervisor.queues[0], message)
+		cancel()
+
+		assert.Equal(t, dispositionDelete, result.disposition, errorQueueURL)
+		assert.Equal(t, FailureBodyTooLarge, result.reason, errorQueueURL)
+	}
+
+	assert.Zero(t, requests.Load())
+	assert.Equal(t, []string{"0123456789"}, sent)
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.dropped.WithLabelValues("orders", "too-large")))
+
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+		HTTPURL:      ts.URL,
+		MaxBodyBytes: 10,
+	})
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+	assert.Equal(t, "delivered", supervisor.processMessage(ctx, supervisor.queues[0], message).status)
+}
+
+func TestSupervisorHTTPMaxBodySize(t *testing.T) {
+	var (
+		requests atomic.Int32
+		header   http.Header
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		header = r.Header
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	mockSQS := &mockSQS{}
+	var sent []string
+	mockSQS.sendMessageFunc = func(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+		sent = append(sent, *input.MessageBody)
+		return &sqs.SendMessageOutput{}, nil
+	}
+
+	message := &types.Message{
+		Body:          aws.String("0123456789"),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+	}
+
+	// The body template makes the request body longer than the message body.
+	tmpl, err := ParseBodyTemplate(`{"body": {{ .Body | json }}}`)
+	assert.NoError(t, err)
+
+	for _, errorQueueURL := range []string{"", "https://error.queue"} {
+		supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+			HTTPURL:         ts.URL,
+			BodyTemplate:    tmpl,
+			HTTPMaxBodySize: 16,
+			HTTPMaxRetries:  2,
+			ErrorQueueURL:   errorQueueURL,
+		})
+
+		ctx, cancel := supervisor.inFlightContext(time.Now())
+		result := supervisor.processMessage(ctx, supervisor.queues[0], message)
+		cancel()
+
+		assert.Equal(t, dispositionDelete, result.disposition, errorQueueURL)
+		assert.Equal(t, FailureBodyTooLarge, result.reason, errorQueueURL)
+	}
+
+	assert.Zero(t, requests.Load())
+	assert.Equal(t, []string{"0123456789"}, sent)
+
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+		HTTPURL:         ts.URL,
+		HTTPMaxBodySize: 10,
+	})
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+	assert.Equal(t, "delivered", supervisor.processMessage(ctx, supervisor.queues[0], message).status)
+	assert.Equal(t, "10", header.Get("Content-Length"))
+	assert.Equal(t, "10", header.Get("X-Aws-Sqsd-Msg-Size"))
+}
@@ -0,0 +1,204 @@
+package supervisor
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// circuitProbeWait is how long workers wait before checking again while a
+// half-open circuit breaker probes the worker.
+const circuitProbeWait = time.Second
+
+// errCircuitOpen is returned instead of delivering a message while the
+// circuit breaker is open.
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (c circuitState) String() string {
+	switch c {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	}
+
+	return "closed"
+}
+
+// circuitBreaker stops deliveries after threshold consecutive failures. Once
+// open, it lets a single probe through after openDuration: the circuit closes
+// again if the probe succeeds, and reopens if it fails. A nil
+// *circuitBreaker never opens.
+type circuitBreaker struct {
+	threshold    int
+	openDuration time.Duration
+	// onChange is called outside the lock on every state change.
+	onChange func(from, to circuitState)
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// newCircuitBreaker returns nil when threshold is not positive.
+func newCircuitBreaker(threshold int, openDuration time.Duration, onChange func(from, to circuitState)) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+
+	return &circuitBreaker{
+		threshold:    threshold,
+		openDuration: openDuration,
+		onChange:     onChange,
+	}
+}
+
+// allow reports whether a delivery may be made. Every allowed delivery must be
+// followed by a record or an abort.
+func (b *circuitBreaker) allow(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	from := b.state
+	allowed := true
+
+	switch b.state {
+	case circuitOpen:
+		if now.Before(b.openedAt.Add(b.openDuration)) {
+			allowed = false
+			break
+		}
+
+		b.state = circuitHalfOpen
+		b.probing = true
+	case circuitHalfOpen:
+		if b.probing {
+			allowed = false
+			break
+		}
+
+		b.probing = true
+	}
+
+	to := b.state
+	b.mu.Unlock()
+
+	b.changed(from, to)
+
+	return allowed
+}
+
+// record records the outcome of an allowed delivery.
+func (b *circuitBreaker) record(now time.Time, ok bool) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	from := b.state
+
+	switch {
+	case ok:
+		b.failures = 0
+		b.state = circuitClosed
+	case b.state == circuitHalfOpen:
+		b.state = circuitOpen
+		b.openedAt = now
+	default:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.state = circuitOpen
+			b.openedAt = now
+		}
+	}
+	b.probing = false
+
+	to := b.state
+	b.mu.Unlock()
+
+	b.changed(from, to)
+}
+
+// abort records that an allowed delivery says nothing about the health of the
+// worker, e.g. because it was canceled.
+func (b *circuitBreaker) abort() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// pause returns how long workers should wait before receiving again, or zero
+// if deliveries may be made.
+func (b *circuitBreaker) pause(now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if wait := b.openedAt.Add(b.openDuration).Sub(now); wait > 0 {
+			return wait
+		}
+	case circuitHalfOpen:
+		if b.probing {
+			return circuitProbeWait
+		}
+	}
+
+	return 0
+}
+
+func (b *circuitBreaker) changed(from, to circuitState) {
+	if from != to && b.onChange != nil {
+		b.onChange(from, to)
+	}
+}
+
+// circuitFailure reports whether a delivery outcome counts as a failure of
+// the worker for the circuit breaker: it could not be reached, did not respond
+// in time, or responded with a 5xx status code.
+func circuitFailure(res *http.Response, err error) bool {
+	if err != nil {
+		reason := failureReasonForError(err)
+		return reason == FailureConnectionError || reason == FailureHTTPTimeout
+	}
+
+	return failureReasonForStatus(res.StatusCode) == FailureHTTP5xx
+}
+
+// circuitChanged logs and records state changes of the circuit breaker.
+func (s *Supervisor) circuitChanged(from, to circuitState) {
+	for _, q := range s.queues {
+		s.workerConfig.Metrics.setCircuitState(q.url, to)
+	}
+
+	switch to {
+	case circuitOpen:
+		s.logger.Warnf("Circuit breaker opened, pausing deliveries for %s", s.workerConfig.CircuitOpenDuration)
+	case circuitHalfOpen:
+		s.logger.Info("Circuit breaker half-open, probing the worker")
+	case circuitClosed:
+		s.logger.Info("Circuit breaker closed, resuming deliveries")
+	}
+}
@@ -0,0 +1,133 @@
+package supervisor
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var changes []string
+	breaker := newCircuitBreaker(2, time.Minute, func(from, to circuitState) {
+		changes = append(changes, from.String()+">"+to.String())
+	})
+
+	now := time.Now()
+
+	assert.True(t, breaker.allow(now))
+	breaker.record(now, false)
+	assert.True(t, breaker.allow(now))
+	breaker.record(now, true)
+	assert.True(t, breaker.allow(now))
+	breaker.record(now, false)
+	assert.Zero(t, breaker.pause(now))
+
+	assert.True(t, breaker.allow(now))
+	breaker.record(now, false)
+	assert.Equal(t, []string{"closed>open"}, changes)
+	assert.False(t, breaker.allow(now))
+	assert.Equal(t, time.Minute, breaker.pause(now))
+
+	// A single probe goes through once the circuit has been open long enough.
+	now = now.Add(time.Minute)
+	assert.Zero(t, breaker.pause(now))
+	assert.True(t, breaker.allow(now))
+	assert.False(t, breaker.allow(now))
+	assert.Equal(t, circuitProbeWait, breaker.pause(now))
+
+	breaker.record(now, false)
+	assert.Equal(t, []string{"closed>open", "open>half-open", "half-open>open"}, changes)
+	assert.False(t, breaker.allow(now))
+
+	// A canceled probe lets another one through.
+	now = now.Add(time.Minute)
+	assert.True(t, breaker.allow(now))
+	breaker.abort()
+	assert.True(t, breaker.allow(now))
+
+	breaker.record(now, true)
+	assert.Equal(t, "half-open>closed", changes[len(changes)-1])
+	assert.True(t, breaker.allow(now))
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	breaker := newCircuitBreaker(0, time.Minute, nil)
+	assert.Nil(t, breaker)
+
+	breaker.record(time.Now(), false)
+	assert.True(t, breaker.allow(time.Now()))
+	assert.Zero(t, breaker.pause(time.Now()))
+}
+
+func TestCircuitFailure(t *testing.T) {
+	assert.True(t, circuitFailure(nil, errors.New("connection refused")))
+	assert.False(t, circuitFailure(nil, &decodeError{err: errors.New("invalid")}))
+	assert.True(t, circuitFailure(&http.Response{StatusCode: http.StatusBadGateway}, nil))
+	assert.False(t, circuitFailure(&http.Response{StatusCode: http.StatusBadRequest}, nil))
+	assert.False(t, circuitFailure(&http.Response{StatusCode: http.StatusOK}, nil))
+}
+
+func TestSupervisorCircuitBreaker(t *testing.T) {
+	var (
+		requests atomic.Int32
+		healthy  atomic.Bool
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if healthy.Load() {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	metrics := NewMetrics(prometheus.NewRegistry())
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		QueueURL:                "https://queue.url/jobs",
+		HTTPURL:                 ts.URL,
+		CircuitFailureThreshold: 2,
+		CircuitOpenDuration:     20 * time.Millisecond,
+		Metrics:                 metrics,
+	})
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	process := func() messageResult {
+		return supervisor.processMessage(ctx, supervisor.queues[0], &types.Message{
+			Body:          aws.String("message"),
+			MessageId:     aws.String("m1"),
+			ReceiptHandle: aws.String("r1"),
+		})
+	}
+
+	assert.Equal(t, FailureHTTP5xx, process().reason)
+	assert.Equal(t, FailureHTTP5xx, process().reason)
+
+	result := process()
+	assert.Equal(t, FailureCircuitOpen, result.reason)
+	assert.Equal(t, dispositionRetry, result.disposition)
+	assert.Equal(t, int32(2), requests.Load())
+	assert.True(t, supervisor.breaker.pause(time.Now()) > 0)
+	assert.Equal(t, float64(circuitOpen), testutil.ToFloat64(metrics.circuitState.WithLabelValues("jobs")))
+
+	time.Sleep(20 * time.Millisecond)
+	healthy.Store(true)
+
+	assert.Equal(t, "delivered", process().status)
+	assert.Equal(t, int32(3), requests.Load())
+	assert.Equal(t, float64(circuitClosed), testutil.ToFloat64(metrics.circuitState.WithLabelValues("jobs")))
+}
@@ -0,0 +1,104 @@
+package supervisor
+
+import "sync"
+
+// messageCapacity bounds how many received messages are in flight across all
+// the workers of a supervisor. Workers reserve capacity before receiving and
+// ask SQS for no more messages than they reserved. A nil *messageCapacity
+// never limits.
+type messageCapacity struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	free   int
+	closed bool
+}
+
+// newMessageCapacity returns nil when max is not positive.
+func newMessageCapacity(max int) *messageCapacity {
+	if max <= 0 {
+		return nil
+	}
+
+	c := &messageCapacity{free: max}
+	c.cond = sync.NewCond(&c.mu)
+
+	return c
+}
+
+// acquire blocks until capacity is free and reserves up to n messages,
+// returning how many. It returns false once the capacity is closed.
+func (c *messageCapacity) acquire(n int) (int, bool) {
+	if c == nil {
+		return n, true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.free == 0 && !c.closed {
+		c.cond.Wait()
+	}
+
+	if c.closed {
+		return 0, false
+	}
+
+	if n < 1 {
+		// SQS receives a single message when none is asked for.
+		n = 1
+	}
+	if n > c.free {
+		n = c.free
+	}
+	c.free -= n
+
+	return n, true
+}
+
+// tryAcquire reserves up to n messages without waiting, returning how many.
+// It returns zero when no capacity is free or it is closed.
+func (c *messageCapacity) tryAcquire(n int) int {
+	if c == nil {
+		return n
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return 0
+	}
+
+	if n > c.free {
+		n = c.free
+	}
+	c.free -= n
+
+	return n
+}
+
+// release frees n reserved messages.
+func (c *messageCapacity) release(n int) {
+	if c == nil || n == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	c.free += n
+	c.mu.Unlock()
+
+	c.cond.Broadcast()
+}
+
+// close wakes up and fails every pending and future acquire.
+func (c *messageCapacity) close() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+
+	c.cond.Broadcast()
+}
@@ -0,0 +1,114 @@
+package supervisor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageCapacity(t *testing.T) {
+	capacity := newMessageCapacity(5)
+
+	n, ok := capacity.acquire(10)
+	assert.True(t, ok)
+	assert.Equal(t, 5, n)
+
+	acquired := make(chan int)
+	go func() {
+		n, _ := capacity.acquire(10)
+		acquired <- n
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquire did not wait for free capacity")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	capacity.release(2)
+	assert.Equal(t, 2, <-acquired)
+
+	go func() {
+		_, ok := capacity.acquire(1)
+		assert.False(t, ok)
+		acquired <- 0
+	}()
+	capacity.close()
+	<-acquired
+
+	n, ok = newMessageCapacity(0).acquire(10)
+	assert.True(t, ok)
+	assert.Equal(t, 10, n)
+}
+
+func TestSupervisorMaxInFlightMessages(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			max := maxInFlight.Load()
+			if n <= max || maxInFlight.CompareAndSwap(max, n) {
+				break
+			}
+		}
+
+		time.Sleep(5 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	mockSQS := &mockSQS{}
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+		HTTPURL:             ts.URL,
+		QueueMaxMessages:    10,
+		BatchConcurrency:    10,
+		MaxInFlightMessages: 3,
+	})
+
+	var received, maxRequested atomic.Int32
+	mockSQS.receiveMessageFunc = func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		requested := int32(input.MaxNumberOfMessages)
+		if requested > maxRequested.Load() {
+			maxRequested.Store(requested)
+		}
+
+		if received.Add(requested) > 30 {
+			supervisor.Shutdown()
+			return &sqs.ReceiveMessageOutput{}, nil
+		}
+
+		output := &sqs.ReceiveMessageOutput{}
+		for i := int32(0); i < requested; i++ {
+			id := fmt.Sprintf("m%d-%d", received.Load(), i)
+			output.Messages = append(output.Messages, types.Message{
+				Body:          aws.String("message"),
+				MessageId:     aws.String(id),
+				ReceiptHandle: aws.String("r" + id),
+			})
+		}
+
+		return output, nil
+	}
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		return &sqs.DeleteMessageBatchOutput{}, nil
+	}
+
+	supervisor.Start(4)
+	supervisor.Wait()
+
+	assert.LessOrEqual(t, maxRequested.Load(), int32(3))
+	assert.LessOrEqual(t, maxInFlight.Load(), int32(3))
+	assert.Positive(t, maxInFlight.Load())
+}
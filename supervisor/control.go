@@ -0,0 +1,195 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// pauseCheckInterval is how often paused workers check whether they were
+// resumed.
+const pauseCheckInterval = time.Second
+
+// drainCheckInterval is how often Drain checks whether messages are still
+// being processed.
+const drainCheckInterval = 100 * time.Millisecond
+
+// errNotRunning is returned when changing the workers of a supervisor that
+// was not started or is shutting down.
+var errNotRunning = errors.New("supervisor is not running")
+
+// Pause stops the workers from receiving messages until Resume is called.
+// Messages already received are processed.
+func (s *Supervisor) Pause() {
+	if !s.paused.Swap(true) {
+		s.logger.Info("Receiving paused")
+	}
+}
+
+// Resume lets the workers receive messages again after Pause or Drain.
+func (s *Supervisor) Resume() {
+	if s.paused.Swap(false) {
+		s.logger.Info("Receiving resumed")
+	}
+}
+
+// Paused reports whether receiving is paused.
+func (s *Supervisor) Paused() bool {
+	return s.paused.Load()
+}
+
+// Drain pauses receiving like Pause, then blocks until every received
+// message has been processed or ctx is done, returning the error of ctx in
+// the latter case.
+func (s *Supervisor) Drain(ctx context.Context) error {
+	s.Pause()
+
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+
+	for s.inFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+// inFlight is how many received messages are being processed.
+func (s *Supervisor) inFlight() int {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+
+	return len(s.stats.inFlight)
+}
+
+// countEmptyReceive records an empty receive of worker id. Receives while
+// the worker still processes pending batches, or while any message is in
+// flight or awaiting an acknowledgment, don't count, as failed messages may
+// be received again. The supervisor shuts down once every worker made
+// ExitAfterEmptyReceives consecutive empty receives.
+func (s *Supervisor) countEmptyReceive(id int, pending int) {
+	max := s.workerConfig.ExitAfterEmptyReceives
+	if max <= 0 || pending > 0 || s.inFlight() > 0 || s.awaitingAck() > 0 {
+		return
+	}
+
+	s.workersMu.Lock()
+	s.emptyReceives[id]++
+	count := s.emptyReceives[id]
+	all := true
+	for worker := range s.aliveWorkers {
+		if s.emptyReceives[worker] < max {
+			all = false
+			break
+		}
+	}
+	s.workersMu.Unlock()
+
+	switch {
+	case count < max:
+	case all:
+		s.logger.Infof("No worker received a message in %d consecutive receives, shutting down", max)
+		s.Shutdown()
+	case count == max:
+		s.logger.WithField("worker", id).Infof("No message received in %d consecutive receives, waiting for the other workers", max)
+	}
+}
+
+// resetEmptyReceives lets every worker that made ExitAfterEmptyReceives
+// consecutive empty receives poll again, after a worker received messages.
+func (s *Supervisor) resetEmptyReceives() {
+	if s.workerConfig.ExitAfterEmptyReceives <= 0 {
+		return
+	}
+
+	s.workersMu.Lock()
+	defer s.workersMu.Unlock()
+
+	for id := range s.emptyReceives {
+		delete(s.emptyReceives, id)
+	}
+}
+
+// drainedWorker reports whether the worker id made ExitAfterEmptyReceives
+// consecutive empty receives and stops polling until the others did too.
+func (s *Supervisor) drainedWorker(id int) bool {
+	max := s.workerConfig.ExitAfterEmptyReceives
+	if max <= 0 {
+		return false
+	}
+
+	s.workersMu.Lock()
+	defer s.workersMu.Unlock()
+
+	return s.emptyReceives[id] >= max
+}
+
+// SetWorkers changes the number of workers of a running supervisor. Workers
+// started beyond the pollers and ramp-up limits computed by Start don't raise
+// them. Workers in excess stop once they have processed the messages they
+// hold.
+func (s *Supervisor) SetWorkers(numWorkers int) error {
+	if numWorkers < 1 {
+		return errors.New("at least one worker is required")
+	}
+
+	s.workersMu.Lock()
+	defer s.workersMu.Unlock()
+
+	if s.targetWorkers == 0 || s.shutdown.Load() {
+		return errNotRunning
+	}
+
+	if numWorkers != s.targetWorkers {
+		s.logger.Infof("Changing the number of workers from %d to %d", s.targetWorkers, numWorkers)
+	}
+	s.startWorkers(numWorkers)
+
+	return nil
+}
+
+// Workers returns the number of workers the supervisor runs, which workers
+// stopping after SetWorkers may still exceed.
+func (s *Supervisor) Workers() int {
+	s.workersMu.Lock()
+	defer s.workersMu.Unlock()
+
+	return s.targetWorkers
+}
+
+// startWorkers starts the workers missing to have numWorkers of them.
+// s.workersMu must be held.
+func (s *Supervisor) startWorkers(numWorkers int) {
+	s.targetWorkers = numWorkers
+
+	for id := 0; id < numWorkers; id++ {
+		if s.aliveWorkers[id] {
+			continue
+		}
+
+		s.aliveWorkers[id] = true
+		s.wg.Add(1)
+		go s.worker(id)
+	}
+}
+
+// retireWorker reports whether the worker id is in excess of the number of
+// workers set and must stop.
+func (s *Supervisor) retireWorker(id int) bool {
+	s.workersMu.Lock()
+	defer s.workersMu.Unlock()
+
+	if !s.aliveWorkers[id] || id < s.targetWorkers {
+		return false
+	}
+
+	delete(s.aliveWorkers, id)
+	delete(s.emptyReceives, id)
+	s.logger.WithField("worker", id).Info("Stopping worker")
+
+	return true
+}
@@ -0,0 +1,202 @@
+package supervisor
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorSetWorkers(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	mockSQS := &mockSQS{}
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		time.Sleep(time.Millisecond)
+		return &sqs.ReceiveMessageOutput{}, nil
+	}
+	supervisor := New(mockSQS, nil, WithLogger(log.WithFields(log.Fields{})))
+
+	assert.Equal(t, errNotRunning, supervisor.SetWorkers(2))
+
+	supervisor.Start(2)
+	defer supervisor.Wait()
+	defer supervisor.Shutdown()
+
+	assert.NoError(t, supervisor.SetWorkers(4))
+	assert.Equal(t, 4, supervisor.Workers())
+	assert.Eventually(t, func() bool { return supervisor.Stats().Workers == 4 }, time.Second, time.Millisecond)
+
+	assert.NoError(t, supervisor.SetWorkers(1))
+	assert.Eventually(t, func() bool { return supervisor.Stats().Workers == 1 }, time.Second, time.Millisecond)
+
+	assert.Error(t, supervisor.SetWorkers(0))
+	assert.Equal(t, 1, supervisor.Workers())
+}
+
+func TestSupervisorPauseAndDrain(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	var receives int32
+	mockSQS := &mockSQS{}
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		if atomic.AddInt32(&receives, 1) > 1 {
+			time.Sleep(time.Millisecond)
+			return &sqs.ReceiveMessageOutput{}, nil
+		}
+
+		return &sqs.ReceiveMessageOutput{Messages: []types.Message{{
+			Body:          aws.String("body"),
+			MessageId:     aws.String("m1"),
+			ReceiptHandle: aws.String("r1"),
+		}}}, nil
+	}
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+		QueueURL: "https://queue.url/orders",
+		HTTPURL:  ts.URL,
+	})
+	supervisor.Start(1)
+	defer supervisor.Wait()
+	defer supervisor.Shutdown()
+
+	assert.Eventually(t, func() bool { return supervisor.Stats().InFlight == 1 }, time.Second, time.Millisecond)
+
+	// Draining waits for the message being delivered.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, supervisor.Drain(ctx))
+	assert.True(t, supervisor.Paused())
+
+	close(release)
+	assert.NoError(t, supervisor.Drain(context.Background()))
+	assert.Equal(t, 0, supervisor.Stats().InFlight)
+
+	// Paused workers don't receive.
+	paused := atomic.LoadInt32(&receives)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, paused, atomic.LoadInt32(&receives))
+
+	supervisor.Resume()
+	assert.False(t, supervisor.Stats().Paused)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&receives) > paused }, 2*time.Second, time.Millisecond)
+}
+
+func TestSupervisorExitAfterEmptyReceives(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	var receives int32
+	mockSQS := &mockSQS{}
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		time.Sleep(time.Millisecond)
+
+		// The empty receives before the messages are not counted with those after.
+		if n := atomic.AddInt32(&receives, 1); n == 3 {
+			return &sqs.ReceiveMessageOutput{Messages: []types.Message{
+				{Body: aws.String("a"), MessageId: aws.String("m1"), ReceiptHandle: aws.String("r1")},
+				{Body: aws.String("b"), MessageId: aws.String("m2"), ReceiptHandle: aws.String("r2")},
+			}}, nil
+		}
+
+		return &sqs.ReceiveMessageOutput{}, nil
+	}
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+		QueueURL:               "https://queue.url/orders",
+		HTTPURL:                ts.URL,
+		ExitAfterEmptyReceives: 5,
+	})
+	supervisor.Start(2)
+
+	stopped := make(chan struct{})
+	go func() {
+		supervisor.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		supervisor.Shutdown()
+		t.Fatal("supervisor did not stop after consecutive empty receives")
+	}
+
+	stats := supervisor.Stats()
+	assert.Equal(t, int64(2), stats.Processed)
+	assert.True(t, atomic.LoadInt32(&receives) >= 8)
+}
+
+func TestSupervisorExitAfterEmptyReceivesAllWorkers(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	var (
+		supervisor *Supervisor
+		receives   int32
+		running    int32
+	)
+	mockSQS := &mockSQS{}
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		// One worker's long poll returns messages only after the other
+		// workers are done with their empty receives.
+		if n := atomic.AddInt32(&receives, 1); n == 2 {
+			time.Sleep(200 * time.Millisecond)
+			atomic.StoreInt32(&running, supervisor.runningWorkers.Load())
+
+			return &sqs.ReceiveMessageOutput{Messages: []types.Message{
+				{Body: aws.String("a"), MessageId: aws.String("m1"), ReceiptHandle: aws.String("r1")},
+				{Body: aws.String("b"), MessageId: aws.String("m2"), ReceiptHandle: aws.String("r2")},
+			}}, nil
+		}
+
+		time.Sleep(time.Millisecond)
+		return &sqs.ReceiveMessageOutput{}, nil
+	}
+
+	log.SetOutput(ioutil.Discard)
+	supervisor = NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+		QueueURL:               "https://queue.url/orders",
+		HTTPURL:                ts.URL,
+		ExitAfterEmptyReceives: 3,
+	})
+	supervisor.Start(4)
+
+	stopped := make(chan struct{})
+	go func() {
+		supervisor.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		supervisor.Shutdown()
+		t.Fatal("supervisor did not stop after every worker drained")
+	}
+
+	// The workers done with their empty receives wait for the last one
+	// rather than stop, and poll again once it received messages.
+	assert.Equal(t, int32(4), atomic.LoadInt32(&running))
+	assert.Equal(t, int64(2), supervisor.Stats().Processed)
+	assert.True(t, atomic.LoadInt32(&receives) >= 2+4*3, "%d receives", atomic.LoadInt32(&receives))
+}
@@ -0,0 +1,48 @@
+package supervisor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// correlationID returns a stable identifier derived from the message body and,
+// optionally, its string attributes, so redeliveries of the same content carry
+// the same ID.
+func correlationID(msg *types.Message, includeAttributes bool) string {
+	h := sha256.New()
+	h.Write([]byte(aws.ToString(msg.Body)))
+
+	if includeAttributes {
+		names := make([]string, 0, len(msg.MessageAttributes))
+		for name := range msg.MessageAttributes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			h.Write([]byte{0})
+			h.Write([]byte(name))
+			h.Write([]byte{0})
+			h.Write([]byte(aws.ToString(msg.MessageAttributes[name].StringValue)))
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// requestID returns the ID sent in RequestIDHeader with msg: the value of its
+// RequestIDAttribute, or its message ID. Unlike correlation IDs, it differs
+// between messages with the same body.
+func (s *Supervisor) requestID(msg *types.Message) string {
+	if name := s.workerConfig.RequestIDAttribute; len(name) > 0 {
+		if attr, ok := msg.MessageAttributes[name]; ok && len(aws.ToString(attr.StringValue)) > 0 {
+			return aws.ToString(attr.StringValue)
+		}
+	}
+
+	return aws.ToString(msg.MessageId)
+}
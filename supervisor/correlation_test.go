@@ -0,0 +1,111 @@
+package supervisor
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorrelationID(t *testing.T) {
+	a := &types.Message{
+		Body: aws.String("body"),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"type": {DataType: aws.String("String"), StringValue: aws.String("a")},
+		},
+	}
+	b := &types.Message{
+		Body: aws.String("body"),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"type": {DataType: aws.String("String"), StringValue: aws.String("b")},
+		},
+	}
+
+	assert.Len(t, correlationID(a, false), 32)
+	assert.Equal(t, correlationID(a, false), correlationID(b, false))
+	assert.NotEqual(t, correlationID(a, true), correlationID(b, true))
+	assert.NotEqual(t, correlationID(a, false), correlationID(&types.Message{Body: aws.String("other")}, false))
+}
+
+func TestSupervisorCorrelationIDHeader(t *testing.T) {
+	var ids []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids = append(ids, r.Header.Get("X-Correlation-Id"))
+
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		HTTPURL:             ts.URL,
+		CorrelationIDHeader: "X-Correlation-Id",
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	receiveCount := 0
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		receiveCount++
+
+		if receiveCount > 2 {
+			supervisor.Shutdown()
+			return &sqs.ReceiveMessageOutput{}, nil
+		}
+
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String("message 1"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+			}},
+		}, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	if assert.Len(t, ids, 2) {
+		assert.NotEmpty(t, ids[0])
+		assert.Equal(t, ids[0], ids[1])
+	}
+}
+
+func TestSupervisorRequestIDHeader(t *testing.T) {
+	var ids []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids = append(ids, r.Header.Get("X-Request-Id"))
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:            ts.URL,
+		RequestIDHeader:    "X-Request-Id",
+		RequestIDAttribute: "traceId",
+	})
+
+	for _, msg := range []*types.Message{
+		{Body: aws.String("same"), MessageId: aws.String("m1")},
+		{Body: aws.String("same"), MessageId: aws.String("m2")},
+		{Body: aws.String("same"), MessageId: aws.String("m3"), MessageAttributes: map[string]types.MessageAttributeValue{
+			"traceId": {DataType: aws.String("String"), StringValue: aws.String("t1")},
+		}},
+	} {
+		_, err := supervisor.httpRequest(context.Background(), supervisor.queues[0], msg)
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{"m1", "m2", "t1"}, ids)
+}
@@ -0,0 +1,481 @@
+package supervisor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"gopkg.in/yaml.v3"
+)
+
+// CronMode decides how a task is run on its schedule.
+type CronMode string
+
+const (
+	// CronModeDirect POSTs to the URL of the task.
+	CronModeDirect CronMode = "direct"
+	// CronModeEnqueue sends a message for the task to the first queue, which
+	// is then delivered to the URL of the task like Elastic Beanstalk does.
+	CronModeEnqueue CronMode = "enqueue"
+)
+
+// Message attributes of the messages enqueued for a task, named like those of
+// Elastic Beanstalk.
+const (
+	cronTaskNameAttribute    = "beanstalk.sqsd.task_name"
+	cronScheduledAtAttribute = "beanstalk.sqsd.scheduled_time"
+
+	cronMessageBody = "elasticbeanstalk scheduled job"
+)
+
+// cronLockTimeout bounds the time spent acquiring a CronLock.
+const cronLockTimeout = 10 * time.Second
+
+// CronLock elects the replica that runs a task when several of them share the
+// same tasks.
+type CronLock interface {
+	// Acquire reports whether the caller is the first to claim the run of
+	// task scheduled at scheduledAt.
+	Acquire(ctx context.Context, task string, scheduledAt time.Time) (bool, error)
+}
+
+// CronTask is a periodic task that POSTs to the worker on a schedule,
+// independently of the queue.
+type CronTask struct {
+	Name string
+	// URL is the path, relative to the worker URL, or the absolute URL that
+	// is requested.
+	URL      string
+	Schedule *CronSchedule
+}
+
+type cronFile struct {
+	Version int `yaml:"version"`
+	Cron    []struct {
+		Name     string `yaml:"name"`
+		URL      string `yaml:"url"`
+		Schedule string `yaml:"schedule"`
+	} `yaml:"cron"`
+}
+
+// LoadCronTasks reads the tasks of a cron.yaml file in the format used by
+// Elastic Beanstalk worker environments.
+func LoadCronTasks(path string) ([]CronTask, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file cronFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	tasks := make([]CronTask, 0, len(file.Cron))
+	names := make(map[string]bool)
+	for i, entry := range file.Cron {
+		if len(entry.Name) == 0 {
+			return nil, fmt.Errorf("task %d has no name", i)
+		}
+		if names[entry.Name] {
+			return nil, fmt.Errorf("task %s is defined more than once", entry.Name)
+		}
+		names[entry.Name] = true
+
+		if len(entry.URL) == 0 {
+			return nil, fmt.Errorf("task %s has no url", entry.Name)
+		}
+		if _, err := url.Parse(entry.URL); err != nil {
+			return nil, fmt.Errorf("task %s: %s", entry.Name, err)
+		}
+
+		schedule, err := ParseCronSchedule(entry.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("task %s: %s", entry.Name, err)
+		}
+
+		tasks = append(tasks, CronTask{Name: entry.Name, URL: entry.URL, Schedule: schedule})
+	}
+
+	return tasks, nil
+}
+
+// CronSchedule is a parsed cron expression. Schedules are evaluated in UTC.
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+type cronField struct {
+	min, max int
+}
+
+var cronFields = [5]cronField{
+	{0, 59}, // minute
+	{0, 23}, // hour
+	{1, 31}, // day of month
+	{1, 12}, // month
+	{0, 7},  // day of week, 0 and 7 both being Sunday
+}
+
+// ParseCronSchedule parses a standard five field cron expression: minute,
+// hour, day of month, month and day of week. Fields accept *, values,
+// ranges, lists and steps, e.g. "*/15 9-17 * * 1-5".
+func ParseCronSchedule(expr string) (*CronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression %q must have %d fields", expr, len(cronFields))
+	}
+
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %s", expr, err)
+		}
+		sets[i] = set
+	}
+
+	dow := sets[4]
+	if dow&(1<<7) != 0 {
+		dow |= 1
+	}
+
+	return &CronSchedule{
+		minute: sets[0],
+		hour:   sets[1],
+		dom:    sets[2],
+		month:  sets[3],
+		dow:    dow,
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, bounds cronField) (uint64, error) {
+	var set uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rng, step = part[:i], n
+		}
+
+		lo, hi := bounds.min, bounds.max
+		if rng != "*" {
+			var err error
+			if i := strings.Index(rng, "-"); i >= 0 {
+				lo, err = strconv.Atoi(rng[:i])
+				if err == nil {
+					hi, err = strconv.Atoi(rng[i+1:])
+				}
+			} else {
+				lo, err = strconv.Atoi(rng)
+				hi = lo
+				if step > 1 {
+					hi = bounds.max
+				}
+			}
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+		}
+
+		if lo < bounds.min || hi > bounds.max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, bounds.min, bounds.max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+
+	return set, nil
+}
+
+// Next returns the first time after t the schedule fires, or the zero time
+// if it never does.
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// dayMatches follows cron in firing on either the day of month or the day of
+// week when both are restricted.
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+// runCronTask runs task on its schedule until shutdown.
+func (s *Supervisor) runCronTask(task CronTask) {
+	defer s.wg.Done()
+
+	if s.workerConfig.StartupDelay > 0 {
+		s.sleep(s.workerConfig.StartupDelay)
+	}
+
+	var running atomic.Bool
+
+	for {
+		next := task.Schedule.Next(time.Now())
+		if next.IsZero() {
+			s.logger.Warnf("Task %s is never scheduled", task.Name)
+			return
+		}
+
+		select {
+		case <-s.done:
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		s.triggerCronTask(task, &running, next)
+	}
+}
+
+// triggerCronTask starts a run of task in the background unless the previous
+// one, tracked by running, is still in progress.
+func (s *Supervisor) triggerCronTask(task CronTask, running *atomic.Bool, scheduledAt time.Time) bool {
+	if !running.CompareAndSwap(false, true) {
+		s.logger.Warnf("Skipping task %s scheduled at %s, its previous run is still in progress", task.Name, scheduledAt.Format(time.RFC3339))
+		return false
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer running.Store(false)
+
+		s.fireCronTask(task, scheduledAt)
+	}()
+
+	return true
+}
+
+// fireCronTask runs task, scheduled at scheduledAt, unless another replica
+// acquired its CronLock first.
+func (s *Supervisor) fireCronTask(task CronTask, scheduledAt time.Time) {
+	if !s.claimCronTask(task, scheduledAt) {
+		return
+	}
+
+	if s.workerConfig.CronMode == CronModeEnqueue {
+		s.enqueueCronTask(task, scheduledAt)
+		return
+	}
+
+	s.postCronTask(task, scheduledAt)
+}
+
+// claimCronTask reports whether this replica runs task at scheduledAt. Every
+// replica does without a CronLock.
+func (s *Supervisor) claimCronTask(task CronTask, scheduledAt time.Time) bool {
+	if s.workerConfig.CronLock == nil {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cronLockTimeout)
+	defer cancel()
+
+	ok, err := s.workerConfig.CronLock.Acquire(ctx, task.Name, scheduledAt)
+	if err != nil {
+		s.logger.Errorf("Error while acquiring the lock of task %s: %s", task.Name, err)
+		return false
+	}
+	if !ok {
+		s.logger.Debugf("Task %s scheduled at %s is run by another replica", task.Name, scheduledAt.Format(time.RFC3339))
+	}
+
+	return ok
+}
+
+// enqueueCronTask sends a message for task to the first queue. On a FIFO
+// queue, the messages sent by every replica for the same run are
+// deduplicated.
+func (s *Supervisor) enqueueCronTask(task CronTask, scheduledAt time.Time) {
+	q := s.queues[0]
+	scheduled := scheduledAt.UTC().Format(time.RFC3339)
+
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.url),
+		MessageBody: aws.String(cronMessageBody),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			cronTaskNameAttribute: {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(task.Name),
+			},
+			cronScheduledAtAttribute: {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(scheduled),
+			},
+		},
+	}
+
+	if s.workerConfig.FIFO {
+		input.MessageGroupId = aws.String(cronHash(task.Name))
+		input.MessageDeduplicationId = aws.String(cronHash(task.Name + "@" + scheduled))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cronLockTimeout)
+	defer cancel()
+
+	if _, err := s.sqs.SendMessage(ctx, input); err != nil {
+		s.logger.Errorf("Error while enqueuing task %s: %s", task.Name, err)
+		return
+	}
+
+	s.logger.Debugf("Task %s scheduled at %s enqueued", task.Name, scheduled)
+}
+
+// cronHash returns an ID made of characters SQS accepts in MessageGroupId and
+// MessageDeduplicationId, whatever the characters of value.
+func cronHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// cronTask returns the task msg was enqueued for, if it is one of CronTasks.
+func (s *Supervisor) cronTask(msg *types.Message) (CronTask, bool) {
+	attr, ok := msg.MessageAttributes[cronTaskNameAttribute]
+	if !ok {
+		return CronTask{}, false
+	}
+
+	name := aws.ToString(attr.StringValue)
+	for _, task := range s.workerConfig.CronTasks {
+		if task.Name == name {
+			return task, true
+		}
+	}
+
+	return CronTask{}, false
+}
+
+// addCronTaskHeaders sets the headers a direct run of task sends on the
+// delivery of msg, the message enqueued for it.
+func addCronTaskHeaders(task CronTask, msg *types.Message, header http.Header) {
+	header.Set("X-Aws-Sqsd-Taskname", task.Name)
+	if attr, ok := msg.MessageAttributes[cronScheduledAtAttribute]; ok {
+		header.Set("X-Aws-Sqsd-Scheduled-At", aws.ToString(attr.StringValue))
+	}
+}
+
+// postCronTask POSTs to the URL of task.
+func (s *Supervisor) postCronTask(task CronTask, scheduledAt time.Time) {
+	q := s.queues[0]
+	ep := q.acquireEndpoint()
+	defer ep.release()
+
+	taskURL, err := cronTaskURL(ep.url, task.URL)
+	if err != nil {
+		s.logger.Errorf("Error while building the URL of task %s: %s", task.Name, err)
+		return
+	}
+
+	ctx := context.Background()
+	if s.workerConfig.HTTPTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.workerConfig.HTTPTimeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", taskURL, nil)
+	if err != nil {
+		s.logger.Errorf("Error while creating HTTP request for task %s: %s", task.Name, err)
+		return
+	}
+
+	req.Header.Set("X-Aws-Sqsd-Taskname", task.Name)
+	req.Header.Set("X-Aws-Sqsd-Scheduled-At", scheduledAt.UTC().Format(time.RFC3339))
+
+	if len(q.hmacSecretKey) > 0 {
+		if err := s.signRequest(req, taskURL, "", q.hmacSecretKey); err != nil {
+			s.logger.Errorf("Error while signing the request of task %s: %s", task.Name, err)
+			return
+		}
+	}
+
+	if s.workerConfig.Authenticator != nil {
+		if err := s.workerConfig.Authenticator.Authenticate(req, ""); err != nil {
+			s.logger.Errorf("Error while authenticating the request of task %s: %s", task.Name, err)
+			return
+		}
+	}
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		s.logger.Errorf("Error while running task %s: %s", task.Name, err)
+		return
+	}
+	res.Body.Close()
+
+	if res.StatusCode < http.StatusOK || res.StatusCode > 299 {
+		s.logger.Errorf("Task %s failed with status code %d", task.Name, res.StatusCode)
+		return
+	}
+
+	s.logger.Debugf("Task %s scheduled at %s succeeded", task.Name, scheduledAt.Format(time.RFC3339))
+}
+
+// cronTaskURL resolves the URL of a task against the worker URL.
+func cronTaskURL(workerURL, taskURL string) (string, error) {
+	base, err := url.Parse(workerURL)
+	if err != nil {
+		return "", err
+	}
+
+	ref, err := url.Parse(taskURL)
+	if err != nil {
+		return "", err
+	}
+
+	return base.ResolveReference(ref).String(), nil
+}
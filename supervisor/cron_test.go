@@ -0,0 +1,264 @@
+package supervisor
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCronSchedule(t *testing.T) {
+	tests := []struct {
+		expr string
+		from string
+		next string
+	}{
+		{"* * * * *", "2021-01-01T10:00:30Z", "2021-01-01T10:01:00Z"},
+		{"*/15 * * * *", "2021-01-01T10:01:00Z", "2021-01-01T10:15:00Z"},
+		{"0 9-17 * * *", "2021-01-01T17:30:00Z", "2021-01-02T09:00:00Z"},
+		{"30 2 1 * *", "2021-01-15T00:00:00Z", "2021-02-01T02:30:00Z"},
+		{"0 0 * * 1-5", "2021-01-01T12:00:00Z", "2021-01-04T00:00:00Z"},
+		{"0 0 * * 7", "2021-01-01T12:00:00Z", "2021-01-03T00:00:00Z"},
+		{"0 0 13 * 5", "2021-01-02T00:00:00Z", "2021-01-08T00:00:00Z"},
+		{"5,10 0 29 2 *", "2021-01-01T00:00:00Z", "2024-02-29T00:05:00Z"},
+	}
+
+	for _, test := range tests {
+		schedule, err := ParseCronSchedule(test.expr)
+		if !assert.NoError(t, err, test.expr) {
+			continue
+		}
+
+		from, _ := time.Parse(time.RFC3339, test.from)
+		assert.Equal(t, test.next, schedule.Next(from).Format(time.RFC3339), test.expr)
+	}
+}
+
+func TestParseCronScheduleInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		_, err := ParseCronSchedule(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestCronScheduleNever(t *testing.T) {
+	schedule, err := ParseCronSchedule("0 0 31 2 *")
+	assert.NoError(t, err)
+	assert.True(t, schedule.Next(time.Now()).IsZero())
+}
+
+func TestLoadCronTasks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cron")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cron.yaml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`version: 1
+cron:
+  - name: "cleanup"
+    url: "/cleanup"
+    schedule: "*/10 * * * *"
+  - name: "report"
+    url: "/report"
+    schedule: "0 6 * * 1"
+`), 0644))
+
+	tasks, err := LoadCronTasks(path)
+	assert.NoError(t, err)
+	if assert.Len(t, tasks, 2) {
+		assert.Equal(t, "cleanup", tasks[0].Name)
+		assert.Equal(t, "/cleanup", tasks[0].URL)
+		assert.Equal(t, "report", tasks[1].Name)
+		assert.NotNil(t, tasks[1].Schedule)
+	}
+
+	for _, content := range []string{
+		"cron:\n  - url: /a\n    schedule: \"* * * * *\"\n",
+		"cron:\n  - name: a\n    schedule: \"* * * * *\"\n",
+		"cron:\n  - name: a\n    url: /a\n    schedule: \"* *\"\n",
+		"cron:\n  - name: a\n    url: /a\n    schedule: \"* * * * *\"\n  - name: a\n    url: /b\n    schedule: \"* * * * *\"\n",
+	} {
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+
+		_, err := LoadCronTasks(path)
+		assert.Error(t, err, content)
+	}
+}
+
+func TestSupervisorFireCronTask(t *testing.T) {
+	var req *http.Request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:        ts.URL + "/messages",
+		HTTPHMACHeader: "hmac",
+		HMACSecretKey:  []byte("foobar"),
+	})
+
+	scheduledAt := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	supervisor.fireCronTask(CronTask{Name: "cleanup", URL: "/tasks/cleanup"}, scheduledAt)
+
+	if assert.NotNil(t, req) {
+		assert.Equal(t, "POST", req.Method)
+		assert.Equal(t, "/tasks/cleanup", req.URL.Path)
+		assert.Equal(t, "cleanup", req.Header.Get("X-Aws-Sqsd-Taskname"))
+		assert.Equal(t, "2021-01-01T10:00:00Z", req.Header.Get("X-Aws-Sqsd-Scheduled-At"))
+
+		hmac, _ := makeHMAC(HMACSHA256, "POST "+ts.URL+"/tasks/cleanup\n", []byte("foobar"))
+		assert.Equal(t, hmac, req.Header.Get("hmac"))
+	}
+}
+
+func TestSupervisorEnqueueCronTask(t *testing.T) {
+	var req *http.Request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	var sent *sqs.SendMessageInput
+	mockSQS := &mockSQS{
+		sendMessageFunc: func(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+			sent = input
+			return &sqs.SendMessageOutput{}, nil
+		},
+	}
+
+	log.SetOutput(ioutil.Discard)
+	task := CronTask{Name: "cleanup", URL: "/tasks/cleanup"}
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+		QueueURL:  "https://queue.url/jobs.fifo",
+		HTTPURL:   ts.URL + "/messages",
+		FIFO:      true,
+		CronTasks: []CronTask{task},
+		CronMode:  CronModeEnqueue,
+	})
+
+	scheduledAt := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	supervisor.fireCronTask(task, scheduledAt)
+	assert.Nil(t, req)
+
+	if assert.NotNil(t, sent) {
+		assert.Equal(t, "https://queue.url/jobs.fifo", aws.ToString(sent.QueueUrl))
+		assert.Equal(t, "cleanup", aws.ToString(sent.MessageAttributes[cronTaskNameAttribute].StringValue))
+		assert.Equal(t, "2021-01-01T10:00:00Z", aws.ToString(sent.MessageAttributes[cronScheduledAtAttribute].StringValue))
+		assert.NotEmpty(t, aws.ToString(sent.MessageGroupId))
+		assert.Len(t, aws.ToString(sent.MessageDeduplicationId), 64)
+
+		// The enqueued message is delivered to the URL of the task.
+		ctx, cancel := supervisor.inFlightContext(time.Now())
+		defer cancel()
+		supervisor.processMessage(ctx, supervisor.queues[0], &types.Message{
+			Body:              sent.MessageBody,
+			MessageAttributes: sent.MessageAttributes,
+			MessageId:         aws.String("m1"),
+			ReceiptHandle:     aws.String("r1"),
+		})
+
+		if assert.NotNil(t, req) {
+			assert.Equal(t, "/tasks/cleanup", req.URL.Path)
+			assert.Equal(t, "cleanup", req.Header.Get("X-Aws-Sqsd-Taskname"))
+			assert.Equal(t, "2021-01-01T10:00:00Z", req.Header.Get("X-Aws-Sqsd-Scheduled-At"))
+		}
+	}
+}
+
+type mockCronLock struct {
+	acquired map[string]bool
+}
+
+func (l *mockCronLock) Acquire(ctx context.Context, task string, scheduledAt time.Time) (bool, error) {
+	key := task + "@" + scheduledAt.String()
+	if l.acquired[key] {
+		return false, nil
+	}
+
+	l.acquired[key] = true
+	return true, nil
+}
+
+func TestSupervisorCronTaskLock(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	lock := &mockCronLock{acquired: make(map[string]bool)}
+	replicas := make([]*Supervisor, 3)
+	for i := range replicas {
+		replicas[i] = NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+			HTTPURL:  ts.URL,
+			CronLock: lock,
+		})
+	}
+
+	task := CronTask{Name: "cleanup", URL: "/tasks/cleanup"}
+	scheduledAt := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	for _, s := range replicas {
+		s.fireCronTask(task, scheduledAt)
+	}
+	assert.Equal(t, int32(1), requests.Load())
+
+	replicas[1].fireCronTask(task, scheduledAt.Add(time.Hour))
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestSupervisorCronTaskSuppressesOverlap(t *testing.T) {
+	var requests atomic.Int32
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-unblock
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL: ts.URL,
+	})
+
+	task := CronTask{Name: "slow", URL: "/slow"}
+	var running atomic.Bool
+
+	assert.True(t, supervisor.triggerCronTask(task, &running, time.Now()))
+	assert.False(t, supervisor.triggerCronTask(task, &running, time.Now()))
+
+	close(unblock)
+	supervisor.wg.Wait()
+
+	assert.Equal(t, int32(1), requests.Load())
+	assert.True(t, supervisor.triggerCronTask(task, &running, time.Now()))
+	supervisor.wg.Wait()
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestCronTaskURL(t *testing.T) {
+	u, err := cronTaskURL("http://localhost:8080/messages", "/tasks/a?x=1")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080/tasks/a?x=1", u)
+
+	u, err = cronTaskURL("http://localhost:8080/messages", "http://other/b")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://other/b", u)
+}
@@ -0,0 +1,64 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DynamoDBClient is the part of the DynamoDB API NewDynamoDBCronLock uses.
+// *dynamodb.Client implements it.
+type DynamoDBClient interface {
+	PutItem(ctx context.Context, input *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+var _ DynamoDBClient = (*dynamodb.Client)(nil)
+
+type dynamoDBCronLock struct {
+	dynamodb DynamoDBClient
+	table    string
+}
+
+// NewDynamoDBCronLock returns a CronLock keeping the last run of every task
+// in a DynamoDB table whose partition key is the string attribute "task".
+// The first replica to record a run acquires it.
+func NewDynamoDBCronLock(dynamodb DynamoDBClient, table string) CronLock {
+	return &dynamoDBCronLock{
+		dynamodb: dynamodb,
+		table:    table,
+	}
+}
+
+func (l *dynamoDBCronLock) Acquire(ctx context.Context, task string, scheduledAt time.Time) (bool, error) {
+	scheduled := strconv.FormatInt(scheduledAt.Unix(), 10)
+
+	_, err := l.dynamodb.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(l.table),
+		Item: map[string]ddbtypes.AttributeValue{
+			"task":        &ddbtypes.AttributeValueMemberS{Value: task},
+			"scheduledAt": &ddbtypes.AttributeValueMemberN{Value: scheduled},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#task) OR #scheduledAt < :scheduledAt"),
+		ExpressionAttributeNames: map[string]string{
+			"#task":        "task",
+			"#scheduledAt": "scheduledAt",
+		},
+		ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{
+			":scheduledAt": &ddbtypes.AttributeValueMemberN{Value: scheduled},
+		},
+	})
+	if err != nil {
+		var cerr *ddbtypes.ConditionalCheckFailedException
+		if errors.As(err, &cerr) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,60 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockDynamoDB struct {
+	putItemFunc func(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error)
+}
+
+func (m *mockDynamoDB) PutItem(ctx context.Context, input *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	return m.putItemFunc(input)
+}
+
+func TestDynamoDBCronLock(t *testing.T) {
+	last := map[string]string{}
+	db := &mockDynamoDB{
+		putItemFunc: func(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+			assert.Equal(t, "cron-locks", aws.ToString(input.TableName))
+
+			task := input.Item["task"].(*ddbtypes.AttributeValueMemberS).Value
+			scheduled := input.ExpressionAttributeValues[":scheduledAt"].(*ddbtypes.AttributeValueMemberN).Value
+			if prev, ok := last[task]; ok && prev >= scheduled {
+				return nil, &ddbtypes.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+			}
+
+			last[task] = scheduled
+			return &dynamodb.PutItemOutput{}, nil
+		},
+	}
+
+	lock := NewDynamoDBCronLock(db, "cron-locks")
+	scheduledAt := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	ok, err := lock.Acquire(context.Background(), "cleanup", scheduledAt)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = lock.Acquire(context.Background(), "cleanup", scheduledAt)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = lock.Acquire(context.Background(), "cleanup", scheduledAt.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	db.putItemFunc = func(*dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+		return nil, errors.New("network error")
+	}
+	_, err = lock.Acquire(context.Background(), "cleanup", scheduledAt.Add(2*time.Minute))
+	assert.Error(t, err)
+}
@@ -0,0 +1,185 @@
+package supervisor
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// deliveredCache remembers message IDs for a fixed window. A nil
+// *deliveredCache never contains anything.
+type deliveredCache struct {
+	sync.Mutex
+
+	window  time.Duration
+	entries map[string]time.Time
+}
+
+func newDeliveredCache(window time.Duration) *deliveredCache {
+	if window <= 0 {
+		return nil
+	}
+
+	return &deliveredCache{
+		window:  window,
+		entries: make(map[string]time.Time),
+	}
+}
+
+func (c *deliveredCache) add(id string) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	now := time.Now()
+	for k, expires := range c.entries {
+		if now.After(expires) {
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[id] = now.Add(c.window)
+}
+
+func (c *deliveredCache) contains(id string) bool {
+	if c == nil {
+		return false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	expires, ok := c.entries[id]
+	if !ok {
+		return false
+	}
+
+	if time.Now().After(expires) {
+		delete(c.entries, id)
+		return false
+	}
+
+	return true
+}
+
+// DedupStore remembers the keys of messages delivered to the worker, so that
+// a redelivery within DedupWindow is deleted without being delivered again.
+// A store may be shared by several supervisors, and by several daemons when
+// it is remote.
+type DedupStore interface {
+	// Seen reports whether key was recorded and has not expired.
+	Seen(ctx context.Context, key string) (bool, error)
+	// Record records key for ttl.
+	Record(ctx context.Context, key string, ttl time.Duration) error
+}
+
+// MemoryDedupStore is a DedupStore holding at most maxEntries keys in memory,
+// evicting the least recently recorded first.
+type MemoryDedupStore struct {
+	mu sync.Mutex
+
+	maxEntries int
+	order      *list.List
+	entries    map[string]*list.Element
+}
+
+type memoryDedupEntry struct {
+	key     string
+	expires time.Time
+}
+
+// NewMemoryDedupStore returns a MemoryDedupStore holding at most maxEntries
+// keys, or any number of them when maxEntries isn't positive.
+func NewMemoryDedupStore(maxEntries int) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (m *MemoryDedupStore) Seen(ctx context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.entries[key]
+	if !ok {
+		return false, nil
+	}
+
+	if time.Now().After(elem.Value.(*memoryDedupEntry).expires) {
+		m.order.Remove(elem)
+		delete(m.entries, key)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (m *MemoryDedupStore) Record(ctx context.Context, key string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expires := time.Now().Add(ttl)
+	if elem, ok := m.entries[key]; ok {
+		elem.Value.(*memoryDedupEntry).expires = expires
+		m.order.MoveToFront(elem)
+		return nil
+	}
+
+	m.entries[key] = m.order.PushFront(&memoryDedupEntry{key: key, expires: expires})
+
+	for m.maxEntries > 0 && m.order.Len() > m.maxEntries {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryDedupEntry).key)
+	}
+
+	return nil
+}
+
+// dedupKey returns the key msg is deduplicated by in the DedupStore: its
+// deduplication ID on a FIFO queue, its message ID otherwise, along with the
+// queue it was received from.
+func dedupKey(q *queue, msg *types.Message) string {
+	id := msg.Attributes[string(types.MessageSystemAttributeNameMessageDeduplicationId)]
+	if len(id) == 0 {
+		id = aws.ToString(msg.MessageId)
+	}
+
+	return q.url + "/" + id
+}
+
+// seenBefore reports whether msg was delivered within DedupWindow. Messages
+// are delivered when the store fails.
+func (s *Supervisor) seenBefore(ctx context.Context, q *queue, msg *types.Message) bool {
+	if s.workerConfig.DedupStore == nil || s.workerConfig.DedupWindow <= 0 {
+		return false
+	}
+
+	seen, err := s.workerConfig.DedupStore.Seen(ctx, dedupKey(q, msg))
+	if err != nil {
+		s.messageLogger(q, msg).Errorf("Error while looking up the message in the dedup store: %s", err)
+		return false
+	}
+
+	return seen
+}
+
+// recordDelivered records msg in the DedupStore once it was delivered.
+func (s *Supervisor) recordDelivered(ctx context.Context, q *queue, msg *types.Message) {
+	if s.workerConfig.DedupStore == nil || s.workerConfig.DedupWindow <= 0 {
+		return
+	}
+
+	if err := s.workerConfig.DedupStore.Record(ctx, dedupKey(q, msg), s.workerConfig.DedupWindow); err != nil {
+		s.messageLogger(q, msg).Errorf("Error while recording the message in the dedup store: %s", err)
+	}
+}
@@ -0,0 +1,89 @@
+package supervisor
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryDedupStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryDedupStore(2)
+
+	assert.NoError(t, store.Record(ctx, "a", time.Minute))
+	assert.NoError(t, store.Record(ctx, "b", time.Millisecond))
+
+	seen, _ := store.Seen(ctx, "a")
+	assert.True(t, seen)
+
+	time.Sleep(5 * time.Millisecond)
+	seen, _ = store.Seen(ctx, "b")
+	assert.False(t, seen, "expired keys are not seen")
+
+	// Recording a key again makes it the most recent one.
+	assert.NoError(t, store.Record(ctx, "c", time.Minute))
+	assert.NoError(t, store.Record(ctx, "a", time.Minute))
+	assert.NoError(t, store.Record(ctx, "d", time.Minute))
+
+	for key, want := range map[string]bool{"a": true, "c": false, "d": true} {
+		seen, _ := store.Seen(ctx, key)
+		assert.Equal(t, want, seen, key)
+	}
+}
+
+func TestSupervisorDedupStore(t *testing.T) {
+	deliveries := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deliveries++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		QueueURL:    "https://queue.url/jobs.fifo",
+		HTTPURL:     ts.URL,
+		FIFO:        true,
+		DedupStore:  NewMemoryDedupStore(0),
+		DedupWindow: time.Minute,
+	})
+
+	assert.Contains(t, supervisor.receiveAttributeNames(), types.MessageSystemAttributeNameMessageDeduplicationId)
+
+	message := func(id string, dedupID string) *types.Message {
+		return &types.Message{
+			Body:          aws.String("body"),
+			MessageId:     aws.String(id),
+			ReceiptHandle: aws.String("r-" + id),
+			Attributes: map[string]string{
+				string(types.MessageSystemAttributeNameMessageGroupId):         "g",
+				string(types.MessageSystemAttributeNameMessageDeduplicationId): dedupID,
+			},
+		}
+	}
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	result := supervisor.processMessage(ctx, supervisor.queues[0], message("m1", "order-1"))
+	assert.Equal(t, "delivered", result.status)
+
+	// A message sent again with the same deduplication ID past the SQS
+	// deduplication interval gets a new message ID.
+	result = supervisor.processMessage(ctx, supervisor.queues[0], message("m2", "order-1"))
+	assert.Equal(t, "duplicate", result.status)
+	assert.Equal(t, dispositionDelete, result.disposition)
+
+	result = supervisor.processMessage(ctx, supervisor.queues[0], message("m3", "order-2"))
+	assert.Equal(t, "delivered", result.status)
+
+	assert.Equal(t, 2, deliveries)
+}
@@ -0,0 +1,186 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// Deliverer delivers a message received from the queue at queueURL. The
+// returned response decides what happens to the message like an HTTP
+// worker's response would.
+type Deliverer interface {
+	Deliver(ctx context.Context, queueURL string, msg *types.Message) (*http.Response, error)
+}
+
+// deliver sends msg to the configured Deliverer, or to the HTTP worker when
+// there is none, once the request limiter lets it through.
+func (s *Supervisor) deliver(ctx context.Context, q *queue, msg *types.Message) (*http.Response, error) {
+	return s.send(ctx, q, func() (*http.Response, error) {
+		if s.workerConfig.Deliverer != nil {
+			return s.workerConfig.Deliverer.Deliver(ctx, q.url, msg)
+		}
+
+		return s.httpRequest(ctx, q, msg)
+	})
+}
+
+// Redeliver delivers msg like a message received from the first queue of the
+// supervisor, through the same filters, middleware, signing and retries,
+// without deleting it or changing its visibility. It returns an error unless
+// the message was delivered successfully.
+func (s *Supervisor) Redeliver(ctx context.Context, msg *types.Message) error {
+	q := s.queues[0]
+
+	result := messageResult{msg: msg}
+	delivery := s.prepareMessage(ctx, q, &result)
+	if delivery == nil {
+		status := result.status
+		if len(status) == 0 {
+			status = string(result.reason)
+		}
+
+		return fmt.Errorf("message was not delivered: %s", status)
+	}
+
+	res, err := s.deliverWithRetries(ctx, q, delivery)
+	if err != nil {
+		return err
+	}
+
+	if !s.successful(res.StatusCode) {
+		return fmt.Errorf("worker responded with status %d", res.StatusCode)
+	}
+	if s.softFailed(res) {
+		return errors.New("worker asked for the message to be retried")
+	}
+
+	return nil
+}
+
+// send makes a request to the worker for a message of q once the request
+// limiter and the circuit breaker let it through, and records its outcome in
+// the circuit breaker and its latency for back-pressure.
+func (s *Supervisor) send(ctx context.Context, q *queue, request func() (*http.Response, error)) (*http.Response, error) {
+	if err := s.requests.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer s.requests.release()
+
+	if !s.breaker.allow(time.Now()) {
+		return nil, errCircuitOpen
+	}
+
+	start := time.Now()
+	s.backpressure.begin()
+	s.workerConfig.Metrics.addInFlightRequests(q.url, 1)
+	res, err := request()
+	s.workerConfig.Metrics.addInFlightRequests(q.url, -1)
+	s.backpressure.end(start, time.Now(), ctx.Err() == nil)
+
+	if ctx.Err() != nil {
+		s.breaker.abort()
+	} else {
+		s.breaker.record(time.Now(), !circuitFailure(res, err))
+	}
+
+	return res, err
+}
+
+type sqsForwarder struct {
+	sqs      SQSClient
+	queueURL string
+}
+
+// NewSQSForwarder returns a Deliverer that sends messages to the queue at
+// queueURL, keeping their body and attributes.
+func NewSQSForwarder(sqs SQSClient, queueURL string) Deliverer {
+	return &sqsForwarder{
+		sqs:      sqs,
+		queueURL: queueURL,
+	}
+}
+
+func (f *sqsForwarder) Deliver(ctx context.Context, queueURL string, msg *types.Message) (*http.Response, error) {
+	input := &sqs.SendMessageInput{
+		QueueUrl:          aws.String(f.queueURL),
+		MessageBody:       msg.Body,
+		MessageAttributes: msg.MessageAttributes,
+	}
+
+	if groupID, ok := msg.Attributes[string(types.MessageSystemAttributeNameMessageGroupId)]; ok {
+		input.MessageGroupId = aws.String(groupID)
+		input.MessageDeduplicationId = msg.MessageId
+	}
+
+	if _, err := f.sqs.SendMessage(ctx, input); err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+	}, nil
+}
+
+// SNSClient is the part of the SNS API NewSNSForwarder uses. *sns.Client
+// implements it.
+type SNSClient interface {
+	Publish(ctx context.Context, input *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error)
+}
+
+var _ SNSClient = (*sns.Client)(nil)
+
+type snsForwarder struct {
+	sns      SNSClient
+	topicARN string
+}
+
+// NewSNSForwarder returns a Deliverer that publishes messages to the topic
+// topicARN, keeping their body and attributes.
+func NewSNSForwarder(sns SNSClient, topicARN string) Deliverer {
+	return &snsForwarder{
+		sns:      sns,
+		topicARN: topicARN,
+	}
+}
+
+func (f *snsForwarder) Deliver(ctx context.Context, queueURL string, msg *types.Message) (*http.Response, error) {
+	input := &sns.PublishInput{
+		TopicArn: aws.String(f.topicARN),
+		Message:  msg.Body,
+	}
+
+	if len(msg.MessageAttributes) > 0 {
+		input.MessageAttributes = make(map[string]snstypes.MessageAttributeValue, len(msg.MessageAttributes))
+		for name, attr := range msg.MessageAttributes {
+			input.MessageAttributes[name] = snstypes.MessageAttributeValue{
+				DataType:    attr.DataType,
+				StringValue: attr.StringValue,
+				BinaryValue: attr.BinaryValue,
+			}
+		}
+	}
+
+	if groupID, ok := msg.Attributes[string(types.MessageSystemAttributeNameMessageGroupId)]; ok {
+		input.MessageGroupId = aws.String(groupID)
+		input.MessageDeduplicationId = msg.MessageId
+	}
+
+	if _, err := f.sns.Publish(ctx, input); err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+	}, nil
+}
@@ -0,0 +1,177 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorForwardsToSQS(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	sourceSQS := &mockSQS{}
+	targetSQS := &mockSQS{}
+	config := WorkerConfig{
+		Deliverer: NewSQSForwarder(targetSQS, "https://sqs.us-east-1.amazonaws.com/123456789012/target"),
+	}
+
+	attributes := map[string]types.MessageAttributeValue{
+		"event": {DataType: aws.String("String"), StringValue: aws.String("created")},
+	}
+	sourceSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:              aws.String("message 1"),
+				MessageId:         aws.String("m1"),
+				ReceiptHandle:     aws.String("r1"),
+				MessageAttributes: attributes,
+			}, {
+				Body:          aws.String("message 2"),
+				MessageId:     aws.String("m2"),
+				ReceiptHandle: aws.String("r2"),
+			}},
+		}, nil
+	}
+
+	var forwarded []*sqs.SendMessageInput
+	targetSQS.sendMessageFunc = func(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+		forwarded = append(forwarded, input)
+		if *input.MessageBody == "message 2" {
+			return nil, errors.New("access denied")
+		}
+
+		return &sqs.SendMessageOutput{}, nil
+	}
+
+	supervisor := NewSupervisor(logger, sourceSQS, &http.Client{}, config)
+
+	var deleted []string
+	sourceSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		defer supervisor.Shutdown()
+
+		for _, entry := range input.Entries {
+			deleted = append(deleted, *entry.Id)
+		}
+
+		return nil, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	if assert.Len(t, forwarded, 2) {
+		assert.Equal(t, "https://sqs.us-east-1.amazonaws.com/123456789012/target", *forwarded[0].QueueUrl)
+		assert.Equal(t, "message 1", *forwarded[0].MessageBody)
+		assert.Equal(t, attributes, forwarded[0].MessageAttributes)
+		assert.Nil(t, forwarded[0].MessageGroupId)
+	}
+	assert.Equal(t, []string{"m1"}, deleted)
+}
+
+func TestSQSForwarderKeepsMessageGroup(t *testing.T) {
+	targetSQS := &mockSQS{}
+
+	var forwarded *sqs.SendMessageInput
+	targetSQS.sendMessageFunc = func(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+		forwarded = input
+		return &sqs.SendMessageOutput{}, nil
+	}
+
+	res, err := NewSQSForwarder(targetSQS, "target").Deliver(context.Background(), "source", &types.Message{
+		Body:      aws.String("message 1"),
+		MessageId: aws.String("m1"),
+		Attributes: map[string]string{
+			string(types.MessageSystemAttributeNameMessageGroupId): "g1",
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "g1", *forwarded.MessageGroupId)
+	assert.Equal(t, "m1", *forwarded.MessageDeduplicationId)
+}
+
+type mockSNS struct {
+	published []*sns.PublishInput
+}
+
+func (m *mockSNS) Publish(ctx context.Context, input *sns.PublishInput, optFns ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	m.published = append(m.published, input)
+	return &sns.PublishOutput{}, nil
+}
+
+func TestSNSForwarder(t *testing.T) {
+	topic := &mockSNS{}
+
+	res, err := NewSNSForwarder(topic, "arn:aws:sns:us-east-1:123456789012:failures").Deliver(context.Background(), "source", &types.Message{
+		Body:      aws.String("message 1"),
+		MessageId: aws.String("m1"),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"type": {DataType: aws.String("String"), StringValue: aws.String("order")},
+		},
+		Attributes: map[string]string{
+			string(types.MessageSystemAttributeNameMessageGroupId): "g1",
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	if assert.Len(t, topic.published, 1) {
+		published := topic.published[0]
+		assert.Equal(t, "arn:aws:sns:us-east-1:123456789012:failures", *published.TopicArn)
+		assert.Equal(t, "message 1", *published.Message)
+		assert.Equal(t, "order", *published.MessageAttributes["type"].StringValue)
+		assert.Equal(t, "g1", *published.MessageGroupId)
+		assert.Equal(t, "m1", *published.MessageDeduplicationId)
+	}
+}
+
+func TestSupervisorRedeliver(t *testing.T) {
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		assert.NotEmpty(t, r.Header.Get("X-Signature"))
+
+		if string(body) == "fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	mockSQS := &mockSQS{}
+	mockSQS.changeMessageVisibilityFunc = func(*sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+		t.Error("visibility changed")
+		return nil, nil
+	}
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+		QueueURL:       "https://queue.url/orders",
+		HTTPURL:        ts.URL,
+		HTTPHMACHeader: "X-Signature",
+		HMACSecretKey:  []byte("secret"),
+		BodyFilter:     regexp.MustCompile(`^fail$|"keep": true`),
+	})
+
+	message := func(body string) *types.Message {
+		return &types.Message{Body: aws.String(body), MessageId: aws.String(body), ReceiptHandle: aws.String("r-" + body)}
+	}
+
+	assert.NoError(t, supervisor.Redeliver(context.Background(), message(`{"keep": true}`)))
+	assert.EqualError(t, supervisor.Redeliver(context.Background(), message("fail")), "worker responded with status 500")
+	assert.EqualError(t, supervisor.Redeliver(context.Background(), message(`{"keep": false}`)), "message was not delivered: filtered")
+	assert.Len(t, bodies, 2)
+}
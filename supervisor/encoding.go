@@ -0,0 +1,128 @@
+package supervisor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/klauspost/compress/zstd"
+)
+
+// BodyEncoding decides what happens to message bodies whose encoding is set
+// in the BodyEncodingAttribute message attribute.
+type BodyEncoding string
+
+const (
+	// BodyEncodingIgnore delivers bodies as they are, whatever their
+	// encoding attribute.
+	BodyEncodingIgnore BodyEncoding = "ignore"
+	// BodyEncodingDecode decodes base64 bodies and decompresses gzip and
+	// zstd bodies before delivery.
+	BodyEncodingDecode BodyEncoding = "decode"
+	// BodyEncodingPassthrough decodes base64 bodies but delivers gzip and
+	// zstd bodies compressed, with their encoding in the Content-Encoding
+	// header.
+	BodyEncodingPassthrough BodyEncoding = "passthrough"
+)
+
+// DefaultBodyEncodingAttribute is the message attribute read for the encoding
+// of a body when BodyEncodingAttribute is empty.
+const DefaultBodyEncodingAttribute = "contentEncoding"
+
+// bodyEncoding returns the encoding of the body of msg, lowercased, or an
+// empty string when encoded bodies are delivered as they are.
+func (s *Supervisor) bodyEncoding(msg *types.Message) string {
+	if s.workerConfig.BodyEncoding != BodyEncodingDecode && s.workerConfig.BodyEncoding != BodyEncodingPassthrough {
+		return ""
+	}
+
+	name := s.workerConfig.BodyEncodingAttribute
+	if len(name) == 0 {
+		name = DefaultBodyEncodingAttribute
+	}
+
+	encoding := strings.ToLower(strings.TrimSpace(stringAttribute(msg, name)))
+	if encoding == "identity" {
+		return ""
+	}
+
+	return encoding
+}
+
+// contentEncoding returns the Content-Encoding of the request delivering
+// msg, set when compressed bodies are passed through.
+func (s *Supervisor) contentEncoding(msg *types.Message) string {
+	if s.workerConfig.BodyEncoding != BodyEncodingPassthrough {
+		return ""
+	}
+
+	switch encoding := s.bodyEncoding(msg); encoding {
+	case "gzip", "zstd":
+		return encoding
+	}
+
+	return ""
+}
+
+// decodeBody decodes body according to the encoding attribute of msg.
+// Compressed bodies are base64 encoded, as SQS bodies can only hold text.
+func (s *Supervisor) decodeBody(msg *types.Message, body string) (string, error) {
+	encoding := s.bodyEncoding(msg)
+	if len(encoding) == 0 {
+		return body, nil
+	}
+
+	switch encoding {
+	case "base64", "gzip", "zstd":
+	default:
+		return "", &decodeError{err: fmt.Errorf("unknown encoding %q of message %s", encoding, aws.ToString(msg.MessageId))}
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return "", &decodeError{err: err}
+	}
+
+	if encoding == "base64" || s.workerConfig.BodyEncoding == BodyEncodingPassthrough {
+		return string(decoded), nil
+	}
+
+	var r io.Reader
+	switch encoding {
+	case "gzip":
+		gr, err := gzip.NewReader(bytes.NewReader(decoded))
+		if err != nil {
+			return "", &decodeError{err: err}
+		}
+		defer gr.Close()
+		r = gr
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(decoded))
+		if err != nil {
+			return "", &decodeError{err: err}
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	// Bodies are bounded by MaxBodyBytes once decompressed too.
+	if max := s.workerConfig.MaxBodyBytes; max > 0 {
+		r = io.LimitReader(r, int64(max)+1)
+	}
+
+	decompressed, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", &decodeError{err: err}
+	}
+	if max := s.workerConfig.MaxBodyBytes; max > 0 && len(decompressed) > max {
+		return "", &decodeError{err: fmt.Errorf("decompressed body exceeds %d bytes", max)}
+	}
+
+	return string(decompressed), nil
+}
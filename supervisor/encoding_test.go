@@ -0,0 +1,102 @@
+package supervisor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/klauspost/compress/zstd"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func encodedMessage(id string, body []byte, encoding string) *types.Message {
+	msg := &types.Message{
+		Body:          aws.String(base64.StdEncoding.EncodeToString(body)),
+		MessageId:     aws.String(id),
+		ReceiptHandle: aws.String("r-" + id),
+	}
+	if len(encoding) > 0 {
+		msg.MessageAttributes = map[string]types.MessageAttributeValue{
+			DefaultBodyEncodingAttribute: {DataType: aws.String("String"), StringValue: aws.String(encoding)},
+		}
+	}
+
+	return msg
+}
+
+func TestSupervisorBodyEncoding(t *testing.T) {
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write([]byte("gzip payload"))
+	gw.Close()
+
+	zw, _ := zstd.NewWriter(nil)
+	zstded := zw.EncodeAll([]byte("zstd payload"), nil)
+	zw.Close()
+
+	var (
+		body     []byte
+		encoding string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		encoding = r.Header.Get("Content-Encoding")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:      ts.URL,
+		BodyEncoding: BodyEncodingDecode,
+		MaxBodyBytes: 1024,
+	})
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	for _, tt := range []struct {
+		msg  *types.Message
+		body string
+	}{
+		{msg: encodedMessage("m1", gzipped.Bytes(), "gzip"), body: "gzip payload"},
+		{msg: encodedMessage("m2", zstded, "ZSTD"), body: "zstd payload"},
+		{msg: encodedMessage("m3", []byte{0x00, 0xff}, "base64"), body: "\x00\xff"},
+		{msg: encodedMessage("m4", []byte("plain"), ""), body: "cGxhaW4="},
+	} {
+		result := supervisor.processMessage(ctx, supervisor.queues[0], tt.msg)
+		assert.Equal(t, "delivered", result.status, *tt.msg.MessageId)
+		assert.Equal(t, tt.body, string(body), *tt.msg.MessageId)
+		assert.Empty(t, encoding, *tt.msg.MessageId)
+	}
+
+	for _, msg := range []*types.Message{
+		encodedMessage("m5", []byte("not gzip"), "gzip"),
+		encodedMessage("m6", []byte("payload"), "br"),
+	} {
+		result := supervisor.processMessage(ctx, supervisor.queues[0], msg)
+		assert.Equal(t, FailureDecodeError, result.reason, *msg.MessageId)
+	}
+
+	// Bodies decompressed past MaxBodyBytes are not delivered.
+	var large bytes.Buffer
+	gw = gzip.NewWriter(&large)
+	gw.Write(bytes.Repeat([]byte("a"), 2048))
+	gw.Close()
+	result := supervisor.processMessage(ctx, supervisor.queues[0], encodedMessage("m8", large.Bytes(), "gzip"))
+	assert.Equal(t, FailureDecodeError, result.reason)
+
+	supervisor.workerConfig.BodyEncoding = BodyEncodingPassthrough
+	result = supervisor.processMessage(ctx, supervisor.queues[0], encodedMessage("m9", gzipped.Bytes(), "gzip"))
+	assert.Equal(t, "delivered", result.status)
+	assert.Equal(t, gzipped.Bytes(), body)
+	assert.Equal(t, "gzip", encoding)
+}
@@ -0,0 +1,98 @@
+package supervisor
+
+import (
+	"sync"
+)
+
+// endpoint is a worker URL messages are delivered to. It counts the
+// deliveries in flight so that it can be drained once replaced.
+type endpoint struct {
+	url string
+
+	mu       sync.Mutex
+	inFlight int
+	draining bool
+	drained  chan struct{}
+}
+
+func newEndpoint(url string) *endpoint {
+	return &endpoint{
+		url:     url,
+		drained: make(chan struct{}),
+	}
+}
+
+// acquireEndpoint returns the endpoint of q, counting a delivery as in
+// flight until release is called.
+func (q *queue) acquireEndpoint() *endpoint {
+	for {
+		ep := q.endpoint.Load()
+
+		ep.mu.Lock()
+		if !ep.draining {
+			ep.inFlight++
+			ep.mu.Unlock()
+			return ep
+		}
+		ep.mu.Unlock()
+	}
+}
+
+func (ep *endpoint) release() {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	ep.inFlight--
+	if ep.draining && ep.inFlight == 0 {
+		close(ep.drained)
+	}
+}
+
+// drain stops new deliveries from using ep and returns a channel closed once
+// those in flight have finished.
+func (ep *endpoint) drain() <-chan struct{} {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	if !ep.draining {
+		ep.draining = true
+		if ep.inFlight == 0 {
+			close(ep.drained)
+		}
+	}
+
+	return ep.drained
+}
+
+// SwitchHTTPURL delivers new messages to url instead of HTTPURL, on every
+// queue that doesn't have its own URL. Deliveries already in flight finish on
+// the previous URL; the returned channel is closed once they all have.
+func (s *Supervisor) SwitchHTTPURL(url string) <-chan struct{} {
+	var drained []<-chan struct{}
+
+	for _, q := range s.queues {
+		if !q.defaultHTTPURL {
+			continue
+		}
+
+		old := q.endpoint.Swap(newEndpoint(url))
+		if old.url != url {
+			s.logger.Infof("Switching deliveries from %s to %s", old.url, url)
+		}
+
+		drained = append(drained, old.drain())
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for _, ch := range drained {
+			<-ch
+		}
+
+		s.logger.Info("Deliveries to the previous HTTP URL have drained")
+	}()
+
+	return done
+}
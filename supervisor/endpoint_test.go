@@ -0,0 +1,101 @@
+package supervisor
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorSwitchHTTPURL(t *testing.T) {
+	arrived := make(chan struct{})
+	unblock := make(chan struct{})
+	oldServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-unblock
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer oldServer.Close()
+
+	newRequests := 0
+	newServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		newRequests++
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer newServer.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	config := WorkerConfig{
+		HTTPURL: oldServer.URL,
+	}
+
+	supervisor := NewSupervisor(logger, &mockSQS{}, &http.Client{}, config)
+	q := supervisor.queues[0]
+
+	msg := func(id string) *types.Message {
+		return &types.Message{
+			Body:          aws.String(id),
+			MessageId:     aws.String(id),
+			ReceiptHandle: aws.String(id),
+		}
+	}
+
+	oldDone := make(chan *http.Response)
+	go func() {
+		res, err := supervisor.httpRequest(context.Background(), q, msg("m1"))
+		assert.NoError(t, err)
+		oldDone <- res
+	}()
+
+	<-arrived
+	drained := supervisor.SwitchHTTPURL(newServer.URL)
+
+	res, err := supervisor.httpRequest(context.Background(), q, msg("m2"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, 1, newRequests)
+
+	select {
+	case <-drained:
+		t.Fatal("drained before the delivery to the old URL finished")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(unblock)
+	res = <-oldDone
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("not drained after the delivery to the old URL finished")
+	}
+}
+
+func TestSupervisorSwitchHTTPURLKeepsQueueURLs(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	config := WorkerConfig{
+		HTTPURL: "http://default",
+		Queues: []QueueConfig{
+			{URL: "https://orders.queue", HTTPURL: "http://orders"},
+			{URL: "https://emails.queue"},
+		},
+	}
+
+	supervisor := NewSupervisor(logger, &mockSQS{}, &http.Client{}, config)
+	<-supervisor.SwitchHTTPURL("http://new")
+
+	assert.Equal(t, "http://orders", supervisor.queues[0].endpoint.Load().url)
+	assert.Equal(t, "http://new", supervisor.queues[1].endpoint.Load().url)
+}
@@ -0,0 +1,66 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// envelopeContentType is the Content-Type of requests with BodyEnvelope.
+const envelopeContentType = "application/json"
+
+// messageEnvelope is the body of requests with BodyEnvelope.
+type messageEnvelope struct {
+	MessageId         string                       `json:"messageId"`
+	ReceiptHandle     string                       `json:"receiptHandle"`
+	Body              string                       `json:"body"`
+	Attributes        map[string]string            `json:"attributes"`
+	MessageAttributes map[string]envelopeAttribute `json:"messageAttributes"`
+	ReceiveCount      int                          `json:"receiveCount"`
+	// SentTimestamp is in milliseconds since the epoch, 0 when SQS didn't
+	// return it.
+	SentTimestamp int64 `json:"sentTimestamp"`
+}
+
+// envelopeAttribute is a message attribute in a messageEnvelope. Binary
+// values are encoded in base64.
+type envelopeAttribute struct {
+	DataType    string `json:"dataType"`
+	StringValue string `json:"stringValue,omitempty"`
+	BinaryValue []byte `json:"binaryValue,omitempty"`
+}
+
+// envelopeBody returns the JSON envelope of msg, whose body is body.
+func envelopeBody(msg *types.Message, body string) (string, error) {
+	env := messageEnvelope{
+		MessageId:         aws.ToString(msg.MessageId),
+		ReceiptHandle:     aws.ToString(msg.ReceiptHandle),
+		Body:              body,
+		Attributes:        make(map[string]string, len(msg.Attributes)),
+		MessageAttributes: make(map[string]envelopeAttribute, len(msg.MessageAttributes)),
+		ReceiveCount:      receiveCount(msg),
+	}
+
+	for name, value := range msg.Attributes {
+		env.Attributes[name] = value
+	}
+	for name, attr := range msg.MessageAttributes {
+		env.MessageAttributes[name] = envelopeAttribute{
+			DataType:    aws.ToString(attr.DataType),
+			StringValue: aws.ToString(attr.StringValue),
+			BinaryValue: attr.BinaryValue,
+		}
+	}
+	if sent, ok := sentTimestamp(msg); ok {
+		env.SentTimestamp = sent.UnixNano() / 1e6
+	}
+
+	b, err := json.Marshal(env)
+	if err != nil {
+		return "", fmt.Errorf("Error while encoding the message envelope: %s", err)
+	}
+
+	return string(b), nil
+}
@@ -0,0 +1,75 @@
+package supervisor
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorBodyEnvelope(t *testing.T) {
+	var (
+		body   string
+		header http.Header
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body, header = string(b), r.Header
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		QueueURL:        "https://queue.url/jobs",
+		HTTPURL:         ts.URL,
+		HTTPContentType: "text/plain",
+		BodyEnvelope:    true,
+	})
+	assert.Equal(t, []types.MessageSystemAttributeName{types.MessageSystemAttributeNameAll}, supervisor.receiveAttributeNames())
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	result := supervisor.processMessage(ctx, supervisor.queues[0], &types.Message{
+		Body:          aws.String(`say "hi"`),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"type":      {DataType: aws.String("String"), StringValue: aws.String("greeting")},
+			"signature": {DataType: aws.String("Binary"), BinaryValue: []byte("sig")},
+		},
+		Attributes: map[string]string{
+			string(types.MessageSystemAttributeNameSentTimestamp):           "1609459200000",
+			string(types.MessageSystemAttributeNameApproximateReceiveCount): "2",
+		},
+	})
+
+	assert.Equal(t, "delivered", result.status)
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	assert.JSONEq(t, `{
+		"messageId": "m1",
+		"receiptHandle": "r1",
+		"body": "say \"hi\"",
+		"attributes": {"SentTimestamp": "1609459200000", "ApproximateReceiveCount": "2"},
+		"messageAttributes": {
+			"type": {"dataType": "String", "stringValue": "greeting"},
+			"signature": {"dataType": "Binary", "binaryValue": "c2ln"}
+		},
+		"receiveCount": 2,
+		"sentTimestamp": 1609459200000
+	}`, body)
+}
+
+func TestEnvelopeBodyWithoutAttributes(t *testing.T) {
+	body, err := envelopeBody(&types.Message{MessageId: aws.String("m1"), ReceiptHandle: aws.String("r1")}, "payload")
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"messageId":"m1","receiptHandle":"r1","body":"payload","attributes":{},"messageAttributes":{},"receiveCount":0,"sentTimestamp":0}`, body)
+}
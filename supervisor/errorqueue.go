@@ -0,0 +1,157 @@
+package supervisor
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const defaultErrorQueueMaxReceives = 5
+
+// Message attributes describing the last failed delivery of the messages
+// moved to the error queue with ErrorQueueFailureAttributes.
+const (
+	failureStatusCodeAttribute   = "sqsd.failure.status_code"
+	failureReasonAttribute       = "sqsd.failure.reason"
+	failureResponseBodyAttribute = "sqsd.failure.response_body"
+)
+
+// maxMessageAttributes is the most attributes SQS accepts on a message.
+const maxMessageAttributes = 10
+
+// receiveCount returns how many times msg has been received.
+func receiveCount(msg *types.Message) int {
+	count, _ := strconv.Atoi(msg.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+	return count
+}
+
+func (s *Supervisor) errorQueueMaxReceives() int {
+	if s.workerConfig.ErrorQueueMaxReceives <= 0 {
+		return defaultErrorQueueMaxReceives
+	}
+
+	return s.workerConfig.ErrorQueueMaxReceives
+}
+
+// poisoned reports whether msg was received more than MaxReceiveCount times.
+func (s *Supervisor) poisoned(msg *types.Message) bool {
+	return s.workerConfig.MaxReceiveCount > 0 && receiveCount(msg) > s.workerConfig.MaxReceiveCount
+}
+
+// dropPoison moves the poisoned message of result to the error queue if there
+// is one, and marks it for deletion otherwise.
+func (s *Supervisor) dropPoison(q *queue, result *messageResult) {
+	s.workerConfig.Metrics.incDropped(q.url, "poison")
+
+	result.disposition = dispositionDelete
+	result.status = "poison"
+	if s.errorQueue != nil {
+		result.disposition = dispositionRetry
+		s.moveToErrorQueue(q, result)
+	}
+}
+
+// dropCorrupt moves the message of result, whose body does not match its
+// MD5, to the error queue if there is one, and leaves it for the redrive
+// policy of the queue otherwise.
+func (s *Supervisor) dropCorrupt(q *queue, result *messageResult) {
+	result.disposition = dispositionRetry
+	result.status = "corrupt"
+	if s.errorQueue != nil {
+		s.moveToErrorQueue(q, result)
+	}
+}
+
+// shouldMoveToErrorQueue reports whether the delivery of result failed for
+// the last time allowed before its message goes to the error queue.
+func (s *Supervisor) shouldMoveToErrorQueue(result messageResult) bool {
+	if s.errorQueue == nil || result.attempts == 0 || result.disposition == dispositionDelete {
+		return false
+	}
+
+	if result.status != "" && result.status != "retry" {
+		return false
+	}
+
+	if rule, ok := s.statusRule(result.statusCode); ok {
+		if rule.Action == StatusActionErrorQueue {
+			return true
+		}
+	} else if s.workerConfig.ErrorQueueStatusCodes.Contains(result.statusCode) {
+		return true
+	}
+
+	return receiveCount(result.msg) >= s.errorQueueMaxReceives()
+}
+
+// moveToErrorQueue sends the message of result to the error queue and, if
+// that succeeded, marks it for deletion from q.
+func (s *Supervisor) moveToErrorQueue(q *queue, result *messageResult) {
+	msg := result.msg
+	if s.workerConfig.ErrorQueueFailureAttributes {
+		msg = s.withFailureAttributes(q, result)
+	}
+
+	if _, err := s.errorQueue.Deliver(context.Background(), q.url, msg); err != nil {
+		s.logger.Errorf("Error while moving message %s to the error queue: %s", aws.ToString(msg.MessageId), err)
+		return
+	}
+
+	s.logger.Warnf("Message %s failed %d times, moved it to the error queue", aws.ToString(msg.MessageId), receiveCount(msg))
+
+	result.disposition = dispositionDelete
+	result.status = "error-queue"
+}
+
+// withFailureAttributes returns a copy of the message of result with
+// attributes describing its last failed delivery. The message is returned as
+// it is when they would exceed the attributes SQS accepts.
+func (s *Supervisor) withFailureAttributes(q *queue, result *messageResult) *types.Message {
+	attrs := make(map[string]types.MessageAttributeValue, len(result.msg.MessageAttributes)+3)
+	for name, value := range result.msg.MessageAttributes {
+		attrs[name] = value
+	}
+
+	if result.statusCode > 0 {
+		attrs[failureStatusCodeAttribute] = types.MessageAttributeValue{
+			DataType:    aws.String("Number"),
+			StringValue: aws.String(strconv.Itoa(result.statusCode)),
+		}
+	}
+	if len(result.reason) > 0 {
+		attrs[failureReasonAttribute] = types.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(string(result.reason)),
+		}
+	}
+	if body := attributeString(result.responseBody); len(body) > 0 {
+		attrs[failureResponseBodyAttribute] = types.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(body),
+		}
+	}
+
+	if len(attrs) > maxMessageAttributes {
+		s.resultLogger(q, result).Warn("Message has too many attributes to add the failure attributes to it in the error queue")
+		return result.msg
+	}
+
+	msg := *result.msg
+	msg.MessageAttributes = attrs
+
+	return &msg
+}
+
+// attributeString drops the characters SQS rejects in a String attribute
+// from s.
+func attributeString(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' || (r >= 0x20 && r <= 0xfffd) || r >= 0x10000 {
+			return r
+		}
+		return -1
+	}, s)
+}
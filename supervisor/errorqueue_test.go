@@ -0,0 +1,290 @@
+package supervisor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorErrorQueue(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		QueueURL:              "https://source.queue",
+		HTTPURL:               ts.URL,
+		ErrorQueueURL:         "https://error.queue",
+		ErrorQueueMaxReceives: 3,
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	attributes := map[string]types.MessageAttributeValue{
+		"type": {DataType: aws.String("String"), StringValue: aws.String("order")},
+	}
+
+	receiveCount := 0
+	mockSQS.receiveMessageFunc = func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		receiveCount++
+
+		assert.Contains(t, input.MessageSystemAttributeNames, types.MessageSystemAttributeNameApproximateReceiveCount)
+
+		if receiveCount > 1 {
+			supervisor.Shutdown()
+			return &sqs.ReceiveMessageOutput{}, nil
+		}
+
+		message := func(id string, receives string) *types.Message {
+			return &types.Message{
+				Body:              aws.String("body " + id),
+				MessageId:         aws.String(id),
+				ReceiptHandle:     aws.String("r" + id),
+				MessageAttributes: attributes,
+				Attributes: map[string]string{
+					string(types.MessageSystemAttributeNameApproximateReceiveCount): receives,
+				},
+			}
+		}
+
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{*message("m1", "2"), *message("m2", "3")},
+		}, nil
+	}
+
+	var sent []*sqs.SendMessageInput
+	mockSQS.sendMessageFunc = func(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+		sent = append(sent, input)
+		return &sqs.SendMessageOutput{}, nil
+	}
+
+	var deleted []string
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		assert.Equal(t, "https://source.queue", *input.QueueUrl)
+
+		for _, entry := range input.Entries {
+			deleted = append(deleted, *entry.Id)
+		}
+
+		return &sqs.DeleteMessageBatchOutput{}, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	if assert.Len(t, sent, 1) {
+		assert.Equal(t, "https://error.queue", *sent[0].QueueUrl)
+		assert.Equal(t, "body m2", *sent[0].MessageBody)
+		assert.Equal(t, attributes, sent[0].MessageAttributes)
+	}
+	assert.Equal(t, []string{"m2"}, deleted)
+	assert.Equal(t, int64(1), supervisor.Report().Statuses["error-queue"])
+}
+
+func TestSupervisorErrorQueueSendFailure(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	mockSQS := &mockSQS{}
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+		ErrorQueueURL: "https://error.queue",
+	})
+
+	mockSQS.sendMessageFunc = func(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+		return nil, assert.AnError
+	}
+
+	result := messageResult{
+		msg: &types.Message{
+			Body:      aws.String("body"),
+			MessageId: aws.String("m1"),
+			Attributes: map[string]string{
+				string(types.MessageSystemAttributeNameApproximateReceiveCount): "5",
+			},
+		},
+		attempts: 1,
+	}
+
+	assert.True(t, supervisor.shouldMoveToErrorQueue(result))
+	supervisor.moveToErrorQueue(supervisor.queues[0], &result)
+	assert.Equal(t, dispositionRetry, result.disposition)
+}
+
+func TestSupervisorNoErrorQueue(t *testing.T) {
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{})
+
+	assert.False(t, supervisor.shouldMoveToErrorQueue(messageResult{
+		msg: &types.Message{
+			Attributes: map[string]string{
+				string(types.MessageSystemAttributeNameApproximateReceiveCount): "100",
+			},
+		},
+		attempts: 1,
+	}))
+}
+
+func TestSupervisorErrorQueueStatusCodes(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	topic := &mockSNS{}
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		ErrorDestination:      NewSNSForwarder(topic, "arn:aws:sns:us-east-1:123456789012:failures"),
+		ErrorQueueStatusCodes: StatusCodes{{Min: 400, Max: 499}},
+	})
+
+	result := func(statusCode int) messageResult {
+		return messageResult{
+			msg: &types.Message{
+				Body:      aws.String("body"),
+				MessageId: aws.String("m1"),
+				Attributes: map[string]string{
+					string(types.MessageSystemAttributeNameApproximateReceiveCount): "1",
+				},
+			},
+			statusCode: statusCode,
+			attempts:   1,
+		}
+	}
+
+	assert.False(t, supervisor.shouldMoveToErrorQueue(result(http.StatusInternalServerError)))
+
+	rejected := result(http.StatusUnprocessableEntity)
+	assert.True(t, supervisor.shouldMoveToErrorQueue(rejected))
+
+	supervisor.moveToErrorQueue(supervisor.queues[0], &rejected)
+	assert.Equal(t, dispositionDelete, rejected.disposition)
+	assert.Equal(t, "error-queue", rejected.status)
+	assert.Len(t, topic.published, 1)
+}
+
+func TestSupervisorErrorQueueFailureAttributes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("database\x00 is down, try again later"))
+	}))
+	defer ts.Close()
+
+	var sent *sqs.SendMessageInput
+	mockSQS := &mockSQS{
+		sendMessageFunc: func(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+			sent = input
+			return &sqs.SendMessageOutput{}, nil
+		},
+	}
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+		HTTPURL:                     ts.URL,
+		HTTPErrorBodyLimit:          16,
+		ErrorQueueURL:               "https://error.queue",
+		ErrorQueueMaxReceives:       1,
+		ErrorQueueFailureAttributes: true,
+	})
+
+	attributes := map[string]types.MessageAttributeValue{
+		"type": {DataType: aws.String("String"), StringValue: aws.String("order")},
+	}
+	msg := &types.Message{
+		Body:              aws.String("body"),
+		MessageId:         aws.String("m1"),
+		ReceiptHandle:     aws.String("r1"),
+		MessageAttributes: attributes,
+		Attributes: map[string]string{
+			string(types.MessageSystemAttributeNameApproximateReceiveCount): "1",
+		},
+	}
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+	result := supervisor.processMessage(ctx, supervisor.queues[0], msg)
+	assert.Equal(t, "database\x00 is dow", result.responseBody)
+
+	supervisor.moveToErrorQueue(supervisor.queues[0], &result)
+	if assert.NotNil(t, sent) {
+		attrs := sent.MessageAttributes
+		assert.Equal(t, "order", aws.ToString(attrs["type"].StringValue))
+		assert.Equal(t, "500", aws.ToString(attrs[failureStatusCodeAttribute].StringValue))
+		assert.Equal(t, "Number", aws.ToString(attrs[failureStatusCodeAttribute].DataType))
+		assert.Equal(t, string(FailureHTTP5xx), aws.ToString(attrs[failureReasonAttribute].StringValue))
+		assert.Equal(t, "database is dow", aws.ToString(attrs[failureResponseBodyAttribute].StringValue))
+	}
+	assert.Len(t, attributes, 1, "the attributes of the received message are left as they are")
+
+	// Failure attributes are left out rather than exceeding the attributes
+	// SQS accepts.
+	for i := 0; i < maxMessageAttributes; i++ {
+		attributes[fmt.Sprintf("attr%d", i)] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String("v")}
+	}
+	assert.Same(t, msg, supervisor.withFailureAttributes(supervisor.queues[0], &result))
+}
+
+func TestSupervisorMaxReceiveCount(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	mockSQS := &mockSQS{}
+	var sent []string
+	mockSQS.sendMessageFunc = func(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+		sent = append(sent, *input.MessageBody)
+		return &sqs.SendMessageOutput{}, nil
+	}
+
+	message := &types.Message{
+		Body:          aws.String("poison"),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+		Attributes: map[string]string{
+			string(types.MessageSystemAttributeNameApproximateReceiveCount): "4",
+		},
+	}
+
+	metrics := NewMetrics(prometheus.NewRegistry())
+	for errorQueueURL, status := range map[string]string{"": "poison", "https://error.queue": "error-queue"} {
+		supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+			HTTPURL:         ts.URL,
+			MaxReceiveCount: 3,
+			ErrorQueueURL:   errorQueueURL,
+			Metrics:         metrics,
+		})
+
+		ctx, cancel := supervisor.inFlightContext(time.Now())
+		result := supervisor.processMessage(ctx, supervisor.queues[0], message)
+		cancel()
+
+		assert.Equal(t, dispositionDelete, result.disposition, errorQueueURL)
+		assert.Equal(t, FailurePoison, result.reason, errorQueueURL)
+		assert.Equal(t, status, result.status, errorQueueURL)
+	}
+
+	assert.Zero(t, requests.Load())
+	assert.Equal(t, []string{"poison"}, sent)
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.failures.WithLabelValues(queueLabel(""), string(FailurePoison))))
+
+	// Messages received up to MaxReceiveCount times are delivered.
+	message.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)] = "3"
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+		HTTPURL:         ts.URL,
+		MaxReceiveCount: 3,
+	})
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+	assert.Equal(t, "delivered", supervisor.processMessage(ctx, supervisor.queues[0], message).status)
+}
@@ -0,0 +1,87 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// EventType identifies a step in the life of a message.
+type EventType string
+
+const (
+	// EventReceived is emitted for every message received from the queue.
+	EventReceived EventType = "received"
+	// EventProcessed is emitted once a message has been handled, with its
+	// final status.
+	EventProcessed EventType = "processed"
+	// EventDeleted is emitted once a message has been deleted from the queue.
+	EventDeleted EventType = "deleted"
+)
+
+// Event is a single entry of an EventStream. Field names are part of the
+// stream format and must not change.
+type Event struct {
+	Type       EventType `json:"type"`
+	Timestamp  time.Time `json:"timestamp"`
+	QueueURL   string    `json:"queueUrl"`
+	MessageID  string    `json:"messageId"`
+	GroupID    string    `json:"messageGroupId,omitempty"`
+	Status     string    `json:"status,omitempty"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Attempts   int       `json:"attempts,omitempty"`
+	DurationMs int64     `json:"durationMs,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+}
+
+// EventStream writes message lifecycle events as newline-delimited JSON. A
+// nil *EventStream discards events.
+type EventStream struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewEventStream(w io.Writer) *EventStream {
+	return &EventStream{enc: json.NewEncoder(w)}
+}
+
+func (e *EventStream) write(event Event) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.enc.Encode(event)
+}
+
+func (s *Supervisor) emitEvent(eventType EventType, q *queue, msg *types.Message, result *messageResult) {
+	stream := s.workerConfig.EventStream
+	if stream == nil {
+		return
+	}
+
+	event := Event{
+		Type:      eventType,
+		Timestamp: time.Now().UTC(),
+		QueueURL:  q.url,
+		MessageID: aws.ToString(msg.MessageId),
+		GroupID:   messageGroupID(msg),
+	}
+
+	if result != nil {
+		event.Status = result.status
+		if len(event.Status) == 0 {
+			event.Status = "failed"
+		}
+		event.StatusCode = result.statusCode
+		event.Attempts = result.attempts
+		event.DurationMs = result.duration.Milliseconds()
+		event.Reason = string(result.reason)
+	}
+
+	if err := stream.write(event); err != nil {
+		s.logger.Errorf("Error while writing to the event stream: %s", err)
+	}
+}
@@ -0,0 +1,95 @@
+package supervisor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorEventStream(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) == "message 2" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		QueueURL:    "https://sqs.us-east-1.amazonaws.com/123456789012/queue",
+		HTTPURL:     ts.URL,
+		EventStream: NewEventStream(&buf),
+	}
+
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String("message 1"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+			}, {
+				Body:          aws.String("message 2"),
+				MessageId:     aws.String("m2"),
+				ReceiptHandle: aws.String("r2"),
+			}},
+		}, nil
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		defer supervisor.Shutdown()
+
+		return nil, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	var events []Event
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var event Event
+		if assert.NoError(t, json.Unmarshal(scanner.Bytes(), &event), scanner.Text()) {
+			events = append(events, event)
+		}
+	}
+
+	type step struct {
+		Type       EventType
+		MessageID  string
+		Status     string
+		StatusCode int
+	}
+	var steps []step
+	for _, event := range events {
+		assert.Equal(t, config.QueueURL, event.QueueURL)
+		assert.False(t, event.Timestamp.IsZero())
+		steps = append(steps, step{event.Type, event.MessageID, event.Status, event.StatusCode})
+	}
+
+	assert.Equal(t, []step{
+		{EventReceived, "m1", "", 0},
+		{EventReceived, "m2", "", 0},
+		{EventProcessed, "m1", "delivered", http.StatusOK},
+		{EventProcessed, "m2", "failed", http.StatusInternalServerError},
+		{EventDeleted, "m1", "", 0},
+	}, steps)
+}
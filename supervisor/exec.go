@@ -0,0 +1,91 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// envNameUnsafe matches the characters of attribute names that can't be
+// used in environment variable names.
+var envNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+type execDeliverer struct {
+	command string
+	timeout time.Duration
+}
+
+// NewExecDeliverer returns a Deliverer that runs command with /bin/sh for
+// every message, with the message body on its stdin. The message ID, queue
+// URL and receive count are set in SQSD_MESSAGE_ID, SQSD_QUEUE_URL and
+// SQSD_RECEIVE_COUNT, and the String and Number attributes in SQSD_ATTR_<name>.
+// Exiting with 0 is a success, any other exit status a 500 response. The
+// command is killed after timeout, unless it is zero.
+func NewExecDeliverer(command string, timeout time.Duration) Deliverer {
+	return &execDeliverer{
+		command: command,
+		timeout: timeout,
+	}
+}
+
+func (d *execDeliverer) Deliver(ctx context.Context, queueURL string, msg *types.Message) (*http.Response, error) {
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", d.command)
+	cmd.Stdin = strings.NewReader(aws.ToString(msg.Body))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), execEnv(queueURL, msg)...)
+	killProcessGroup(cmd)
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	statusCode := http.StatusOK
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return nil, err
+		}
+
+		statusCode = http.StatusInternalServerError
+	}
+
+	return &http.Response{
+		StatusCode: statusCode,
+		Header:     http.Header{},
+	}, nil
+}
+
+// execEnv returns the environment variables describing msg to the command.
+func execEnv(queueURL string, msg *types.Message) []string {
+	env := []string{
+		"SQSD_MESSAGE_ID=" + aws.ToString(msg.MessageId),
+		"SQSD_QUEUE_URL=" + queueURL,
+		"SQSD_RECEIVE_COUNT=" + msg.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)],
+	}
+
+	for name, attr := range msg.MessageAttributes {
+		if attr.StringValue == nil || strings.HasPrefix(aws.ToString(attr.DataType), "Binary") {
+			continue
+		}
+
+		env = append(env, "SQSD_ATTR_"+envNameUnsafe.ReplaceAllString(name, "_")+"="+*attr.StringValue)
+	}
+
+	return env
+}
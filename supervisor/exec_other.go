@@ -0,0 +1,9 @@
+//go:build !unix
+
+package supervisor
+
+import "os/exec"
+
+// killProcessGroup leaves cmd as it is: only the shell is killed when its
+// context is done.
+func killProcessGroup(cmd *exec.Cmd) {}
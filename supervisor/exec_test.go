@@ -0,0 +1,54 @@
+package supervisor
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecDeliverer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exec")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	t.Setenv("EXEC_TEST_OUT", out)
+
+	msg := &types.Message{
+		Body:      aws.String("message body"),
+		MessageId: aws.String("m1"),
+		Attributes: map[string]string{
+			string(types.MessageSystemAttributeNameApproximateReceiveCount): "2",
+		},
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"event-type": {DataType: aws.String("String"), StringValue: aws.String("order")},
+		},
+	}
+
+	deliverer := NewExecDeliverer(`cat > "$EXEC_TEST_OUT"; echo " $SQSD_MESSAGE_ID $SQSD_QUEUE_URL $SQSD_RECEIVE_COUNT $SQSD_ATTR_event_type" >> "$EXEC_TEST_OUT"`, time.Second)
+	res, err := deliverer.Deliver(context.Background(), "https://queue.url/jobs", msg)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	written, err := ioutil.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "message body m1 https://queue.url/jobs 2 order\n", string(written))
+
+	res, err = NewExecDeliverer("exit 3", time.Second).Deliver(context.Background(), "https://queue.url/jobs", msg)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+
+	start := time.Now()
+	_, err = NewExecDeliverer("sleep 5", 50*time.Millisecond).Deliver(context.Background(), "https://queue.url/jobs", msg)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, FailureHTTPTimeout, failureReasonForError(err))
+	assert.True(t, time.Since(start) < time.Second)
+}
@@ -0,0 +1,128 @@
+package supervisor
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFailoverAfter is the FailoverAfter used when none is configured.
+	DefaultFailoverAfter = 30 * time.Second
+	// DefaultFailbackInterval is the FailbackInterval used when none is
+	// configured.
+	DefaultFailbackInterval = time.Minute
+)
+
+// queueFailover picks the queue workers receive from with
+// QueueScheduleFailover: the first of its queues, in priority order, that
+// didn't keep failing.
+type queueFailover struct {
+	after    time.Duration
+	interval time.Duration
+	changed  func(from, to int)
+
+	mu     sync.Mutex
+	active int
+	// failingSince holds, for every queue, when receives from it started to
+	// fail in a row, or the zero time when the last one succeeded.
+	failingSince []time.Time
+	lastProbe    time.Time
+}
+
+func newQueueFailover(queues int, after, interval time.Duration, changed func(from, to int)) *queueFailover {
+	if after <= 0 {
+		after = DefaultFailoverAfter
+	}
+	if interval <= 0 {
+		interval = DefaultFailbackInterval
+	}
+
+	return &queueFailover{
+		after:        after,
+		interval:     interval,
+		changed:      changed,
+		failingSince: make([]time.Time, queues),
+	}
+}
+
+// next returns the index of the queue to receive from at now: the active
+// queue, or the first queue once every interval while failed over, to probe
+// whether it recovered.
+func (f *queueFailover) next(now time.Time) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.active > 0 && now.Sub(f.lastProbe) >= f.interval {
+		f.lastProbe = now
+		return 0
+	}
+
+	return f.active
+}
+
+// observe records whether receiving from queue i at now failed. The active
+// queue is failed over to the next one once it kept failing for after, and
+// any queue before it that succeeds becomes active again.
+func (f *queueFailover) observe(i int, failed bool, now time.Time) {
+	f.mu.Lock()
+
+	from := f.active
+	switch {
+	case !failed:
+		f.failingSince[i] = time.Time{}
+		if i < f.active {
+			f.active = i
+		}
+	case f.failingSince[i].IsZero():
+		f.failingSince[i] = now
+	case i == f.active && i+1 < len(f.failingSince) && now.Sub(f.failingSince[i]) >= f.after:
+		f.active = i + 1
+		f.lastProbe = now
+	}
+	to := f.active
+
+	f.mu.Unlock()
+
+	if from != to && f.changed != nil {
+		f.changed(from, to)
+	}
+}
+
+// activeQueue returns the index of the queue workers receive from.
+func (f *queueFailover) activeQueue() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.active
+}
+
+// observeReceive records the outcome of a receive from q with
+// QueueScheduleFailover.
+func (s *Supervisor) observeReceive(q *queue, err error) {
+	if s.failover == nil {
+		return
+	}
+
+	for i, candidate := range s.queues {
+		if candidate == q {
+			s.failover.observe(i, err != nil, time.Now())
+			return
+		}
+	}
+}
+
+// failoverChanged logs and records that workers switched from receiving from
+// the queue at index from to the one at index to.
+func (s *Supervisor) failoverChanged(from, to int) {
+	fromURL, toURL := s.queues[from].url, s.queues[to].url
+
+	s.workerConfig.Metrics.setActiveQueue(fromURL, false)
+	s.workerConfig.Metrics.setActiveQueue(toURL, true)
+	s.workerConfig.Metrics.incFailovers(toURL)
+
+	if to > from {
+		s.logger.Warnf("Receiving from %s kept failing for %s, failing over to %s", fromURL, s.failover.after, toURL)
+	} else {
+		s.logger.Infof("Receiving from %s succeeded again, failing back from %s", toURL, fromURL)
+	}
+}
@@ -0,0 +1,122 @@
+package supervisor
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueFailover(t *testing.T) {
+	var changes [][2]int
+	f := newQueueFailover(3, time.Minute, 5*time.Minute, func(from, to int) {
+		changes = append(changes, [2]int{from, to})
+	})
+
+	now := time.Now()
+	assert.Equal(t, 0, f.next(now))
+
+	// Errors shorter than a minute don't fail over.
+	f.observe(0, true, now)
+	f.observe(0, true, now.Add(59*time.Second))
+	assert.Equal(t, 0, f.next(now.Add(59*time.Second)))
+
+	// A success starts over.
+	f.observe(0, false, now.Add(59*time.Second))
+	f.observe(0, true, now.Add(time.Minute))
+	f.observe(0, true, now.Add(2*time.Minute-time.Second))
+	assert.Equal(t, 0, f.activeQueue())
+
+	f.observe(0, true, now.Add(2*time.Minute))
+	assert.Equal(t, 1, f.activeQueue())
+	assert.Equal(t, [][2]int{{0, 1}}, changes)
+
+	// The first queue is probed every interval.
+	assert.Equal(t, 1, f.next(now.Add(3*time.Minute)))
+	assert.Equal(t, 0, f.next(now.Add(7*time.Minute)))
+	assert.Equal(t, 1, f.next(now.Add(7*time.Minute)))
+	f.observe(0, true, now.Add(7*time.Minute))
+	assert.Equal(t, 1, f.activeQueue())
+
+	// The last queue failing doesn't fail over any further.
+	f.observe(1, true, now.Add(7*time.Minute))
+	f.observe(1, true, now.Add(8*time.Minute))
+	f.observe(1, true, now.Add(9*time.Minute))
+	assert.Equal(t, 2, f.activeQueue())
+	f.observe(2, true, now.Add(9*time.Minute))
+	f.observe(2, true, now.Add(20*time.Minute))
+	assert.Equal(t, 2, f.activeQueue())
+
+	f.observe(0, false, now.Add(21*time.Minute))
+	assert.Equal(t, 0, f.activeQueue())
+	assert.Equal(t, [][2]int{{0, 1}, {1, 2}, {2, 0}}, changes)
+}
+
+func TestSupervisorFailover(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	primary := "https://sqs.us-east-1.amazonaws.com/123456789012/orders"
+	secondary := "https://sqs.us-west-2.amazonaws.com/123456789012/orders-dr"
+
+	var (
+		mu       sync.Mutex
+		received []string
+		healthy  bool
+	)
+	mockSQS := &mockSQS{}
+	mockSQS.receiveMessageFunc = func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		url := aws.ToString(input.QueueUrl)
+		received = append(received, url)
+		if url == primary && !healthy {
+			return nil, errors.New("service unavailable")
+		}
+		if url == secondary && len(received) >= 10 {
+			healthy = true
+		}
+
+		return &sqs.ReceiveMessageOutput{}, nil
+	}
+
+	metrics := NewMetrics(prometheus.NewRegistry())
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+		QueueURLs:              []string{primary, secondary},
+		QueueSchedule:          QueueScheduleFailover,
+		FailoverAfter:          20 * time.Millisecond,
+		FailbackInterval:       20 * time.Millisecond,
+		ReceiveErrorMaxBackoff: 5 * time.Millisecond,
+		Metrics:                metrics,
+	})
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.activeQueue.WithLabelValues("orders")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.activeQueue.WithLabelValues("orders-dr")))
+
+	supervisor.Start(1)
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return healthy && supervisor.failover.activeQueue() == 0
+	}, 5*time.Second, 5*time.Millisecond)
+	supervisor.Shutdown()
+	supervisor.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	assert.Equal(t, primary, received[0])
+	assert.Contains(t, received, secondary)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.failovers.WithLabelValues("orders-dr")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.failovers.WithLabelValues("orders")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.activeQueue.WithLabelValues("orders")))
+}
@@ -0,0 +1,153 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FailureReason classifies why a message was not delivered. Reasons are used
+// as log fields and metric labels and must not change.
+type FailureReason string
+
+const (
+	// FailureHTTPTimeout means the worker did not respond in time.
+	FailureHTTPTimeout FailureReason = "http-timeout"
+	// FailureHTTP5xx means the worker responded with a 5xx status code.
+	FailureHTTP5xx FailureReason = "http-5xx"
+	// FailureHTTP4xx means the worker responded with a 4xx status code.
+	FailureHTTP4xx FailureReason = "http-4xx"
+	// FailureConnectionError means the request failed without a response.
+	FailureConnectionError FailureReason = "connection-error"
+	// FailureSignatureSkipped means the request was not sent because it could
+	// not be signed.
+	FailureSignatureSkipped FailureReason = "signature-skipped"
+	// FailureOversized means the response body exceeded HTTPMaxResponseBody.
+	FailureOversized FailureReason = "oversized"
+	// FailureFiltered means the message did not match the body filter, or
+	// was dropped by the MessageFilter or a Middleware.
+	FailureFiltered FailureReason = "filtered"
+	// FailureFilterError means the MessageFilter or a Middleware returned an
+	// error.
+	FailureFilterError FailureReason = "filter-error"
+	// FailureBodyTemplate means the body template failed for the message.
+	FailureBodyTemplate FailureReason = "body-template"
+	// FailureDecodeError means the message body could not be decoded.
+	FailureDecodeError FailureReason = "decode-error"
+	// FailureBodyTooLarge means the message body exceeded MaxBodyBytes, or
+	// its request body HTTPMaxBodySize.
+	FailureBodyTooLarge FailureReason = "body-too-large"
+	// FailureCircuitOpen means the message was not delivered because the
+	// circuit breaker was open.
+	FailureCircuitOpen FailureReason = "circuit-open"
+	// FailurePayloadError means the payload of the message could not be
+	// fetched from S3.
+	FailurePayloadError FailureReason = "payload-error"
+	// FailureBatchResponse means the worker's response to a batch could not
+	// be parsed or had no result for the message.
+	FailureBatchResponse FailureReason = "batch-response"
+	// FailurePoison means the message was received more than MaxReceiveCount
+	// times.
+	FailurePoison FailureReason = "poison"
+	// FailureCorrupt means the message body did not match its MD5 with
+	// VerifyMD5.
+	FailureCorrupt FailureReason = "corrupt"
+)
+
+// signatureError is returned when a request could not be signed.
+type signatureError struct {
+	err error
+}
+
+func (e *signatureError) Error() string {
+	return e.err.Error()
+}
+
+// oversizedError is returned when a response body exceeds the configured
+// maximum.
+type oversizedError struct {
+	max int64
+}
+
+func (e *oversizedError) Error() string {
+	return fmt.Sprintf("HTTP response body exceeds %d bytes", e.max)
+}
+
+// failureReasonForError classifies an error returned by a delivery.
+func failureReasonForError(err error) FailureReason {
+	var sigErr *signatureError
+	if errors.As(err, &sigErr) {
+		return FailureSignatureSkipped
+	}
+
+	var tmplErr *templateError
+	if errors.As(err, &tmplErr) {
+		return FailureBodyTemplate
+	}
+
+	var decErr *decodeError
+	if errors.As(err, &decErr) {
+		return FailureDecodeError
+	}
+
+	if errors.Is(err, errCircuitOpen) {
+		return FailureCircuitOpen
+	}
+
+	var payloadErr *s3PayloadError
+	if errors.As(err, &payloadErr) {
+		return FailurePayloadError
+	}
+
+	var batchErr *batchResponseError
+	if errors.As(err, &batchErr) {
+		return FailureBatchResponse
+	}
+
+	var sizeErr *oversizedError
+	if errors.As(err, &sizeErr) {
+		return FailureOversized
+	}
+
+	var tooLargeErr *requestTooLargeError
+	if errors.As(err, &tooLargeErr) {
+		return FailureBodyTooLarge
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return FailureHTTPTimeout
+	}
+
+	return FailureConnectionError
+}
+
+// failureReasonForStatus classifies a non-successful status code. Status
+// codes outside the 4xx and 5xx classes have no reason.
+func failureReasonForStatus(statusCode int) FailureReason {
+	switch {
+	case statusCode >= http.StatusInternalServerError && statusCode <= 599:
+		return FailureHTTP5xx
+	case statusCode >= http.StatusBadRequest && statusCode < http.StatusInternalServerError:
+		return FailureHTTP4xx
+	}
+
+	return ""
+}
+
+// recordFailure records reason on result and in the metrics, and returns a
+// logger tagged with it and the fields of resultLogger.
+func (s *Supervisor) recordFailure(q *queue, result *messageResult, reason FailureReason) *log.Entry {
+	if len(reason) == 0 {
+		return s.resultLogger(q, result)
+	}
+
+	result.reason = reason
+	s.workerConfig.Metrics.incFailures(q.url, reason)
+
+	return s.resultLogger(q, result).WithField("reason", string(reason))
+}
@@ -0,0 +1,123 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorFailureReasons(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/5xx":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/4xx":
+			w.WriteHeader(http.StatusNotFound)
+		case "/slow":
+			time.Sleep(100 * time.Millisecond)
+		case "/large":
+			w.Write([]byte("a response body that is too large"))
+		}
+	}))
+	defer ts.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name   string
+		config WorkerConfig
+		client *http.Client
+		reason FailureReason
+	}{
+		{
+			name:   "http-5xx",
+			config: WorkerConfig{HTTPURL: ts.URL + "/5xx"},
+			reason: FailureHTTP5xx,
+		},
+		{
+			name:   "http-4xx",
+			config: WorkerConfig{HTTPURL: ts.URL + "/4xx"},
+			reason: FailureHTTP4xx,
+		},
+		{
+			name:   "http-timeout",
+			config: WorkerConfig{HTTPURL: ts.URL + "/slow"},
+			client: &http.Client{Timeout: 10 * time.Millisecond},
+			reason: FailureHTTPTimeout,
+		},
+		{
+			name:   "connection-error",
+			config: WorkerConfig{HTTPURL: closed.URL},
+			reason: FailureConnectionError,
+		},
+		{
+			name:   "oversized",
+			config: WorkerConfig{HTTPURL: ts.URL + "/large", HTTPMaxResponseBody: 4},
+			reason: FailureOversized,
+		},
+		{
+			name:   "filtered",
+			config: WorkerConfig{HTTPURL: ts.URL, BodyFilter: regexp.MustCompile("^order")},
+			reason: FailureFiltered,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, hook := test.NewNullLogger()
+			logger.SetLevel(log.DebugLevel)
+
+			client := tt.client
+			if client == nil {
+				client = &http.Client{}
+			}
+
+			metrics := NewMetrics(prometheus.NewRegistry())
+			config := tt.config
+			config.QueueURL = "https://sqs.us-east-1.amazonaws.com/123456789012/orders"
+			config.Metrics = metrics
+
+			supervisor := NewSupervisor(log.NewEntry(logger), &mockSQS{}, client, config)
+
+			result := supervisor.processMessage(context.Background(), supervisor.queues[0], &types.Message{
+				Body:          aws.String("invoice"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+			})
+
+			assert.Equal(t, tt.reason, result.reason)
+			assert.Equal(t, float64(1), testutil.ToFloat64(metrics.failures.WithLabelValues("orders", string(tt.reason))))
+
+			if assert.NotNil(t, hook.LastEntry()) {
+				assert.Equal(t, string(tt.reason), hook.LastEntry().Data["reason"])
+				assert.Equal(t, "m1", hook.LastEntry().Data["messageId"])
+			}
+		})
+	}
+}
+
+func TestFailureReasonForError(t *testing.T) {
+	assert.Equal(t, FailureSignatureSkipped, failureReasonForError(&signatureError{err: errors.New("write failed")}))
+	assert.Equal(t, FailureOversized, failureReasonForError(&oversizedError{max: 10}))
+	assert.Equal(t, FailureHTTPTimeout, failureReasonForError(context.DeadlineExceeded))
+	assert.Equal(t, FailureConnectionError, failureReasonForError(errors.New("connection refused")))
+}
+
+func TestFailureReasonForStatus(t *testing.T) {
+	assert.Equal(t, FailureHTTP5xx, failureReasonForStatus(http.StatusBadGateway))
+	assert.Equal(t, FailureHTTP4xx, failureReasonForStatus(http.StatusTooManyRequests))
+	assert.Equal(t, FailureReason(""), failureReasonForStatus(http.StatusMovedPermanently))
+}
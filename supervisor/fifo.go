@@ -0,0 +1,90 @@
+package supervisor
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const defaultFIFOMaxGroups = 10
+
+// processFIFOBatch delivers messages of different groups concurrently while
+// keeping delivery within a group sequential. The first message of a group
+// that is not deleted stops delivery of the rest of that group, so they are
+// redelivered in order. Those are reported with the "halted" status.
+func (s *Supervisor) processFIFOBatch(ctx context.Context, q *queue, messages []types.Message) []messageResult {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make([]messageResult, 0, len(messages))
+	)
+
+	for _, group := range groupMessages(messages) {
+		wg.Add(1)
+		s.fifoGroups <- struct{}{}
+
+		go func(group []*types.Message) {
+			defer wg.Done()
+			defer func() { <-s.fifoGroups }()
+
+			for i, msg := range group {
+				result := s.processMessage(ctx, q, msg)
+
+				mu.Lock()
+				results = append(results, result)
+				mu.Unlock()
+
+				if result.disposition != dispositionDelete {
+					halted := group[i+1:]
+					if len(halted) > 0 {
+						s.logger.Warnf("Halting delivery of %d messages in group %s after %s was not processed", len(halted), messageGroupID(msg), *msg.MessageId)
+					}
+
+					mu.Lock()
+					for _, msg := range halted {
+						results = append(results, messageResult{msg: msg, status: "halted"})
+					}
+					mu.Unlock()
+
+					return
+				}
+			}
+		}(group)
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// groupMessages partitions messages by MessageGroupId, preserving the order in
+// which groups and messages were received.
+func groupMessages(messages []types.Message) [][]*types.Message {
+	index := make(map[string]int)
+	groups := make([][]*types.Message, 0)
+
+	for i := range messages {
+		msg := &messages[i]
+		id := messageGroupID(msg)
+
+		i, ok := index[id]
+		if !ok {
+			i = len(groups)
+			index[id] = i
+			groups = append(groups, nil)
+		}
+
+		groups[i] = append(groups[i], msg)
+	}
+
+	return groups
+}
+
+// messageGroupHeader carries the MessageGroupId of messages received from a
+// FIFO queue.
+const messageGroupHeader = "X-Aws-Sqsd-Message-Group-Id"
+
+func messageGroupID(msg *types.Message) string {
+	return msg.Attributes[string(types.MessageSystemAttributeNameMessageGroupId)]
+}
@@ -0,0 +1,171 @@
+package supervisor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func fifoMessages(groups int, perGroup int) []types.Message {
+	messages := make([]types.Message, 0, groups*perGroup)
+
+	for i := 0; i < perGroup; i++ {
+		for g := 0; g < groups; g++ {
+			id := fmt.Sprintf("g%d-%d", g, i)
+			messages = append(messages, types.Message{
+				Body:          aws.String(id),
+				MessageId:     aws.String(id),
+				ReceiptHandle: aws.String(id),
+				Attributes: map[string]string{
+					string(types.MessageSystemAttributeNameMessageGroupId): fmt.Sprintf("g%d", g),
+				},
+			})
+		}
+	}
+
+	return messages
+}
+
+func TestSupervisorFIFOMaxGroups(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		active    int
+		maxActive int
+		delivered = map[string][]string{}
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		group := strings.SplitN(string(body), "-", 2)[0]
+		delivered[group] = append(delivered[group], string(body))
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		HTTPURL:       ts.URL,
+		FIFO:          true,
+		FIFOMaxGroups: 3,
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	mockSQS.receiveMessageFunc = func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		assert.Contains(t, input.MessageSystemAttributeNames, types.MessageSystemAttributeNameMessageGroupId)
+
+		return &sqs.ReceiveMessageOutput{Messages: fifoMessages(8, 3)}, nil
+	}
+
+	deleted := 0
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		assert.True(t, len(input.Entries) <= maxBatchEntries)
+
+		deleted += len(input.Entries)
+		if deleted == 24 {
+			supervisor.Shutdown()
+		}
+
+		return nil, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	assert.True(t, maxActive <= 3, "at most 3 groups should be active, got %d", maxActive)
+	assert.True(t, maxActive > 1, "groups should be processed concurrently")
+
+	assert.Len(t, delivered, 8)
+	for g := 0; g < 8; g++ {
+		group := fmt.Sprintf("g%d", g)
+		assert.Equal(t, []string{group + "-0", group + "-1", group + "-2"}, delivered[group])
+	}
+}
+
+func TestSupervisorFIFOFailureHaltsGroup(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		delivered []string
+		groups    []string
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		mu.Lock()
+		delivered = append(delivered, string(body))
+		groups = append(groups, r.Header.Get("X-Aws-Sqsd-Message-Group-Id"))
+		mu.Unlock()
+
+		if string(body) == "g0-0" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		HTTPURL: ts.URL,
+		FIFO:    true,
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	mockSQS.receiveMessageFunc = func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		return &sqs.ReceiveMessageOutput{Messages: fifoMessages(2, 2)}, nil
+	}
+
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		defer supervisor.Shutdown()
+
+		ids := make([]string, 0, len(input.Entries))
+		for _, entry := range input.Entries {
+			ids = append(ids, *entry.Id)
+		}
+		assert.ElementsMatch(t, []string{"g1-0", "g1-1"}, ids)
+
+		return nil, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	assert.NotContains(t, delivered, "g0-1")
+	assert.ElementsMatch(t, []string{"g0", "g1", "g1"}, groups)
+
+	report := supervisor.Report()
+	assert.Equal(t, int64(1), report.Statuses["halted"])
+	assert.Equal(t, int64(4), report.Processed)
+}
@@ -0,0 +1,45 @@
+package supervisor
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// MessageFilter decides, before delivery, whether a message is sent to the
+// worker and with what body. Returning send false deletes the message without
+// delivering it, returning an error leaves it in the queue for redelivery.
+// Otherwise body is delivered in place of the message body.
+type MessageFilter interface {
+	Filter(msg *types.Message) (send bool, body string, err error)
+}
+
+// MessageFilterFunc adapts a function to a MessageFilter.
+type MessageFilterFunc func(msg *types.Message) (send bool, body string, err error)
+
+func (f MessageFilterFunc) Filter(msg *types.Message) (bool, string, error) {
+	return f(msg)
+}
+
+// filterMessage runs the MessageFilter, then the Middleware, on msg. It
+// returns the message to deliver, which carries the filtered body, or nil
+// when msg must not be sent.
+func (s *Supervisor) filterMessage(ctx context.Context, msg *types.Message) (*types.Message, error) {
+	if s.workerConfig.MessageFilter != nil {
+		send, body, err := s.workerConfig.MessageFilter.Filter(msg)
+		if err != nil || !send {
+			return nil, err
+		}
+
+		filtered := *msg
+		filtered.Body = aws.String(body)
+		msg = &filtered
+	}
+
+	if len(s.workerConfig.Middleware) == 0 {
+		return msg, nil
+	}
+
+	return Chain(s.workerConfig.Middleware...)(ctx, msg)
+}
@@ -0,0 +1,69 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorMessageFilter(t *testing.T) {
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL: ts.URL,
+		MessageFilter: MessageFilterFunc(func(msg *types.Message) (bool, string, error) {
+			body := aws.ToString(msg.Body)
+			switch {
+			case body == "heartbeat":
+				return false, "", nil
+			case body == "invalid":
+				return false, "", errors.New("invalid message")
+			}
+
+			return true, strings.ToUpper(body), nil
+		}),
+	})
+
+	tests := []struct {
+		body        string
+		disposition disposition
+		status      string
+		reason      FailureReason
+	}{
+		{body: "order", disposition: dispositionDelete, status: "delivered"},
+		{body: "heartbeat", disposition: dispositionDelete, status: "filtered", reason: FailureFiltered},
+		{body: "invalid", disposition: dispositionRetry, reason: FailureFilterError},
+	}
+
+	for _, tt := range tests {
+		msg := &types.Message{
+			Body:          aws.String(tt.body),
+			MessageId:     aws.String(tt.body),
+			ReceiptHandle: aws.String("r1"),
+		}
+		result := supervisor.processMessage(context.Background(), supervisor.queues[0], msg)
+
+		assert.Equal(t, tt.disposition, result.disposition, tt.body)
+		assert.Equal(t, tt.status, result.status, tt.body)
+		assert.Equal(t, tt.reason, result.reason, tt.body)
+		assert.Equal(t, tt.body, aws.ToString(msg.Body), tt.body)
+	}
+
+	assert.Equal(t, []string{"ORDER"}, bodies)
+}
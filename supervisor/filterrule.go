@@ -0,0 +1,204 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// FilterActionForward delivers the messages matching a FilterRule.
+const FilterActionForward FilterAction = "forward"
+
+// FilterRule decides what happens to the messages it matches before they are
+// delivered, sparing requests for messages the worker would ignore.
+type FilterRule struct {
+	// Attribute is the name of a String or Number message attribute the
+	// message must have.
+	Attribute string `json:"attribute"`
+	// Path is a JSONPath to a value the body of the message must have, e.g.
+	// "$.event.type" or "$.items[0].sku". Only member and array index
+	// selectors are supported.
+	Path string `json:"path"`
+	// Value, when set, is the value Attribute and the value at Path must
+	// have. Values at Path other than strings are compared in their JSON
+	// encoding, e.g. "42" or "true".
+	Value string `json:"value"`
+	// Action is what happens to the messages matching the rule: they are
+	// delivered with FilterActionForward, deleted with FilterActionDelete or
+	// left in the queue with FilterActionLeave.
+	Action FilterAction `json:"action"`
+}
+
+// ParseFilterRules parses a JSON array of filter rules, e.g.
+// [{"attribute": "tenant", "value": "ours", "action": "forward"},
+// {"path": "$.event.type", "value": "ping", "action": "delete"}].
+func ParseFilterRules(value string) ([]FilterRule, error) {
+	var rules []FilterRule
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return nil, err
+	}
+
+	for i, rule := range rules {
+		if len(rule.Attribute) == 0 && len(rule.Path) == 0 {
+			return nil, fmt.Errorf("rule %d has neither an attribute nor a path", i)
+		}
+		if len(rule.Path) > 0 {
+			if _, err := parseJSONPath(rule.Path); err != nil {
+				return nil, fmt.Errorf("rule %d has an invalid path: %s", i, err)
+			}
+		}
+		if !rule.Action.valid() {
+			return nil, fmt.Errorf("rule %d has an invalid action %q: must be forward, delete or leave", i, rule.Action)
+		}
+	}
+
+	return rules, nil
+}
+
+func (a FilterAction) valid() bool {
+	return a == FilterActionForward || a == FilterActionDelete || a == FilterActionLeave
+}
+
+// filterRuleAction returns the action of the first of FilterRules matching
+// msg, or FilterDefaultAction when none does. Paths select values in the body
+// the worker would receive, unwrapped from SNS and decoded.
+func (s *Supervisor) filterRuleAction(msg *types.Message) FilterAction {
+	// The body is decoded once, for the first rule with a path.
+	var (
+		document interface{}
+		decoded  bool
+		valid    bool
+	)
+
+	for _, rule := range s.workerConfig.FilterRules {
+		if len(rule.Attribute) > 0 {
+			value, ok := attributeValue(msg, rule.Attribute)
+			if !ok || (len(rule.Value) > 0 && value != rule.Value) {
+				continue
+			}
+		}
+
+		if len(rule.Path) > 0 {
+			if !decoded {
+				decoded = true
+				if body, err := s.messageBody(msg); err == nil {
+					valid = json.Unmarshal([]byte(body), &document) == nil
+				}
+			}
+			if !valid {
+				continue
+			}
+
+			value, ok := jsonPathValue(document, rule.Path)
+			if !ok || (len(rule.Value) > 0 && value != rule.Value) {
+				continue
+			}
+		}
+
+		return rule.Action
+	}
+
+	if len(s.workerConfig.FilterDefaultAction) == 0 {
+		return FilterActionForward
+	}
+
+	return s.workerConfig.FilterDefaultAction
+}
+
+// jsonPathValue returns the value at path in v, a decoded JSON document, as
+// a string for strings and in its JSON encoding otherwise.
+func jsonPathValue(v interface{}, path string) (string, bool) {
+	segments, err := parseJSONPath(path)
+	if err != nil {
+		return "", false
+	}
+
+	for _, segment := range segments {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			if segment.index >= 0 {
+				return "", false
+			}
+
+			var ok bool
+			if v, ok = node[segment.name]; !ok {
+				return "", false
+			}
+		case []interface{}:
+			if segment.index < 0 || segment.index >= len(node) {
+				return "", false
+			}
+
+			v = node[segment.index]
+		default:
+			return "", false
+		}
+	}
+
+	if s, ok := v.(string); ok {
+		return s, true
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", false
+	}
+
+	return string(b), true
+}
+
+// jsonPathSegment selects the member name of an object, or the element at
+// index of an array when index is not negative.
+type jsonPathSegment struct {
+	name  string
+	index int
+}
+
+// parseJSONPath parses a JSONPath made of member and array index selectors,
+// e.g. "$.items[0].sku".
+func parseJSONPath(path string) ([]jsonPathSegment, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, errors.New("must start with $")
+	}
+
+	var segments []jsonPathSegment
+	rest := path[1:]
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+
+			name := rest[1 : end+1]
+			if len(name) == 0 {
+				return nil, errors.New("empty member name")
+			}
+
+			segments = append(segments, jsonPathSegment{name: name, index: -1})
+			rest = rest[end+1:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, errors.New("unterminated index")
+			}
+
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid index %q", rest[1:end])
+			}
+
+			segments = append(segments, jsonPathSegment{index: index})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected %q", rest[0])
+		}
+	}
+
+	return segments, nil
+}
@@ -0,0 +1,111 @@
+package supervisor
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFilterRules(t *testing.T) {
+	rules, err := ParseFilterRules(`[{"attribute": "tenant", "value": "ours", "action": "forward"}, {"path": "$.items[0].sku", "action": "leave"}]`)
+	assert.NoError(t, err)
+	assert.Equal(t, []FilterRule{
+		{Attribute: "tenant", Value: "ours", Action: FilterActionForward},
+		{Path: "$.items[0].sku", Action: FilterActionLeave},
+	}, rules)
+
+	for value, want := range map[string]string{
+		`[{"value": "x", "action": "delete"}]`:         "rule 0 has neither an attribute nor a path",
+		`[{"path": "event", "action": "delete"}]`:      "rule 0 has an invalid path: must start with $",
+		`[{"path": "$.items[x]", "action": "delete"}]`: `rule 0 has an invalid path: invalid index "x"`,
+		`[{"path": "$..event", "action": "delete"}]`:   "rule 0 has an invalid path: empty member name",
+		`[{"attribute": "tenant", "action": "drop"}]`:  `rule 0 has an invalid action "drop": must be forward, delete or leave`,
+	} {
+		_, err := ParseFilterRules(value)
+		assert.EqualError(t, err, want, value)
+	}
+}
+
+func TestJSONPathValue(t *testing.T) {
+	var document interface{} = map[string]interface{}{
+		"event": map[string]interface{}{"type": "created", "version": float64(2)},
+		"items": []interface{}{map[string]interface{}{"sku": "a1"}},
+		"test":  true,
+	}
+
+	for path, want := range map[string]string{
+		"$.event.type":    "created",
+		"$.event.version": "2",
+		"$.items[0].sku":  "a1",
+		"$.test":          "true",
+		"$.event":         `{"type":"created","version":2}`,
+	} {
+		value, ok := jsonPathValue(document, path)
+		assert.True(t, ok, path)
+		assert.Equal(t, want, value, path)
+	}
+
+	for _, path := range []string{"$.missing", "$.items[1]", "$.event[0]", "$.items.sku", "$.test.value"} {
+		_, ok := jsonPathValue(document, path)
+		assert.False(t, ok, path)
+	}
+}
+
+func TestSupervisorFilterRules(t *testing.T) {
+	deliveries := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deliveries++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL: ts.URL,
+		FilterRules: []FilterRule{
+			{Path: "$.event.type", Value: "ping", Action: FilterActionDelete},
+			{Attribute: "tenant", Value: "ours", Action: FilterActionForward},
+			{Attribute: "tenant", Action: FilterActionLeave},
+		},
+		FilterDefaultAction: FilterActionDelete,
+	})
+
+	message := func(body string, tenant string) *types.Message {
+		msg := &types.Message{Body: aws.String(body), MessageId: aws.String("m"), ReceiptHandle: aws.String("r")}
+		if len(tenant) > 0 {
+			msg.MessageAttributes = map[string]types.MessageAttributeValue{
+				"tenant": {DataType: aws.String("String"), StringValue: aws.String(tenant)},
+			}
+		}
+
+		return msg
+	}
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	for _, c := range []struct {
+		body        string
+		tenant      string
+		status      string
+		disposition disposition
+	}{
+		{`{"event": {"type": "created"}}`, "ours", "delivered", dispositionDelete},
+		{`{"event": {"type": "ping"}}`, "ours", "filtered", dispositionDelete},
+		{`{"event": {"type": "created"}}`, "theirs", "filtered", dispositionRetry},
+		{`not json`, "", "filtered", dispositionDelete},
+	} {
+		result := supervisor.processMessage(ctx, supervisor.queues[0], message(c.body, c.tenant))
+		assert.Equal(t, c.status, result.status, c.body+" "+c.tenant)
+		assert.Equal(t, c.disposition, result.disposition, c.body+" "+c.tenant)
+	}
+
+	assert.Equal(t, 1, deliveries)
+}
@@ -0,0 +1,157 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcStatusCodes maps gRPC status codes to the HTTP status codes their
+// responses are handled like, as in the gRPC HTTP mapping.
+var grpcStatusCodes = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+type grpcDeliverer struct {
+	conn    *grpc.ClientConn
+	method  string
+	timeout time.Duration
+}
+
+// NewGRPCDeliverer returns a Deliverer that calls method, such as
+// "/jobs.Worker/Process", on the gRPC server at target for every message.
+// The message body is sent as the encoded request message and the response
+// message is ignored. The message ID, queue URL, receive count and String and
+// Number attributes are sent as x-sqsd-* metadata. The status of the call is
+// handled like the matching HTTP status code, and its message like a response
+// body. Calls are cancelled after timeout, unless it is zero. Connections are
+// made without TLS when creds is nil.
+func NewGRPCDeliverer(target string, method string, creds credentials.TransportCredentials, timeout time.Duration) (Deliverer, error) {
+	if creds == nil {
+		creds = insecure.NewCredentials()
+	}
+
+	if !strings.HasPrefix(method, "/") {
+		method = "/" + method
+	}
+
+	conn, err := grpc.Dial(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+
+	return &grpcDeliverer{
+		conn:    conn,
+		method:  method,
+		timeout: timeout,
+	}, nil
+}
+
+func (d *grpcDeliverer) Deliver(ctx context.Context, queueURL string, msg *types.Message) (*http.Response, error) {
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+
+	ctx = metadata.NewOutgoingContext(ctx, grpcMetadata(queueURL, msg))
+
+	req := []byte(aws.ToString(msg.Body))
+	var reply []byte
+	err := d.conn.Invoke(ctx, d.method, &req, &reply, grpc.ForceCodec(rawCodec{}))
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return nil, err
+	}
+
+	statusCode, ok := grpcStatusCodes[st.Code()]
+	if !ok {
+		statusCode = http.StatusInternalServerError
+	}
+
+	return &http.Response{
+		StatusCode: statusCode,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader(st.Message())),
+	}, nil
+}
+
+// grpcMetadata returns the metadata describing msg to the gRPC server.
+func grpcMetadata(queueURL string, msg *types.Message) metadata.MD {
+	md := metadata.Pairs(
+		"x-sqsd-msgid", aws.ToString(msg.MessageId),
+		"x-sqsd-queue", queueURL,
+		"x-sqsd-receive-count", msg.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)],
+	)
+
+	for name, attr := range msg.MessageAttributes {
+		if attr.StringValue == nil || strings.HasPrefix(aws.ToString(attr.DataType), "Binary") {
+			continue
+		}
+
+		md.Append("x-sqsd-attr-"+strings.ToLower(envNameUnsafe.ReplaceAllString(name, "-")), *attr.StringValue)
+	}
+
+	return md
+}
+
+// rawCodec sends and receives gRPC messages as they are encoded, so calls
+// need no generated message types.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("raw codec can't marshal %T", v)
+	}
+
+	return *b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("raw codec can't unmarshal into %T", v)
+	}
+
+	*b = append((*b)[:0], data...)
+
+	return nil
+}
+
+// Name is the content subtype of the calls, which servers expect to be proto.
+func (rawCodec) Name() string {
+	return "proto"
+}
@@ -0,0 +1,90 @@
+package supervisor
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCDeliverer(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		method string
+		body   []byte
+		md     metadata.MD
+	)
+	server := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}), grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		method, _ = grpc.MethodFromServerStream(stream)
+		md, _ = metadata.FromIncomingContext(stream.Context())
+		if err := stream.RecvMsg(&body); err != nil {
+			return err
+		}
+
+		switch string(body) {
+		case "invalid":
+			return status.Error(codes.InvalidArgument, "unknown order")
+		case "slow":
+			time.Sleep(time.Second)
+		}
+
+		reply := []byte{}
+		return stream.SendMsg(&reply)
+	}))
+	go server.Serve(lis)
+	defer server.Stop()
+
+	deliverer, err := NewGRPCDeliverer(lis.Addr().String(), "jobs.Worker/Process", nil, 100*time.Millisecond)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	msg := &types.Message{
+		Body:      aws.String("order"),
+		MessageId: aws.String("m1"),
+		Attributes: map[string]string{
+			string(types.MessageSystemAttributeNameApproximateReceiveCount): "2",
+		},
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"Event.Type": {DataType: aws.String("String"), StringValue: aws.String("created")},
+		},
+	}
+
+	res, err := deliverer.Deliver(context.Background(), "https://queue.url/jobs", msg)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+	assert.Equal(t, "/jobs.Worker/Process", method)
+	assert.Equal(t, "order", string(body))
+	assert.Equal(t, []string{"m1"}, md.Get("x-sqsd-msgid"))
+	assert.Equal(t, []string{"https://queue.url/jobs"}, md.Get("x-sqsd-queue"))
+	assert.Equal(t, []string{"2"}, md.Get("x-sqsd-receive-count"))
+	assert.Equal(t, []string{"created"}, md.Get("x-sqsd-attr-event-type"))
+
+	msg.Body = aws.String("invalid")
+	res, err = deliverer.Deliver(context.Background(), "https://queue.url/jobs", msg)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		body, _ := ioutil.ReadAll(res.Body)
+		assert.Equal(t, "unknown order", string(body))
+	}
+
+	msg.Body = aws.String("slow")
+	_, err = deliverer.Deliver(context.Background(), "https://queue.url/jobs", msg)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, FailureHTTPTimeout, failureReasonForError(err))
+}
@@ -0,0 +1,115 @@
+package supervisor
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+const defaultWorkerHealthInterval = 5 * time.Second
+
+// Healthy reports whether any of the supervisor's workers are running.
+func (s *Supervisor) Healthy() bool {
+	return s.runningWorkers.Load() > 0
+}
+
+// Ready reports whether the supervisor should receive traffic: it has
+// received from the queue successfully, within ReadyReceiveMaxAge when set,
+// is not shutting down and, when a worker health URL is configured, the
+// worker is healthy.
+func (s *Supervisor) Ready() bool {
+	return s.receivedRecently() && !s.shutdown.Load() && s.workerReady()
+}
+
+// receivedRecently reports whether messages were received successfully, and
+// within ReadyReceiveMaxAge when set.
+func (s *Supervisor) receivedRecently() bool {
+	last := s.lastReceive.Load()
+	if last == 0 {
+		return false
+	}
+
+	maxAge := s.workerConfig.ReadyReceiveMaxAge
+	return maxAge <= 0 || time.Since(time.Unix(0, last)) <= maxAge
+}
+
+// workerReady reports whether the worker is healthy. It always is when no
+// worker health URL is configured.
+func (s *Supervisor) workerReady() bool {
+	if len(s.workerConfig.WorkerHealthURL) == 0 {
+		return true
+	}
+
+	return s.workerHealthy.Load()
+}
+
+// watchWorkerHealth probes the worker health URL until the supervisor shuts
+// down.
+func (s *Supervisor) watchWorkerHealth() {
+	ticker := time.NewTicker(s.workerHealthInterval())
+	defer ticker.Stop()
+
+	for {
+		s.checkWorkerHealth()
+
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Supervisor) workerHealthInterval() time.Duration {
+	if s.workerConfig.WorkerHealthInterval <= 0 {
+		return defaultWorkerHealthInterval
+	}
+
+	return s.workerConfig.WorkerHealthInterval
+}
+
+func (s *Supervisor) checkWorkerHealth() {
+	healthy := false
+
+	ctx := context.Background()
+	if s.workerConfig.HTTPTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.workerConfig.HTTPTimeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.workerConfig.WorkerHealthURL, nil)
+	if err == nil {
+		var res *http.Response
+		res, err = s.httpClient.Do(req)
+		if err == nil {
+			res.Body.Close()
+			healthy = res.StatusCode >= http.StatusOK && res.StatusCode < http.StatusMultipleChoices
+		}
+	}
+
+	if s.workerHealthy.Swap(healthy) != healthy {
+		if healthy {
+			s.logger.Info("Worker is healthy")
+			s.ramp.restart(time.Now())
+		} else if err != nil {
+			s.logger.Warnf("Worker is unhealthy: %s", err)
+		} else {
+			s.logger.Warn("Worker is unhealthy")
+		}
+	}
+}
+
+// receivePaused reports whether workers should hold off receiving messages
+// because the worker is unhealthy.
+func (s *Supervisor) receivePaused() bool {
+	return s.workerConfig.PauseWhenUnhealthy && !s.workerReady()
+}
+
+// sleep waits for d or until the supervisor shuts down, whichever comes first.
+func (s *Supervisor) sleep(d time.Duration) {
+	select {
+	case <-s.done:
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,146 @@
+package supervisor
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorWorkerHealthGate(t *testing.T) {
+	var healthy atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		HTTPURL:              ts.URL,
+		WorkerHealthURL:      ts.URL,
+		WorkerHealthInterval: 10 * time.Millisecond,
+		PauseWhenUnhealthy:   true,
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	var receiveCount atomic.Int32
+	var stop atomic.Bool
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		receiveCount.Add(1)
+		time.Sleep(time.Millisecond)
+
+		if stop.Load() {
+			supervisor.Shutdown()
+		}
+
+		return &sqs.ReceiveMessageOutput{}, nil
+	}
+	supervisor.Start(1)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, supervisor.Ready())
+	assert.Zero(t, receiveCount.Load())
+
+	healthy.Store(true)
+	assert.Eventually(t, supervisor.Ready, time.Second, 5*time.Millisecond)
+	assert.Eventually(t, func() bool { return receiveCount.Load() > 0 }, 2*time.Second, 5*time.Millisecond)
+
+	healthy.Store(false)
+	assert.Eventually(t, func() bool { return !supervisor.Ready() }, time.Second, 5*time.Millisecond)
+
+	time.Sleep(20 * time.Millisecond)
+	paused := receiveCount.Load()
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, paused, receiveCount.Load())
+
+	stop.Store(true)
+	healthy.Store(true)
+	supervisor.Wait()
+}
+
+func TestSupervisorHealthyAndReady(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, WorkerConfig{})
+
+	var fail atomic.Bool
+	fail.Store(true)
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		time.Sleep(time.Millisecond)
+
+		if fail.Load() {
+			return nil, errors.New("access denied")
+		}
+
+		return &sqs.ReceiveMessageOutput{}, nil
+	}
+
+	assert.False(t, supervisor.Healthy())
+	assert.False(t, supervisor.Ready())
+
+	supervisor.Start(1)
+
+	assert.Eventually(t, supervisor.Healthy, time.Second, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, supervisor.Ready())
+
+	fail.Store(false)
+	assert.Eventually(t, supervisor.Ready, time.Second, 5*time.Millisecond)
+
+	supervisor.Shutdown()
+	assert.False(t, supervisor.Ready())
+
+	supervisor.Wait()
+	assert.False(t, supervisor.Healthy())
+}
+
+func TestSupervisorReadyReceiveMaxAge(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, WorkerConfig{
+		ReadyReceiveMaxAge:     30 * time.Millisecond,
+		ReceiveErrorMaxBackoff: time.Millisecond,
+	})
+
+	var fail atomic.Bool
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		time.Sleep(time.Millisecond)
+
+		if fail.Load() {
+			return nil, errors.New("access denied")
+		}
+
+		return &sqs.ReceiveMessageOutput{}, nil
+	}
+
+	supervisor.Start(1)
+	defer supervisor.Wait()
+	defer supervisor.Shutdown()
+
+	assert.Eventually(t, supervisor.Ready, time.Second, 5*time.Millisecond)
+
+	fail.Store(true)
+	assert.Eventually(t, func() bool { return !supervisor.Ready() }, time.Second, 5*time.Millisecond)
+
+	fail.Store(false)
+	assert.Eventually(t, supervisor.Ready, time.Second, 5*time.Millisecond)
+}
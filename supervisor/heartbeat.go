@@ -0,0 +1,179 @@
+package supervisor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// maxVisibility is the longest SQS lets a message stay invisible after it is
+// received.
+const maxVisibility = 12 * time.Hour
+
+type heartbeatKey struct{}
+
+// heartbeat extends the visibility timeout of the messages of a batch while
+// they are being delivered, every VisibilityExtensionInterval. Each tick
+// extends all of them with a single ChangeMessageVisibilityBatch call.
+type heartbeat struct {
+	s        *Supervisor
+	q        *queue
+	interval time.Duration
+
+	// mu is held while extending, so that a message is never extended once
+	// it was removed.
+	mu sync.Mutex
+	// until holds when SQS stops extending each message, VisibilityMax after
+	// it was received.
+	until map[*types.Message]time.Time
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// withHeartbeat returns ctx carrying a heartbeat for the messages of q
+// processed under it, and the function stopping it once they all were.
+func (s *Supervisor) withHeartbeat(ctx context.Context, q *queue) (context.Context, func()) {
+	if s.workerConfig.VisibilityExtensionInterval <= 0 {
+		return ctx, func() {}
+	}
+
+	hb := s.newHeartbeat(q)
+	return context.WithValue(ctx, heartbeatKey{}, hb), hb.close
+}
+
+func (s *Supervisor) newHeartbeat(q *queue) *heartbeat {
+	hb := &heartbeat{
+		s:        s,
+		q:        q,
+		interval: s.workerConfig.VisibilityExtensionInterval,
+		until:    make(map[*types.Message]time.Time),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go hb.run()
+
+	return hb
+}
+
+func (hb *heartbeat) run() {
+	defer close(hb.stopped)
+
+	ticker := time.NewTicker(hb.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hb.stop:
+			return
+		case <-ticker.C:
+		}
+
+		hb.extend()
+	}
+}
+
+// extend keeps every message invisible for twice the interval, never past
+// its VisibilityMax.
+func (hb *heartbeat) extend() {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+
+	now := time.Now()
+	entries := make([]types.ChangeMessageVisibilityBatchRequestEntry, 0, len(hb.until))
+	for msg, until := range hb.until {
+		extension := 2 * hb.interval
+		if remaining := until.Sub(now); remaining < extension {
+			extension = remaining
+		}
+		if extension <= 0 {
+			delete(hb.until, msg)
+			continue
+		}
+
+		entries = append(entries, types.ChangeMessageVisibilityBatchRequestEntry{
+			Id:                msg.MessageId,
+			ReceiptHandle:     msg.ReceiptHandle,
+			VisibilityTimeout: visibilitySeconds(extension),
+		})
+	}
+
+	if len(entries) > 0 {
+		hb.s.changeVisibility(hb.q, entries)
+	}
+}
+
+func (hb *heartbeat) add(msg *types.Message, until time.Time) {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+
+	hb.until[msg] = until
+}
+
+func (hb *heartbeat) remove(msg *types.Message) {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+
+	delete(hb.until, msg)
+}
+
+func (hb *heartbeat) close() {
+	close(hb.stop)
+	<-hb.stopped
+}
+
+// startHeartbeat extends the visibility timeout of msg with the heartbeat of
+// ctx until the returned function is called, so that it is not redelivered
+// while it is being delivered. Every extension keeps the message invisible
+// for twice VisibilityExtensionInterval, never past VisibilityMax after it
+// was received. Without a heartbeat in ctx, msg gets one of its own.
+func (s *Supervisor) startHeartbeat(ctx context.Context, q *queue, msg *types.Message) func() {
+	if s.workerConfig.VisibilityExtensionInterval <= 0 {
+		return func() {}
+	}
+
+	receivedAt, ok := ctx.Value(receivedAtKey{}).(time.Time)
+	if !ok {
+		receivedAt = time.Now()
+	}
+
+	hb, ok := ctx.Value(heartbeatKey{}).(*heartbeat)
+	if !ok || hb.q != q {
+		hb = s.newHeartbeat(q)
+		hb.add(msg, receivedAt.Add(s.visibilityMax()))
+		return hb.close
+	}
+
+	hb.add(msg, receivedAt.Add(s.visibilityMax()))
+	return func() { hb.remove(msg) }
+}
+
+// extendVisibility keeps msg invisible for d from now, rounded up to the
+// second.
+func (s *Supervisor) extendVisibility(q *queue, msg *types.Message, d time.Duration) {
+	_, err := s.sqs.ChangeMessageVisibility(context.Background(), &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(q.url),
+		ReceiptHandle:     msg.ReceiptHandle,
+		VisibilityTimeout: visibilitySeconds(d),
+	})
+	if err != nil {
+		s.logger.Errorf("Error while extending the visibility of message %s: %s", aws.ToString(msg.MessageId), err)
+	}
+}
+
+// visibilitySeconds returns d in seconds, rounded up.
+func visibilitySeconds(d time.Duration) int32 {
+	return int32((d + time.Second - 1) / time.Second)
+}
+
+func (s *Supervisor) visibilityMax() time.Duration {
+	if s.workerConfig.VisibilityMax <= 0 || s.workerConfig.VisibilityMax > maxVisibility {
+		return maxVisibility
+	}
+
+	return s.workerConfig.VisibilityMax
+}
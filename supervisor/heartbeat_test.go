@@ -0,0 +1,127 @@
+package supervisor
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorHeartbeat(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		HTTPURL:                     ts.URL,
+		BatchConcurrency:            2,
+		VisibilityExtensionInterval: 20 * time.Millisecond,
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	var (
+		mu         sync.Mutex
+		calls      int
+		extensions = make(map[string]int)
+	)
+	mockSQS.changeMessageVisibilityBatchFunc = func(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		calls++
+		for _, entry := range input.Entries {
+			assert.Equal(t, int32(1), entry.VisibilityTimeout)
+			extensions[*entry.ReceiptHandle]++
+		}
+
+		return nil, nil
+	}
+	mockSQS.changeMessageVisibilityFunc = func(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+		assert.Fail(t, "ChangeMessageVisibility was called")
+		return nil, nil
+	}
+
+	supervisor.handleBatch(supervisor.queues[0], time.Now(), []types.Message{
+		{Body: aws.String("message 1"), MessageId: aws.String("m1"), ReceiptHandle: aws.String("r1")},
+		{Body: aws.String("message 2"), MessageId: aws.String("m2"), ReceiptHandle: aws.String("r2")},
+	})
+
+	mu.Lock()
+	afterDelivery := map[string]int{"r1": extensions["r1"], "r2": extensions["r2"]}
+	afterDeliveryCalls := calls
+	mu.Unlock()
+
+	assert.True(t, afterDelivery["r1"] >= 3, "r1 extended %d times", afterDelivery["r1"])
+	assert.True(t, afterDelivery["r2"] >= 3, "r2 extended %d times", afterDelivery["r2"])
+	// Both messages are extended together on every tick, save possibly the
+	// first and last when their deliveries don't start and end at once.
+	assert.True(t, afterDeliveryCalls <= afterDelivery["r1"]+1, "%d calls for %d extensions", afterDeliveryCalls, afterDelivery["r1"])
+
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, afterDelivery, extensions)
+}
+
+func TestSupervisorHeartbeatStopsAtVisibilityMax(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	mockSQS := &mockSQS{}
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+		VisibilityExtensionInterval: 10 * time.Millisecond,
+		VisibilityMax:               35 * time.Millisecond,
+	})
+
+	var (
+		mu         sync.Mutex
+		extensions int
+	)
+	mockSQS.changeMessageVisibilityBatchFunc = func(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		extensions += len(input.Entries)
+
+		return nil, nil
+	}
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	stop := supervisor.startHeartbeat(ctx, supervisor.queues[0], &types.Message{MessageId: aws.String("m1"), ReceiptHandle: aws.String("r1")})
+	time.Sleep(100 * time.Millisecond)
+	stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.True(t, extensions >= 1 && extensions <= 4, "extended %d times", extensions)
+}
+
+func TestSupervisorProcessingDeadlineWithHeartbeat(t *testing.T) {
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		VisibilityTimeout:           30 * time.Second,
+		VisibilityExtensionInterval: 10 * time.Second,
+		VisibilityMax:               time.Hour,
+	})
+
+	receivedAt := time.Now()
+	ctx, cancel := supervisor.inFlightContext(receivedAt)
+	defer cancel()
+
+	deadline, ok := supervisor.processingDeadline(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, receivedAt.Add(time.Hour), deadline)
+}
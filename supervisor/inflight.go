@@ -0,0 +1,128 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+type receivedAtKey struct{}
+
+type queueVisibilityKey struct{}
+
+// inFlightContext returns the context a batch received at receivedAt is
+// processed under. It expires MaxInFlight after receivedAt when that is set.
+func (s *Supervisor) inFlightContext(receivedAt time.Time) (context.Context, context.CancelFunc) {
+	ctx := context.WithValue(context.Background(), receivedAtKey{}, receivedAt)
+
+	if s.workerConfig.MaxInFlight > 0 {
+		return context.WithDeadline(ctx, receivedAt.Add(s.workerConfig.MaxInFlight))
+	}
+
+	return context.WithCancel(ctx)
+}
+
+// withQueueVisibility returns ctx carrying the default visibility timeout of
+// q, for the messages received from q when VisibilityTimeout is unset.
+func withQueueVisibility(ctx context.Context, q *queue) context.Context {
+	if q.visibilityTimeout <= 0 {
+		return ctx
+	}
+
+	return context.WithValue(ctx, queueVisibilityKey{}, q.visibilityTimeout)
+}
+
+// processingDeadline returns when the daemon gives up on the messages
+// processed under ctx: either when MaxInFlight is reached or when their
+// visibility expires, whichever comes first. With VisibilityExtensionInterval
+// their visibility lasts until VisibilityMax.
+func (s *Supervisor) processingDeadline(ctx context.Context) (time.Time, bool) {
+	deadline, ok := ctx.Deadline()
+
+	visibility := s.workerConfig.VisibilityTimeout
+	if visibility == 0 {
+		visibility, _ = ctx.Value(queueVisibilityKey{}).(time.Duration)
+	}
+	if s.workerConfig.VisibilityExtensionInterval > 0 {
+		visibility = s.visibilityMax()
+	}
+
+	receivedAt, hasReceivedAt := ctx.Value(receivedAtKey{}).(time.Time)
+	if visibility > 0 && hasReceivedAt {
+		expiry := receivedAt.Add(visibility)
+		if !ok || expiry.Before(deadline) {
+			deadline, ok = expiry, true
+		}
+	}
+
+	return deadline, ok
+}
+
+// requestDeadline returns when a request to the worker made under ctx is
+// canceled with VisibilityDeadline: VisibilityDeadlineMargin before the
+// daemon gives up on its message. A deadline already past, e.g. with a
+// margin longer than the visibility timeout of the queue, is not applied
+// rather than cancel the request before it is sent.
+func (s *Supervisor) requestDeadline(ctx context.Context) (time.Time, bool) {
+	if !s.workerConfig.VisibilityDeadline {
+		return time.Time{}, false
+	}
+
+	deadline, ok := s.processingDeadline(ctx)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	deadline = deadline.Add(-s.workerConfig.VisibilityDeadlineMargin)
+	if !deadline.After(time.Now()) {
+		return time.Time{}, false
+	}
+
+	return deadline, true
+}
+
+// releaseBatch makes messages immediately visible again without processing
+// them.
+func (s *Supervisor) releaseBatch(q *queue, messages []types.Message) {
+	s.logger.Infof("Shutting down, releasing %d received messages to the queue", len(messages))
+
+	entries := make([]types.ChangeMessageVisibilityBatchRequestEntry, 0, len(messages))
+	for _, msg := range messages {
+		entries = append(entries, types.ChangeMessageVisibilityBatchRequestEntry{
+			Id:                msg.MessageId,
+			ReceiptHandle:     msg.ReceiptHandle,
+			VisibilityTimeout: 0,
+		})
+	}
+
+	s.changeVisibility(q, entries)
+}
+
+// releaseReason describes why a message processed under ctx is released.
+func (s *Supervisor) releaseReason(ctx context.Context) string {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) && s.workerConfig.MaxInFlight > 0 {
+		return fmt.Sprintf("it was in flight for longer than %s", s.workerConfig.MaxInFlight)
+	}
+
+	return "its processing was canceled"
+}
+
+// releaseMessage makes msg immediately visible again so that it can be
+// redelivered, abandoning its local processing for reason.
+func (s *Supervisor) releaseMessage(q *queue, msg *types.Message, reason string) {
+	s.messageLogger(q, msg).Warnf("Releasing the message to the queue: %s", reason)
+
+	_, err := s.sqs.ChangeMessageVisibility(context.Background(), &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(q.url),
+		ReceiptHandle:     msg.ReceiptHandle,
+		VisibilityTimeout: 0,
+	})
+	if err != nil {
+		s.logger.Errorf("Error while releasing message %s: %s", *msg.MessageId, err)
+	}
+}
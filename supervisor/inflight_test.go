@@ -0,0 +1,262 @@
+package supervisor
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorReleasesMessagesAfterMaxInFlight(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	nullLogger, hook := test.NewNullLogger()
+	logger := log.NewEntry(nullLogger)
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		QueueURL:    "https://sqs.us-east-1.amazonaws.com/123456789012/queue",
+		HTTPURL:     ts.URL,
+		MaxInFlight: 50 * time.Millisecond,
+	}
+
+	var receivedAt time.Time
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		receivedAt = time.Now()
+
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String("message 1"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+			}, {
+				Body:          aws.String("message 2"),
+				MessageId:     aws.String("m2"),
+				ReceiptHandle: aws.String("r2"),
+			}},
+		}, nil
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	var (
+		released   []string
+		releasedAt time.Time
+	)
+	mockSQS.changeMessageVisibilityFunc = func(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+		assert.Equal(t, config.QueueURL, *input.QueueUrl)
+		assert.Equal(t, int32(0), input.VisibilityTimeout)
+
+		released = append(released, *input.ReceiptHandle)
+		releasedAt = time.Now()
+		if len(released) == 2 {
+			supervisor.Shutdown()
+		}
+
+		return nil, nil
+	}
+
+	deleteCount := 0
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		deleteCount++
+
+		return nil, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	assert.Equal(t, []string{"r1", "r2"}, released)
+	assert.Equal(t, 0, deleteCount)
+	elapsed := releasedAt.Sub(receivedAt)
+	assert.True(t, elapsed >= 50*time.Millisecond && elapsed < time.Second, "released after %s", elapsed)
+
+	var reasons []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == log.WarnLevel {
+			reasons = append(reasons, entry.Message)
+		}
+	}
+	assert.Contains(t, reasons, "Releasing the message to the queue: it was in flight for longer than 50ms")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, "its processing was canceled", supervisor.releaseReason(ctx))
+}
+
+func TestSupervisorDeadlineHeader(t *testing.T) {
+	tests := []struct {
+		maxInFlight time.Duration
+		expected    time.Duration
+	}{
+		{0, 30 * time.Second},
+		{5 * time.Second, 5 * time.Second},
+		{time.Minute, 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		var (
+			mu     sync.Mutex
+			header string
+		)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			header = r.Header.Get("X-Sqsd-Deadline")
+			mu.Unlock()
+
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		log.SetOutput(ioutil.Discard)
+		logger := log.WithFields(log.Fields{})
+		mockSQS := &mockSQS{}
+		config := WorkerConfig{
+			HTTPURL:           ts.URL,
+			DeadlineHeader:    "X-Sqsd-Deadline",
+			VisibilityTimeout: 30 * time.Second,
+			MaxInFlight:       tt.maxInFlight,
+		}
+
+		var receivedAt time.Time
+		mockSQS.receiveMessageFunc = func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+			assert.Equal(t, int32(30), input.VisibilityTimeout)
+			receivedAt = time.Now()
+
+			return &sqs.ReceiveMessageOutput{
+				Messages: []types.Message{{
+					Body:          aws.String("message 1"),
+					MessageId:     aws.String("m1"),
+					ReceiptHandle: aws.String("r1"),
+				}},
+			}, nil
+		}
+
+		supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+		mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+			defer supervisor.Shutdown()
+
+			return nil, nil
+		}
+
+		supervisor.Start(1)
+		supervisor.Wait()
+		ts.Close()
+
+		mu.Lock()
+		deadline, err := time.Parse(time.RFC3339Nano, header)
+		mu.Unlock()
+		if assert.NoError(t, err, "maxInFlight %s", tt.maxInFlight) {
+			assert.WithinDuration(t, receivedAt.Add(tt.expected), deadline, 50*time.Millisecond, "maxInFlight %s", tt.maxInFlight)
+		}
+	}
+}
+
+func TestSupervisorVisibilityDeadline(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		header string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		header = r.Header.Get("X-Sqsd-Deadline")
+		mu.Unlock()
+		ioutil.ReadAll(r.Body)
+
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	queueURL := "https://sqs.us-east-1.amazonaws.com/123456789012/queue"
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		QueueURL:                 queueURL,
+		HTTPURL:                  ts.URL,
+		DeadlineHeader:           "X-Sqsd-Deadline",
+		QueueVisibilityTimeouts:  map[string]time.Duration{queueURL: 2 * time.Second},
+		VisibilityDeadline:       true,
+		VisibilityDeadlineMargin: 1500 * time.Millisecond,
+	})
+
+	q := supervisor.queues[0]
+	receivedAt := time.Now()
+	ctx, cancel := supervisor.inFlightContext(receivedAt)
+	defer cancel()
+	ctx = withQueueVisibility(ctx, q)
+
+	deadline, ok := supervisor.processingDeadline(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, receivedAt.Add(2*time.Second), deadline)
+
+	result := supervisor.processMessage(ctx, q, &types.Message{
+		Body:          aws.String("message 1"),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+	})
+	assert.Equal(t, FailureHTTPTimeout, result.reason)
+	assert.WithinDuration(t, receivedAt.Add(500*time.Millisecond), time.Now(), 200*time.Millisecond)
+
+	mu.Lock()
+	sent, err := time.Parse(time.RFC3339Nano, header)
+	mu.Unlock()
+	if assert.NoError(t, err) {
+		assert.WithinDuration(t, receivedAt.Add(500*time.Millisecond), sent, 50*time.Millisecond)
+	}
+}
+
+func TestSupervisorVisibilityDeadlinePast(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	queueURL := "https://sqs.us-east-1.amazonaws.com/123456789012/queue"
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		QueueURL:                 queueURL,
+		HTTPURL:                  ts.URL,
+		QueueVisibilityTimeouts:  map[string]time.Duration{queueURL: 5 * time.Second},
+		VisibilityDeadline:       true,
+		VisibilityDeadlineMargin: 5 * time.Second,
+	})
+
+	q := supervisor.queues[0]
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+	ctx = withQueueVisibility(ctx, q)
+
+	// A margin as long as the visibility timeout leaves no time for the
+	// request, which is sent without a deadline instead of canceled.
+	_, ok := supervisor.requestDeadline(ctx)
+	assert.False(t, ok)
+
+	result := supervisor.processMessage(ctx, q, &types.Message{
+		Body:          aws.String("message 1"),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+	})
+	assert.Equal(t, "delivered", result.status)
+}
@@ -0,0 +1,384 @@
+package supervisor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "sqsd"
+
+	// maxQueueLabelLength bounds the length of the queue label. Longer queue
+	// names are truncated and suffixed with a hash of the full URL.
+	maxQueueLabelLength = 64
+)
+
+// Metrics holds the Prometheus collectors updated by a Supervisor. A nil
+// *Metrics is valid and records nothing.
+type Metrics struct {
+	timeToFirstDelivery prometheus.Gauge
+	deliveries          *prometheus.CounterVec
+	messageAge          *prometheus.HistogramVec
+	dropped             *prometheus.CounterVec
+	undeleted           *prometheus.CounterVec
+	failures            *prometheus.CounterVec
+	received            *prometheus.CounterVec
+	delivered           *prometheus.CounterVec
+	failed              *prometheus.CounterVec
+	requestDuration     *prometheus.HistogramVec
+	deleted             *prometheus.CounterVec
+	receiveDuration     *prometheus.HistogramVec
+	inFlight            *prometheus.GaugeVec
+	inFlightRequests    *prometheus.GaugeVec
+	workers             prometheus.Gauge
+	circuitState        *prometheus.GaugeVec
+	receiveErrors       *prometheus.CounterVec
+	failingWorkers      prometheus.Gauge
+	failovers           *prometheus.CounterVec
+	activeQueue         *prometheus.GaugeVec
+	receiveLimit        *prometheus.GaugeVec
+}
+
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		timeToFirstDelivery: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "time_to_first_delivery_seconds",
+			Help:      "Seconds between the supervisor starting and its first successful delivery.",
+		}),
+		deliveries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "deliveries_total",
+			Help:      "Deliveries to the worker by queue and HTTP status class.",
+		}, []string{"queue", "status"}),
+		messageAge: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "message_age_seconds",
+			Help:      "Seconds between a message being sent to the queue and its delivery to the worker.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 16),
+		}, []string{"queue"}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "dropped_messages_total",
+			Help:      "Messages dropped without delivery by queue and reason: stale, too-large or poison.",
+		}, []string{"queue", "reason"}),
+		undeleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "undeleted_messages_total",
+			Help:      "Messages that could not be deleted from the queue after processing, and may be processed again.",
+		}, []string{"queue"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "failures_total",
+			Help:      "Messages not delivered by queue and failure reason.",
+		}, []string{"queue", "reason"}),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "received_messages_total",
+			Help:      "Messages received from the queue.",
+		}, []string{"queue"}),
+		delivered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "delivered_messages_total",
+			Help:      "Messages successfully delivered to the worker.",
+		}, []string{"queue"}),
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "failed_messages_total",
+			Help:      "Messages whose delivery to the worker failed.",
+		}, []string{"queue"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "request_duration_seconds",
+			Help:      "Duration of the HTTP requests made to the worker.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"queue"}),
+		deleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "deleted_messages_total",
+			Help:      "Messages deleted from the queue.",
+		}, []string{"queue"}),
+		receiveDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "receive_duration_seconds",
+			Help:      "Duration of the ReceiveMessage calls made to SQS, long polling included.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"queue"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "in_flight_messages",
+			Help:      "Messages received and not yet processed.",
+		}, []string{"queue"}),
+		inFlightRequests: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "in_flight_requests",
+			Help:      "Requests made to the worker and not yet answered.",
+		}, []string{"queue"}),
+		workers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "workers",
+			Help:      "Running workers.",
+		}),
+		circuitState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "circuit_state",
+			Help:      "State of the circuit breaker: 0 closed, 1 open, 2 half-open.",
+		}, []string{"queue"}),
+		receiveErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "receive_errors_total",
+			Help:      "ReceiveMessage calls to SQS that failed, by error class.",
+		}, []string{"queue", "class"}),
+		failingWorkers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "receive_failing_workers",
+			Help:      "Workers failing to receive messages for longer than the alert threshold.",
+		}),
+		failovers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "failovers_total",
+			Help:      "Switches of the workers to receiving from the queue, by failover or failback.",
+		}, []string{"queue"}),
+		activeQueue: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "active_queue",
+			Help:      "Whether workers receive from the queue with the failover schedule: 1 active, 0 standby.",
+		}, []string{"queue"}),
+		receiveLimit: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "receive_limit",
+			Help:      "Most messages workers receive at a time under back-pressure, 0 while receiving is paused.",
+		}, []string{"queue"}),
+	}
+
+	reg.MustRegister(m.timeToFirstDelivery, m.deliveries, m.messageAge, m.dropped, m.undeleted, m.failures,
+		m.received, m.delivered, m.failed, m.requestDuration, m.deleted, m.receiveDuration, m.inFlight, m.inFlightRequests, m.workers,
+		m.circuitState, m.receiveErrors, m.failingWorkers, m.failovers, m.activeQueue, m.receiveLimit)
+
+	return m
+}
+
+func (m *Metrics) setTimeToFirstDelivery(d time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.timeToFirstDelivery.Set(d.Seconds())
+}
+
+// incDeliveries counts a delivery. A statusCode of 0 means the request failed
+// without a response.
+func (m *Metrics) incDeliveries(queueURL string, statusCode int) {
+	if m == nil {
+		return
+	}
+
+	m.deliveries.WithLabelValues(queueLabel(queueURL), statusLabel(statusCode)).Inc()
+}
+
+// observeMessageAge records the age of msg when it is about to be delivered.
+// Messages without a SentTimestamp are ignored.
+func (m *Metrics) observeMessageAge(queueURL string, msg *types.Message, now time.Time) {
+	if m == nil {
+		return
+	}
+
+	sent, ok := sentTimestamp(msg)
+	if !ok {
+		return
+	}
+
+	m.messageAge.WithLabelValues(queueLabel(queueURL)).Observe(now.Sub(sent).Seconds())
+}
+
+func (m *Metrics) incDropped(queueURL string, reason string) {
+	if m == nil {
+		return
+	}
+
+	m.dropped.WithLabelValues(queueLabel(queueURL), reason).Inc()
+}
+
+func (m *Metrics) addUndeleted(queueURL string, n int) {
+	if m == nil {
+		return
+	}
+
+	m.undeleted.WithLabelValues(queueLabel(queueURL)).Add(float64(n))
+}
+
+func (m *Metrics) addReceived(queueURL string, n int) {
+	if m == nil {
+		return
+	}
+
+	m.received.WithLabelValues(queueLabel(queueURL)).Add(float64(n))
+}
+
+// observeResult counts a processed message as delivered or failed. Messages
+// that ended any other way, e.g. filtered or released, are not counted.
+func (m *Metrics) observeResult(queueURL string, result messageResult) {
+	if m == nil {
+		return
+	}
+
+	switch result.status {
+	case "delivered":
+		m.delivered.WithLabelValues(queueLabel(queueURL)).Inc()
+	case "":
+		m.failed.WithLabelValues(queueLabel(queueURL)).Inc()
+	}
+}
+
+func (m *Metrics) observeRequestDuration(queueURL string, d time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.requestDuration.WithLabelValues(queueLabel(queueURL)).Observe(d.Seconds())
+}
+
+func (m *Metrics) incDeleted(queueURL string) {
+	if m == nil {
+		return
+	}
+
+	m.deleted.WithLabelValues(queueLabel(queueURL)).Inc()
+}
+
+func (m *Metrics) observeReceiveDuration(queueURL string, d time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.receiveDuration.WithLabelValues(queueLabel(queueURL)).Observe(d.Seconds())
+}
+
+// addInFlight adds n, which may be negative, to the messages in flight.
+func (m *Metrics) addInFlight(queueURL string, n int) {
+	if m == nil {
+		return
+	}
+
+	m.inFlight.WithLabelValues(queueLabel(queueURL)).Add(float64(n))
+}
+
+// addInFlightRequests adds n, which may be negative, to the requests made to
+// the worker and not yet answered.
+func (m *Metrics) addInFlightRequests(queueURL string, n int) {
+	if m == nil {
+		return
+	}
+
+	m.inFlightRequests.WithLabelValues(queueLabel(queueURL)).Add(float64(n))
+}
+
+// addWorkers adds n, which may be negative, to the running workers.
+func (m *Metrics) addWorkers(n int) {
+	if m == nil {
+		return
+	}
+
+	m.workers.Add(float64(n))
+}
+
+func (m *Metrics) setCircuitState(queueURL string, state circuitState) {
+	if m == nil {
+		return
+	}
+
+	m.circuitState.WithLabelValues(queueLabel(queueURL)).Set(float64(state))
+}
+
+func (m *Metrics) incReceiveErrors(queueURL string, class errorClass) {
+	if m == nil {
+		return
+	}
+
+	m.receiveErrors.WithLabelValues(queueLabel(queueURL), string(class)).Inc()
+}
+
+// addFailingWorkers adds n, which may be negative, to the workers failing to
+// receive messages.
+func (m *Metrics) addFailingWorkers(n int) {
+	if m == nil {
+		return
+	}
+
+	m.failingWorkers.Add(float64(n))
+}
+
+func (m *Metrics) incFailovers(queueURL string) {
+	if m == nil {
+		return
+	}
+
+	m.failovers.WithLabelValues(queueLabel(queueURL)).Inc()
+}
+
+func (m *Metrics) setActiveQueue(queueURL string, active bool) {
+	if m == nil {
+		return
+	}
+
+	value := 0.0
+	if active {
+		value = 1
+	}
+	m.activeQueue.WithLabelValues(queueLabel(queueURL)).Set(value)
+}
+
+func (m *Metrics) setReceiveLimit(queueURL string, limit int) {
+	if m == nil {
+		return
+	}
+
+	m.receiveLimit.WithLabelValues(queueLabel(queueURL)).Set(float64(limit))
+}
+
+func (m *Metrics) incFailures(queueURL string, reason FailureReason) {
+	if m == nil {
+		return
+	}
+
+	m.failures.WithLabelValues(queueLabel(queueURL), string(reason)).Inc()
+}
+
+// sentTimestamp returns when msg was sent to the queue.
+func sentTimestamp(msg *types.Message) (time.Time, bool) {
+	ms, err := strconv.ParseInt(msg.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, ms*int64(time.Millisecond)), true
+}
+
+// queueLabel returns the queue name from a queue URL, bounded to
+// maxQueueLabelLength characters.
+func queueLabel(queueURL string) string {
+	name := queueName(queueURL)
+	if len(name) <= maxQueueLabelLength {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(queueURL))
+	hash := hex.EncodeToString(sum[:4])
+
+	return name[:maxQueueLabelLength-len(hash)-1] + "-" + hash
+}
+
+// statusLabel buckets a status code into its class, e.g. "2xx".
+func statusLabel(statusCode int) string {
+	if statusCode < 100 || statusCode > 599 {
+		return "error"
+	}
+
+	return fmt.Sprintf("%dxx", statusCode/100)
+}
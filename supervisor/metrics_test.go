@@ -0,0 +1,229 @@
+package supervisor
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueLabel(t *testing.T) {
+	assert.Equal(t, "orders", queueLabel("https://sqs.us-east-1.amazonaws.com/123456789012/orders"))
+
+	long := "https://sqs.us-east-1.amazonaws.com/123456789012/" + strings.Repeat("q", 100)
+	label := queueLabel(long)
+	assert.Len(t, label, maxQueueLabelLength)
+	assert.NotEqual(t, label, queueLabel(long+"x"))
+}
+
+func TestStatusLabel(t *testing.T) {
+	assert.Equal(t, "2xx", statusLabel(http.StatusOK))
+	assert.Equal(t, "4xx", statusLabel(http.StatusTooManyRequests))
+	assert.Equal(t, "5xx", statusLabel(http.StatusBadGateway))
+	assert.Equal(t, "error", statusLabel(0))
+}
+
+func TestSupervisorDeliveryCounters(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) == "fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	metrics := NewMetrics(prometheus.NewRegistry())
+	config := WorkerConfig{
+		QueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/orders",
+		HTTPURL:  ts.URL,
+		Metrics:  metrics,
+	}
+
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String("ok"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+			}, {
+				Body:          aws.String("ok"),
+				MessageId:     aws.String("m2"),
+				ReceiptHandle: aws.String("r2"),
+			}, {
+				Body:          aws.String("fail"),
+				MessageId:     aws.String("m3"),
+				ReceiptHandle: aws.String("r3"),
+			}},
+		}, nil
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		defer supervisor.Shutdown()
+
+		output := &sqs.DeleteMessageBatchOutput{}
+		for _, entry := range input.Entries {
+			output.Successful = append(output.Successful, types.DeleteMessageBatchResultEntry{Id: entry.Id})
+		}
+
+		return output, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.deliveries.WithLabelValues("orders", "2xx")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.deliveries.WithLabelValues("orders", "5xx")))
+
+	assert.Equal(t, float64(3), testutil.ToFloat64(metrics.received.WithLabelValues("orders")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.delivered.WithLabelValues("orders")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.failed.WithLabelValues("orders")))
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.deleted.WithLabelValues("orders")))
+	assert.Zero(t, testutil.ToFloat64(metrics.inFlight.WithLabelValues("orders")))
+	assert.Zero(t, testutil.ToFloat64(metrics.inFlightRequests.WithLabelValues("orders")))
+	assert.Zero(t, testutil.ToFloat64(metrics.workers))
+
+	m := &dto.Metric{}
+	assert.NoError(t, metrics.requestDuration.WithLabelValues("orders").(prometheus.Histogram).Write(m))
+	assert.Equal(t, uint64(3), m.GetHistogram().GetSampleCount())
+
+	m = &dto.Metric{}
+	assert.NoError(t, metrics.receiveDuration.WithLabelValues("orders").(prometheus.Histogram).Write(m))
+	assert.NotZero(t, m.GetHistogram().GetSampleCount())
+}
+
+func TestMetricsGauges(t *testing.T) {
+	metrics := NewMetrics(prometheus.NewRegistry())
+	queueURL := "https://sqs.us-east-1.amazonaws.com/123456789012/orders"
+
+	metrics.addInFlight(queueURL, 10)
+	metrics.addInFlight(queueURL, -3)
+	metrics.addInFlightRequests(queueURL, 2)
+	metrics.addInFlightRequests(queueURL, -1)
+	metrics.addWorkers(4)
+	metrics.addWorkers(-1)
+
+	assert.Equal(t, float64(7), testutil.ToFloat64(metrics.inFlight.WithLabelValues("orders")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.inFlightRequests.WithLabelValues("orders")))
+	assert.Equal(t, float64(3), testutil.ToFloat64(metrics.workers))
+
+	var nilMetrics *Metrics
+	nilMetrics.addInFlight(queueURL, 1)
+	nilMetrics.addInFlightRequests(queueURL, 1)
+	nilMetrics.addWorkers(1)
+}
+
+func TestMetricsMessageAge(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics := NewMetrics(reg)
+	queueURL := "https://sqs.us-east-1.amazonaws.com/123456789012/orders"
+	now := time.Unix(1600000000, 0)
+
+	sentAt := func(d time.Duration) *types.Message {
+		ms := now.Add(-d).UnixNano() / int64(time.Millisecond)
+		return &types.Message{Attributes: map[string]string{
+			string(types.MessageSystemAttributeNameSentTimestamp): strconv.FormatInt(ms, 10),
+		}}
+	}
+
+	metrics.observeMessageAge(queueURL, sentAt(1500*time.Millisecond), now)
+	metrics.observeMessageAge(queueURL, sentAt(90*time.Second), now)
+	metrics.observeMessageAge(queueURL, &types.Message{}, now)
+
+	m := &dto.Metric{}
+	assert.NoError(t, metrics.messageAge.WithLabelValues("orders").(prometheus.Histogram).Write(m))
+	assert.Equal(t, uint64(2), m.GetHistogram().GetSampleCount())
+	assert.InDelta(t, 91.5, m.GetHistogram().GetSampleSum(), 0.001)
+
+	for _, b := range m.GetHistogram().GetBucket() {
+		switch b.GetUpperBound() {
+		case 1:
+			assert.Equal(t, uint64(0), b.GetCumulativeCount())
+		case 2:
+			assert.Equal(t, uint64(1), b.GetCumulativeCount())
+		case 128:
+			assert.Equal(t, uint64(2), b.GetCumulativeCount())
+		}
+	}
+}
+
+func TestSupervisorRequestsSentTimestampWithMetrics(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		Metrics: NewMetrics(prometheus.NewRegistry()),
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	mockSQS.receiveMessageFunc = func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		defer supervisor.Shutdown()
+
+		assert.Contains(t, input.MessageSystemAttributeNames, types.MessageSystemAttributeNameSentTimestamp)
+
+		return &sqs.ReceiveMessageOutput{}, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+}
+
+func TestMetricsUndeleted(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	mockSQS := &mockSQS{}
+	metrics := NewMetrics(prometheus.NewRegistry())
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+		QueueURL:         "https://sqs.us-east-1.amazonaws.com/123456789012/orders",
+		DeleteMaxRetries: 1,
+		Metrics:          metrics,
+	})
+
+	attempts := 0
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		attempts++
+
+		output := &sqs.DeleteMessageBatchOutput{}
+		for _, entry := range input.Entries {
+			if *entry.Id == "m1" {
+				output.Successful = append(output.Successful, types.DeleteMessageBatchResultEntry{Id: entry.Id})
+				continue
+			}
+
+			output.Failed = append(output.Failed, types.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("ReceiptHandleIsInvalid")})
+		}
+
+		return output, nil
+	}
+
+	undeleted := supervisor.deleteMessages(supervisor.queues[0], []types.DeleteMessageBatchRequestEntry{
+		{Id: aws.String("m1"), ReceiptHandle: aws.String("r1")},
+		{Id: aws.String("m2"), ReceiptHandle: aws.String("r2")},
+	})
+
+	assert.Equal(t, 2, attempts)
+	if assert.Len(t, undeleted, 1) {
+		assert.Equal(t, "m2", *undeleted[0].Id)
+	}
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.undeleted.WithLabelValues("orders")))
+}
@@ -0,0 +1,31 @@
+package supervisor
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// Middleware transforms a message before it is delivered. It returns the
+// message to deliver, nil to delete msg without delivering it, or an error to
+// leave msg in the queue for redelivery. A Middleware must not modify msg; it
+// returns a modified copy instead. String attributes it adds are sent as
+// headers like any other.
+type Middleware func(ctx context.Context, msg *types.Message) (*types.Message, error)
+
+// Chain returns a Middleware running middlewares in order, each on the
+// message returned by the previous one. It stops at the first that drops the
+// message or fails.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(ctx context.Context, msg *types.Message) (*types.Message, error) {
+		for _, m := range middlewares {
+			var err error
+			msg, err = m(ctx, msg)
+			if err != nil || msg == nil {
+				return nil, err
+			}
+		}
+
+		return msg, nil
+	}
+}
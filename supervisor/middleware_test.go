@@ -0,0 +1,117 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func appendBody(suffix string) Middleware {
+	return func(ctx context.Context, msg *types.Message) (*types.Message, error) {
+		transformed := *msg
+		transformed.Body = aws.String(*msg.Body + suffix)
+		return &transformed, nil
+	}
+}
+
+func TestChain(t *testing.T) {
+	msg := &types.Message{Body: aws.String("body")}
+
+	out, err := Chain(appendBody(" a"), appendBody(" b"))(context.Background(), msg)
+	assert.NoError(t, err)
+	assert.Equal(t, "body a b", *out.Body)
+	assert.Equal(t, "body", *msg.Body)
+
+	called := false
+	never := func(ctx context.Context, msg *types.Message) (*types.Message, error) {
+		called = true
+		return msg, nil
+	}
+	drop := func(ctx context.Context, msg *types.Message) (*types.Message, error) {
+		return nil, nil
+	}
+	fail := func(ctx context.Context, msg *types.Message) (*types.Message, error) {
+		return msg, errors.New("failed")
+	}
+
+	out, err = Chain(drop, never)(context.Background(), msg)
+	assert.NoError(t, err)
+	assert.Nil(t, out)
+
+	out, err = Chain(fail, never)(context.Background(), msg)
+	assert.Error(t, err)
+	assert.Nil(t, out)
+	assert.False(t, called)
+
+	out, err = Chain()(context.Background(), msg)
+	assert.NoError(t, err)
+	assert.Same(t, msg, out)
+}
+
+func TestSupervisorMiddleware(t *testing.T) {
+	var (
+		body   string
+		header http.Header
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body, header = string(b), r.Header
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	tag := func(ctx context.Context, msg *types.Message) (*types.Message, error) {
+		tagged := *msg
+		tagged.MessageAttributes = map[string]types.MessageAttributeValue{
+			"length": {DataType: aws.String("Number"), StringValue: aws.String("4")},
+		}
+		return &tagged, nil
+	}
+	reject := func(ctx context.Context, msg *types.Message) (*types.Message, error) {
+		if strings.Contains(*msg.Body, "secret") {
+			return nil, nil
+		}
+		return msg, nil
+	}
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := New(&mockSQS{}, &http.Client{},
+		WithWorkerConfig(WorkerConfig{
+			HTTPURL:    ts.URL,
+			Middleware: []Middleware{reject},
+		}),
+		WithMiddleware(tag, appendBody("!")),
+	)
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	result := supervisor.processMessage(ctx, supervisor.queues[0], &types.Message{
+		Body:          aws.String("body"),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+	})
+	assert.Equal(t, "delivered", result.status)
+	assert.Equal(t, "body!", body)
+	assert.Equal(t, "4", header.Get("X-Aws-Sqsd-Attr-length"))
+
+	body = ""
+	result = supervisor.processMessage(ctx, supervisor.queues[0], &types.Message{
+		Body:          aws.String("secret"),
+		MessageId:     aws.String("m2"),
+		ReceiptHandle: aws.String("r2"),
+	})
+	assert.Equal(t, "filtered", result.status)
+	assert.Equal(t, dispositionDelete, result.disposition)
+	assert.Empty(t, body)
+}
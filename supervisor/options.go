@@ -0,0 +1,108 @@
+package supervisor
+
+import (
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Option configures a Supervisor created with New.
+type Option func(*options)
+
+type options struct {
+	logger        *log.Entry
+	httpClient    HTTPClient
+	config        WorkerConfig
+	deliverer     Deliverer
+	metrics       *Metrics
+	messageFilter MessageFilter
+	middleware    []Middleware
+}
+
+// WithLogger sets the logger of the supervisor. The standard logrus logger is
+// used otherwise.
+func WithLogger(logger *log.Entry) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithHTTPClient sets the client making the requests to the worker, taking
+// precedence over the client passed to New.
+func WithHTTPClient(client HTTPClient) Option {
+	return func(o *options) {
+		o.httpClient = client
+	}
+}
+
+// WithWorkerConfig sets the configuration of the supervisor.
+func WithWorkerConfig(config WorkerConfig) Option {
+	return func(o *options) {
+		o.config = config
+	}
+}
+
+// WithDeliverer sets the Deliverer delivering messages instead of HTTP
+// requests, taking precedence over WorkerConfig.Deliverer.
+func WithDeliverer(deliverer Deliverer) Option {
+	return func(o *options) {
+		o.deliverer = deliverer
+	}
+}
+
+// WithMetrics sets the Metrics updated by the supervisor, taking precedence
+// over WorkerConfig.Metrics.
+func WithMetrics(metrics *Metrics) Option {
+	return func(o *options) {
+		o.metrics = metrics
+	}
+}
+
+// WithMessageFilter sets the MessageFilter run before every delivery, taking
+// precedence over WorkerConfig.MessageFilter.
+func WithMessageFilter(filter MessageFilter) Option {
+	return func(o *options) {
+		o.messageFilter = filter
+	}
+}
+
+// WithMiddleware adds middlewares to run before every delivery, after those
+// of WorkerConfig.Middleware.
+func WithMiddleware(middlewares ...Middleware) Option {
+	return func(o *options) {
+		o.middleware = append(o.middleware, middlewares...)
+	}
+}
+
+// New returns a Supervisor receiving messages with sqs and delivering them
+// with httpClient, http.DefaultClient when nil, configured by opts. It is the
+// same as NewSupervisor, for programs embedding the package.
+func New(sqs SQSClient, httpClient HTTPClient, opts ...Option) *Supervisor {
+	o := options{
+		logger:     log.NewEntry(log.StandardLogger()),
+		httpClient: httpClient,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.httpClient == nil {
+		o.httpClient = http.DefaultClient
+	}
+
+	config := o.config
+	if o.deliverer != nil {
+		config.Deliverer = o.deliverer
+	}
+	if o.metrics != nil {
+		config.Metrics = o.metrics
+	}
+	if o.messageFilter != nil {
+		config.MessageFilter = o.messageFilter
+	}
+	if len(o.middleware) > 0 {
+		config.Middleware = append(append([]Middleware(nil), config.Middleware...), o.middleware...)
+	}
+
+	return NewSupervisor(o.logger, sqs, o.httpClient, config)
+}
@@ -0,0 +1,79 @@
+package supervisor
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	supervisor := New(&mockSQS{}, nil)
+	assert.NotNil(t, supervisor.logger)
+	assert.Same(t, http.DefaultClient, supervisor.httpClient)
+	assert.Len(t, supervisor.queues, 1)
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithField("app", "embedded")
+	metrics := NewMetrics(prometheus.NewRegistry())
+	filter := MessageFilterFunc(func(*types.Message) (bool, string, error) {
+		return false, "", nil
+	})
+	client := &http.Client{Timeout: time.Second}
+	deliverer := NewSQSForwarder(&mockSQS{}, "https://queue.url/forward")
+
+	supervisor = New(&mockSQS{}, &http.Client{},
+		WithMetrics(metrics),
+		WithWorkerConfig(WorkerConfig{
+			QueueURL: "https://queue.url/orders",
+			HTTPURL:  "http://worker",
+		}),
+		WithLogger(logger),
+		WithMessageFilter(filter),
+		WithHTTPClient(client),
+		WithDeliverer(deliverer),
+	)
+
+	assert.Same(t, logger, supervisor.logger)
+	assert.Same(t, metrics, supervisor.workerConfig.Metrics)
+	assert.Same(t, client, supervisor.httpClient)
+	assert.Equal(t, deliverer, supervisor.workerConfig.Deliverer)
+	assert.NotNil(t, supervisor.workerConfig.MessageFilter)
+	assert.Equal(t, "http://worker", supervisor.workerConfig.HTTPURL)
+	assert.Equal(t, "https://queue.url/orders", supervisor.queues[0].url)
+}
+
+func TestSupervisorStartContext(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	mockSQS := &mockSQS{}
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		time.Sleep(time.Millisecond)
+		return &sqs.ReceiveMessageOutput{}, nil
+	}
+	supervisor := New(mockSQS, nil, WithLogger(log.WithFields(log.Fields{})))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	supervisor.StartContext(ctx, 2)
+	assert.Eventually(t, func() bool { return supervisor.Stats().Workers == 2 }, time.Second, time.Millisecond)
+
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		supervisor.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("supervisor did not stop when its context was canceled")
+	}
+}
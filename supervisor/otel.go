@@ -0,0 +1,418 @@
+package supervisor
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// traceparentHeader and tracestateHeader carry the W3C trace context,
+	// both as HTTP headers and as message attributes set by the producer.
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+
+	// DefaultOTLPEndpoint is where spans are exported when no endpoint is
+	// configured, the traces path of a local OpenTelemetry collector.
+	DefaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+
+	defaultTracerServiceName = "simple-sqsd"
+
+	tracerBatchSize     = 512
+	tracerQueueSize     = 2048
+	tracerFlushInterval = 5 * time.Second
+	tracerExportTimeout = 10 * time.Second
+
+	// spanKindConsumer and the span status codes are the values of the OTLP
+	// protocol.
+	spanKindConsumer  = 5
+	spanStatusOK      = 1
+	spanStatusError   = 2
+	traceFlagsSampled = 0x01
+)
+
+// Tracer exports an OpenTelemetry span for every message delivered to the
+// worker to an OTLP/HTTP collector, in batches. A nil *Tracer is valid and
+// traces nothing.
+type Tracer struct {
+	logger      *log.Entry
+	endpoint    string
+	headers     map[string]string
+	serviceName string
+	client      *http.Client
+
+	spans     chan *span
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewTracer returns a Tracer exporting spans to the OTLP/HTTP traces endpoint,
+// DefaultOTLPEndpoint when empty, with the extra request headers. Spans are
+// sent until Close is called.
+func NewTracer(logger *log.Entry, endpoint string, serviceName string, headers map[string]string) *Tracer {
+	if len(endpoint) == 0 {
+		endpoint = DefaultOTLPEndpoint
+	}
+	if len(serviceName) == 0 {
+		serviceName = defaultTracerServiceName
+	}
+
+	t := &Tracer{
+		logger:      logger,
+		endpoint:    endpoint,
+		headers:     headers,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: tracerExportTimeout},
+		spans:       make(chan *span, tracerQueueSize),
+		done:        make(chan struct{}),
+	}
+	go t.run()
+
+	return t
+}
+
+// Close exports the spans still waiting to be sent and stops the tracer.
+func (t *Tracer) Close() {
+	if t == nil {
+		return
+	}
+
+	t.closeOnce.Do(func() {
+		close(t.spans)
+	})
+	<-t.done
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(tracerFlushInterval)
+	defer ticker.Stop()
+
+	var batch []*span
+	for {
+		select {
+		case sp, ok := <-t.spans:
+			if !ok {
+				t.export(batch)
+				return
+			}
+
+			batch = append(batch, sp)
+			if len(batch) >= tracerBatchSize {
+				t.export(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			t.export(batch)
+			batch = nil
+		}
+	}
+}
+
+// export sends spans to the collector. Spans that can't be sent are dropped.
+func (t *Tracer) export(spans []*span) {
+	if len(spans) == 0 {
+		return
+	}
+
+	doc, err := json.Marshal(t.request(spans))
+	if err != nil {
+		t.logger.Errorf("Error while encoding spans: %s", err)
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.endpoint, bytes.NewReader(doc))
+	if err != nil {
+		t.logger.Errorf("Error while creating the span export request: %s", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		t.logger.Errorf("Error while exporting %d spans: %s", len(spans), err)
+		return
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		t.logger.Errorf("Error while exporting %d spans: status code %d", len(spans), res.StatusCode)
+	}
+}
+
+// startSpan starts the span of the delivery of msg, continuing the trace from
+// the message's traceparent and tracestate attributes when the producer set
+// them.
+func (t *Tracer) startSpan(queueURL string, msg *types.Message) *span {
+	if t == nil {
+		return nil
+	}
+
+	sp := &span{
+		tracer:    t,
+		name:      queueName(queueURL) + " process",
+		start:     time.Now(),
+		queueURL:  queueURL,
+		messageID: aws.ToString(msg.MessageId),
+		flags:     traceFlagsSampled,
+	}
+	copy(sp.spanID[:], randomBytes(8))
+
+	if parent, ok := parseTraceparent(stringAttribute(msg, traceparentHeader)); ok {
+		sp.traceID = parent.traceID
+		sp.parentID = parent.spanID
+		sp.flags = parent.flags
+		sp.traceState = stringAttribute(msg, tracestateHeader)
+	} else {
+		copy(sp.traceID[:], randomBytes(16))
+	}
+
+	return sp
+}
+
+// span is the delivery of a message to the worker, retries included.
+type span struct {
+	tracer *Tracer
+
+	traceID    [16]byte
+	spanID     [8]byte
+	parentID   [8]byte
+	flags      byte
+	traceState string
+
+	name       string
+	start      time.Time
+	end        time.Time
+	queueURL   string
+	messageID  string
+	statusCode int
+	err        error
+}
+
+// traceparent returns the traceparent header value that makes the worker's
+// span a child of sp.
+func (sp *span) traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", hex.EncodeToString(sp.traceID[:]), hex.EncodeToString(sp.spanID[:]), sp.flags)
+}
+
+// finish ends sp with the outcome of the delivery and queues it for export
+// if it is sampled.
+func (sp *span) finish(res *http.Response, err error) {
+	if sp == nil {
+		return
+	}
+
+	sp.end = time.Now()
+	sp.err = err
+	if res != nil {
+		sp.statusCode = res.StatusCode
+	}
+
+	if sp.flags&traceFlagsSampled == 0 {
+		return
+	}
+
+	select {
+	case sp.tracer.spans <- sp:
+	default:
+		sp.tracer.logger.Warn("Span export queue is full, dropping a span")
+	}
+}
+
+type spanContextKey struct{}
+
+func withSpan(ctx context.Context, sp *span) context.Context {
+	if sp == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, spanContextKey{}, sp)
+}
+
+func spanFromContext(ctx context.Context) *span {
+	sp, _ := ctx.Value(spanContextKey{}).(*span)
+	return sp
+}
+
+// traceContext is a parsed traceparent header.
+type traceContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	flags   byte
+}
+
+// parseTraceparent parses a version 00 W3C traceparent value such as
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01. Later versions are
+// read as version 00, as the specification requires.
+func parseTraceparent(value string) (traceContext, bool) {
+	var tc traceContext
+
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return tc, false
+	}
+
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != len(tc.traceID) || allZero(traceID) {
+		return tc, false
+	}
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != len(tc.spanID) || allZero(spanID) {
+		return tc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return tc, false
+	}
+
+	copy(tc.traceID[:], traceID)
+	copy(tc.spanID[:], spanID)
+	tc.flags = flags[0]
+
+	return tc, true
+}
+
+func allZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// stringAttribute returns the value of the message attribute name of msg, or
+// an empty string.
+func stringAttribute(msg *types.Message, name string) string {
+	if attr, ok := msg.MessageAttributes[name]; ok {
+		return aws.ToString(attr.StringValue)
+	}
+	return ""
+}
+
+// The OTLP/HTTP JSON encoding of spans, limited to the fields set here.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	TraceState        string          `json:"traceState,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func stringKeyValue(key string, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: &value}}
+}
+
+func intKeyValue(key string, value int) otlpAttribute {
+	s := strconv.Itoa(value)
+	return otlpAttribute{Key: key, Value: otlpValue{IntValue: &s}}
+}
+
+func (t *Tracer) request(spans []*span) otlpRequest {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, sp := range spans {
+		encoded = append(encoded, sp.encode())
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			stringKeyValue("service.name", t.serviceName),
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: defaultTracerServiceName},
+			Spans: encoded,
+		}},
+	}}}
+}
+
+func (sp *span) encode() otlpSpan {
+	encoded := otlpSpan{
+		TraceID:           hex.EncodeToString(sp.traceID[:]),
+		SpanID:            hex.EncodeToString(sp.spanID[:]),
+		TraceState:        sp.traceState,
+		Name:              sp.name,
+		Kind:              spanKindConsumer,
+		StartTimeUnixNano: strconv.FormatInt(sp.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(sp.end.UnixNano(), 10),
+		Attributes: []otlpAttribute{
+			stringKeyValue("messaging.system", "aws_sqs"),
+			stringKeyValue("messaging.operation", "process"),
+			stringKeyValue("messaging.destination.name", queueName(sp.queueURL)),
+			stringKeyValue("messaging.message.id", sp.messageID),
+		},
+		Status: otlpStatus{Code: spanStatusOK},
+	}
+	if !allZero(sp.parentID[:]) {
+		encoded.ParentSpanID = hex.EncodeToString(sp.parentID[:])
+	}
+	if sp.statusCode > 0 {
+		encoded.Attributes = append(encoded.Attributes, intKeyValue("http.response.status_code", sp.statusCode))
+	}
+
+	switch {
+	case sp.err != nil:
+		encoded.Status = otlpStatus{Code: spanStatusError, Message: sp.err.Error()}
+	case sp.statusCode >= 400:
+		encoded.Status = otlpStatus{Code: spanStatusError, Message: fmt.Sprintf("status code %d", sp.statusCode)}
+	}
+
+	return encoded
+}
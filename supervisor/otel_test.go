@@ -0,0 +1,152 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorTracer(t *testing.T) {
+	var mu sync.Mutex
+	var exported []otlpRequest
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "abc", r.Header.Get("Api-Key"))
+
+		mu.Lock()
+		exported = append(exported, req)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	var traceparents, tracestates []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents = append(traceparents, r.Header.Get(traceparentHeader))
+		tracestates = append(tracestates, r.Header.Get(tracestateHeader))
+
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	tracer := NewTracer(logger, collector.URL, "orders", map[string]string{"api-key": "abc"})
+	mockSQS := &mockSQS{}
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, WorkerConfig{
+		QueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/jobs",
+		HTTPURL:  ts.URL,
+		Tracer:   tracer,
+	})
+
+	receiveCount := 0
+	mockSQS.receiveMessageFunc = func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		receiveCount++
+
+		if receiveCount > 1 {
+			supervisor.Shutdown()
+			return &sqs.ReceiveMessageOutput{}, nil
+		}
+
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String("message 1"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+			}, {
+				Body:          aws.String("message 2"),
+				MessageId:     aws.String("m2"),
+				ReceiptHandle: aws.String("r2"),
+				MessageAttributes: map[string]types.MessageAttributeValue{
+					traceparentHeader: {DataType: aws.String("String"), StringValue: aws.String("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")},
+					tracestateHeader:  {DataType: aws.String("String"), StringValue: aws.String("vendor=value")},
+				},
+			}, {
+				Body:          aws.String("message 3"),
+				MessageId:     aws.String("m3"),
+				ReceiptHandle: aws.String("r3"),
+				MessageAttributes: map[string]types.MessageAttributeValue{
+					traceparentHeader: {DataType: aws.String("String"), StringValue: aws.String("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")},
+				},
+			}},
+		}, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+	tracer.Close()
+
+	if assert.Len(t, traceparents, 3) {
+		assert.Regexp(t, `^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`, traceparents[0])
+		assert.Regexp(t, `^00-4bf92f3577b34da6a3ce929d0e0e4736-[0-9a-f]{16}-01$`, traceparents[1])
+		assert.NotContains(t, traceparents[1], "00f067aa0ba902b7")
+		assert.Regexp(t, `^00-4bf92f3577b34da6a3ce929d0e0e4736-[0-9a-f]{16}-00$`, traceparents[2])
+		assert.Equal(t, []string{"", "vendor=value", ""}, tracestates)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	var spans []otlpSpan
+	for _, req := range exported {
+		if assert.Len(t, req.ResourceSpans, 1) {
+			rs := req.ResourceSpans[0]
+			assert.Equal(t, "orders", *rs.Resource.Attributes[0].Value.StringValue)
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}
+
+	// The unsampled trace of the third message is not exported.
+	if assert.Len(t, spans, 2) {
+		for _, sp := range spans {
+			assert.Equal(t, "jobs process", sp.Name)
+			assert.Equal(t, spanKindConsumer, sp.Kind)
+			assert.Equal(t, spanStatusError, sp.Status.Code)
+		}
+
+		assert.Empty(t, spans[0].ParentSpanID)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[1].TraceID)
+		assert.Equal(t, "00f067aa0ba902b7", spans[1].ParentSpanID)
+		assert.Equal(t, "vendor=value", spans[1].TraceState)
+		assert.Equal(t, traceparents[1][36:52], spans[1].SpanID)
+		assert.Contains(t, spans[1].Attributes, intKeyValue("http.response.status_code", http.StatusInternalServerError))
+		assert.Contains(t, spans[1].Attributes, stringKeyValue("messaging.message.id", "m2"))
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		ok    bool
+	}{
+		{value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", ok: true},
+		{value: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", ok: true},
+		{value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"},
+		{value: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{value: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+		{value: "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01"},
+		{value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz"},
+		{value: ""},
+	} {
+		tc, ok := parseTraceparent(tt.value)
+		assert.Equal(t, tt.ok, ok, tt.value)
+		if ok {
+			assert.Equal(t, byte(0x01), tc.flags, tt.value)
+		}
+	}
+
+	assert.Nil(t, (*Tracer)(nil).startSpan("https://queue.url", &types.Message{}))
+}
@@ -0,0 +1,62 @@
+package supervisor
+
+import (
+	"time"
+)
+
+const defaultOutcomeBufferSize = 1000
+
+// Outcome describes the result of delivering a single message to the worker.
+type Outcome struct {
+	MessageID  string    `json:"messageId"`
+	QueueURL   string    `json:"queueUrl"`
+	Success    bool      `json:"success"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"durationMs"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// OutcomePublisher emits delivery outcomes to an external system such as a
+// message bus. Publish is called from a single goroutine, never from the
+// workers themselves.
+type OutcomePublisher interface {
+	Publish(outcome Outcome) error
+}
+
+type nopOutcomePublisher struct{}
+
+func (nopOutcomePublisher) Publish(Outcome) error {
+	return nil
+}
+
+// startPublisher drains queued outcomes into the configured publisher until
+// the outcomes channel is closed.
+func (s *Supervisor) startPublisher() {
+	go func() {
+		defer close(s.outcomesDone)
+
+		for outcome := range s.outcomes {
+			if err := s.publisher.Publish(outcome); err != nil {
+				s.logger.Errorf("Error while publishing delivery outcome: %s", err)
+			}
+		}
+	}()
+}
+
+// publishOutcome queues an outcome without blocking; outcomes are dropped when
+// the buffer is full.
+func (s *Supervisor) publishOutcome(outcome Outcome) {
+	select {
+	case s.outcomes <- outcome:
+	default:
+		s.logger.Warnf("Outcome buffer is full, dropping outcome for message %s", outcome.MessageID)
+	}
+}
+
+func (s *Supervisor) closePublisher() {
+	s.closePublisherOnce.Do(func() {
+		close(s.outcomes)
+		<-s.outcomesDone
+	})
+}
@@ -0,0 +1,62 @@
+package supervisor
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// PathSanitizer decides how an attribute value is turned into a path segment.
+type PathSanitizer string
+
+const (
+	// PathSanitizeSlug lowercases the value and replaces anything other than
+	// letters, digits, dashes and underscores with a dash.
+	PathSanitizeSlug PathSanitizer = "slug"
+	// PathSanitizeEscape percent-encodes the value.
+	PathSanitizeEscape PathSanitizer = "escape"
+)
+
+var slugInvalidChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+func (p PathSanitizer) sanitize(value string) string {
+	if p == PathSanitizeEscape {
+		return url.PathEscape(value)
+	}
+
+	return strings.Trim(slugInvalidChars.ReplaceAllString(strings.ToLower(value), "-"), "-")
+}
+
+// requestURL returns the URL msg is delivered to, based on baseURL.
+// Messages enqueued for a task go to the URL of the task, then messages taking
+// one of the Routes to the URL of the route.
+func (s *Supervisor) requestURL(baseURL string, msg *types.Message) string {
+	if task, ok := s.cronTask(msg); ok {
+		if taskURL, err := cronTaskURL(baseURL, task.URL); err == nil {
+			return taskURL
+		}
+	}
+
+	if routeURL, ok := s.routeURL(baseURL, msg); ok {
+		return routeURL
+	}
+
+	if len(s.workerConfig.HTTPPathAttribute) == 0 {
+		return baseURL
+	}
+
+	attr, ok := msg.MessageAttributes[s.workerConfig.HTTPPathAttribute]
+	if !ok {
+		return baseURL
+	}
+
+	segment := s.workerConfig.HTTPPathSanitizer.sanitize(aws.ToString(attr.StringValue))
+	if len(segment) == 0 {
+		return baseURL
+	}
+
+	return strings.TrimSuffix(baseURL, "/") + "/" + segment
+}
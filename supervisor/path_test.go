@@ -0,0 +1,81 @@
+package supervisor
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathSanitizer(t *testing.T) {
+	assert.Equal(t, "order-created", PathSanitizeSlug.sanitize("Order Created"))
+	assert.Equal(t, "order-created", PathSanitizeSlug.sanitize("../order/created"))
+	assert.Equal(t, "order%2Fcreated", PathSanitizeEscape.sanitize("order/created"))
+}
+
+func TestSupervisorPathAttribute(t *testing.T) {
+	hmacSecretKey := []byte("foobar")
+	var path string
+	hmacSuccess := false
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+
+		body, _ := ioutil.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, hmacSecretKey)
+		mac.Write([]byte(fmt.Sprintf("POST http://%s%s\n%s", r.Host, r.URL.Path, string(body))))
+		hmacSuccess = hmac.Equal([]byte(r.Header.Get("hmac")), []byte(hex.EncodeToString(mac.Sum(nil))))
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		HTTPURL:           ts.URL + "/events/",
+		HTTPPathAttribute: "type",
+		HTTPPathSanitizer: PathSanitizeSlug,
+
+		HTTPHMACHeader: "hmac",
+		HMACSecretKey:  hmacSecretKey,
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	receiveCount := 0
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		receiveCount++
+
+		if receiveCount > 1 {
+			supervisor.Shutdown()
+			return &sqs.ReceiveMessageOutput{}, nil
+		}
+
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String("message 1"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+				MessageAttributes: map[string]types.MessageAttributeValue{
+					"type": {DataType: aws.String("String"), StringValue: aws.String("Order Created")},
+				},
+			}},
+		}, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	assert.Equal(t, "/events/order-created", path)
+	assert.True(t, hmacSuccess)
+}
@@ -0,0 +1,113 @@
+package supervisor
+
+import "sync"
+
+// pollerGate bounds how many workers of a supervisor long poll the queue at
+// the same time. Each run of idleReceives consecutive empty receives lets one
+// fewer worker poll, down to min; the next message received lets up to max
+// poll again. A nil *pollerGate never limits.
+type pollerGate struct {
+	min          int
+	max          int
+	idleReceives int
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	allowed int
+	polling int
+	// idle counts the consecutive empty receives since allowed last changed.
+	idle   int
+	closed bool
+}
+
+// newPollerGate returns nil when min is not positive. max is capped to
+// numWorkers, and defaults to it.
+func newPollerGate(min int, max int, idleReceives int, numWorkers int) *pollerGate {
+	if min <= 0 {
+		return nil
+	}
+
+	if max <= 0 || max > numWorkers {
+		max = numWorkers
+	}
+	if min > max {
+		min = max
+	}
+	if idleReceives < 1 {
+		idleReceives = 1
+	}
+
+	g := &pollerGate{
+		min:          min,
+		max:          max,
+		idleReceives: idleReceives,
+		allowed:      max,
+	}
+	g.cond = sync.NewCond(&g.mu)
+
+	return g
+}
+
+// acquire blocks until the worker may poll. It returns false once the gate is
+// closed. Every successful acquire must be followed by a release.
+func (g *pollerGate) acquire() bool {
+	if g == nil {
+		return true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for g.polling >= g.allowed && !g.closed {
+		g.cond.Wait()
+	}
+
+	if g.closed {
+		return false
+	}
+
+	g.polling++
+
+	return true
+}
+
+// release records that a poll received n messages.
+func (g *pollerGate) release(n int) {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.polling--
+
+	if n > 0 {
+		g.idle = 0
+		g.allowed = g.max
+		g.cond.Broadcast()
+		return
+	}
+
+	g.idle++
+	if g.idle >= g.idleReceives && g.allowed > g.min {
+		g.allowed--
+		g.idle = 0
+	}
+
+	// A waiting worker may poll in the slot just released.
+	g.cond.Signal()
+}
+
+// close wakes up waiting workers and makes acquire fail from then on.
+func (g *pollerGate) close() {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.closed = true
+	g.cond.Broadcast()
+}
@@ -0,0 +1,145 @@
+package supervisor
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPollerGate(t *testing.T) {
+	gate := newPollerGate(1, 0, 2, 3)
+	assert.Equal(t, 3, gate.allowed)
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, gate.acquire())
+	}
+
+	// Two empty receives let one fewer worker poll.
+	gate.release(0)
+	gate.release(0)
+	assert.Equal(t, 2, gate.allowed)
+
+	// With one worker still polling, two more empty receives bring the gate
+	// down to the minimum.
+	for i := 0; i < 2; i++ {
+		assert.True(t, gate.acquire())
+		gate.release(0)
+	}
+	assert.Equal(t, 1, gate.allowed)
+
+	acquired := make(chan bool)
+	go func() {
+		acquired <- gate.acquire()
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquire did not wait while the minimum was polling")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// Receiving messages lets every worker poll again right away.
+	gate.release(5)
+	assert.True(t, <-acquired)
+	assert.Equal(t, 3, gate.allowed)
+	assert.True(t, gate.acquire())
+	assert.True(t, gate.acquire())
+
+	go func() {
+		acquired <- gate.acquire()
+	}()
+	gate.close()
+	assert.False(t, <-acquired)
+	assert.False(t, gate.acquire())
+}
+
+func TestPollerGateLimits(t *testing.T) {
+	assert.Nil(t, newPollerGate(0, 5, 3, 10))
+
+	gate := newPollerGate(4, 2, 0, 10)
+	assert.Equal(t, 2, gate.max)
+	assert.Equal(t, 2, gate.min)
+	assert.Equal(t, 1, gate.idleReceives)
+
+	gate = newPollerGate(1, 20, 1, 10)
+	assert.Equal(t, 10, gate.max)
+
+	var nilGate *pollerGate
+	assert.True(t, nilGate.acquire())
+	nilGate.release(0)
+	nilGate.close()
+}
+
+func TestSupervisorScalesDownIdlePollers(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	var (
+		mu       sync.Mutex
+		receives int
+		deleted  = make(chan struct{})
+	)
+
+	log.SetOutput(ioutil.Discard)
+	mockSQS := &mockSQS{}
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+		HTTPURL:            ts.URL,
+		MinPollers:         1,
+		PollerIdleReceives: 1,
+	})
+
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		mu.Lock()
+		receives++
+		n := receives
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		if n == 100 {
+			return &sqs.ReceiveMessageOutput{Messages: []types.Message{{
+				Body:          aws.String("message"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+			}}}, nil
+		}
+
+		return &sqs.ReceiveMessageOutput{}, nil
+	}
+	mockSQS.deleteMessageBatchFunc = func(*sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		close(deleted)
+		return &sqs.DeleteMessageBatchOutput{}, nil
+	}
+
+	supervisor.Start(4)
+	defer supervisor.Wait()
+	defer supervisor.Shutdown()
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return receives >= 20
+	}, time.Second, time.Millisecond)
+
+	supervisor.pollers.mu.Lock()
+	assert.Equal(t, 1, supervisor.pollers.allowed)
+	assert.True(t, supervisor.pollers.polling <= 1)
+	supervisor.pollers.mu.Unlock()
+
+	select {
+	case <-deleted:
+	case <-time.After(time.Second):
+		t.Fatal("message was not processed")
+	}
+}
@@ -0,0 +1,115 @@
+package supervisor
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// QueueSchedule decides which queue a worker receives from next when a
+// supervisor polls more than one queue.
+type QueueSchedule string
+
+const (
+	// QueueScheduleRoundRobin has every worker cycle through all queues, so a
+	// busy queue cannot starve the others.
+	QueueScheduleRoundRobin QueueSchedule = "round-robin"
+	// QueueScheduleDedicated binds each worker to a single queue.
+	QueueScheduleDedicated QueueSchedule = "dedicated"
+	// QueueScheduleFailover has every worker receive from the first queue,
+	// e.g. in the primary region, and from the next ones, in order, only
+	// while it keeps failing.
+	QueueScheduleFailover QueueSchedule = "failover"
+)
+
+// QueueConfig overrides how the messages of one queue are delivered. Empty
+// fields fall back to the WorkerConfig.
+type QueueConfig struct {
+	URL string
+
+	HTTPURL         string
+	HTTPContentType string
+	HMACSecretKey   []byte
+}
+
+// queue is a queue polled by a supervisor, with the delivery settings that
+// apply to its messages.
+type queue struct {
+	url string
+
+	endpoint        atomic.Pointer[endpoint]
+	defaultHTTPURL  bool
+	httpContentType string
+	hmacSecretKey   []byte
+
+	// visibilityTimeout is the default visibility timeout of the queue, zero
+	// when unknown.
+	visibilityTimeout time.Duration
+
+	// failedAttempts holds the ReceiveRequestAttemptIds of the receives from
+	// the queue that failed, oldest first.
+	attemptsMu     sync.Mutex
+	failedAttempts []failedAttempt
+}
+
+func newQueues(config WorkerConfig) []*queue {
+	queueConfigs := config.Queues
+	if len(queueConfigs) == 0 {
+		urls := config.QueueURLs
+		if len(urls) == 0 {
+			urls = []string{config.QueueURL}
+		}
+
+		for _, url := range urls {
+			queueConfigs = append(queueConfigs, QueueConfig{URL: url})
+		}
+	}
+
+	queues := make([]*queue, 0, len(queueConfigs))
+	for _, qc := range queueConfigs {
+		q := &queue{
+			url:             qc.URL,
+			httpContentType: qc.HTTPContentType,
+			hmacSecretKey:   qc.HMACSecretKey,
+
+			visibilityTimeout: config.QueueVisibilityTimeouts[qc.URL],
+		}
+
+		httpURL := qc.HTTPURL
+		if len(httpURL) == 0 {
+			httpURL = config.HTTPURL
+			q.defaultHTTPURL = true
+		}
+		q.endpoint.Store(newEndpoint(httpURL))
+
+		if len(q.httpContentType) == 0 {
+			q.httpContentType = config.HTTPContentType
+		}
+		if len(q.hmacSecretKey) == 0 {
+			q.hmacSecretKey = config.HMACSecretKey
+		}
+
+		queues = append(queues, q)
+	}
+
+	return queues
+}
+
+// nextQueue returns the queue the given worker should receive from next.
+func (s *Supervisor) nextQueue(worker int) *queue {
+	if s.failover != nil {
+		return s.queues[s.failover.next(time.Now())]
+	}
+
+	if len(s.queues) == 1 {
+		return s.queues[0]
+	}
+
+	if s.workerConfig.QueueSchedule == QueueScheduleDedicated {
+		return s.queues[worker%len(s.queues)]
+	}
+
+	next := atomic.AddUint64(&s.queueCursor, 1)
+
+	return s.queues[next%uint64(len(s.queues))]
+}
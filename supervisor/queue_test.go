@@ -0,0 +1,178 @@
+package supervisor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorRoundRobinQueues(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		HTTPURL:       ts.URL,
+		QueueURLs:     []string{"https://busy.queue", "https://quiet.queue"},
+		QueueSchedule: QueueScheduleRoundRobin,
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	receives := map[string]int{}
+	mockSQS.receiveMessageFunc = func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		url := *input.QueueUrl
+		receives[url]++
+
+		if url == "https://quiet.queue" {
+			if receives[url] > 1 {
+				return &sqs.ReceiveMessageOutput{}, nil
+			}
+
+			return &sqs.ReceiveMessageOutput{
+				Messages: []types.Message{{
+					Body:          aws.String("quiet"),
+					MessageId:     aws.String("quiet"),
+					ReceiptHandle: aws.String("quiet"),
+				}},
+			}, nil
+		}
+
+		messages := make([]types.Message, 0, 10)
+		for i := 0; i < 10; i++ {
+			id := fmt.Sprintf("busy-%d-%d", receives[url], i)
+			messages = append(messages, types.Message{
+				Body:          aws.String(id),
+				MessageId:     aws.String(id),
+				ReceiptHandle: aws.String(id),
+			})
+		}
+
+		return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+	}
+
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		if *input.QueueUrl == "https://quiet.queue" {
+			assert.Equal(t, "quiet", *input.Entries[0].Id)
+			supervisor.Shutdown()
+		}
+
+		return nil, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	assert.Equal(t, 1, receives["https://quiet.queue"])
+	assert.True(t, receives["https://busy.queue"] <= 1, "busy queue received %d times before the quiet queue", receives["https://busy.queue"])
+}
+
+func TestSupervisorDedicatedQueues(t *testing.T) {
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		QueueURLs:     []string{"https://a.queue", "https://b.queue"},
+		QueueSchedule: QueueScheduleDedicated,
+	})
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "https://a.queue", supervisor.nextQueue(0).url)
+		assert.Equal(t, "https://b.queue", supervisor.nextQueue(1).url)
+	}
+}
+
+func TestSupervisorPerQueueDelivery(t *testing.T) {
+	type request struct {
+		path        string
+		contentType string
+		signature   string
+		body        string
+	}
+
+	var (
+		mu       sync.Mutex
+		requests []request
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		mu.Lock()
+		requests = append(requests, request{r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("hmac"), string(body)})
+		mu.Unlock()
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		HTTPURL:         ts.URL + "/default",
+		HTTPContentType: "text/plain",
+		HTTPHMACHeader:  "hmac",
+		HMACSecretKey:   []byte("default-secret"),
+		Queues: []QueueConfig{{
+			URL:             "https://orders.queue",
+			HTTPURL:         ts.URL + "/orders",
+			HTTPContentType: "application/json",
+			HMACSecretKey:   []byte("orders-secret"),
+		}, {
+			URL: "https://emails.queue",
+		}},
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	received := map[string]bool{}
+	mockSQS.receiveMessageFunc = func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		url := *input.QueueUrl
+		if received[url] {
+			return &sqs.ReceiveMessageOutput{}, nil
+		}
+		received[url] = true
+
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String(url),
+				MessageId:     aws.String(url),
+				ReceiptHandle: aws.String(url),
+			}},
+		}, nil
+	}
+
+	deletes := 0
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		deletes++
+		if deletes == 2 {
+			supervisor.Shutdown()
+		}
+
+		return nil, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	sign := func(secret string, url string, body string) string {
+		signature, err := makeHMAC(HMACSHA256, fmt.Sprintf("POST %s\n%s", url, body), []byte(secret))
+		assert.NoError(t, err)
+		return signature
+	}
+
+	assert.ElementsMatch(t, []request{
+		{"/orders", "application/json", sign("orders-secret", ts.URL+"/orders", "https://orders.queue"), "https://orders.queue"},
+		{"/default", "text/plain", sign("default-secret", ts.URL+"/default", "https://emails.queue"), "https://emails.queue"},
+	}, requests)
+}
@@ -0,0 +1,141 @@
+package supervisor
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rampLimiter bounds the number of concurrent deliveries while concurrency
+// ramps up, starting at step deliveries and growing by step at even intervals
+// until max is reached at the end of duration. Once the ramp is over there is
+// no limit. A nil *rampLimiter never limits.
+type rampLimiter struct {
+	max      int
+	step     int
+	duration time.Duration
+
+	mu       sync.Mutex
+	started  time.Time
+	inFlight int
+	released chan struct{}
+}
+
+func newRampLimiter(max int, step int, duration time.Duration) *rampLimiter {
+	if step < 1 {
+		step = 1
+	}
+
+	return &rampLimiter{
+		max:      max,
+		step:     step,
+		duration: duration,
+		released: make(chan struct{}),
+	}
+}
+
+// restart begins a new ramp at now, unless a ramp is due to begin later.
+func (r *rampLimiter) restart(now time.Time) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.After(r.started) {
+		r.started = now
+	}
+}
+
+// limit returns how many deliveries may be in flight at now, and how long
+// until that limit next grows. A limit of zero means there is no limit.
+func (r *rampLimiter) limit(now time.Time) (int, time.Duration) {
+	elapsed := now.Sub(r.started)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	if elapsed >= r.duration || r.step >= r.max {
+		return 0, 0
+	}
+
+	steps := (r.max + r.step - 1) / r.step
+	interval := r.duration / time.Duration(steps)
+	if interval <= 0 {
+		return 0, 0
+	}
+
+	limit := r.step * (int(elapsed/interval) + 1)
+	if limit >= r.max {
+		return 0, 0
+	}
+
+	return limit, interval - elapsed%interval
+}
+
+// tryAcquire takes a slot if the limit at now allows it. Otherwise it returns
+// a channel closed on the next release and how long until the limit grows.
+func (r *rampLimiter) tryAcquire(now time.Time) (bool, <-chan struct{}, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	limit, next := r.limit(now)
+	if limit == 0 || r.inFlight < limit {
+		r.inFlight++
+		return true, nil, 0
+	}
+
+	return false, r.released, next
+}
+
+// acquire waits for a slot until ctx is done. It reports whether a slot was
+// taken, in which case release must be called.
+func (r *rampLimiter) acquire(ctx context.Context) bool {
+	if r == nil {
+		return true
+	}
+
+	for {
+		ok, released, next := r.tryAcquire(time.Now())
+		if ok {
+			return true
+		}
+
+		timer := time.NewTimer(next)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-released:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (r *rampLimiter) release() {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.inFlight--
+	close(r.released)
+	r.released = make(chan struct{})
+}
+
+// maxDeliveries is how many deliveries numWorkers workers can make at the same
+// time once ramped up.
+func (s *Supervisor) maxDeliveries(numWorkers int) int {
+	max := numWorkers
+	if s.workerConfig.MaxInFlightBatches > 1 {
+		max *= s.workerConfig.MaxInFlightBatches
+	}
+	if s.workerConfig.BatchConcurrency > 1 {
+		max *= s.workerConfig.BatchConcurrency
+	}
+
+	return max
+}
@@ -0,0 +1,136 @@
+package supervisor
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRampLimiterLimit(t *testing.T) {
+	start := time.Now()
+	r := newRampLimiter(4, 1, time.Second)
+	r.restart(start)
+
+	limit, next := r.limit(start)
+	assert.Equal(t, 1, limit)
+	assert.Equal(t, 250*time.Millisecond, next)
+
+	limit, next = r.limit(start.Add(300 * time.Millisecond))
+	assert.Equal(t, 2, limit)
+	assert.Equal(t, 200*time.Millisecond, next)
+
+	limit, _ = r.limit(start.Add(600 * time.Millisecond))
+	assert.Equal(t, 3, limit)
+
+	limit, _ = r.limit(start.Add(800 * time.Millisecond))
+	assert.Zero(t, limit)
+
+	limit, _ = r.limit(start.Add(-time.Second))
+	assert.Equal(t, 1, limit)
+}
+
+func TestRampLimiterStep(t *testing.T) {
+	start := time.Now()
+	r := newRampLimiter(10, 4, time.Second)
+	r.restart(start)
+
+	limit, _ := r.limit(start)
+	assert.Equal(t, 4, limit)
+
+	limit, _ = r.limit(start.Add(400 * time.Millisecond))
+	assert.Equal(t, 8, limit)
+
+	limit, _ = r.limit(start.Add(700 * time.Millisecond))
+	assert.Zero(t, limit)
+}
+
+func TestRampLimiterAcquire(t *testing.T) {
+	r := newRampLimiter(2, 1, time.Hour)
+	r.restart(time.Now())
+
+	assert.True(t, r.acquire(context.Background()))
+
+	ok, released, _ := r.tryAcquire(time.Now())
+	assert.False(t, ok)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.False(t, r.acquire(ctx))
+
+	r.release()
+	select {
+	case <-released:
+	default:
+		t.Fatal("release did not wake waiters")
+	}
+
+	assert.True(t, r.acquire(context.Background()))
+}
+
+func TestRampLimiterNil(t *testing.T) {
+	var r *rampLimiter
+
+	assert.True(t, r.acquire(context.Background()))
+	r.release()
+	r.restart(time.Now())
+}
+
+func TestSupervisorRampRestartsOnRecovery(t *testing.T) {
+	var healthy atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	config := WorkerConfig{
+		HTTPURL:         ts.URL,
+		WorkerHealthURL: ts.URL,
+		RampUpDuration:  time.Second,
+		RampUpStep:      1,
+	}
+
+	supervisor := NewSupervisor(logger, &mockSQS{}, &http.Client{}, config)
+	supervisor.ramp = newRampLimiter(supervisor.maxDeliveries(4), config.RampUpStep, config.RampUpDuration)
+	supervisor.ramp.restart(time.Now().Add(-time.Hour))
+
+	limit, _ := supervisor.ramp.limit(time.Now())
+	assert.Zero(t, limit)
+
+	supervisor.checkWorkerHealth()
+	assert.False(t, supervisor.workerReady())
+
+	healthy.Store(true)
+	recovered := time.Now()
+	supervisor.checkWorkerHealth()
+	assert.True(t, supervisor.workerReady())
+
+	var limits []int
+	for _, elapsed := range []time.Duration{0, 300 * time.Millisecond, 600 * time.Millisecond, time.Second} {
+		limit, _ := supervisor.ramp.limit(recovered.Add(elapsed))
+		limits = append(limits, limit)
+	}
+	assert.Equal(t, []int{1, 2, 3, 0}, limits)
+}
+
+func TestSupervisorMaxDeliveries(t *testing.T) {
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		BatchConcurrency:   3,
+		MaxInFlightBatches: 2,
+	})
+
+	assert.Equal(t, 24, supervisor.maxDeliveries(4))
+}
@@ -0,0 +1,149 @@
+package supervisor
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// maxReceiveWaitTime is the longest long poll SQS allows, in seconds.
+const maxReceiveWaitTime = 20
+
+// systemAttributeNames are the message system attributes SQS returns.
+var systemAttributeNames = map[types.MessageSystemAttributeName]bool{
+	types.MessageSystemAttributeNameAll:                              true,
+	types.MessageSystemAttributeNameSenderId:                         true,
+	types.MessageSystemAttributeNameSentTimestamp:                    true,
+	types.MessageSystemAttributeNameApproximateReceiveCount:          true,
+	types.MessageSystemAttributeNameApproximateFirstReceiveTimestamp: true,
+	types.MessageSystemAttributeNameSequenceNumber:                   true,
+	types.MessageSystemAttributeNameMessageDeduplicationId:           true,
+	types.MessageSystemAttributeNameMessageGroupId:                   true,
+	types.MessageSystemAttributeNameAWSTraceHeader:                   true,
+	types.MessageSystemAttributeNameDeadLetterQueueSourceArn:         true,
+}
+
+// messageAttributeNamePattern matches the message attribute names SQS
+// accepts.
+var messageAttributeNamePattern = regexp.MustCompile(`^[A-Za-z0-9_\-.]{1,256}$`)
+
+// Validate reports the settings of c that SQS would reject on every receive,
+// so that they are caught before the supervisor starts.
+func (c WorkerConfig) Validate() error {
+	if c.QueueWaitTime < 0 || c.QueueWaitTime > maxReceiveWaitTime {
+		return errors.New("QueueWaitTime must be between 0 and 20")
+	}
+
+	if c.QueueMaxMessages < 1 || c.QueueMaxMessages > maxBatchEntries {
+		return errors.New("QueueMaxMessages must be between 1 and 10")
+	}
+
+	if c.VisibilityTimeout < 0 || c.VisibilityTimeout > maxVisibility {
+		return errors.New("VisibilityTimeout must be between 0 and 12 hours")
+	}
+
+	if err := ValidateAttributeNames(c.ReceiveAttributeNames); err != nil {
+		return fmt.Errorf("ReceiveAttributeNames: %s", err)
+	}
+
+	if err := ValidateMessageAttributeNames(c.ReceiveMessageAttributeNames); err != nil {
+		return fmt.Errorf("ReceiveMessageAttributeNames: %s", err)
+	}
+
+	if c.ReceiveRequestAttemptID && !c.FIFO {
+		return errors.New("ReceiveRequestAttemptID must only be used with FIFO")
+	}
+
+	return nil
+}
+
+// ValidateAttributeNames reports the first of names that is not a message
+// system attribute SQS returns, or "All".
+func ValidateAttributeNames(names []string) error {
+	for _, name := range names {
+		if !systemAttributeNames[types.MessageSystemAttributeName(name)] {
+			return fmt.Errorf("unknown attribute %q", name)
+		}
+	}
+
+	return nil
+}
+
+// ValidateMessageAttributeNames reports the first of names SQS would reject
+// in the MessageAttributeNames of a receive, which must be "All", attribute
+// names, or prefixes followed by ".*".
+func ValidateMessageAttributeNames(names []string) error {
+	for _, name := range names {
+		if name == "All" || name == ".*" {
+			continue
+		}
+
+		if !messageAttributeNamePattern.MatchString(strings.TrimSuffix(name, ".*")) {
+			return fmt.Errorf("invalid name %q: must be up to 256 letters, digits, hyphens, underscores and periods", name)
+		}
+	}
+
+	return nil
+}
+
+// receiveMessageAttributeNames are the message attributes requested on every
+// receive, all of them unless ReceiveMessageAttributeNames is set.
+func (s *Supervisor) receiveMessageAttributeNames() []string {
+	if len(s.workerConfig.ReceiveMessageAttributeNames) == 0 {
+		return []string{"All"}
+	}
+
+	return s.workerConfig.ReceiveMessageAttributeNames
+}
+
+// receiveAttemptIDLifetime is how long SQS deduplicates receives by their
+// ReceiveRequestAttemptId.
+const receiveAttemptIDLifetime = 5 * time.Minute
+
+// failedAttempt is the ReceiveRequestAttemptId of a receive that failed.
+type failedAttempt struct {
+	id       string
+	failedAt time.Time
+}
+
+// receiveAttemptID returns the ReceiveRequestAttemptId of the next receive
+// from q, or an empty string unless ReceiveRequestAttemptID is set. The ID of
+// a receive that failed is reused, so that SQS returns the messages that
+// receive may have made invisible instead of hiding them until their
+// visibility timeout expires.
+func (s *Supervisor) receiveAttemptID(q *queue) string {
+	if !s.workerConfig.ReceiveRequestAttemptID {
+		return ""
+	}
+
+	q.attemptsMu.Lock()
+	defer q.attemptsMu.Unlock()
+
+	for len(q.failedAttempts) > 0 {
+		attempt := q.failedAttempts[0]
+		q.failedAttempts = q.failedAttempts[1:]
+
+		if time.Since(attempt.failedAt) < receiveAttemptIDLifetime {
+			return attempt.id
+		}
+	}
+
+	return randomHex(16)
+}
+
+// receiveFailed keeps the ReceiveRequestAttemptId of a failed receive from q
+// to be reused by the next one.
+func (s *Supervisor) receiveFailed(q *queue, attemptID string) {
+	if len(attemptID) == 0 {
+		return
+	}
+
+	q.attemptsMu.Lock()
+	defer q.attemptsMu.Unlock()
+
+	q.failedAttempts = append(q.failedAttempts, failedAttempt{id: attemptID, failedAt: time.Now()})
+}
@@ -0,0 +1,82 @@
+package supervisor
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerConfigValidate(t *testing.T) {
+	valid := WorkerConfig{QueueMaxMessages: 10, QueueWaitTime: 20}
+	assert.NoError(t, valid.Validate())
+
+	for name, c := range map[string]WorkerConfig{
+		"wait time":         {QueueMaxMessages: 10, QueueWaitTime: 21},
+		"no max messages":   {QueueMaxMessages: 0},
+		"max messages":      {QueueMaxMessages: 11},
+		"visibility":        {QueueMaxMessages: 10, VisibilityTimeout: 13 * time.Hour},
+		"attribute":         {QueueMaxMessages: 10, ReceiveAttributeNames: []string{"SenderID"}},
+		"message attribute": {QueueMaxMessages: 10, ReceiveMessageAttributeNames: []string{"tenant id"}},
+		"attempt id":        {QueueMaxMessages: 10, ReceiveRequestAttemptID: true},
+	} {
+		assert.Error(t, c.Validate(), name)
+	}
+
+	valid.ReceiveAttributeNames = []string{"SenderId", "All"}
+	valid.ReceiveMessageAttributeNames = []string{"tenant", "trace.*", "All"}
+	valid.ReceiveRequestAttemptID = true
+	valid.FIFO = true
+	assert.NoError(t, valid.Validate())
+}
+
+func TestSupervisorReceiveSettings(t *testing.T) {
+	var inputs []*sqs.ReceiveMessageInput
+	fail := true
+	mock := &mockSQS{
+		receiveMessageFunc: func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+			inputs = append(inputs, input)
+			if fail {
+				fail = false
+				return nil, errors.New("connection reset")
+			}
+			return &sqs.ReceiveMessageOutput{}, nil
+		},
+	}
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mock, &http.Client{}, WorkerConfig{
+		QueueURL:                     "https://sqs.us-east-1.amazonaws.com/123456789012/queue.fifo",
+		FIFO:                         true,
+		ReceiveAttributeNames:        []string{string(types.MessageSystemAttributeNameSenderId)},
+		ReceiveMessageAttributeNames: []string{"tenant"},
+		ReceiveRequestAttemptID:      true,
+	})
+
+	q := supervisor.queues[0]
+	for i := 0; i < 3; i++ {
+		supervisor.receiveWait(q, 10, 0)
+	}
+
+	if assert.Len(t, inputs, 3) {
+		assert.Equal(t, []string{"tenant"}, inputs[0].MessageAttributeNames)
+		assert.Contains(t, inputs[0].MessageSystemAttributeNames, types.MessageSystemAttributeNameSenderId)
+		assert.Contains(t, inputs[0].MessageSystemAttributeNames, types.MessageSystemAttributeNameMessageGroupId)
+
+		// The failed receive is retried with the same ID, the next one gets
+		// a new ID.
+		assert.NotEmpty(t, aws.ToString(inputs[0].ReceiveRequestAttemptId))
+		assert.Equal(t, aws.ToString(inputs[0].ReceiveRequestAttemptId), aws.ToString(inputs[1].ReceiveRequestAttemptId))
+		assert.NotEqual(t, aws.ToString(inputs[1].ReceiveRequestAttemptId), aws.ToString(inputs[2].ReceiveRequestAttemptId))
+	}
+
+	q.failedAttempts = []failedAttempt{{id: "expired", failedAt: time.Now().Add(-receiveAttemptIDLifetime)}}
+	assert.NotEqual(t, "expired", supervisor.receiveAttemptID(q))
+}
@@ -0,0 +1,141 @@
+package supervisor
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	log "github.com/sirupsen/logrus"
+)
+
+// errorClass is the kind of an error returned by SQS, deciding how long a
+// worker backs off after it.
+type errorClass string
+
+const (
+	errorClassThrottling errorClass = "throttling"
+	errorClassAuth       errorClass = "auth"
+	errorClassNetwork    errorClass = "network"
+	errorClassOther      errorClass = "other"
+)
+
+// authErrorCodes are the error codes of requests rejected for their
+// credentials or permissions.
+var authErrorCodes = map[string]bool{
+	"AccessDenied":                true,
+	"AccessDeniedException":       true,
+	"InvalidClientTokenId":        true,
+	"SignatureDoesNotMatch":       true,
+	"UnrecognizedClientException": true,
+	"ExpiredToken":                true,
+	"ExpiredTokenException":       true,
+	"KMS.AccessDeniedException":   true,
+}
+
+// classifyError returns the class of err, returned by an SQS call.
+func classifyError(err error) errorClass {
+	var aerr smithy.APIError
+	if errors.As(err, &aerr) {
+		code := aerr.ErrorCode()
+		if _, ok := retry.DefaultThrottleErrorCodes[code]; ok || code == "OverLimit" || code == "KMS.ThrottlingException" {
+			return errorClassThrottling
+		}
+		if authErrorCodes[code] {
+			return errorClassAuth
+		}
+	}
+
+	// Requests are only signed once credentials were retrieved.
+	var serr *v4.SigningError
+	if errors.As(err, &serr) {
+		return errorClassAuth
+	}
+
+	var (
+		sendErr    *smithyhttp.RequestSendError
+		timeoutErr *awshttp.ResponseTimeoutError
+		nerr       net.Error
+	)
+	if errors.As(err, &sendErr) || errors.As(err, &timeoutErr) || errors.As(err, &nerr) {
+		return errorClassNetwork
+	}
+
+	return errorClassOther
+}
+
+// receiveErrorBackoffs are the waits after the first of consecutive
+// ReceiveMessage errors by class, doubled for every error after that.
+// Throttling and permissions rarely clear up within a second, so workers
+// back off from them faster.
+var receiveErrorBackoffs = map[errorClass]time.Duration{
+	errorClassThrottling: time.Second,
+	errorClassAuth:       5 * time.Second,
+	errorClassNetwork:    500 * time.Millisecond,
+	errorClassOther:      receiveErrorBackoff,
+}
+
+// receiveFailures tracks the consecutive ReceiveMessage errors of a worker.
+type receiveFailures struct {
+	count int
+	class errorClass
+	since time.Time
+	// alerted is set once the errors lasted ReceiveErrorAlertAfter.
+	alerted bool
+}
+
+// receiveErrored records that worker id failed to receive from q with err and
+// returns how long it waits before receiving again. Only the first error of a
+// run, or of a new class, is logged as an error so that an outage doesn't
+// flood the logs.
+func (s *Supervisor) receiveErrored(id int, q *queue, f *receiveFailures, err error) time.Duration {
+	class := classifyError(err)
+	s.workerConfig.Metrics.incReceiveErrors(q.url, class)
+
+	logger := s.logger.WithFields(log.Fields{"worker": id, "queue": q.url, "errorClass": class})
+
+	if f.count == 0 {
+		f.since = time.Now()
+	}
+	if f.count == 0 || class != f.class {
+		logger.Errorf("Error while receiving messages from the queue: %s", err)
+	} else {
+		logger.Debugf("Error while receiving messages from the queue: %s", err)
+	}
+	f.count++
+	f.class = class
+
+	if after := s.workerConfig.ReceiveErrorAlertAfter; after > 0 && !f.alerted && time.Since(f.since) >= after {
+		f.alerted = true
+		s.workerConfig.Metrics.addFailingWorkers(1)
+		logger.Errorf("Receiving messages has been failing for %s (%d errors), last error: %s",
+			time.Since(f.since).Round(time.Second), f.count, err)
+	}
+
+	return s.receiveErrorDelay(class, f.count)
+}
+
+// receiveSucceeded ends the run of errors of worker id, if any.
+func (s *Supervisor) receiveSucceeded(id int, f *receiveFailures) {
+	if f.count == 0 {
+		return
+	}
+
+	s.logger.WithField("worker", id).Infof("Receiving messages recovered after %d errors in %s",
+		f.count, time.Since(f.since).Round(time.Millisecond))
+
+	f.stop(s.workerConfig.Metrics)
+}
+
+// stop forgets the errors tracked by f, e.g. when its worker stops.
+func (f *receiveFailures) stop(m *Metrics) {
+	if f.alerted {
+		m.addFailingWorkers(-1)
+	}
+
+	*f = receiveFailures{}
+}
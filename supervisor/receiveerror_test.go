@@ -0,0 +1,90 @@
+package supervisor
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyError(t *testing.T) {
+	dial := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	for _, tt := range []struct {
+		err   error
+		class errorClass
+	}{
+		{&smithy.GenericAPIError{Code: "ThrottlingException", Message: "rate exceeded"}, errorClassThrottling},
+		{&types.OverLimit{Message: aws.String("too many messages in flight")}, errorClassThrottling},
+		{&smithy.GenericAPIError{Code: "AccessDenied", Message: "not authorized"}, errorClassAuth},
+		{&v4.SigningError{Err: errors.New("failed to retrieve credentials: no EC2 IMDS role found")}, errorClassAuth},
+		{&smithyhttp.RequestSendError{Err: dial}, errorClassNetwork},
+		{dial, errorClassNetwork},
+		{&types.QueueDoesNotExist{Message: aws.String("queue does not exist")}, errorClassOther},
+		{errors.New("unexpected"), errorClassOther},
+	} {
+		assert.Equal(t, tt.class, classifyError(tt.err), tt.err.Error())
+	}
+}
+
+func TestSupervisorReceiveErrorDelayByClass(t *testing.T) {
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		ReceiveErrorMaxBackoff: 8 * time.Second,
+	})
+
+	for class, max := range map[errorClass]time.Duration{
+		errorClassThrottling: 2 * time.Second,
+		errorClassAuth:       8 * time.Second,
+		errorClassNetwork:    time.Second,
+		errorClassOther:      200 * time.Millisecond,
+	} {
+		delay := supervisor.receiveErrorDelay(class, 2)
+		assert.True(t, delay >= max/2 && delay <= max, "%s: %s", class, delay)
+	}
+}
+
+func TestSupervisorReceiveErrorAlert(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	metrics := NewMetrics(prometheus.NewRegistry())
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		QueueURL:               "https://sqs.us-east-1.amazonaws.com/123456789012/orders",
+		ReceiveErrorAlertAfter: time.Minute,
+		Metrics:                metrics,
+	})
+	q := supervisor.queues[0]
+	throttled := &smithy.GenericAPIError{Code: "ThrottlingException", Message: "rate exceeded"}
+
+	var failures receiveFailures
+	supervisor.receiveErrored(1, q, &failures, throttled)
+	supervisor.receiveErrored(1, q, &failures, errors.New("unexpected"))
+	assert.Equal(t, 2, failures.count)
+	assert.Equal(t, errorClassOther, failures.class)
+	assert.False(t, failures.alerted)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.receiveErrors.WithLabelValues("orders", "throttling")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.receiveErrors.WithLabelValues("orders", "other")))
+
+	failures.since = time.Now().Add(-time.Minute)
+	supervisor.receiveErrored(1, q, &failures, throttled)
+	assert.True(t, failures.alerted)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.failingWorkers))
+
+	// The alert is not repeated while the errors go on.
+	supervisor.receiveErrored(1, q, &failures, throttled)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.failingWorkers))
+
+	supervisor.receiveSucceeded(1, &failures)
+	assert.Equal(t, receiveFailures{}, failures)
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.failingWorkers))
+}
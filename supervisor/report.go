@@ -0,0 +1,322 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// Report summarises what a supervisor did since it was started. Field names
+// are part of the report format and must not change.
+type Report struct {
+	StartedAt     time.Time `json:"startedAt"`
+	StoppedAt     time.Time `json:"stoppedAt"`
+	UptimeSeconds float64   `json:"uptimeSeconds"`
+
+	Received  int64 `json:"received"`
+	Processed int64 `json:"processed"`
+	Deleted   int64 `json:"deleted"`
+
+	// Statuses counts processed messages by status, e.g. "delivered".
+	Statuses map[string]int64 `json:"statuses"`
+	// FailureReasons counts messages that were not delivered by reason.
+	FailureReasons map[FailureReason]int64 `json:"failureReasons"`
+
+	// Abandoned lists the messages that were still being processed when the
+	// report was made.
+	Abandoned []AbandonedMessage `json:"abandoned"`
+}
+
+// AbandonedMessage is a message whose processing had not finished.
+type AbandonedMessage struct {
+	QueueURL  string `json:"queueUrl"`
+	MessageID string `json:"messageId"`
+}
+
+// WriteFile writes the report to path as indented JSON.
+func (r Report) WriteFile(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// stats accumulates the totals of a Report.
+type stats struct {
+	mu sync.Mutex
+
+	received  int64
+	processed int64
+	deleted   int64
+	statuses  map[string]int64
+	reasons   map[FailureReason]int64
+
+	// inFlight maps the ID of every message being processed to its queue.
+	inFlight map[string]string
+	// queues holds the counters of every queue by URL.
+	queues map[string]*queueStats
+}
+
+// queueStats accumulates the counters of a QueueStats.
+type queueStats struct {
+	processed int64
+	failed    int64
+	lastError *QueueError
+}
+
+func newStats() *stats {
+	return &stats{
+		statuses: make(map[string]int64),
+		reasons:  make(map[FailureReason]int64),
+		inFlight: make(map[string]string),
+		queues:   make(map[string]*queueStats),
+	}
+}
+
+// queue returns the counters of the queue at url. st.mu must be held.
+func (st *stats) queue(url string) *queueStats {
+	qs, ok := st.queues[url]
+	if !ok {
+		qs = &queueStats{}
+		st.queues[url] = qs
+	}
+
+	return qs
+}
+
+func (st *stats) receive(q *queue, messages []types.Message) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.received += int64(len(messages))
+	for _, msg := range messages {
+		st.inFlight[aws.ToString(msg.MessageId)] = q.url
+	}
+}
+
+func (st *stats) process(q *queue, result messageResult) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	status := result.status
+	if len(status) == 0 {
+		status = "failed"
+	}
+
+	qs := st.queue(q.url)
+
+	st.processed++
+	qs.processed++
+	st.statuses[status]++
+	if len(result.reason) > 0 {
+		st.reasons[result.reason]++
+		qs.failed++
+
+		message := fmt.Sprintf("message %s: %s", aws.ToString(result.msg.MessageId), result.reason)
+		if result.statusCode > 0 {
+			message += fmt.Sprintf(" (status %d)", result.statusCode)
+		}
+		qs.lastError = &QueueError{At: time.Now(), Message: message}
+	}
+}
+
+// receiveError records that receiving messages from q failed with err.
+func (st *stats) receiveError(q *queue, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.queue(q.url).lastError = &QueueError{At: time.Now(), Message: "receive: " + err.Error()}
+}
+
+func (st *stats) delete() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.deleted++
+}
+
+func (st *stats) finish(messages []types.Message) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for _, msg := range messages {
+		delete(st.inFlight, aws.ToString(msg.MessageId))
+	}
+}
+
+// Report summarises the supervisor's processing so far. Messages still being
+// processed are reported as abandoned.
+func (s *Supervisor) Report() Report {
+	st := s.stats
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	report := Report{
+		StartedAt: s.startedAt,
+		StoppedAt: now,
+
+		Received:  st.received,
+		Processed: st.processed,
+		Deleted:   st.deleted,
+
+		Statuses:       make(map[string]int64, len(st.statuses)),
+		FailureReasons: make(map[FailureReason]int64, len(st.reasons)),
+
+		Abandoned: make([]AbandonedMessage, 0, len(st.inFlight)),
+	}
+
+	if !s.startedAt.IsZero() {
+		report.UptimeSeconds = now.Sub(s.startedAt).Seconds()
+	}
+
+	for status, n := range st.statuses {
+		report.Statuses[status] = n
+	}
+	for reason, n := range st.reasons {
+		report.FailureReasons[reason] = n
+	}
+
+	for id, queueURL := range st.inFlight {
+		report.Abandoned = append(report.Abandoned, AbandonedMessage{QueueURL: queueURL, MessageID: id})
+	}
+	sort.Slice(report.Abandoned, func(i, j int) bool {
+		return report.Abandoned[i].MessageID < report.Abandoned[j].MessageID
+	})
+
+	return report
+}
+
+// Stats is a snapshot of the counters of a supervisor.
+type Stats struct {
+	Received  int64 `json:"received"`
+	Processed int64 `json:"processed"`
+	Deleted   int64 `json:"deleted"`
+	// InFlight is how many received messages are being processed.
+	InFlight int `json:"inFlight"`
+	// Workers is how many workers are running.
+	Workers int `json:"workers"`
+	// Paused reports whether receiving is paused, see Pause.
+	Paused bool `json:"paused"`
+	// AwaitingAck is how many messages accepted by the worker are awaiting
+	// an acknowledgment, see Ack.
+	AwaitingAck int `json:"awaitingAck"`
+
+	Statuses       map[string]int64        `json:"statuses"`
+	FailureReasons map[FailureReason]int64 `json:"failureReasons"`
+
+	// Queues holds the counters of every queue by URL.
+	Queues map[string]QueueStats `json:"queues"`
+}
+
+// QueueStats is a snapshot of the counters of a queue of a supervisor.
+type QueueStats struct {
+	InFlight  int   `json:"inFlight"`
+	Processed int64 `json:"processed"`
+	// Failed is how many processed messages were not delivered, for any
+	// FailureReason.
+	Failed int64 `json:"failed"`
+	// LastError is the last failure to receive or deliver a message of the
+	// queue, if any.
+	LastError *QueueError `json:"lastError,omitempty"`
+}
+
+// QueueError describes an error of a queue.
+type QueueError struct {
+	At      time.Time `json:"at"`
+	Message string    `json:"message"`
+}
+
+// Stats returns a snapshot of the counters of the supervisor. It can be
+// called at any time, from any goroutine.
+func (s *Supervisor) Stats() Stats {
+	awaitingAck := s.awaitingAck()
+
+	st := s.stats
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	stats := Stats{
+		Received:  st.received,
+		Processed: st.processed,
+		Deleted:   st.deleted,
+		InFlight:  len(st.inFlight),
+		Workers:   int(s.runningWorkers.Load()),
+		Paused:    s.paused.Load(),
+
+		AwaitingAck: awaitingAck,
+
+		Statuses:       make(map[string]int64, len(st.statuses)),
+		FailureReasons: make(map[FailureReason]int64, len(st.reasons)),
+		Queues:         make(map[string]QueueStats, len(s.queues)),
+	}
+
+	for status, n := range st.statuses {
+		stats.Statuses[status] = n
+	}
+	for reason, n := range st.reasons {
+		stats.FailureReasons[reason] = n
+	}
+
+	for _, q := range s.queues {
+		qs := st.queue(q.url)
+		stats.Queues[q.url] = QueueStats{Processed: qs.processed, Failed: qs.failed, LastError: qs.lastError}
+	}
+	for _, url := range st.inFlight {
+		qs := stats.Queues[url]
+		qs.InFlight++
+		stats.Queues[url] = qs
+	}
+
+	return stats
+}
+
+// MergeReports combines the reports of supervisors running side by side into
+// one covering all of them.
+func MergeReports(reports ...Report) Report {
+	merged := Report{
+		Statuses:       make(map[string]int64),
+		FailureReasons: make(map[FailureReason]int64),
+		Abandoned:      make([]AbandonedMessage, 0),
+	}
+
+	for _, r := range reports {
+		if !r.StartedAt.IsZero() && (merged.StartedAt.IsZero() || r.StartedAt.Before(merged.StartedAt)) {
+			merged.StartedAt = r.StartedAt
+		}
+		if r.StoppedAt.After(merged.StoppedAt) {
+			merged.StoppedAt = r.StoppedAt
+		}
+
+		merged.Received += r.Received
+		merged.Processed += r.Processed
+		merged.Deleted += r.Deleted
+
+		for status, n := range r.Statuses {
+			merged.Statuses[status] += n
+		}
+		for reason, n := range r.FailureReasons {
+			merged.FailureReasons[reason] += n
+		}
+
+		merged.Abandoned = append(merged.Abandoned, r.Abandoned...)
+	}
+
+	if !merged.StartedAt.IsZero() {
+		merged.UptimeSeconds = merged.StoppedAt.Sub(merged.StartedAt).Seconds()
+	}
+
+	sort.Slice(merged.Abandoned, func(i, j int) bool {
+		return merged.Abandoned[i].MessageID < merged.Abandoned[j].MessageID
+	})
+
+	return merged
+}
@@ -0,0 +1,186 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorReport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) == "fail" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		QueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/orders",
+		HTTPURL:  ts.URL,
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	receiveCount := 0
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		receiveCount++
+
+		if receiveCount > 1 {
+			supervisor.Shutdown()
+			return &sqs.ReceiveMessageOutput{}, nil
+		}
+
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{
+				{Body: aws.String("ok"), MessageId: aws.String("m1"), ReceiptHandle: aws.String("r1")},
+				{Body: aws.String("ok"), MessageId: aws.String("m2"), ReceiptHandle: aws.String("r2")},
+				{Body: aws.String("fail"), MessageId: aws.String("m3"), ReceiptHandle: aws.String("r3")},
+			},
+		}, nil
+	}
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		return &sqs.DeleteMessageBatchOutput{}, nil
+	}
+
+	supervisor.Start(1)
+	time.Sleep(10 * time.Millisecond)
+	supervisor.Wait()
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	if !assert.NoError(t, supervisor.Report().WriteFile(path)) {
+		return
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var report Report
+	if !assert.NoError(t, json.Unmarshal(b, &report)) {
+		return
+	}
+
+	assert.Equal(t, int64(3), report.Received)
+	assert.Equal(t, int64(3), report.Processed)
+	assert.Equal(t, int64(2), report.Deleted)
+	assert.Equal(t, map[string]int64{"delivered": 2, "failed": 1}, report.Statuses)
+	assert.Equal(t, map[FailureReason]int64{FailureHTTP5xx: 1}, report.FailureReasons)
+	assert.Empty(t, report.Abandoned)
+	assert.True(t, report.UptimeSeconds >= 0.01)
+	assert.False(t, report.StartedAt.IsZero())
+	assert.True(t, report.StoppedAt.After(report.StartedAt))
+}
+
+func TestSupervisorReportAbandoned(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		QueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/orders",
+	})
+
+	messages := []types.Message{{MessageId: aws.String("m2")}, {MessageId: aws.String("m1")}}
+	supervisor.stats.receive(supervisor.queues[0], messages)
+
+	assert.Equal(t, []AbandonedMessage{
+		{QueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/orders", MessageID: "m1"},
+		{QueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/orders", MessageID: "m2"},
+	}, supervisor.Report().Abandoned)
+
+	supervisor.stats.finish(messages)
+	assert.Empty(t, supervisor.Report().Abandoned)
+}
+
+func TestSupervisorStats(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		QueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/orders",
+	})
+
+	messages := []types.Message{{MessageId: aws.String("m1")}, {MessageId: aws.String("m2")}}
+	supervisor.stats.receive(supervisor.queues[0], messages)
+	supervisor.stats.process(supervisor.queues[0], messageResult{msg: &messages[0], status: "delivered"})
+	supervisor.stats.delete()
+
+	stats := supervisor.Stats()
+	assert.Equal(t, Stats{
+		Received:       2,
+		Processed:      1,
+		Deleted:        1,
+		InFlight:       2,
+		Statuses:       map[string]int64{"delivered": 1},
+		FailureReasons: map[FailureReason]int64{},
+		Queues: map[string]QueueStats{
+			"https://sqs.us-east-1.amazonaws.com/123456789012/orders": {InFlight: 2, Processed: 1},
+		},
+	}, stats)
+
+	// Snapshots don't change with the supervisor.
+	supervisor.stats.process(supervisor.queues[0], messageResult{msg: &messages[1], reason: FailureHTTP5xx, statusCode: http.StatusBadGateway})
+	assert.Empty(t, stats.FailureReasons)
+	assert.Equal(t, int64(1), supervisor.Stats().FailureReasons[FailureHTTP5xx])
+
+	queueStats := supervisor.Stats().Queues["https://sqs.us-east-1.amazonaws.com/123456789012/orders"]
+	assert.Equal(t, int64(1), queueStats.Failed)
+	if assert.NotNil(t, queueStats.LastError) {
+		assert.Equal(t, "message m2: http-5xx (status 502)", queueStats.LastError.Message)
+	}
+
+	supervisor.stats.receiveError(supervisor.queues[0], errors.New("access denied"))
+	queueStats = supervisor.Stats().Queues["https://sqs.us-east-1.amazonaws.com/123456789012/orders"]
+	assert.Equal(t, "receive: access denied", queueStats.LastError.Message)
+}
+
+func TestMergeReports(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	merged := MergeReports(
+		Report{
+			StartedAt: start.Add(time.Second),
+			StoppedAt: start.Add(10 * time.Second),
+			Received:  3,
+			Processed: 2,
+			Deleted:   1,
+			Statuses:  map[string]int64{"delivered": 1, "failed": 1},
+			Abandoned: []AbandonedMessage{{QueueURL: "q1", MessageID: "m2"}},
+		},
+		Report{
+			StartedAt:      start,
+			StoppedAt:      start.Add(5 * time.Second),
+			Received:       1,
+			Processed:      1,
+			Deleted:        1,
+			Statuses:       map[string]int64{"delivered": 1},
+			FailureReasons: map[FailureReason]int64{FailureHTTP5xx: 1},
+			Abandoned:      []AbandonedMessage{{QueueURL: "q2", MessageID: "m1"}},
+		},
+		Report{},
+	)
+
+	assert.Equal(t, start, merged.StartedAt)
+	assert.Equal(t, start.Add(10*time.Second), merged.StoppedAt)
+	assert.Equal(t, 10.0, merged.UptimeSeconds)
+	assert.Equal(t, int64(4), merged.Received)
+	assert.Equal(t, int64(3), merged.Processed)
+	assert.Equal(t, int64(2), merged.Deleted)
+	assert.Equal(t, map[string]int64{"delivered": 2, "failed": 1}, merged.Statuses)
+	assert.Equal(t, map[FailureReason]int64{FailureHTTP5xx: 1}, merged.FailureReasons)
+	assert.Equal(t, []AbandonedMessage{{QueueURL: "q2", MessageID: "m1"}, {QueueURL: "q1", MessageID: "m2"}}, merged.Abandoned)
+}
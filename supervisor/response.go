@@ -0,0 +1,149 @@
+package supervisor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ContentTypePolicy decides what happens when a successful response's
+// Content-Type doesn't match the Accept header sent to the worker.
+type ContentTypePolicy string
+
+const (
+	// ContentTypeIgnore doesn't inspect the response Content-Type.
+	ContentTypeIgnore ContentTypePolicy = "ignore"
+	// ContentTypeWarn logs a mismatch but still treats the delivery as
+	// successful.
+	ContentTypeWarn ContentTypePolicy = "warn"
+	// ContentTypeFail treats a mismatch as a failed delivery.
+	ContentTypeFail ContentTypePolicy = "fail"
+)
+
+// acceptsContentType reports whether contentType satisfies the media ranges
+// listed in accept.
+func acceptsContentType(accept string, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, r := range strings.Split(accept, ",") {
+		accepted, _, err := mime.ParseMediaType(strings.TrimSpace(r))
+		if err != nil {
+			continue
+		}
+
+		if accepted == "*/*" || accepted == mediaType {
+			return true
+		}
+
+		if strings.HasSuffix(accepted, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(accepted, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkResponseContentType reports whether res is acceptable under the
+// configured ContentTypePolicy.
+func (s *Supervisor) checkResponseContentType(res *http.Response) bool {
+	policy := s.workerConfig.HTTPAcceptPolicy
+	if len(s.workerConfig.HTTPAccept) == 0 || policy == "" || policy == ContentTypeIgnore {
+		return true
+	}
+
+	contentType := res.Header.Get("Content-Type")
+	if acceptsContentType(s.workerConfig.HTTPAccept, contentType) {
+		return true
+	}
+
+	s.logger.Warnf("Response Content-Type %q does not match Accept %q", contentType, s.workerConfig.HTTPAccept)
+
+	return policy != ContentTypeFail
+}
+
+// softFailed reports whether the worker asked for the message to be retried
+// through the HTTPRetryHeader despite a successful status code.
+func (s *Supervisor) softFailed(res *http.Response) bool {
+	if len(s.workerConfig.HTTPRetryHeader) == 0 {
+		return false
+	}
+
+	retry, err := strconv.ParseBool(res.Header.Get(s.workerConfig.HTTPRetryHeader))
+	return err == nil && retry
+}
+
+// requeued reports whether the worker asked, through the requeueDelayHeader
+// of its successful response, for the message of result to be received
+// again after a delay rather than deleted, and sets its visibility timeout to
+// that delay. A message with an invalid delay is left to be retried.
+func (s *Supervisor) requeued(q *queue, result *messageResult, res *http.Response) bool {
+	delay, ok, err := requeueDelay(res)
+	if err != nil {
+		s.resultLogger(q, result).Errorf("Error getting the requeue delay from HTTP response: %s", err)
+
+		result.status = "retry"
+		return true
+	}
+	if !ok {
+		return false
+	}
+
+	s.resultLogger(q, result).Debugf("Worker asked for the message to be requeued in %d seconds", delay)
+	s.recordFirstDelivery()
+
+	result.disposition = dispositionChangeVisibility
+	result.visibilityTimeout = delay
+	result.status = "requeued"
+
+	return true
+}
+
+// captureResponseBody replaces the body of res with an in-memory copy of at
+// most its first max bytes, for the body of a failed delivery to be logged.
+// The body is always closed.
+func captureResponseBody(res *http.Response, max int64) {
+	defer res.Body.Close()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, max))
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+}
+
+// responseExcerpt returns at most the first max bytes of the body of res,
+// which can still be read in full afterwards.
+func responseExcerpt(res *http.Response, max int64) string {
+	if max <= 0 || res.Body == nil {
+		return ""
+	}
+
+	excerpt, _ := ioutil.ReadAll(io.LimitReader(res.Body, max))
+	res.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(excerpt), res.Body))
+
+	return strings.ToValidUTF8(string(excerpt), "")
+}
+
+// bufferResponseBody reads at most max bytes of the body of res and replaces
+// it with an in-memory copy. The body is always closed.
+func bufferResponseBody(res *http.Response, max int64) error {
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, max+1))
+	if err != nil {
+		return fmt.Errorf("Error while reading HTTP response body: %s", err)
+	}
+
+	if int64(len(body)) > max {
+		return &oversizedError{max: max}
+	}
+
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return nil
+}
@@ -0,0 +1,253 @@
+package supervisor
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcceptsContentType(t *testing.T) {
+	assert.True(t, acceptsContentType("application/json", "application/json; charset=utf-8"))
+	assert.True(t, acceptsContentType("text/plain, application/json", "application/json"))
+	assert.True(t, acceptsContentType("application/*", "application/xml"))
+	assert.True(t, acceptsContentType("*/*", "text/html"))
+	assert.False(t, acceptsContentType("application/json", "text/html"))
+	assert.False(t, acceptsContentType("application/json", ""))
+}
+
+func TestSupervisorAcceptPolicy(t *testing.T) {
+	for _, policy := range []ContentTypePolicy{ContentTypeWarn, ContentTypeFail} {
+		t.Run(string(policy), func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "application/json", r.Header.Get("Accept"))
+
+				w.Header().Set("Content-Type", "text/html")
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer ts.Close()
+
+			log.SetOutput(ioutil.Discard)
+			logger := log.WithFields(log.Fields{})
+			mockSQS := &mockSQS{}
+			config := WorkerConfig{
+				HTTPURL:          ts.URL,
+				HTTPAccept:       "application/json",
+				HTTPAcceptPolicy: policy,
+			}
+
+			supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+			receiveCount := 0
+			mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+				receiveCount++
+
+				if receiveCount == 2 {
+					supervisor.Shutdown()
+					return &sqs.ReceiveMessageOutput{}, nil
+				}
+
+				return &sqs.ReceiveMessageOutput{
+					Messages: []types.Message{{
+						Body:          aws.String("message 1"),
+						MessageId:     aws.String("m1"),
+						ReceiptHandle: aws.String("r1"),
+					}},
+				}, nil
+			}
+
+			deleted := false
+			mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+				deleted = true
+				return nil, nil
+			}
+
+			supervisor.Start(1)
+			supervisor.Wait()
+
+			assert.Equal(t, policy == ContentTypeWarn, deleted)
+		})
+	}
+}
+
+func TestSupervisorRetryHeader(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) == "message 2" {
+			w.Header().Set("X-Sqsd-Retry", "true")
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		HTTPURL:         ts.URL,
+		HTTPRetryHeader: "X-Sqsd-Retry",
+	}
+
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String("message 1"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+			}, {
+				Body:          aws.String("message 2"),
+				MessageId:     aws.String("m2"),
+				ReceiptHandle: aws.String("r2"),
+			}},
+		}, nil
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	var deleted []string
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		defer supervisor.Shutdown()
+
+		for _, entry := range input.Entries {
+			deleted = append(deleted, *entry.Id)
+		}
+
+		return nil, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	assert.Equal(t, []string{"m1"}, deleted)
+}
+
+func TestBufferResponseBody(t *testing.T) {
+	res := &http.Response{Body: ioutil.NopCloser(strings.NewReader("0123456789"))}
+	assert.NoError(t, bufferResponseBody(res, 10))
+	body, _ := ioutil.ReadAll(res.Body)
+	assert.Equal(t, "0123456789", string(body))
+
+	res = &http.Response{Body: ioutil.NopCloser(strings.NewReader("0123456789a"))}
+	assert.EqualError(t, bufferResponseBody(res, 10), "HTTP response body exceeds 10 bytes")
+}
+
+func TestResponseExcerpt(t *testing.T) {
+	res := &http.Response{Body: ioutil.NopCloser(strings.NewReader("0123456789"))}
+	assert.Equal(t, "0123", responseExcerpt(res, 4))
+	body, _ := ioutil.ReadAll(res.Body)
+	assert.Equal(t, "0123456789", string(body))
+
+	res = &http.Response{Body: ioutil.NopCloser(strings.NewReader("caf\u00e9"))}
+	assert.Equal(t, "caf", responseExcerpt(res, 4), "a truncated character is dropped")
+
+	assert.Empty(t, responseExcerpt(&http.Response{}, 4))
+
+	res = &http.Response{Body: ioutil.NopCloser(strings.NewReader("0123456789"))}
+	captureResponseBody(res, 4)
+	body, _ = ioutil.ReadAll(res.Body)
+	assert.Equal(t, "0123", string(body))
+}
+
+func TestSupervisorMaxResponseBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		w.WriteHeader(http.StatusOK)
+		if string(body) == "message 2" {
+			w.Write(bytes.Repeat([]byte("x"), 1<<20))
+			return
+		}
+
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		HTTPURL:             ts.URL,
+		HTTPMaxResponseBody: 1024,
+	}
+
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String("message 1"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+			}, {
+				Body:          aws.String("message 2"),
+				MessageId:     aws.String("m2"),
+				ReceiptHandle: aws.String("r2"),
+			}},
+		}, nil
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	var deleted []string
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		defer supervisor.Shutdown()
+
+		for _, entry := range input.Entries {
+			deleted = append(deleted, *entry.Id)
+		}
+
+		return nil, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	assert.Equal(t, []string{"m1"}, deleted)
+}
+
+func TestSupervisorRequeueDelayHeader(t *testing.T) {
+	delay := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Sqsd-Requeue-Delay", delay)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL: ts.URL,
+	})
+
+	message := &types.Message{
+		Body:          aws.String("message"),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+	}
+
+	for value, expected := range map[string]int64{"300": 300, "0": 0, "99999999": 43200} {
+		delay = value
+
+		result := supervisor.processMessage(context.Background(), supervisor.queues[0], message)
+		assert.Equal(t, dispositionChangeVisibility, result.disposition, value)
+		assert.Equal(t, expected, result.visibilityTimeout, value)
+		assert.Equal(t, "requeued", result.status, value)
+	}
+
+	delay = "later"
+	result := supervisor.processMessage(context.Background(), supervisor.queues[0], message)
+	assert.Equal(t, dispositionRetry, result.disposition)
+	assert.Equal(t, "retry", result.status)
+
+	delay = ""
+	result = supervisor.processMessage(context.Background(), supervisor.queues[0], message)
+	assert.Equal(t, dispositionDelete, result.disposition)
+	assert.Equal(t, "delivered", result.status)
+}
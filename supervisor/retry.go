@@ -0,0 +1,242 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultVisibilityTimeout is the SQS default, used to bound retries when no
+// VisibilityTimeout is configured.
+const defaultVisibilityTimeout = 30 * time.Second
+
+// deliverWithRetries delivers msg, retrying errors and 5xx responses up to
+// HTTPMaxRetries times with exponential backoff. Retries stop early rather
+// than run past the visibility timeout of msg.
+func (s *Supervisor) deliverWithRetries(ctx context.Context, q *queue, msg *types.Message) (*http.Response, error) {
+	return s.withRetries(ctx, s.messageLogger(q, msg), func() (*http.Response, error) {
+		return s.deliver(ctx, q, msg)
+	})
+}
+
+// withRetries calls deliver, and calls it again as deliverWithRetries would
+// retry a message.
+func (s *Supervisor) withRetries(ctx context.Context, logger *log.Entry, deliver func() (*http.Response, error)) (*http.Response, error) {
+	deadline := s.retryDeadline(ctx)
+
+	for attempt := 0; ; attempt++ {
+		res, err := deliver()
+		if attempt >= s.workerConfig.HTTPMaxRetries || !s.retryable(res, err) || ctx.Err() != nil {
+			return res, err
+		}
+
+		delay := s.retryDelay(attempt)
+		if after, err := s.retryAfter(res); err == nil && after > delay {
+			delay = after
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return res, err
+		}
+
+		logger.Infof("Retrying delivery in %s", delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return res, err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable reports whether a delivery may succeed if attempted again.
+func (s *Supervisor) retryable(res *http.Response, err error) bool {
+	if err != nil {
+		var (
+			tmplErr *templateError
+			decErr  *decodeError
+			sizeErr *requestTooLargeError
+		)
+		return !errors.As(err, &tmplErr) && !errors.As(err, &decErr) && !errors.As(err, &sizeErr) && !errors.Is(err, errCircuitOpen)
+	}
+
+	// The worker chose when the message should be received again.
+	if _, ok, _ := visibilityTimeout(res); ok {
+		return false
+	}
+
+	if rule, ok := s.statusRule(res.StatusCode); ok {
+		// Only plain retries are left to RetryStatusCodes.
+		if rule.Action != StatusActionRetry || rule.VisibilityTimeout > 0 {
+			return false
+		}
+
+		return s.retriedStatus(res.StatusCode)
+	}
+
+	return s.retriedStatus(res.StatusCode) && !s.successful(res.StatusCode) && !s.discarded(res.StatusCode)
+}
+
+// retryDelay is how long to wait before the retry following attempt: half of
+// HTTPRetryBackoff doubled attempt times, capped at HTTPRetryMaxBackoff, plus
+// up to as much again of jitter.
+func (s *Supervisor) retryDelay(attempt int) time.Duration {
+	first := s.workerConfig.HTTPRetryBackoff
+	if first <= 0 {
+		return 0
+	}
+
+	max := s.workerConfig.HTTPRetryMaxBackoff
+	if max <= 0 {
+		max = DefaultHTTPRetryMaxBackoff
+	}
+
+	// Doubling is checked against max first, as it would overflow after
+	// enough attempts.
+	backoff := max
+	if attempt < 63 && first <= max>>uint(attempt) {
+		backoff = first << uint(attempt)
+	}
+
+	half := backoff / 2
+
+	return half + time.Duration(rand.Int63n(int64(backoff-half)+1))
+}
+
+// retryAfter returns how long the Retry-After header of res, in seconds or as
+// an HTTP date, asks to wait, capped at RetryAfterMax.
+func (s *Supervisor) retryAfter(res *http.Response) (time.Duration, error) {
+	if res == nil {
+		return 0, errors.New("no HTTP response")
+	}
+
+	retryAfter := res.Header.Get("Retry-After")
+	if len(retryAfter) == 0 {
+		return 0, errors.New("Retry-After header value is empty")
+	}
+
+	var delay time.Duration
+	if seconds, err := strconv.ParseInt(retryAfter, 10, 64); err == nil {
+		if seconds > int64(maxVisibility/time.Second) {
+			seconds = int64(maxVisibility / time.Second)
+		}
+		delay = time.Duration(seconds) * time.Second
+	} else if date, dateErr := http.ParseTime(retryAfter); dateErr == nil {
+		delay = time.Until(date)
+	} else {
+		return 0, err
+	}
+
+	max := s.workerConfig.RetryAfterMax
+	if max <= 0 || max > maxVisibility {
+		max = maxVisibility
+	}
+
+	switch {
+	case delay < 0:
+		return 0, nil
+	case delay > max:
+		return max, nil
+	}
+
+	return delay, nil
+}
+
+// visibilityTimeoutHeader lets the worker choose, in seconds, when a message
+// it failed is received again, overriding Retry-After.
+const visibilityTimeoutHeader = "X-Sqsd-Visibility-Timeout"
+
+// visibilityTimeout returns the visibility timeout the worker asked for in
+// the visibilityTimeoutHeader of res, and whether it asked for one.
+func visibilityTimeout(res *http.Response) (int64, bool, error) {
+	return headerSeconds(res, visibilityTimeoutHeader)
+}
+
+// requeueDelayHeader lets the worker have a message it processed
+// successfully left in the queue, and received again after a delay in
+// seconds, rather than deleted.
+const requeueDelayHeader = "X-Sqsd-Requeue-Delay"
+
+// requeueDelay returns the delay the worker asked for in the
+// requeueDelayHeader of res, and whether it asked for one.
+func requeueDelay(res *http.Response) (int64, bool, error) {
+	return headerSeconds(res, requeueDelayHeader)
+}
+
+// headerSeconds parses the header name of res as a visibility timeout in
+// seconds, capped at the SQS maximum, and reports whether it is set.
+func headerSeconds(res *http.Response, name string) (int64, bool, error) {
+	value := res.Header.Get(name)
+	if len(value) == 0 {
+		return 0, false, nil
+	}
+
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch {
+	case seconds < 0:
+		return 0, false, fmt.Errorf("negative %s", name)
+	case seconds > int64(maxVisibility/time.Second):
+		seconds = int64(maxVisibility / time.Second)
+	}
+
+	return seconds, true, nil
+}
+
+// receiveErrorBackoff is the wait after the first of consecutive
+// ReceiveMessage errors of no particular class, doubled for every error after
+// that.
+const receiveErrorBackoff = 100 * time.Millisecond
+
+// receiveErrorDelay is how long to wait before receiving again after
+// consecutive ReceiveMessage errors, the last of class, capped at
+// ReceiveErrorMaxBackoff, with jitter so that workers failing together don't
+// retry together.
+func (s *Supervisor) receiveErrorDelay(class errorClass, failures int) time.Duration {
+	max := s.workerConfig.ReceiveErrorMaxBackoff
+	if max <= 0 {
+		max = DefaultReceiveErrorMaxBackoff
+	}
+
+	first, ok := receiveErrorBackoffs[class]
+	if !ok {
+		first = receiveErrorBackoff
+	}
+
+	backoff := max
+	if failures <= 30 {
+		if d := first << uint(failures-1); d > 0 && d < max {
+			backoff = d
+		}
+	}
+
+	half := backoff / 2
+
+	return half + time.Duration(rand.Int63n(int64(backoff-half)+1))
+}
+
+// retryDeadline is the time after which messages processed under ctx are no
+// longer retried.
+func (s *Supervisor) retryDeadline(ctx context.Context) time.Time {
+	if deadline, ok := s.processingDeadline(ctx); ok {
+		return deadline
+	}
+
+	receivedAt, ok := ctx.Value(receivedAtKey{}).(time.Time)
+	if !ok {
+		receivedAt = time.Now()
+	}
+
+	return receivedAt.Add(defaultVisibilityTimeout)
+}
@@ -0,0 +1,387 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRetryTestServer(failures int32) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	return ts, &requests
+}
+
+func TestSupervisorRetriesDelivery(t *testing.T) {
+	ts, requests := newRetryTestServer(2)
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:          ts.URL,
+		HTTPMaxRetries:   3,
+		HTTPRetryBackoff: time.Millisecond,
+	})
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	result := supervisor.processMessage(ctx, supervisor.queues[0], &types.Message{
+		Body:          aws.String("message"),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+	})
+
+	assert.Equal(t, int32(3), requests.Load())
+	assert.Equal(t, dispositionDelete, result.disposition)
+	assert.Equal(t, "delivered", result.status)
+}
+
+func TestSupervisorRetriesExhausted(t *testing.T) {
+	ts, requests := newRetryTestServer(10)
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:          ts.URL,
+		HTTPMaxRetries:   2,
+		HTTPRetryBackoff: time.Millisecond,
+	})
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	result := supervisor.processMessage(ctx, supervisor.queues[0], &types.Message{
+		Body:          aws.String("message"),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+	})
+
+	assert.Equal(t, int32(3), requests.Load())
+	assert.Equal(t, dispositionRetry, result.disposition)
+	assert.Equal(t, FailureHTTP5xx, result.reason)
+}
+
+func TestSupervisorRetriesStopAtVisibilityTimeout(t *testing.T) {
+	ts, requests := newRetryTestServer(10)
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:           ts.URL,
+		HTTPMaxRetries:    5,
+		HTTPRetryBackoff:  40 * time.Millisecond,
+		VisibilityTimeout: 100 * time.Millisecond,
+	})
+
+	start := time.Now()
+	ctx, cancel := supervisor.inFlightContext(start)
+	defer cancel()
+
+	supervisor.deliverWithRetries(ctx, supervisor.queues[0], &types.Message{
+		Body:          aws.String("message"),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+	})
+
+	// Waits of 20-40ms, 40-80ms and 80-160ms leave room for at most two
+	// retries within 100ms.
+	assert.True(t, requests.Load() <= 3, "%d requests", requests.Load())
+	assert.True(t, time.Since(start) < 250*time.Millisecond)
+}
+
+func TestSupervisorDoesNotRetry4xx(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:          ts.URL,
+		HTTPMaxRetries:   3,
+		HTTPRetryBackoff: time.Millisecond,
+	})
+
+	supervisor.deliverWithRetries(context.Background(), supervisor.queues[0], &types.Message{
+		Body:          aws.String("message"),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+	})
+
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestSupervisorRetryStatusCodes(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/429":
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	for path, expected := range map[string]int32{"/429": 3, "/500": 1} {
+		requests.Store(0)
+		supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+			HTTPURL:          ts.URL + path,
+			HTTPMaxRetries:   2,
+			HTTPRetryBackoff: time.Millisecond,
+			RetryStatusCodes: StatusCodes{{http.StatusTooManyRequests, http.StatusTooManyRequests}, {502, 504}},
+		})
+
+		supervisor.deliverWithRetries(context.Background(), supervisor.queues[0], &types.Message{
+			Body:          aws.String("message"),
+			MessageId:     aws.String("m1"),
+			ReceiptHandle: aws.String("r1"),
+		})
+
+		assert.Equal(t, expected, requests.Load(), path)
+	}
+}
+
+func TestSupervisorRetryDelay(t *testing.T) {
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPRetryBackoff: 100 * time.Millisecond,
+	})
+
+	for attempt, max := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		delay := supervisor.retryDelay(attempt)
+		assert.True(t, delay >= max/2 && delay <= max, "attempt %d: %s", attempt, delay)
+	}
+
+	// Many attempts wait for the maximum rather than overflow to no wait.
+	for _, attempt := range []int{8, 40, 63, 64, 1000} {
+		delay := supervisor.retryDelay(attempt)
+		assert.True(t, delay >= DefaultHTTPRetryMaxBackoff/2 && delay <= DefaultHTTPRetryMaxBackoff, "attempt %d: %s", attempt, delay)
+	}
+
+	supervisor = NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPRetryBackoff:    100 * time.Millisecond,
+		HTTPRetryMaxBackoff: 300 * time.Millisecond,
+	})
+	delay := supervisor.retryDelay(2)
+	assert.True(t, delay >= 150*time.Millisecond && delay <= 300*time.Millisecond, delay)
+
+	supervisor = NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{})
+	assert.Equal(t, time.Duration(0), supervisor.retryDelay(3))
+}
+
+func TestSupervisorReceiveErrorDelay(t *testing.T) {
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		ReceiveErrorMaxBackoff: time.Second,
+	})
+
+	for i, max := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		delay := supervisor.receiveErrorDelay(errorClassOther, i+1)
+		assert.True(t, delay >= max/2 && delay <= max, "failure %d: %s", i+1, delay)
+	}
+
+	delay := supervisor.receiveErrorDelay(errorClassOther, 100)
+	assert.True(t, delay >= 500*time.Millisecond && delay <= time.Second, delay)
+
+	supervisor = NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{})
+	delay = supervisor.receiveErrorDelay(errorClassOther, 100)
+	assert.True(t, delay >= DefaultReceiveErrorMaxBackoff/2 && delay <= DefaultReceiveErrorMaxBackoff, delay)
+}
+
+func TestSupervisorReceiveErrorBackoff(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	mockSQS := &mockSQS{}
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+		ReceiveErrorMaxBackoff: 200 * time.Millisecond,
+	})
+
+	var receivedAt []time.Time
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		receivedAt = append(receivedAt, time.Now())
+		if len(receivedAt) == 4 {
+			return &sqs.ReceiveMessageOutput{}, nil
+		}
+		if len(receivedAt) == 6 {
+			supervisor.Shutdown()
+		}
+
+		return nil, errors.New("throttled")
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	if assert.Len(t, receivedAt, 6) {
+		// Waits grow with consecutive errors...
+		assert.True(t, receivedAt[1].Sub(receivedAt[0]) >= 50*time.Millisecond)
+		assert.True(t, receivedAt[3].Sub(receivedAt[2]) >= 100*time.Millisecond)
+		// ...and start over after a successful receive.
+		assert.True(t, receivedAt[4].Sub(receivedAt[3]) < 50*time.Millisecond)
+		assert.True(t, receivedAt[5].Sub(receivedAt[4]) < 150*time.Millisecond)
+	}
+}
+
+func TestSupervisorRetryAfter(t *testing.T) {
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		RetryAfterMax: time.Hour,
+	})
+
+	response := func(retryAfter string) *http.Response {
+		res := &http.Response{Header: http.Header{}}
+		if len(retryAfter) > 0 {
+			res.Header.Set("Retry-After", retryAfter)
+		}
+		return res
+	}
+
+	delay, err := supervisor.retryAfter(response("120"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, delay)
+
+	delay, err = supervisor.retryAfter(response(time.Now().Add(10 * time.Minute).UTC().Format(http.TimeFormat)))
+	assert.NoError(t, err)
+	assert.True(t, delay > 9*time.Minute && delay <= 10*time.Minute, delay)
+
+	delay, err = supervisor.retryAfter(response(time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)))
+	assert.NoError(t, err)
+	assert.Zero(t, delay)
+
+	delay, err = supervisor.retryAfter(response("99999999999999"))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, delay)
+
+	for _, retryAfter := range []string{"", "soon"} {
+		_, err = supervisor.retryAfter(response(retryAfter))
+		assert.Error(t, err, retryAfter)
+	}
+
+	_, err = supervisor.retryAfter(nil)
+	assert.Error(t, err)
+}
+
+func TestSupervisorRetriesHonorRetryAfter(t *testing.T) {
+	var requests []time.Time
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, time.Now())
+		if len(requests) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:          ts.URL,
+		HTTPMaxRetries:   1,
+		HTTPRetryBackoff: time.Millisecond,
+	})
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	result := supervisor.processMessage(ctx, supervisor.queues[0], &types.Message{
+		Body:          aws.String("message"),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+	})
+
+	assert.Equal(t, "delivered", result.status)
+	if assert.Len(t, requests, 2) {
+		assert.True(t, requests[1].Sub(requests[0]) >= time.Second)
+	}
+}
+
+func TestSupervisorServiceUnavailableRetryAfter(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "90")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:       ts.URL,
+		RetryAfterMax: time.Minute,
+	})
+
+	result := supervisor.processMessage(context.Background(), supervisor.queues[0], &types.Message{
+		Body:          aws.String("message"),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+	})
+
+	assert.Equal(t, dispositionChangeVisibility, result.disposition)
+	assert.Equal(t, int64(60), result.visibilityTimeout)
+}
+
+func TestSupervisorVisibilityTimeoutHeader(t *testing.T) {
+	var requests atomic.Int32
+	timeout := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Retry-After", "30")
+		w.Header().Set("X-Sqsd-Visibility-Timeout", timeout)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:          ts.URL,
+		HTTPMaxRetries:   2,
+		HTTPRetryBackoff: time.Millisecond,
+	})
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	message := &types.Message{
+		Body:          aws.String("message"),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+	}
+
+	for value, expected := range map[string]int64{"300": 300, "0": 0, "99999999": 43200} {
+		timeout = value
+		requests.Store(0)
+
+		result := supervisor.processMessage(ctx, supervisor.queues[0], message)
+		assert.Equal(t, dispositionChangeVisibility, result.disposition, value)
+		assert.Equal(t, expected, result.visibilityTimeout, value)
+		assert.Equal(t, int32(1), requests.Load(), value)
+	}
+
+	res := &http.Response{Header: http.Header{}}
+	for _, value := range []string{"soon", "-1"} {
+		res.Header.Set("X-Sqsd-Visibility-Timeout", value)
+		_, _, err := visibilityTimeout(res)
+		assert.Error(t, err, value)
+	}
+}
@@ -0,0 +1,109 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// routePlaceholder matches the {attribute} placeholders of route URLs.
+var routePlaceholder = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// Route sends the messages having a message attribute to their own URL.
+type Route struct {
+	// Attribute is the name of the String or Number message attribute a
+	// message must have to take the route. A route without an attribute is a
+	// default route taken by every message.
+	Attribute string `json:"attribute"`
+	// Value, when set, is the value Attribute must have.
+	Value string `json:"value"`
+	// URL is the URL messages are delivered to, resolved against the URL of
+	// their queue when it is relative, e.g. "/tasks/{taskType}". Every
+	// {name} placeholder is replaced by the percent-encoded value of the
+	// message attribute name, and a message missing one doesn't take the
+	// route.
+	URL string `json:"url"`
+}
+
+// ParseRoutes parses a JSON array of routes, e.g.
+// [{"attribute": "taskType", "url": "/tasks/{taskType}"}, {"url": "/other"}].
+func ParseRoutes(value string) ([]Route, error) {
+	var routes []Route
+	if err := json.Unmarshal([]byte(value), &routes); err != nil {
+		return nil, err
+	}
+
+	for i, route := range routes {
+		if len(route.URL) == 0 {
+			return nil, fmt.Errorf("route %d has no url", i)
+		}
+		if len(route.Value) > 0 && len(route.Attribute) == 0 {
+			return nil, fmt.Errorf("route %d has a value but no attribute", i)
+		}
+
+		// Placeholders are replaced by escaped values, which can't make a
+		// valid URL invalid.
+		if strings.ContainsAny(routePlaceholder.ReplaceAllString(route.URL, ""), "{}") {
+			return nil, fmt.Errorf("route %d has an unterminated placeholder", i)
+		}
+		for _, match := range routePlaceholder.FindAllStringSubmatch(route.URL, -1) {
+			if len(match[1]) == 0 {
+				return nil, fmt.Errorf("route %d has an empty placeholder", i)
+			}
+		}
+		if _, err := url.Parse(routePlaceholder.ReplaceAllString(route.URL, "x")); err != nil {
+			return nil, fmt.Errorf("route %d has an invalid url: %s", i, err)
+		}
+	}
+
+	return routes, nil
+}
+
+// routeURL returns the URL of the first route msg takes, resolved against
+// baseURL, and false when it takes none.
+func (s *Supervisor) routeURL(baseURL string, msg *types.Message) (string, bool) {
+	for _, route := range s.workerConfig.Routes {
+		if len(route.Attribute) > 0 {
+			value, ok := attributeValue(msg, route.Attribute)
+			if !ok || (len(route.Value) > 0 && value != route.Value) {
+				continue
+			}
+		}
+
+		complete := true
+		routeURL := routePlaceholder.ReplaceAllStringFunc(route.URL, func(placeholder string) string {
+			value, ok := attributeValue(msg, placeholder[1:len(placeholder)-1])
+			complete = complete && ok
+			return url.PathEscape(value)
+		})
+		if !complete {
+			continue
+		}
+
+		resolved, err := cronTaskURL(baseURL, routeURL)
+		if err != nil {
+			s.logger.Errorf("Error while resolving the route URL %s of message %s: %s", routeURL, aws.ToString(msg.MessageId), err)
+			continue
+		}
+
+		return resolved, true
+	}
+
+	return "", false
+}
+
+// attributeValue returns the value of the String or Number message attribute
+// name of msg.
+func attributeValue(msg *types.Message, name string) (string, bool) {
+	attr, ok := msg.MessageAttributes[name]
+	if !ok || attr.StringValue == nil || strings.HasPrefix(aws.ToString(attr.DataType), "Binary") {
+		return "", false
+	}
+
+	return *attr.StringValue, true
+}
@@ -0,0 +1,73 @@
+package supervisor
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := ParseRoutes(`[{"attribute": "taskType", "value": "resize", "url": "http://images/resize"}, {"url": "/tasks/{taskType}"}]`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []Route{
+			{Attribute: "taskType", Value: "resize", URL: "http://images/resize"},
+			{URL: "/tasks/{taskType}"},
+		}, routes)
+	}
+
+	for _, value := range []string{
+		`{"url": "/tasks"}`,
+		`[{"attribute": "taskType"}]`,
+		`[{"value": "resize", "url": "/resize"}]`,
+		`[{"url": "/tasks/{taskType"}]`,
+		`[{"url": "/tasks/{}"}]`,
+		`[{"url": "http://[::1"}]`,
+	} {
+		_, err := ParseRoutes(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestSupervisorRoutes(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL: "http://worker/base/",
+		Routes: []Route{
+			{Attribute: "taskType", Value: "resize", URL: "http://images/resize"},
+			{Attribute: "taskType", URL: "tasks/{taskType}/{tenant}"},
+			{Attribute: "priority", URL: "/urgent"},
+		},
+	})
+
+	message := func(attributes map[string]string) *types.Message {
+		msg := &types.Message{MessageId: aws.String("m"), MessageAttributes: map[string]types.MessageAttributeValue{}}
+		for name, value := range attributes {
+			msg.MessageAttributes[name] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+		}
+		return msg
+	}
+
+	for _, test := range []struct {
+		attributes map[string]string
+		url        string
+	}{
+		{map[string]string{"taskType": "resize", "tenant": "a"}, "http://images/resize"},
+		{map[string]string{"taskType": "crop/all", "tenant": "a b"}, "http://worker/base/tasks/crop%2Fall/a%20b"},
+		// Messages missing a placeholder attribute fall through to the next
+		// route.
+		{map[string]string{"taskType": "crop", "priority": "high"}, "http://worker/urgent"},
+		{map[string]string{"taskType": "crop"}, "http://worker/base/"},
+		{map[string]string{}, "http://worker/base/"},
+	} {
+		assert.Equal(t, test.url, supervisor.requestURL("http://worker/base/", message(test.attributes)), test.attributes)
+	}
+
+	// A route without an attribute takes every message.
+	supervisor.workerConfig.Routes = []Route{{URL: "/other"}}
+	assert.Equal(t, "http://worker/other", supervisor.requestURL("http://worker/base/", message(nil)))
+}
@@ -0,0 +1,131 @@
+package supervisor
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// S3Client is the part of the S3 API ResolveS3Pointers uses. *s3.Client
+// implements it.
+type S3Client interface {
+	GetObject(ctx context.Context, input *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	DeleteObject(ctx context.Context, input *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+}
+
+var _ S3Client = (*s3.Client)(nil)
+
+// Class names the Amazon SQS Extended Client tags the pointers to payloads
+// stored in S3 with, the second one in its versions before 2.0.
+var s3PointerClasses = map[string]bool{
+	"software.amazon.payloadoffloading.PayloadS3Pointer": true,
+	"com.amazon.sqs.javamessaging.MessageS3Pointer":      true,
+}
+
+// Message attributes the Extended Client records the size of a payload
+// stored in S3 in. They are removed from the delivered message.
+var s3PayloadSizeAttributes = []string{"ExtendedPayloadSize", "SQSLargePayloadSize"}
+
+// s3Pointer locates the payload of a message stored in S3.
+type s3Pointer struct {
+	Bucket string `json:"s3BucketName"`
+	Key    string `json:"s3Key"`
+}
+
+// parseS3Pointer parses a body written by the Extended Client in place of a
+// payload stored in S3, e.g.
+// ["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3BucketName":"b","s3Key":"k"}].
+func parseS3Pointer(body string) (*s3Pointer, bool) {
+	var parts []json.RawMessage
+	if err := json.Unmarshal([]byte(body), &parts); err != nil || len(parts) != 2 {
+		return nil, false
+	}
+
+	var class string
+	if err := json.Unmarshal(parts[0], &class); err != nil || !s3PointerClasses[class] {
+		return nil, false
+	}
+
+	var pointer s3Pointer
+	if err := json.Unmarshal(parts[1], &pointer); err != nil || len(pointer.Bucket) == 0 || len(pointer.Key) == 0 {
+		return nil, false
+	}
+
+	return &pointer, true
+}
+
+// s3PayloadError is returned when the payload of a message can't be fetched
+// from S3.
+type s3PayloadError struct {
+	err error
+}
+
+func (e *s3PayloadError) Error() string {
+	return "Error while fetching the message payload from S3: " + e.err.Error()
+}
+
+// resolveS3Pointer returns msg with the payload its body points to in S3 as
+// its body, or msg itself when it isn't a pointer or ResolveS3Pointers is
+// disabled.
+func (s *Supervisor) resolveS3Pointer(ctx context.Context, msg *types.Message) (*types.Message, error) {
+	if !s.workerConfig.ResolveS3Pointers {
+		return msg, nil
+	}
+
+	pointer, ok := parseS3Pointer(aws.ToString(msg.Body))
+	if !ok {
+		return msg, nil
+	}
+
+	output, err := s.workerConfig.S3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(pointer.Bucket),
+		Key:    aws.String(pointer.Key),
+	})
+	if err != nil {
+		return nil, &s3PayloadError{err: err}
+	}
+	defer output.Body.Close()
+
+	payload, err := ioutil.ReadAll(output.Body)
+	if err != nil {
+		return nil, &s3PayloadError{err: err}
+	}
+
+	resolved := *msg
+	resolved.Body = aws.String(string(payload))
+	resolved.MessageAttributes = make(map[string]types.MessageAttributeValue, len(msg.MessageAttributes))
+	for name, value := range msg.MessageAttributes {
+		resolved.MessageAttributes[name] = value
+	}
+	for _, name := range s3PayloadSizeAttributes {
+		delete(resolved.MessageAttributes, name)
+	}
+
+	return &resolved, nil
+}
+
+// deleteS3Payload deletes the payload stored in S3 of a message deleted from
+// the queue, with DeleteS3Payloads. The payloads of messages moved to the
+// error queue are kept for the pointer sent there.
+func (s *Supervisor) deleteS3Payload(result messageResult) {
+	if !s.workerConfig.ResolveS3Pointers || !s.workerConfig.DeleteS3Payloads || result.status == "error-queue" {
+		return
+	}
+
+	pointer, ok := parseS3Pointer(aws.ToString(result.msg.Body))
+	if !ok {
+		return
+	}
+
+	_, err := s.workerConfig.S3.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(pointer.Bucket),
+		Key:    aws.String(pointer.Key),
+	})
+	if err != nil {
+		s.logger.Errorf("Error while deleting the payload of message %s from S3: %s", aws.ToString(result.msg.MessageId), err)
+	}
+}
@@ -0,0 +1,127 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockS3 struct {
+	objects map[string]string
+	deleted []string
+}
+
+func (m *mockS3) GetObject(ctx context.Context, input *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	object, ok := m.objects[*input.Bucket+"/"+*input.Key]
+	if !ok {
+		return nil, errors.New("NoSuchKey")
+	}
+
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader(object))}, nil
+}
+
+func (m *mockS3) DeleteObject(ctx context.Context, input *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	m.deleted = append(m.deleted, *input.Bucket+"/"+*input.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func TestParseS3Pointer(t *testing.T) {
+	pointer, ok := parseS3Pointer(`["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3BucketName":"payloads","s3Key":"abc"}]`)
+	if assert.True(t, ok) {
+		assert.Equal(t, &s3Pointer{Bucket: "payloads", Key: "abc"}, pointer)
+	}
+
+	_, ok = parseS3Pointer(`["com.amazon.sqs.javamessaging.MessageS3Pointer",{"s3BucketName":"payloads","s3Key":"abc"}]`)
+	assert.True(t, ok)
+
+	for _, body := range []string{
+		`{"s3BucketName":"payloads","s3Key":"abc"}`,
+		`["other.Class",{"s3BucketName":"payloads","s3Key":"abc"}]`,
+		`["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3BucketName":"payloads"}]`,
+		`plain body`,
+	} {
+		_, ok := parseS3Pointer(body)
+		assert.False(t, ok, body)
+	}
+}
+
+func TestSupervisorResolveS3Pointers(t *testing.T) {
+	var bodies []string
+	var sizeHeaders []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		sizeHeaders = append(sizeHeaders, r.Header.Get("X-Aws-Sqsd-Attr-ExtendedPayloadSize"))
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	store := &mockS3{objects: map[string]string{"payloads/abc": "large payload"}}
+	mockSQS := &mockSQS{}
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+		HTTPURL:           ts.URL,
+		ResolveS3Pointers: true,
+		DeleteS3Payloads:  true,
+		S3:                store,
+	})
+
+	receiveCount := 0
+	mockSQS.receiveMessageFunc = func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		receiveCount++
+
+		if receiveCount > 1 {
+			supervisor.Shutdown()
+			return &sqs.ReceiveMessageOutput{}, nil
+		}
+
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String(`["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3BucketName":"payloads","s3Key":"abc"}]`),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+				MessageAttributes: map[string]types.MessageAttributeValue{
+					"ExtendedPayloadSize": {DataType: aws.String("Number"), StringValue: aws.String("13")},
+				},
+			}, {
+				Body:          aws.String(`["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3BucketName":"payloads","s3Key":"missing"}]`),
+				MessageId:     aws.String("m2"),
+				ReceiptHandle: aws.String("r2"),
+			}, {
+				Body:          aws.String("small payload"),
+				MessageId:     aws.String("m3"),
+				ReceiptHandle: aws.String("r3"),
+			}},
+		}, nil
+	}
+
+	var deleted []string
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		for _, entry := range input.Entries {
+			deleted = append(deleted, *entry.Id)
+		}
+		return &sqs.DeleteMessageBatchOutput{}, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	assert.ElementsMatch(t, []string{"large payload", "small payload"}, bodies)
+	assert.Equal(t, []string{"", ""}, sizeHeaders)
+	assert.ElementsMatch(t, []string{"m1", "m3"}, deleted, "a message whose payload can't be fetched is left in the queue")
+	assert.Equal(t, []string{"payloads/abc"}, store.deleted)
+	assert.Equal(t, int64(1), supervisor.Report().FailureReasons[FailurePayloadError])
+}
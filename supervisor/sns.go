@@ -0,0 +1,68 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// Headers carrying the metadata of unwrapped SNS notifications. The topic ARN
+// and message ID headers are those of SNS HTTP deliveries.
+const (
+	snsTopicARNHeader  = "X-Amz-Sns-Topic-Arn"
+	snsMessageIDHeader = "X-Amz-Sns-Message-Id"
+	snsSubjectHeader   = "X-Amz-Sns-Subject"
+)
+
+// snsEnvelope is the JSON envelope of SNS notifications delivered to a queue
+// without raw message delivery.
+type snsEnvelope struct {
+	Type      string
+	MessageId string
+	TopicArn  string
+	Subject   string
+	Message   *string
+}
+
+// parseSNSEnvelope returns the SNS notification envelope body is, or nil if
+// it is not one.
+func parseSNSEnvelope(body string) *snsEnvelope {
+	if !strings.HasPrefix(strings.TrimSpace(body), "{") {
+		return nil
+	}
+
+	var env snsEnvelope
+	if err := json.Unmarshal([]byte(body), &env); err != nil {
+		return nil
+	}
+
+	if env.Type != "Notification" || len(env.TopicArn) == 0 || env.Message == nil {
+		return nil
+	}
+
+	return &env
+}
+
+// snsEnvelope returns the SNS notification envelope of msg with UnwrapSNS, or
+// nil.
+func (s *Supervisor) snsEnvelope(msg *types.Message) *snsEnvelope {
+	if !s.workerConfig.UnwrapSNS {
+		return nil
+	}
+
+	return parseSNSEnvelope(aws.ToString(msg.Body))
+}
+
+// addSNSHeaders sets the SNS metadata headers of env. The subject header is
+// left out when the notification has none.
+func addSNSHeaders(env *snsEnvelope, header http.Header) {
+	header.Set(snsTopicARNHeader, env.TopicArn)
+	header.Set(snsMessageIDHeader, env.MessageId)
+
+	if len(env.Subject) > 0 {
+		header.Set(snsSubjectHeader, env.Subject)
+	}
+}
@@ -0,0 +1,83 @@
+package supervisor
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+const snsNotification = `{
+  "Type": "Notification",
+  "MessageId": "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+  "TopicArn": "arn:aws:sns:us-east-1:123456789012:orders",
+  "Subject": "Order placed",
+  "Message": "{\"id\":42}",
+  "Timestamp": "2021-01-01T00:00:00.000Z"
+}`
+
+func TestParseSNSEnvelope(t *testing.T) {
+	env := parseSNSEnvelope(snsNotification)
+	if assert.NotNil(t, env) {
+		assert.Equal(t, "arn:aws:sns:us-east-1:123456789012:orders", env.TopicArn)
+		assert.Equal(t, `{"id":42}`, *env.Message)
+	}
+
+	for _, body := range []string{
+		"plain text",
+		`{"id":42}`,
+		`{"Type":"SubscriptionConfirmation","TopicArn":"arn:aws:sns:us-east-1:123456789012:orders","Message":"confirm"}`,
+		`{"Type":"Notification","TopicArn":"arn:aws:sns:us-east-1:123456789012:orders"}`,
+		`{"Type":"Notification",`,
+	} {
+		assert.Nil(t, parseSNSEnvelope(body), body)
+	}
+}
+
+func TestSupervisorUnwrapSNS(t *testing.T) {
+	var (
+		body   string
+		header http.Header
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body, header = string(b), r.Header
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:   ts.URL,
+		UnwrapSNS: true,
+	})
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	result := supervisor.processMessage(ctx, supervisor.queues[0], &types.Message{
+		Body:          aws.String(snsNotification),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+	})
+	assert.Equal(t, "delivered", result.status)
+	assert.Equal(t, `{"id":42}`, body)
+	assert.Equal(t, "arn:aws:sns:us-east-1:123456789012:orders", header.Get("X-Amz-Sns-Topic-Arn"))
+	assert.Equal(t, "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324", header.Get("X-Amz-Sns-Message-Id"))
+	assert.Equal(t, "Order placed", header.Get("X-Amz-Sns-Subject"))
+
+	result = supervisor.processMessage(ctx, supervisor.queues[0], &types.Message{
+		Body:          aws.String(`{"id":43}`),
+		MessageId:     aws.String("m2"),
+		ReceiptHandle: aws.String("r2"),
+	})
+	assert.Equal(t, "delivered", result.status)
+	assert.Equal(t, `{"id":43}`, body)
+	assert.Empty(t, header.Get("X-Amz-Sns-Topic-Arn"))
+}
@@ -0,0 +1,32 @@
+package supervisor
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// addSQSDHeaders sets the metadata headers sent by the Elastic Beanstalk
+// SQS daemon. Headers whose attribute is missing from msg are left out.
+func addSQSDHeaders(q *queue, msg *types.Message, header http.Header) {
+	header.Set("X-Aws-Sqsd-Queue", queueName(q.url))
+
+	if sent, ok := sentTimestamp(msg); ok {
+		header.Set("X-Aws-Sqsd-First-Received-At", sent.UTC().Format(time.RFC3339))
+	}
+
+	if count := msg.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)]; len(count) > 0 {
+		header.Set("X-Aws-Sqsd-Receive-Count", count)
+	}
+
+	if sender := msg.Attributes[string(types.MessageSystemAttributeNameSenderId)]; len(sender) > 0 {
+		header.Set("X-Aws-Sqsd-Sender-Id", sender)
+	}
+}
+
+// queueName returns the name of the queue at queueURL.
+func queueName(queueURL string) string {
+	return queueURL[strings.LastIndex(queueURL, "/")+1:]
+}
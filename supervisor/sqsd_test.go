@@ -0,0 +1,87 @@
+package supervisor
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorSQSDHeaders(t *testing.T) {
+	var header http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		QueueURL:        "https://sqs.us-east-1.amazonaws.com/123456789012/jobs",
+		HTTPURL:         ts.URL,
+		EmitSQSDHeaders: true,
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	receiveCount := 0
+	mockSQS.receiveMessageFunc = func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		receiveCount++
+
+		assert.Equal(t, []types.MessageSystemAttributeName{types.MessageSystemAttributeNameAll}, input.MessageSystemAttributeNames)
+
+		if receiveCount > 1 {
+			supervisor.Shutdown()
+			return &sqs.ReceiveMessageOutput{}, nil
+		}
+
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String("message"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+				Attributes: map[string]string{
+					string(types.MessageSystemAttributeNameSentTimestamp):           "1609459200000",
+					string(types.MessageSystemAttributeNameApproximateReceiveCount): "3",
+					string(types.MessageSystemAttributeNameSenderId):                "AIDASENDER",
+				},
+			}},
+		}, nil
+	}
+
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		return &sqs.DeleteMessageBatchOutput{}, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	if assert.NotNil(t, header) {
+		assert.Equal(t, "m1", header.Get("X-Aws-Sqsd-Msgid"))
+		assert.Equal(t, "jobs", header.Get("X-Aws-Sqsd-Queue"))
+		assert.Equal(t, "2021-01-01T00:00:00Z", header.Get("X-Aws-Sqsd-First-Received-At"))
+		assert.Equal(t, "3", header.Get("X-Aws-Sqsd-Receive-Count"))
+		assert.Equal(t, "AIDASENDER", header.Get("X-Aws-Sqsd-Sender-Id"))
+	}
+}
+
+func TestAddSQSDHeadersMissingAttributes(t *testing.T) {
+	header := http.Header{}
+	addSQSDHeaders(&queue{url: "https://queue.url/jobs"}, &types.Message{MessageId: aws.String("m1")}, header)
+
+	assert.Equal(t, http.Header{"X-Aws-Sqsd-Queue": {"jobs"}}, header)
+}
+
+func TestSupervisorNoSQSDHeaders(t *testing.T) {
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{})
+
+	assert.NotContains(t, supervisor.receiveAttributeNames(), types.MessageSystemAttributeNameAll)
+}
@@ -0,0 +1,122 @@
+package supervisor
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// StatusCodes is a set of HTTP status codes made of ranges.
+type StatusCodes []StatusCodeRange
+
+// StatusCodeRange is an inclusive range of HTTP status codes.
+type StatusCodeRange struct {
+	Min, Max int
+}
+
+// defaultSuccessStatusCodes are the status codes that mean a message was
+// delivered when WorkerConfig.SuccessStatusCodes is not set.
+var defaultSuccessStatusCodes = StatusCodes{{http.StatusOK, http.StatusIMUsed}}
+
+// defaultRetryStatusCodes are the status codes retried when
+// WorkerConfig.RetryStatusCodes is not set.
+var defaultRetryStatusCodes = StatusCodes{{http.StatusInternalServerError, 599}}
+
+// ParseStatusCodes parses a comma separated list of status codes and ranges,
+// e.g. "200-299,302".
+func ParseStatusCodes(value string) (StatusCodes, error) {
+	var codes StatusCodes
+
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+
+		lo, hi := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			lo, hi = part[:i], part[i+1:]
+		}
+
+		min, err := parseStatusCode(lo)
+		if err != nil {
+			return nil, err
+		}
+		max, err := parseStatusCode(hi)
+		if err != nil {
+			return nil, err
+		}
+		if min > max {
+			return nil, fmt.Errorf("invalid status code range %q", part)
+		}
+
+		codes = append(codes, StatusCodeRange{Min: min, Max: max})
+	}
+
+	if len(codes) == 0 {
+		return nil, fmt.Errorf("no status codes in %q", value)
+	}
+
+	return codes, nil
+}
+
+func parseStatusCode(value string) (int, error) {
+	code, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || code < 100 || code > 599 {
+		return 0, fmt.Errorf("invalid status code %q", value)
+	}
+
+	return code, nil
+}
+
+// Contains reports whether code is in the set.
+func (c StatusCodes) Contains(code int) bool {
+	for _, r := range c {
+		if code >= r.Min && code <= r.Max {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ContainsRange reports whether any code between min and max is in the set.
+func (c StatusCodes) ContainsRange(min, max int) bool {
+	for _, r := range c {
+		if r.Min <= max && r.Max >= min {
+			return true
+		}
+	}
+
+	return false
+}
+
+// successful reports whether a response with code means the message was
+// delivered.
+func (s *Supervisor) successful(code int) bool {
+	if len(s.workerConfig.SuccessStatusCodes) == 0 {
+		return defaultSuccessStatusCodes.Contains(code)
+	}
+
+	return s.workerConfig.SuccessStatusCodes.Contains(code)
+}
+
+// retriedStatus reports whether a response with code is retried.
+func (s *Supervisor) retriedStatus(code int) bool {
+	if len(s.workerConfig.RetryStatusCodes) == 0 {
+		return defaultRetryStatusCodes.Contains(code)
+	}
+
+	return s.workerConfig.RetryStatusCodes.Contains(code)
+}
+
+// discarded reports whether a response with code means the message should be
+// deleted without being delivered again.
+func (s *Supervisor) discarded(code int) bool {
+	if rule, ok := s.statusRule(code); ok {
+		return rule.Action == StatusActionDrop
+	}
+
+	return !s.successful(code) && s.workerConfig.DiscardStatusCodes.Contains(code)
+}
@@ -0,0 +1,110 @@
+package supervisor
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStatusCodes(t *testing.T) {
+	codes, err := ParseStatusCodes("200-299, 302")
+	assert.NoError(t, err)
+	assert.Equal(t, StatusCodes{{200, 299}, {302, 302}}, codes)
+
+	assert.True(t, codes.Contains(204))
+	assert.True(t, codes.Contains(302))
+	assert.False(t, codes.Contains(301))
+	assert.True(t, codes.ContainsRange(300, 399))
+	assert.False(t, StatusCodes{{200, 299}}.ContainsRange(300, 399))
+
+	for _, value := range []string{"", ",", "abc", "600", "299-200", "200-", "99"} {
+		_, err := ParseStatusCodes(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestSupervisorSuccessAndDiscardStatusCodes(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		switch r.URL.Path {
+		case "/accepted":
+			w.WriteHeader(http.StatusFound)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}, WorkerConfig{
+		HTTPURL:            ts.URL,
+		HTTPPathAttribute:  "path",
+		HTTPMaxRetries:     2,
+		HTTPRetryBackoff:   time.Millisecond,
+		SuccessStatusCodes: StatusCodes{{200, 299}, {302, 302}},
+		DiscardStatusCodes: StatusCodes{{404, 404}, {503, 503}},
+	})
+
+	tests := []struct {
+		path        string
+		disposition disposition
+		status      string
+		requests    int32
+	}{
+		{"ok", dispositionDelete, "delivered", 1},
+		{"accepted", dispositionDelete, "delivered", 1},
+		{"missing", dispositionDelete, "discarded", 1},
+		{"unavailable", dispositionDelete, "discarded", 1},
+	}
+
+	for _, test := range tests {
+		requests.Store(0)
+
+		ctx, cancel := supervisor.inFlightContext(time.Now())
+		result := supervisor.processMessage(ctx, supervisor.queues[0], &types.Message{
+			Body:          aws.String("message"),
+			MessageId:     aws.String(test.path),
+			ReceiptHandle: aws.String("r1"),
+			MessageAttributes: map[string]types.MessageAttributeValue{
+				"path": {DataType: aws.String("String"), StringValue: aws.String(test.path)},
+			},
+		})
+		cancel()
+
+		assert.Equal(t, test.disposition, result.disposition, test.path)
+		assert.Equal(t, test.status, result.status, test.path)
+		assert.Equal(t, test.requests, requests.Load(), test.path)
+	}
+}
+
+func TestSupervisorDefaultSuccessStatusCodes(t *testing.T) {
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		DiscardStatusCodes: StatusCodes{{200, 499}},
+	})
+
+	assert.True(t, supervisor.successful(http.StatusOK))
+	assert.True(t, supervisor.successful(http.StatusIMUsed))
+	assert.False(t, supervisor.successful(http.StatusMultipleChoices))
+
+	assert.False(t, supervisor.discarded(http.StatusOK))
+	assert.True(t, supervisor.discarded(http.StatusBadRequest))
+	assert.False(t, supervisor.discarded(http.StatusInternalServerError))
+}
@@ -0,0 +1,95 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// StatusAction is what happens to a message after a worker response matching
+// a StatusRule.
+type StatusAction string
+
+const (
+	// StatusActionDelete deletes the message as if it was delivered.
+	StatusActionDelete StatusAction = "delete"
+	// StatusActionRetry leaves the message in the queue to be delivered again,
+	// after the VisibilityTimeout of the rule when set.
+	StatusActionRetry StatusAction = "retry"
+	// StatusActionErrorQueue moves the message to the error queue at once,
+	// whatever its receive count.
+	StatusActionErrorQueue StatusAction = "dlq"
+	// StatusActionDrop deletes the message as a failed delivery, like
+	// DiscardStatusCodes.
+	StatusActionDrop StatusAction = "drop"
+)
+
+// StatusRule decides what happens to a message after a worker response with
+// one of its status codes.
+type StatusRule struct {
+	StatusCodes StatusCodes
+	Action      StatusAction
+	// VisibilityTimeout, when set with StatusActionRetry, is how long the
+	// message stays invisible before it is delivered again.
+	VisibilityTimeout time.Duration
+}
+
+// ParseStatusPolicy parses a JSON array of status rules, e.g.
+// [{"status": "400,422", "action": "dlq"},
+// {"status": "429", "action": "retry", "visibilityTimeout": 60}].
+// Visibility timeouts are in seconds.
+func ParseStatusPolicy(value string) ([]StatusRule, error) {
+	var raw []struct {
+		Status            string       `json:"status"`
+		Action            StatusAction `json:"action"`
+		VisibilityTimeout int64        `json:"visibilityTimeout"`
+	}
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, err
+	}
+
+	rules := make([]StatusRule, 0, len(raw))
+	for i, r := range raw {
+		codes, err := ParseStatusCodes(r.Status)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d has invalid status codes: %s", i, err)
+		}
+
+		switch r.Action {
+		case StatusActionDelete, StatusActionErrorQueue, StatusActionDrop:
+			if r.VisibilityTimeout != 0 {
+				return nil, fmt.Errorf("rule %d has a visibility timeout but does not retry", i)
+			}
+		case StatusActionRetry:
+			if r.VisibilityTimeout < 0 || r.VisibilityTimeout > int64(maxVisibility/time.Second) {
+				return nil, fmt.Errorf("rule %d has an invalid visibility timeout: must be between 0 and 43200", i)
+			}
+		default:
+			return nil, fmt.Errorf("rule %d has an invalid action %q: must be delete, retry, dlq or drop", i, r.Action)
+		}
+
+		rules = append(rules, StatusRule{
+			StatusCodes:       codes,
+			Action:            r.Action,
+			VisibilityTimeout: time.Duration(r.VisibilityTimeout) * time.Second,
+		})
+	}
+
+	return rules, nil
+}
+
+// statusRule returns the first rule of StatusPolicy matching a response with
+// code. Responses meaning the message was delivered match no rule.
+func (s *Supervisor) statusRule(code int) (StatusRule, bool) {
+	if s.successful(code) {
+		return StatusRule{}, false
+	}
+
+	for _, rule := range s.workerConfig.StatusPolicy {
+		if rule.StatusCodes.Contains(code) {
+			return rule, true
+		}
+	}
+
+	return StatusRule{}, false
+}
@@ -0,0 +1,108 @@
+package supervisor
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStatusPolicy(t *testing.T) {
+	rules, err := ParseStatusPolicy(`[{"status": "400,422", "action": "dlq"}, {"status": "429", "action": "retry", "visibilityTimeout": 60}]`)
+	assert.NoError(t, err)
+	assert.Equal(t, []StatusRule{
+		{StatusCodes: StatusCodes{{Min: 400, Max: 400}, {Min: 422, Max: 422}}, Action: StatusActionErrorQueue},
+		{StatusCodes: StatusCodes{{Min: 429, Max: 429}}, Action: StatusActionRetry, VisibilityTimeout: time.Minute},
+	}, rules)
+
+	for _, value := range []string{
+		`{"status": "400"}`,
+		`[{"status": "", "action": "drop"}]`,
+		`[{"status": "700", "action": "drop"}]`,
+		`[{"status": "400", "action": "ignore"}]`,
+		`[{"status": "400", "action": "drop", "visibilityTimeout": 60}]`,
+		`[{"status": "429", "action": "retry", "visibilityTimeout": 43201}]`,
+	} {
+		_, err := ParseStatusPolicy(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestSupervisorStatusPolicy(t *testing.T) {
+	requests := make(map[int]int)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		code, _ := strconv.Atoi(string(body))
+		requests[code]++
+
+		if code == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "5")
+		}
+		w.WriteHeader(code)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	topic := &mockSNS{}
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:            ts.URL,
+		HTTPMaxRetries:     2,
+		HTTPRetryBackoff:   time.Millisecond,
+		DiscardStatusCodes: StatusCodes{{Min: 410, Max: 410}},
+		ErrorDestination:   NewSNSForwarder(topic, "arn:aws:sns:us-east-1:123456789012:failures"),
+		StatusPolicy: []StatusRule{
+			{StatusCodes: StatusCodes{{Min: 404, Max: 404}}, Action: StatusActionDelete},
+			{StatusCodes: StatusCodes{{Min: 400, Max: 400}, {Min: 422, Max: 422}}, Action: StatusActionErrorQueue},
+			{StatusCodes: StatusCodes{{Min: 429, Max: 429}}, Action: StatusActionRetry, VisibilityTimeout: time.Minute},
+			{StatusCodes: StatusCodes{{Min: 410, Max: 410}}, Action: StatusActionRetry},
+			{StatusCodes: StatusCodes{{Min: 501, Max: 501}}, Action: StatusActionDrop},
+			{StatusCodes: StatusCodes{{Min: 200, Max: 200}}, Action: StatusActionDrop},
+		},
+	})
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+
+	for _, c := range []struct {
+		code              int
+		status            string
+		reason            FailureReason
+		disposition       disposition
+		visibilityTimeout int64
+		errorQueue        bool
+		requests          int
+	}{
+		{http.StatusOK, "delivered", "", dispositionDelete, 0, false, 1},
+		{http.StatusNotFound, "delivered", "", dispositionDelete, 0, false, 1},
+		{http.StatusUnprocessableEntity, "", FailureHTTP4xx, dispositionRetry, 0, true, 1},
+		{http.StatusTooManyRequests, "", FailureHTTP4xx, dispositionChangeVisibility, 60, false, 1},
+		{http.StatusGone, "", FailureHTTP4xx, dispositionRetry, 0, false, 1},
+		{http.StatusNotImplemented, "discarded", FailureHTTP5xx, dispositionDelete, 0, false, 1},
+		{http.StatusInternalServerError, "", FailureHTTP5xx, dispositionRetry, 0, false, 3},
+	} {
+		msg := &types.Message{
+			Body:          aws.String(strconv.Itoa(c.code)),
+			MessageId:     aws.String("m"),
+			ReceiptHandle: aws.String("r"),
+			Attributes: map[string]string{
+				string(types.MessageSystemAttributeNameApproximateReceiveCount): "1",
+			},
+		}
+
+		name := strconv.Itoa(c.code)
+		result := supervisor.processMessage(ctx, supervisor.queues[0], msg)
+		assert.Equal(t, c.status, result.status, name)
+		assert.Equal(t, c.reason, result.reason, name)
+		assert.Equal(t, c.disposition, result.disposition, name)
+		assert.Equal(t, c.visibilityTimeout, result.visibilityTimeout, name)
+		assert.Equal(t, c.errorQueue, supervisor.shouldMoveToErrorQueue(result), name)
+		assert.Equal(t, c.requests, requests[c.code], name)
+	}
+}
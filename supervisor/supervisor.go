@@ -2,35 +2,102 @@ package supervisor
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
+	"crypto/md5"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
-	"errors"
 	"fmt"
+	"hash"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/sqs"
-	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	log "github.com/sirupsen/logrus"
 )
 
 type Supervisor struct {
-	sync.Mutex
-
-	logger        *log.Entry
-	sqs           sqsiface.SQSAPI
-	httpClient    httpClient
-	workerConfig  WorkerConfig
-	hmacSignature string
+	logger       *log.Entry
+	sqs          SQSClient
+	httpClient   HTTPClient
+	workerConfig WorkerConfig
 
 	startOnce sync.Once
 	wg        sync.WaitGroup
 
-	shutdown bool
+	startedAt         time.Time
+	firstDeliveryOnce sync.Once
+
+	publisher          OutcomePublisher
+	outcomes           chan Outcome
+	outcomesDone       chan struct{}
+	closePublisherOnce sync.Once
+
+	fifoGroups chan struct{}
+
+	delivered *deliveredCache
+
+	queues      []*queue
+	queueCursor uint64
+	// failover picks the queue to receive from with QueueScheduleFailover.
+	failover *queueFailover
+
+	deleteFailuresMu  sync.Mutex
+	deleteFailures    int
+	deletePausedUntil time.Time
+
+	workerHealthy atomic.Bool
+
+	runningWorkers atomic.Int32
+	// paused stops workers from receiving, see Pause.
+	paused atomic.Bool
+
+	// workersMu guards targetWorkers, the number of workers set by Start or
+	// SetWorkers, aliveWorkers, the IDs of the workers running, and
+	// emptyReceives, the consecutive empty receives of each worker, see
+	// ExitAfterEmptyReceives.
+	workersMu     sync.Mutex
+	targetWorkers int
+	aliveWorkers  map[int]bool
+	emptyReceives map[int]int
+	// lastReceive is when messages were last received successfully, in Unix
+	// nanoseconds, or zero if they never were.
+	lastReceive atomic.Int64
+
+	ramp *rampLimiter
+
+	requests *requestLimiter
+	capacity *messageCapacity
+	breaker  *circuitBreaker
+	pollers  *pollerGate
+
+	backpressure *backpressure
+
+	stats *stats
+
+	errorQueue Deliverer
+
+	// pendingAcks holds the messages awaiting an acknowledgment by ID, see
+	// Ack.
+	acksMu      sync.Mutex
+	pendingAcks map[string]*pendingAck
+
+	shutdown     atomic.Bool
+	done         chan struct{}
+	shutdownOnce sync.Once
+
+	// receiveCtx is canceled on shutdown to abort long polls in progress.
+	receiveCtx    context.Context
+	stopReceiving context.CancelFunc
 }
 
 type WorkerConfig struct {
@@ -38,200 +105,1832 @@ type WorkerConfig struct {
 	QueueMaxMessages int
 	QueueWaitTime    int
 
+	// VisibilityTimeout, when set, is requested for every received message
+	// instead of the queue's default.
+	VisibilityTimeout time.Duration
+	// QueueVisibilityTimeouts are the default visibility timeouts of the
+	// queues, by URL, e.g. read from their attributes at startup. They bound
+	// the processing of messages like VisibilityTimeout when it is unset.
+	QueueVisibilityTimeouts map[string]time.Duration
+	// VisibilityDeadline cancels every request to the worker
+	// VisibilityDeadlineMargin before the visibility of its message expires,
+	// so that the worker doesn't keep processing a message SQS may have
+	// redelivered.
+	VisibilityDeadline       bool
+	VisibilityDeadlineMargin time.Duration
+
+	// ReceiveAttributeNames are message system attributes requested on every
+	// receive on top of those the supervisor needs, e.g. SenderId, or "All".
+	ReceiveAttributeNames []string
+	// ReceiveMessageAttributeNames, when set, are the only message attributes
+	// requested on every receive instead of all of them. Names ending with
+	// ".*" request every attribute with that prefix.
+	ReceiveMessageAttributeNames []string
+	// ReceiveRequestAttemptID sets a ReceiveRequestAttemptId on every receive
+	// from a FIFO queue, reused after the receive failed, so that messages
+	// received by a request whose response was lost are received again at
+	// once.
+	ReceiveRequestAttemptID bool
+
+	// VisibilityExtensionInterval, when set, extends the visibility timeout
+	// of every message at that interval while it is being delivered, up to
+	// VisibilityMax after it was received (12 hours when unset).
+	VisibilityExtensionInterval time.Duration
+	VisibilityMax               time.Duration
+
+	// StartupDelay is how long workers wait after Start before receiving
+	// their first messages.
+	StartupDelay time.Duration
+
+	// QueueURLs, when set, replaces QueueURL with several queues polled by the
+	// same workers according to QueueSchedule.
+	QueueURLs     []string
+	QueueSchedule QueueSchedule
+	// FailoverAfter is how long receives from the active queue must keep
+	// failing before QueueScheduleFailover switches to the next queue, and
+	// FailbackInterval how often the first queue is tried again meanwhile.
+	// Empty use DefaultFailoverAfter and DefaultFailbackInterval.
+	FailoverAfter    time.Duration
+	FailbackInterval time.Duration
+
+	// Queues, when set, replaces QueueURL and QueueURLs with queues that may
+	// each be delivered to their own URL, with their own Content-Type and
+	// HMAC secret key.
+	Queues []QueueConfig
+
 	HTTPURL         string
 	HTTPContentType string
 
-	HTTPHMACHeader string
-	HMACSecretKey  []byte
+	// HTTPTimeout, when set, bounds every request to the worker. A request
+	// that times out leaves its message in the queue.
+	HTTPTimeout time.Duration
+
+	// HTTPMaxRetries is how many times a delivery that failed or got a 5xx
+	// response is retried, waiting HTTPRetryBackoff before the first retry
+	// and doubling it for every retry after that, up to HTTPRetryMaxBackoff
+	// or DefaultHTTPRetryMaxBackoff. Retries never run past the processing
+	// deadline of the message.
+	HTTPMaxRetries      int
+	HTTPRetryBackoff    time.Duration
+	HTTPRetryMaxBackoff time.Duration
+
+	// Deliverer, when set, delivers messages instead of an HTTP request to
+	// HTTPURL.
+	Deliverer Deliverer
+
+	// HTTPAccept is sent as the Accept header. HTTPAcceptPolicy decides how a
+	// response with a different Content-Type is handled.
+	HTTPAccept       string
+	HTTPAcceptPolicy ContentTypePolicy
+
+	// HTTPMaxResponseBody, when positive, is the most bytes read from a
+	// response body, which is then kept in memory for inspection. A larger
+	// body fails the delivery. Otherwise the body is not read.
+	HTTPMaxResponseBody int64
+
+	// HTTPErrorBodyLimit, when positive, is the most bytes of the body of a
+	// non-successful response that are logged as responseBody.
+	HTTPErrorBodyLimit int64
+
+	// HTTPRetryHeader names a response header a worker can set to "true" to
+	// have a message with a successful status code left for redelivery.
+	HTTPRetryHeader string
+
+	// Routes send messages to their own URL depending on their attributes.
+	// The first route a message takes decides its URL. Messages taking no
+	// route are delivered to the URL of their queue.
+	Routes []Route
+
+	// HTTPPathAttribute names a message attribute whose value, sanitized by
+	// HTTPPathSanitizer, is appended to HTTPURL as an extra path segment.
+	HTTPPathAttribute string
+	HTTPPathSanitizer PathSanitizer
+
+	// CorrelationIDHeader, when set, carries an ID hashed from the message body
+	// (and its attributes with CorrelationIDAttributes).
+	CorrelationIDHeader     string
+	CorrelationIDAttributes bool
+
+	// RequestIDHeader, when set, carries an ID unique to every message: the
+	// value of its RequestIDAttribute message attribute if it has one, and
+	// its message ID otherwise.
+	RequestIDHeader    string
+	RequestIDAttribute string
+
+	// AttributeHeaderPrefix is prepended to the name of every String and
+	// Number message attribute forwarded as a header. Empty uses
+	// DefaultAttributeHeaderPrefix, NoAttributeHeaderPrefix uses the
+	// attribute names as they are.
+	AttributeHeaderPrefix string
+
+	// DeadlineHeader, when set, carries the time at which the daemon gives up
+	// on the message, based on MaxInFlight and VisibilityTimeout, or the
+	// deadline of the request with VisibilityDeadline.
+	DeadlineHeader string
+
+	// RedeliveryHeader, when set, carries "true" if the message was received
+	// before and "false" on its first delivery.
+	RedeliveryHeader string
+
+	// XRayEnabled sends an X-Ray segment for every delivery to the daemon at
+	// XRayDaemonAddress and passes the trace to the worker in X-Amzn-Trace-Id.
+	XRayEnabled       bool
+	XRayDaemonAddress string
+
+	// Tracer exports an OpenTelemetry span for every delivery and passes the
+	// trace to the worker in traceparent. Traces started by the producer in
+	// the traceparent message attribute are continued.
+	Tracer *Tracer
+
+	// HTTPHMACHeader carries the HMAC of requests signed with HMACSecretKey,
+	// computed with HMACAlgorithm over what HMACSignatureMode selects, or
+	// DefaultHMACHeader.
+	HTTPHMACHeader    string
+	HMACSecretKey     []byte
+	HMACSignatureMode SignatureMode
+	HMACAlgorithm     HMACAlgorithm
+
+	// HMACReplayProtection sends the time and a random nonce with every
+	// signed request, in the X-Sqsd-Signature-Timestamp and
+	// X-Sqsd-Signature-Nonce headers, and signs them ahead of the rest.
+	HMACReplayProtection bool
+
+	// HMACSignatureFormat, when set, is the string signed instead of the one
+	// HMACSignatureMode selects, with the placeholders of
+	// SignaturePlaceholders replaced, e.g. "{timestamp}.{body}". The
+	// timestamp and nonce are only signed where it places them, even with
+	// HMACReplayProtection.
+	HMACSignatureFormat string
+	// HMACHeaderFormat, when set, is the value of HTTPHMACHeader, with
+	// "{signature}" replaced by the hex-encoded HMAC and "{timestamp}" and
+	// "{nonce}" by those signed, e.g. "sha256={signature}".
+	HMACHeaderFormat string
+
+	// Authenticator authenticates requests to the worker once every other
+	// header is set, e.g. with NewSigV4Authenticator.
+	Authenticator Authenticator
+
+	// EmitSQSDHeaders sends the X-Aws-Sqsd-Queue, X-Aws-Sqsd-First-Received-At,
+	// X-Aws-Sqsd-Receive-Count and X-Aws-Sqsd-Sender-Id headers of the
+	// Elastic Beanstalk SQS daemon. All system attributes are then received.
+	EmitSQSDHeaders bool
+
+	// HTTPMethod is the method of requests to the worker, POST when unset.
+	HTTPMethod string
+
+	// BodyTemplate, when set, is executed with the BodyTemplateData of every
+	// message to make the body of its request instead of sending the message
+	// body as it is. See ParseBodyTemplate.
+	BodyTemplate *template.Template
+
+	// BodyEnvelope, when set, sends a JSON envelope holding the message body,
+	// ID, receipt handle, receive count, sent timestamp and attributes as the
+	// body of every request, with the application/json Content-Type. It takes
+	// precedence over BodyTemplate, and every system attribute is received.
+	BodyEnvelope bool
+
+	// ExtraHeaders are added to every request to the worker. They never
+	// replace the HMAC header or the other headers set by the supervisor.
+	ExtraHeaders map[string]string
+
+	DeleteMaxRetries int
+	DeleteRetryDelay time.Duration
+
+	// VisibilityBatchConcurrency is how many ChangeMessageVisibilityBatch
+	// calls of up to 10 entries are made at the same time.
+	VisibilityBatchConcurrency int
+
+	// DeleteFailureThreshold consecutive batches that could not be deleted
+	// pause receiving for DeleteFailureBackoff. Zero disables pausing.
+	DeleteFailureThreshold int
+	DeleteFailureBackoff   time.Duration
+
+	// CircuitFailureThreshold consecutive deliveries that could not reach the
+	// worker, timed out or got a 5xx status code open the circuit breaker:
+	// deliveries and receiving stop for CircuitOpenDuration, after which a
+	// single delivery probes the worker. Zero disables the circuit breaker.
+	CircuitFailureThreshold int
+	CircuitOpenDuration     time.Duration
+
+	// BackpressureLatency and BackpressureInFlight scale down how many
+	// messages workers receive at a time while the p99 latency of deliveries
+	// exceeds BackpressureLatency or BackpressureInFlight deliveries are in
+	// flight, halving it every second down to pausing receiving, and scale it
+	// back up by one message a second once the worker recovered. Zero
+	// disables either.
+	BackpressureLatency  time.Duration
+	BackpressureInFlight int
+
+	// RetryAfterMax caps the Retry-After delay honored on 429 and 503
+	// responses, 12 hours when unset or larger.
+	RetryAfterMax time.Duration
+
+	// ReceiveErrorMaxBackoff caps how long a worker waits before receiving
+	// again after consecutive ReceiveMessage errors. Empty uses
+	// DefaultReceiveErrorMaxBackoff.
+	ReceiveErrorMaxBackoff time.Duration
+	// ReceiveErrorAlertAfter is how long a worker fails to receive messages
+	// before it logs an error and counts in the receive_failing_workers
+	// metric, until it receives again. Zero disables it.
+	ReceiveErrorAlertAfter time.Duration
+
+	Metrics *Metrics
+
+	// OutcomePublisher receives the outcome of every delivery. Outcomes are
+	// queued in a buffer of OutcomeBufferSize and published asynchronously.
+	OutcomePublisher  OutcomePublisher
+	OutcomeBufferSize int
+
+	// FIFO processes messages sharing a MessageGroupId strictly in order. At
+	// most FIFOMaxGroups groups are processed concurrently across all workers.
+	FIFO          bool
+	FIFOMaxGroups int
+
+	// MaxInFlightBatches is how many received batches a worker processes at
+	// the same time. A worker stops receiving while it has that many in
+	// flight. Values below 2 process one batch at a time.
+	MaxInFlightBatches int
+
+	// MaxInFlightMessages, when set, bounds how many received messages are
+	// being processed at the same time across all workers. Workers ask for no
+	// more messages than are free, and wait to receive while none are.
+	MaxInFlightMessages int
+
+	// MinPollers, when set, lets fewer workers long poll an idle queue: each
+	// run of PollerIdleReceives consecutive empty receives lets one fewer
+	// worker poll, down to MinPollers. As soon as messages are received, up
+	// to MaxPollers workers, all of them by default, poll again.
+	MinPollers         int
+	MaxPollers         int
+	PollerIdleReceives int
+
+	// ExitAfterEmptyReceives, when set, shuts the supervisor down once each
+	// of its workers made that many consecutive receives returning no
+	// message while none was being processed or awaiting an acknowledgment,
+	// e.g. for a batch job to drain a queue and exit. Workers that reached it
+	// wait for the others, and all poll again when any receives a message.
+	ExitAfterEmptyReceives int
+
+	// DeliveryBatchSize, when above 1, delivers messages to the HTTP worker
+	// in requests of up to that many messages instead of one request per
+	// message. The request body is a JSON array of objects with the id, body,
+	// attributes and receiveCount of every message, and the response body a
+	// JSON array of objects with the id and statusCode, and optionally the
+	// visibilityTimeout, of every message. Each status code is handled like
+	// the status code of a request for that message alone. A successful
+	// response without a body delivers every message of the batch.
+	// DeliveryBatchWindow, when set, keeps receiving for up to that long after
+	// the first messages of a batch to fill it. Ignored with FIFO or a
+	// Deliverer.
+	DeliveryBatchSize   int
+	DeliveryBatchWindow time.Duration
+
+	// BatchConcurrency is how many messages of a received batch are delivered
+	// at the same time. Values below 2 deliver them one after another.
+	BatchConcurrency int
+
+	// VerifyMD5 checks the body of every message against its MD5OfBody
+	// before delivery. Mismatching messages are not delivered: they are
+	// moved to the error queue if there is one, and left for the queue's
+	// redrive policy otherwise.
+	VerifyMD5 bool
+
+	// DropOlderThan, when set, deletes messages sent longer ago than this
+	// without delivering them.
+	DropOlderThan time.Duration
+
+	// MaxBodyBytes, when set, drops messages whose body is longer than that
+	// without delivering them. They are moved to the error queue if there is
+	// one, deleted otherwise.
+	MaxBodyBytes int
+	// HTTPMaxBodySize, when set, drops messages whose request body, once
+	// decoded and templated, is longer than that many bytes instead of
+	// posting them, like MaxBodyBytes.
+	HTTPMaxBodySize int
+
+	// MaxReceiveCount, when set, drops messages received more than that many
+	// times, according to their ApproximateReceiveCount, without delivering
+	// them, so that poison messages don't keep workers busy. They are moved
+	// to the error queue if there is one, deleted otherwise.
+	MaxReceiveCount int
+
+	// DecodeBase64 decodes message bodies from base64 before delivery.
+	// Messages that fail to decode are left in the queue.
+	DecodeBase64 bool
+
+	// BodyEncoding decides how bodies whose encoding, base64, gzip or zstd,
+	// is set in the BodyEncodingAttribute message attribute are delivered.
+	// Compressed bodies are expected to be base64 encoded. The attribute is
+	// ignored when BodyEncoding is empty.
+	BodyEncoding          BodyEncoding
+	BodyEncodingAttribute string
+
+	// ResolveS3Pointers delivers the payload stored in S3 by the Amazon SQS
+	// Extended Client in place of the pointer message referencing it, fetched
+	// with S3. DeleteS3Payloads deletes the payload once the message is
+	// deleted from the queue, unless it was moved to the error queue.
+	ResolveS3Pointers bool
+	DeleteS3Payloads  bool
+	S3                S3Client
+
+	// UnwrapSNS delivers the Message of SNS notification envelopes instead of
+	// the whole envelope, with their topic ARN, message ID and subject as
+	// headers. Other bodies are delivered as they are.
+	UnwrapSNS bool
+
+	// BodyFilter, when set, only delivers messages whose body matches it.
+	// BodyFilterAction decides what happens to the others.
+	BodyFilter       *regexp.Regexp
+	BodyFilterAction FilterAction
+
+	// FilterRules decide, in order, what happens to the messages that
+	// passed BodyFilter. The first rule a message matches applies, and
+	// FilterDefaultAction, FilterActionForward when empty, when none does.
+	FilterRules         []FilterRule
+	FilterDefaultAction FilterAction
+
+	// MessageFilter, when set, is run on every message that passed
+	// BodyFilter before it is delivered, and may drop it or rewrite its body.
+	MessageFilter MessageFilter
+
+	// Middleware is run in order on every message that passed the
+	// MessageFilter, see Chain.
+	Middleware []Middleware
+
+	// DuplicateWindow is how long a message that was delivered but could not be
+	// deleted is remembered. A redelivery within the window is deleted without
+	// being delivered again. Zero disables duplicate suppression.
+	DuplicateWindow time.Duration
+
+	// DedupStore, with a positive DedupWindow, records every message
+	// delivered to the worker for DedupWindow. A redelivery within the window,
+	// by message ID or by deduplication ID on a FIFO queue, is deleted
+	// without being delivered again.
+	DedupStore  DedupStore
+	DedupWindow time.Duration
+
+	// WorkerHealthURL is probed every WorkerHealthInterval and decides whether
+	// the supervisor is ready. With PauseWhenUnhealthy, no messages are
+	// received while the worker is unhealthy.
+	WorkerHealthURL      string
+	WorkerHealthInterval time.Duration
+	PauseWhenUnhealthy   bool
+
+	// ReadyReceiveMaxAge, when set, makes the supervisor unready if messages
+	// were not received successfully for that long. It should exceed the
+	// long polling wait time.
+	ReadyReceiveMaxAge time.Duration
+
+	// RampUpDuration, when set, ramps delivery concurrency up over that long
+	// after startup and whenever the worker becomes healthy again, starting
+	// at RampUpStep concurrent deliveries and growing by RampUpStep.
+	RampUpDuration time.Duration
+	RampUpStep     int
+
+	// MaxRequestsPerSecond, when positive, limits the deliveries made by all
+	// workers together, with bursts of up to MaxRequestsBurst deliveries.
+	// MaxConcurrentRequests, when positive, bounds how many are in flight at
+	// the same time. Retries count as deliveries.
+	MaxRequestsPerSecond  float64
+	MaxRequestsBurst      int
+	MaxConcurrentRequests int
+
+	// AuditDeletes logs a processing summary for every deleted message.
+	AuditDeletes bool
+
+	// AttemptStore, when set, keeps the number of delivery attempts of each
+	// message across restarts.
+	AttemptStore *AttemptStore
+
+	// EventStream, when set, receives an event for every step in the life of
+	// a message.
+	EventStream *EventStream
+
+	// MaxInFlight is how long messages of a batch may be processed after they
+	// were received. Messages still being processed after that are released
+	// back to the queue for redelivery. Zero disables the limit.
+	MaxInFlight time.Duration
+
+	// ErrorQueueURL, when set, receives the messages whose delivery failed
+	// on their ErrorQueueMaxReceives-th receipt (5 when unset), keeping their
+	// body and attributes. They are then deleted from their queue. Without an
+	// error queue, failed messages are left to the queue's redrive policy.
+	ErrorQueueURL         string
+	ErrorQueueMaxReceives int
+
+	// ErrorDestination, when set, is used instead of ErrorQueueURL, e.g. a
+	// Deliverer made by NewSNSForwarder.
+	ErrorDestination Deliverer
+
+	// ErrorQueueStatusCodes are the worker response status codes that send a
+	// message to the error queue on its first failed delivery, whatever its
+	// receive count.
+	ErrorQueueStatusCodes StatusCodes
+
+	// ErrorQueueFailureAttributes adds the status code, failure reason and
+	// response body of the last delivery to the attributes of the messages
+	// moved to the error queue.
+	ErrorQueueFailureAttributes bool
+
+	// SuccessStatusCodes are the worker response status codes that mean a
+	// message was delivered, 200-226 when unset. Messages getting one of the
+	// DiscardStatusCodes are deleted instead of being retried.
+	SuccessStatusCodes StatusCodes
+	DiscardStatusCodes StatusCodes
+
+	// StatusPolicy decides what happens to a message after a worker response
+	// matching one of its rules, the first that does. It takes precedence
+	// over DiscardStatusCodes, ErrorQueueStatusCodes, RetryStatusCodes and the
+	// visibility timeout the response asks for.
+	StatusPolicy []StatusRule
+
+	// AsyncAckTimeout, when set, keeps the messages the worker answers with
+	// 202 Accepted invisible in the queue until Ack is called with their ID to
+	// delete them. Messages not acknowledged within AsyncAckTimeout, nor
+	// VisibilityMax after they were received, are released to the queue.
+	AsyncAckTimeout time.Duration
+
+	// RetryStatusCodes are the worker response status codes retried up to
+	// HTTPMaxRetries times, 500-599 when unset.
+	RetryStatusCodes StatusCodes
+
+	// CronTasks are POSTed to the worker URL of the first queue on their
+	// schedule, or enqueued to it with CronModeEnqueue. A run is skipped
+	// while the previous run of the same task is still in progress.
+	CronTasks []CronTask
+	CronMode  CronMode
+	// CronLock, when set, lets a single replica run each scheduled task.
+	CronLock CronLock
+}
+
+// SignatureMode selects what the HMAC of a request is computed over.
+type SignatureMode string
+
+const (
+	// SignatureMethodURLBody signs "<method> <url>\n<body>". It is the
+	// default.
+	SignatureMethodURLBody SignatureMode = "method-url-body"
+	// SignatureBodyOnly signs the request body alone.
+	SignatureBodyOnly SignatureMode = "body-only"
+)
+
+// HMACAlgorithm is the hash function the HMAC of a request is computed with.
+type HMACAlgorithm string
+
+const (
+	// HMACSHA256 computes HMAC-SHA256. It is the default.
+	HMACSHA256 HMACAlgorithm = "sha256"
+	// HMACSHA512 computes HMAC-SHA512.
+	HMACSHA512 HMACAlgorithm = "sha512"
+)
+
+func (a HMACAlgorithm) hash() func() hash.Hash {
+	if a == HMACSHA512 {
+		return sha512.New
+	}
+
+	return sha256.New
+}
+
+// Headers carrying what replay protection adds to the signature of a request.
+const (
+	hmacTimestampHeader = "X-Sqsd-Signature-Timestamp"
+	hmacNonceHeader     = "X-Sqsd-Signature-Nonce"
+)
+
+// SignaturePlaceholders are the placeholders of HMACSignatureFormat: the
+// request method, its full URL, the path and query string of that URL, its
+// Content-Type, the Unix time in seconds, a random nonce and the body.
+var SignaturePlaceholders = []string{"{method}", "{url}", "{path}", "{content-type}", "{timestamp}", "{nonce}", "{body}"}
+
+// HeaderPlaceholders are the placeholders of HMACHeaderFormat.
+var HeaderPlaceholders = []string{"{signature}", "{timestamp}", "{nonce}"}
+
+// FilterAction is what happens to a message that is filtered out before
+// delivery.
+type FilterAction string
+
+const (
+	// FilterActionDelete deletes filtered messages without delivering them.
+	FilterActionDelete FilterAction = "delete"
+	// FilterActionLeave leaves filtered messages in the queue.
+	FilterActionLeave FilterAction = "leave"
+)
+
+const (
+	// DefaultAttributeHeaderPrefix is the AttributeHeaderPrefix used when
+	// none is configured.
+	DefaultAttributeHeaderPrefix = "X-Aws-Sqsd-Attr-"
+	// NoAttributeHeaderPrefix forwards message attributes under their own
+	// names.
+	NoAttributeHeaderPrefix = "none"
+
+	// DefaultHMACHeader is the HTTPHMACHeader used when none is configured.
+	DefaultHMACHeader = "MAC"
+
+	// DefaultReceiveErrorMaxBackoff is the ReceiveErrorMaxBackoff used when
+	// none is configured.
+	DefaultReceiveErrorMaxBackoff = 20 * time.Second
+	// DefaultHTTPRetryMaxBackoff is the HTTPRetryMaxBackoff used when none is
+	// configured.
+	DefaultHTTPRetryMaxBackoff = 20 * time.Second
+)
+
+// disposition describes what happens to a message in the queue once it has
+// been processed.
+type disposition int
+
+const (
+	// dispositionRetry leaves the message in the queue so it is redelivered
+	// once its visibility timeout expires.
+	dispositionRetry disposition = iota
+	dispositionDelete
+	dispositionChangeVisibility
+)
+
+type messageResult struct {
+	msg               *types.Message
+	disposition       disposition
+	visibilityTimeout int64
+
+	// status summarises how processing ended, e.g. "delivered" or "filtered".
+	status     string
+	statusCode int
+	attempts   int
+	duration   time.Duration
+
+	// reason classifies why the message was not delivered, if it wasn't.
+	reason FailureReason
+	// responseBody is the start of the body of a non-successful response.
+	responseBody string
 }
 
-type httpClient interface {
+// HTTPClient makes the requests to the worker. *http.Client implements it.
+type HTTPClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
-func NewSupervisor(logger *log.Entry, sqs sqsiface.SQSAPI, httpClient httpClient, config WorkerConfig) *Supervisor {
-	return &Supervisor{
-		logger:        logger,
-		sqs:           sqs,
-		httpClient:    httpClient,
-		workerConfig:  config,
-		hmacSignature: fmt.Sprintf("POST %s\n", config.HTTPURL),
+func NewSupervisor(logger *log.Entry, sqs SQSClient, httpClient HTTPClient, config WorkerConfig) *Supervisor {
+	publisher := config.OutcomePublisher
+	if publisher == nil {
+		publisher = nopOutcomePublisher{}
+	}
+
+	bufferSize := config.OutcomeBufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultOutcomeBufferSize
+	}
+
+	maxGroups := config.FIFOMaxGroups
+	if maxGroups <= 0 {
+		maxGroups = defaultFIFOMaxGroups
+	}
+
+	errorQueue := config.ErrorDestination
+	if errorQueue == nil && len(config.ErrorQueueURL) > 0 {
+		errorQueue = NewSQSForwarder(sqs, config.ErrorQueueURL)
 	}
+
+	receiveCtx, stopReceiving := context.WithCancel(context.Background())
+
+	s := &Supervisor{
+		logger:       logger,
+		sqs:          sqs,
+		httpClient:   httpClient,
+		workerConfig: config,
+
+		publisher:    publisher,
+		outcomes:     make(chan Outcome, bufferSize),
+		outcomesDone: make(chan struct{}),
+
+		fifoGroups: make(chan struct{}, maxGroups),
+
+		delivered: newDeliveredCache(config.DuplicateWindow),
+
+		queues: newQueues(config),
+
+		stats: newStats(),
+
+		aliveWorkers:  make(map[int]bool),
+		emptyReceives: make(map[int]int),
+
+		errorQueue: errorQueue,
+
+		pendingAcks: make(map[string]*pendingAck),
+
+		requests: newRequestLimiter(config.MaxRequestsPerSecond, config.MaxRequestsBurst, config.MaxConcurrentRequests),
+		capacity: newMessageCapacity(config.MaxInFlightMessages),
+
+		done: make(chan struct{}),
+
+		receiveCtx:    receiveCtx,
+		stopReceiving: stopReceiving,
+	}
+	s.breaker = newCircuitBreaker(config.CircuitFailureThreshold, config.CircuitOpenDuration, s.circuitChanged)
+	s.backpressure = newBackpressure(config.BackpressureLatency, config.BackpressureInFlight, config.QueueMaxMessages, s.backpressureChanged)
+	if s.backpressure != nil {
+		for _, q := range s.queues {
+			config.Metrics.setReceiveLimit(q.url, s.backpressure.max)
+		}
+	}
+
+	if config.QueueSchedule == QueueScheduleFailover && len(s.queues) > 1 {
+		s.failover = newQueueFailover(len(s.queues), config.FailoverAfter, config.FailbackInterval, s.failoverChanged)
+		for i, q := range s.queues {
+			config.Metrics.setActiveQueue(q.url, i == 0)
+		}
+	}
+
+	return s
 }
 
 func (s *Supervisor) Start(numWorkers int) {
 	s.startOnce.Do(func() {
-		s.wg.Add(numWorkers)
+		s.startedAt = time.Now()
+		s.startPublisher()
+
+		if s.workerConfig.RampUpDuration > 0 {
+			s.ramp = newRampLimiter(s.maxDeliveries(numWorkers), s.workerConfig.RampUpStep, s.workerConfig.RampUpDuration)
+			s.ramp.restart(s.startedAt.Add(s.workerConfig.StartupDelay))
+		}
+
+		s.pollers = newPollerGate(s.workerConfig.MinPollers, s.workerConfig.MaxPollers, s.workerConfig.PollerIdleReceives, numWorkers)
+
+		if len(s.workerConfig.WorkerHealthURL) > 0 {
+			s.checkWorkerHealth()
+			go s.watchWorkerHealth()
+		}
+
+		if s.workerConfig.StartupDelay > 0 {
+			s.logger.Infof("Waiting %s before receiving messages", s.workerConfig.StartupDelay)
+		}
+
+		s.workersMu.Lock()
+		s.startWorkers(numWorkers)
+		s.workersMu.Unlock()
 
-		for i := 0; i < numWorkers; i++ {
-			go s.worker()
+		s.wg.Add(len(s.workerConfig.CronTasks))
+		for _, task := range s.workerConfig.CronTasks {
+			go s.runCronTask(task)
 		}
 	})
 }
 
+// StartContext starts numWorkers workers like Start, and shuts the
+// supervisor down once ctx is done.
+func (s *Supervisor) StartContext(ctx context.Context, numWorkers int) {
+	s.Start(numWorkers)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Shutdown()
+		case <-s.done:
+		}
+	}()
+}
+
 func (s *Supervisor) Wait() {
 	s.wg.Wait()
+
+	if !s.startedAt.IsZero() {
+		s.closePublisher()
+	}
 }
 
 func (s *Supervisor) Shutdown() {
-	defer s.Unlock()
-	s.Lock()
-
-	s.shutdown = true
+	s.shutdown.Store(true)
+	s.shutdownOnce.Do(func() {
+		close(s.done)
+		s.stopReceiving()
+		s.capacity.close()
+		s.pollers.close()
+	})
 }
 
-func (s *Supervisor) worker() {
+func (s *Supervisor) worker(id int) {
 	defer s.wg.Done()
 
+	s.runningWorkers.Add(1)
+	defer s.runningWorkers.Add(-1)
+
+	s.workerConfig.Metrics.addWorkers(1)
+	defer s.workerConfig.Metrics.addWorkers(-1)
+
 	s.logger.Info("Starting worker")
 
+	if s.workerConfig.StartupDelay > 0 {
+		s.sleep(s.workerConfig.StartupDelay)
+	}
+
+	// With MaxInFlightBatches, batches are processed in the background and
+	// the worker only polls while it holds fewer than that many.
+	var (
+		slots   chan struct{}
+		batches sync.WaitGroup
+	)
+	if s.workerConfig.MaxInFlightBatches > 1 {
+		slots = make(chan struct{}, s.workerConfig.MaxInFlightBatches)
+		defer batches.Wait()
+	}
+
+	// failures tracks consecutive ReceiveMessage errors, to back off rather
+	// than poll a failing queue as fast as possible.
+	var failures receiveFailures
+	defer failures.stop(s.workerConfig.Metrics)
+
 	for {
-		if s.shutdown {
+		if s.shutdown.Load() {
 			return
 		}
 
-		recInput := &sqs.ReceiveMessageInput{
-			MaxNumberOfMessages:   aws.Int64(int64(s.workerConfig.QueueMaxMessages)),
-			QueueUrl:              aws.String(s.workerConfig.QueueURL),
-			WaitTimeSeconds:       aws.Int64(int64(s.workerConfig.QueueWaitTime)),
-			MessageAttributeNames: aws.StringSlice([]string{"All"}),
+		if s.retireWorker(id) {
+			return
 		}
 
-		output, err := s.sqs.ReceiveMessage(recInput)
-		if err != nil {
-			s.logger.Errorf("Error while receiving messages from the queue: %s", err)
+		if s.paused.Load() {
+			s.sleep(pauseCheckInterval)
 			continue
 		}
 
-		if len(output.Messages) == 0 {
+		if s.drainedWorker(id) {
+			s.sleep(pauseCheckInterval)
 			continue
 		}
 
-		deleteEntries := make([]*sqs.DeleteMessageBatchRequestEntry, 0)
-		changeVisibilityEntries := make([]*sqs.ChangeMessageVisibilityBatchRequestEntry, 0)
+		if s.receivePaused() {
+			s.sleep(s.workerHealthInterval())
+			continue
+		}
 
-		for _, msg := range output.Messages {
-			res, err := s.httpRequest(msg)
-			if err != nil {
-				s.logger.Errorf("Error making HTTP request: %s", err)
-				continue
-			}
+		if wait := s.deletePause(); wait > 0 {
+			s.sleep(wait)
+			continue
+		}
 
-			if res.StatusCode < http.StatusOK || res.StatusCode > http.StatusIMUsed {
+		if wait := s.breaker.pause(time.Now()); wait > 0 {
+			s.sleep(wait)
+			continue
+		}
 
-				if res.StatusCode == http.StatusTooManyRequests {
-					sec, err := getRetryAfterFromResponse(res)
-					if err != nil {
-						s.logger.Errorf("Error getting retry after value from HTTP response: %s", err)
-						continue
-					}
+		maxMessages, wait := s.backpressure.receiveLimit(time.Now(), s.workerConfig.QueueMaxMessages)
+		if wait > 0 {
+			s.sleep(wait)
+			continue
+		}
 
-					changeVisibilityEntries = append(changeVisibilityEntries, &sqs.ChangeMessageVisibilityBatchRequestEntry{
-						Id:                msg.MessageId,
-						ReceiptHandle:     msg.ReceiptHandle,
-						VisibilityTimeout: aws.Int64(sec),
-					})
-				}
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			case <-s.done:
+				return
+			}
+		}
+
+		if !s.pollers.acquire() {
+			if slots != nil {
+				<-slots
+			}
+			return
+		}
 
-				s.logger.Errorf("Non-successful status code: %d", res.StatusCode)
+		reserved, ok := s.capacity.acquire(maxMessages)
+		if !ok {
+			s.pollers.release(0)
+			if slots != nil {
+				<-slots
+			}
+			return
+		}
 
-				continue
+		q := s.nextQueue(id)
 
+		messages, receivedAt, err := s.receive(q, reserved)
+		s.observeReceive(q, err)
+		s.pollers.release(len(messages))
+		s.capacity.release(reserved - len(messages))
+		if err != nil {
+			if slots != nil {
+				<-slots
 			}
 
-			deleteEntries = append(deleteEntries, &sqs.DeleteMessageBatchRequestEntry{
-				Id:            msg.MessageId,
-				ReceiptHandle: msg.ReceiptHandle,
-			})
+			s.sleep(s.receiveErrored(id, q, &failures, err))
+			continue
+		}
+		s.receiveSucceeded(id, &failures)
 
-			s.logger.Debugf("Message %s successfully processed", *msg.MessageId)
+		if len(messages) > 0 && s.workerConfig.DeliveryBatchWindow > 0 && s.batchDelivery() {
+			messages = s.fillBatch(q, receivedAt, messages)
 		}
 
-		if len(deleteEntries) > 0 {
-			delInput := &sqs.DeleteMessageBatchInput{
-				Entries:  deleteEntries,
-				QueueUrl: aws.String(s.workerConfig.QueueURL),
+		if len(messages) == 0 || s.shutdown.Load() {
+			if slots != nil {
+				<-slots
 			}
 
-			_, err = s.sqs.DeleteMessageBatch(delInput)
-			if err != nil {
-				s.logger.Errorf("Error while deleting messages from SQS: %s", err)
+			// Shutdown may have begun during a long poll; hand the batch
+			// back rather than start delivering it.
+			if len(messages) > 0 {
+				s.releaseBatch(q, messages)
+				s.capacity.release(len(messages))
+			} else {
+				s.countEmptyReceive(id, len(slots))
 			}
+			continue
 		}
+		s.resetEmptyReceives()
 
-		if len(changeVisibilityEntries) > 0 {
-			changeVisibilityInput := &sqs.ChangeMessageVisibilityBatchInput{
-				Entries:  changeVisibilityEntries,
-				QueueUrl: aws.String(s.workerConfig.QueueURL),
-			}
-
-			_, err = s.sqs.ChangeMessageVisibilityBatch(changeVisibilityInput)
-			if err != nil {
-				s.logger.Errorf("Error while changing visibility on messages from SQS: %s", err)
-			}
+		if slots == nil {
+			s.handleBatch(q, receivedAt, messages)
+			s.capacity.release(len(messages))
+			continue
 		}
+
+		batches.Add(1)
+		go func() {
+			defer batches.Done()
+			defer func() { <-slots }()
+			defer s.capacity.release(len(messages))
+
+			s.handleBatch(q, receivedAt, messages)
+		}()
 	}
 }
 
-func (s *Supervisor) httpRequest(msg *sqs.Message) (*http.Response, error) {
-	body := *msg.Body
-	req, err := http.NewRequest("POST", s.workerConfig.HTTPURL, bytes.NewBufferString(body))
-	req.Header.Add("X-Aws-Sqsd-Msgid", *msg.MessageId)
-	s.addMessageAttributesToHeader(msg.MessageAttributes, req.Header)
+// receive receives a batch of up to maxMessages messages from q, returning
+// them along with when they were received.
+func (s *Supervisor) receive(q *queue, maxMessages int) ([]types.Message, time.Time, error) {
+	return s.receiveWait(q, maxMessages, s.workerConfig.QueueWaitTime)
+}
+
+// receiveWait is receive with a long poll of waitTime seconds.
+func (s *Supervisor) receiveWait(q *queue, maxMessages int, waitTime int) ([]types.Message, time.Time, error) {
+	recInput := &sqs.ReceiveMessageInput{
+		MaxNumberOfMessages:         int32(maxMessages),
+		QueueUrl:                    aws.String(q.url),
+		WaitTimeSeconds:             int32(waitTime),
+		MessageAttributeNames:       s.receiveMessageAttributeNames(),
+		MessageSystemAttributeNames: s.receiveAttributeNames(),
+	}
+
+	if s.workerConfig.VisibilityTimeout > 0 {
+		recInput.VisibilityTimeout = int32(s.workerConfig.VisibilityTimeout / time.Second)
+	}
+
+	// The AWS SDK retries with the same ID too.
+	attemptID := s.receiveAttemptID(q)
+	if len(attemptID) > 0 {
+		recInput.ReceiveRequestAttemptId = aws.String(attemptID)
+	}
+
+	receivedAt := time.Now()
+	output, err := s.sqs.ReceiveMessage(s.receiveCtx, recInput)
+	if err != nil && s.shutdown.Load() {
+		// The long poll was aborted by Shutdown.
+		return nil, receivedAt, nil
+	}
+	s.workerConfig.Metrics.observeReceiveDuration(q.url, time.Since(receivedAt))
 	if err != nil {
-		return nil, fmt.Errorf("Error while creating HTTP request: %s", err)
+		// Workers log receive errors once per run of them.
+		s.logger.Debugf("Error while receiving messages from the queue: %s", err)
+		s.stats.receiveError(q, err)
+		s.receiveFailed(q, attemptID)
+		return nil, receivedAt, err
 	}
 
-	if len(s.workerConfig.HMACSecretKey) > 0 {
-		hmac, err := makeHMAC(strings.Join([]string{s.hmacSignature, body}, ""), s.workerConfig.HMACSecretKey)
-		if err != nil {
-			return nil, err
-		}
+	if output == nil {
+		s.logger.Warn("Received a nil output from the queue without an error")
+		return nil, receivedAt, nil
+	}
+
+	s.lastReceive.Store(time.Now().UnixNano())
+	s.workerConfig.Metrics.addReceived(q.url, len(output.Messages))
+
+	return output.Messages, receivedAt, nil
+}
+
+// handleBatch processes a received batch and applies the results to q.
+func (s *Supervisor) handleBatch(q *queue, receivedAt time.Time, messages []types.Message) {
+	s.stats.receive(q, messages)
+	defer s.stats.finish(messages)
+
+	s.workerConfig.Metrics.addInFlight(q.url, len(messages))
+	defer s.workerConfig.Metrics.addInFlight(q.url, -len(messages))
+
+	for i := range messages {
+		s.emitEvent(EventReceived, q, &messages[i], nil)
+	}
+
+	ctx, cancel := s.inFlightContext(receivedAt)
+	ctx = withQueueVisibility(ctx, q)
+	ctx, stopHeartbeat := s.withHeartbeat(ctx, q)
 
-		req.Header.Set(s.workerConfig.HTTPHMACHeader, hmac)
+	var results []messageResult
+	if s.workerConfig.FIFO {
+		results = s.processFIFOBatch(ctx, q, messages)
+	} else {
+		results = s.processBatch(ctx, q, messages)
 	}
 
-	if len(s.workerConfig.HTTPContentType) > 0 {
-		req.Header.Set("Content-Type", s.workerConfig.HTTPContentType)
+	stopHeartbeat()
+	cancel()
+
+	s.applyResults(q, results)
+}
+
+func (s *Supervisor) receiveAttributeNames() []types.MessageSystemAttributeName {
+	if s.workerConfig.EmitSQSDHeaders || s.workerConfig.BodyEnvelope {
+		return []types.MessageSystemAttributeName{types.MessageSystemAttributeNameAll}
 	}
 
-	res, err := s.httpClient.Do(req)
-	if err != nil {
-		return res, err
+	var names []types.MessageSystemAttributeName
+
+	if s.workerConfig.FIFO {
+		names = append(names, types.MessageSystemAttributeNameMessageGroupId)
+
+		if s.workerConfig.DedupStore != nil {
+			names = append(names, types.MessageSystemAttributeNameMessageDeduplicationId)
+		}
 	}
 
-	res.Body.Close()
+	if s.workerConfig.XRayEnabled {
+		names = append(names, xrayTraceHeaderAttribute)
+	}
 
-	return res, nil
+	if s.workerConfig.Metrics != nil || s.workerConfig.DropOlderThan > 0 {
+		names = append(names, types.MessageSystemAttributeNameSentTimestamp)
+	}
+
+	// The receive count is logged with every message.
+	names = append(names, types.MessageSystemAttributeNameApproximateReceiveCount)
+
+	for _, name := range s.workerConfig.ReceiveAttributeNames {
+		if name == string(types.MessageSystemAttributeNameAll) {
+			return []types.MessageSystemAttributeName{types.MessageSystemAttributeNameAll}
+		}
+
+		names = append(names, types.MessageSystemAttributeName(name))
+	}
+
+	return names
 }
 
-func (s *Supervisor) addMessageAttributesToHeader(attrs map[string]*sqs.MessageAttributeValue, header http.Header) {
-	for k, v := range attrs {
-		header.Add("X-Aws-Sqsd-Attr-" + k, *v.StringValue)
+// messageLogger returns a logger tagged with the queue, ID and receive count
+// of msg and the request and correlation IDs sent with it, when
+// RequestIDHeader and CorrelationIDHeader are set.
+func (s *Supervisor) messageLogger(q *queue, msg *types.Message) *log.Entry {
+	fields := log.Fields{
+		"queue":        q.url,
+		"messageId":    aws.ToString(msg.MessageId),
+		"receiveCount": receiveCount(msg),
+	}
+	if len(s.workerConfig.RequestIDHeader) > 0 {
+		fields["requestId"] = s.requestID(msg)
 	}
+	if len(s.workerConfig.CorrelationIDHeader) > 0 {
+		fields["correlationId"] = correlationID(msg, s.workerConfig.CorrelationIDAttributes)
+	}
+
+	return s.logger.WithFields(fields)
 }
 
-func makeHMAC(signature string, secretKey []byte) (string, error) {
-	mac := hmac.New(sha256.New, secretKey)
+// resultLogger returns a logger tagged with the message of result and, once
+// it was delivered, the status code and duration of its delivery.
+func (s *Supervisor) resultLogger(q *queue, result *messageResult) *log.Entry {
+	logger := s.messageLogger(q, result.msg)
 
-	_, err := mac.Write([]byte(signature))
-	if err != nil {
-		return "", fmt.Errorf("Error while writing HMAC: %s", err)
+	if result.statusCode > 0 {
+		logger = logger.WithField("httpStatus", result.statusCode)
+	}
+	if result.duration > 0 {
+		logger = logger.WithField("durationMs", result.duration.Milliseconds())
+	}
+	if len(result.responseBody) > 0 {
+		logger = logger.WithField("responseBody", result.responseBody)
 	}
 
-	return hex.EncodeToString(mac.Sum(nil)), nil
+	return logger
 }
 
-func getRetryAfterFromResponse(res *http.Response) (int64, error) {
-	retryAfter := res.Header.Get("Retry-After")
-	if len(retryAfter) == 0 {
-		return 0, errors.New("Retry-After header value is empty")
+func (s *Supervisor) processBatch(ctx context.Context, q *queue, messages []types.Message) []messageResult {
+	if s.batchDelivery() {
+		return s.processDeliveryBatches(ctx, q, messages)
 	}
 
-	seconds, err := strconv.ParseInt(retryAfter, 10, 0)
-	if err != nil {
-		return 0, err
+	if s.workerConfig.BatchConcurrency > 1 {
+		return s.processBatchConcurrently(ctx, q, messages, s.workerConfig.BatchConcurrency)
 	}
 
-	return seconds, nil
+	results := make([]messageResult, 0, len(messages))
+
+	for i := range messages {
+		results = append(results, s.processMessage(ctx, q, &messages[i]))
+	}
+
+	return results
+}
+
+// processBatchConcurrently delivers up to concurrency messages at a time.
+// Results are kept in the order the messages were received.
+func (s *Supervisor) processBatchConcurrently(ctx context.Context, q *queue, messages []types.Message, concurrency int) []messageResult {
+	var (
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, concurrency)
+		results = make([]messageResult, len(messages))
+	)
+
+	for i := range messages {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, msg *types.Message) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			results[i] = s.processMessage(ctx, q, msg)
+		}(i, &messages[i])
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// processMessage delivers a single message and decides what should happen to
+// it in the queue. Once ctx is done, the message is released back to the
+// queue instead.
+func (s *Supervisor) processMessage(ctx context.Context, q *queue, msg *types.Message) messageResult {
+	result := messageResult{msg: msg}
+
+	delivery := s.prepareMessage(ctx, q, &result)
+	if delivery == nil {
+		return result
+	}
+
+	if ctx.Err() != nil {
+		s.releaseMessage(q, msg, s.releaseReason(ctx))
+
+		result.status = "released"
+		return result
+	}
+
+	if !s.ramp.acquire(ctx) {
+		s.releaseMessage(q, msg, s.releaseReason(ctx)+" while waiting for a ramp-up slot")
+
+		result.status = "released"
+		return result
+	}
+
+	start := time.Now()
+	s.workerConfig.Metrics.observeMessageAge(q.url, msg, start)
+	stopHeartbeat := s.startHeartbeat(ctx, q, msg)
+	sp := s.workerConfig.Tracer.startSpan(q.url, msg)
+	res, err := s.deliverWithRetries(withSpan(ctx, sp), q, delivery)
+	sp.finish(res, err)
+	stopHeartbeat()
+	s.ramp.release()
+	s.recordOutcome(q, msg, res, err, start)
+	result.attempts = s.workerConfig.AttemptStore.attempts(*msg.MessageId) + 1
+	result.duration = time.Since(start)
+	if err != nil {
+		if ctx.Err() != nil {
+			s.releaseMessage(q, msg, s.releaseReason(ctx))
+
+			result.status = "released"
+			return result
+		}
+
+		reason := failureReasonForError(err)
+		if reason == FailureBodyTooLarge {
+			s.recordFailure(q, &result, reason).Warnf("%s, dropping the message without delivery", err)
+			s.dropTooLarge(q, &result)
+			return result
+		}
+
+		s.recordFailure(q, &result, reason).Errorf("Error making HTTP request: %s", err)
+		return result
+	}
+
+	result.statusCode = res.StatusCode
+
+	if !s.successful(res.StatusCode) {
+		result.responseBody = responseExcerpt(res, s.workerConfig.HTTPErrorBodyLimit)
+		s.handleFailedResponse(q, &result, res)
+
+		return result
+	}
+
+	if !s.checkResponseContentType(res) {
+		return result
+	}
+
+	if s.softFailed(res) {
+		s.resultLogger(q, &result).Info("Worker asked for the message to be retried")
+
+		result.status = "retry"
+		return result
+	}
+
+	if s.requeued(q, &result, res) {
+		return result
+	}
+
+	if s.workerConfig.AsyncAckTimeout > 0 && res.StatusCode == http.StatusAccepted {
+		s.resultLogger(q, &result).Debug("Message accepted, awaiting its acknowledgment")
+		s.recordFirstDelivery()
+
+		result.status = "awaiting-ack"
+		s.holdForAck(ctx, q, result)
+
+		return result
+	}
+
+	s.resultLogger(q, &result).Debug("Message successfully processed")
+	s.recordFirstDelivery()
+	s.recordDelivered(ctx, q, msg)
+
+	result.disposition = dispositionDelete
+	result.status = "delivered"
+
+	return result
+}
+
+// prepareMessage runs the checks, filters and middleware preceding the
+// delivery of the message of result, and returns the message to deliver. It
+// returns nil when the message is not to be delivered, with result telling
+// what happens to it.
+func (s *Supervisor) prepareMessage(ctx context.Context, q *queue, result *messageResult) *types.Message {
+	msg := result.msg
+	logger := s.messageLogger(q, msg)
+
+	if s.delivered.contains(aws.ToString(msg.MessageId)) {
+		logger.Info("Message was already delivered, deleting it without redelivery")
+
+		result.disposition = dispositionDelete
+		result.status = "duplicate"
+		return nil
+	}
+
+	if s.seenBefore(ctx, q, msg) {
+		logger.Info("Message was delivered within the dedup window, deleting it without redelivery")
+
+		result.disposition = dispositionDelete
+		result.status = "duplicate"
+		return nil
+	}
+
+	if s.workerConfig.VerifyMD5 && !bodyMatchesMD5(msg) {
+		s.recordFailure(q, result, FailureCorrupt).Error("Message body does not match its MD5, dropping it without delivery")
+		s.dropCorrupt(q, result)
+		return nil
+	}
+
+	if s.isStale(msg) {
+		logger.Infof("Message is older than %s, deleting it without delivery", s.workerConfig.DropOlderThan)
+		s.workerConfig.Metrics.incDropped(q.url, "stale")
+
+		result.disposition = dispositionDelete
+		result.status = "stale"
+		return nil
+	}
+
+	if s.poisoned(msg) {
+		s.recordFailure(q, result, FailurePoison).Errorf("Message was received %d times, more than %d, dropping it without delivery", receiveCount(msg), s.workerConfig.MaxReceiveCount)
+		s.dropPoison(q, result)
+		return nil
+	}
+
+	if s.bodyTooLarge(msg) {
+		s.recordFailure(q, result, FailureBodyTooLarge).Warnf("Message body exceeds %d bytes, dropping it without delivery", s.workerConfig.MaxBodyBytes)
+		s.dropTooLarge(q, result)
+		return nil
+	}
+
+	if s.workerConfig.BodyFilter != nil && !s.workerConfig.BodyFilter.MatchString(aws.ToString(msg.Body)) {
+		s.recordFailure(q, result, FailureFiltered).Debug("Message does not match the body filter")
+
+		if s.workerConfig.BodyFilterAction != FilterActionLeave {
+			result.disposition = dispositionDelete
+		}
+
+		result.status = "filtered"
+		return nil
+	}
+
+	if len(s.workerConfig.FilterRules) > 0 || len(s.workerConfig.FilterDefaultAction) > 0 {
+		if action := s.filterRuleAction(msg); action != FilterActionForward {
+			s.recordFailure(q, result, FailureFiltered).WithField("action", string(action)).Debug("Message filtered out by the filter rules")
+
+			if action == FilterActionDelete {
+				result.disposition = dispositionDelete
+			}
+
+			result.status = "filtered"
+			return nil
+		}
+	}
+
+	payload, err := s.resolveS3Pointer(ctx, msg)
+	if err != nil {
+		s.recordFailure(q, result, failureReasonForError(err)).Error(err)
+		return nil
+	}
+
+	delivery, err := s.filterMessage(ctx, payload)
+	if err != nil {
+		s.recordFailure(q, result, FailureFilterError).Errorf("Error while filtering the message: %s", err)
+		return nil
+	}
+	if delivery == nil {
+		s.recordFailure(q, result, FailureFiltered).Debug("Message was dropped by the message filter or a middleware")
+
+		result.disposition = dispositionDelete
+		result.status = "filtered"
+		return nil
+	}
+
+	return delivery
+}
+
+// handleFailedResponse decides what happens to the message of result after
+// the worker responded with a non-successful status code.
+func (s *Supervisor) handleFailedResponse(q *queue, result *messageResult, res *http.Response) {
+	rule, ruled := s.statusRule(res.StatusCode)
+	if ruled && rule.Action == StatusActionDelete {
+		s.resultLogger(q, result).Infof("Deleting message after status code %d as if it was delivered", res.StatusCode)
+
+		result.disposition = dispositionDelete
+		result.status = "delivered"
+		return
+	}
+
+	logger := s.recordFailure(q, result, failureReasonForStatus(res.StatusCode))
+
+	if s.discarded(res.StatusCode) {
+		logger.Warnf("Discarding message after status code %d", res.StatusCode)
+
+		result.disposition = dispositionDelete
+		result.status = "discarded"
+		return
+	}
+
+	if ruled && rule.Action == StatusActionErrorQueue {
+		// The message is moved with the other results, see applyResults.
+		logger.Errorf("Non-successful status code: %d, moving the message to the error queue", res.StatusCode)
+		return
+	}
+
+	if ruled && rule.VisibilityTimeout > 0 {
+		result.disposition = dispositionChangeVisibility
+		result.visibilityTimeout = int64(rule.VisibilityTimeout / time.Second)
+	} else if timeout, ok, err := visibilityTimeout(res); err != nil {
+		logger.Errorf("Error getting the visibility timeout from HTTP response: %s", err)
+	} else if ok {
+		result.disposition = dispositionChangeVisibility
+		result.visibilityTimeout = timeout
+	} else if res.StatusCode == http.StatusTooManyRequests || (res.StatusCode == http.StatusServiceUnavailable && len(res.Header.Get("Retry-After")) > 0) {
+		delay, err := s.retryAfter(res)
+		if err != nil {
+			logger.Errorf("Error getting retry after value from HTTP response: %s", err)
+			return
+		}
+
+		result.disposition = dispositionChangeVisibility
+		result.visibilityTimeout = int64((delay + time.Second - 1) / time.Second)
+	}
+
+	logger.Errorf("Non-successful status code: %d", res.StatusCode)
+}
+
+func (s *Supervisor) applyResults(q *queue, results []messageResult) {
+	changeVisibilityEntries := make([]types.ChangeMessageVisibilityBatchRequestEntry, 0)
+
+	for i := range results {
+		if s.shouldMoveToErrorQueue(results[i]) {
+			s.moveToErrorQueue(q, &results[i])
+		}
+	}
+
+	for _, result := range results {
+		s.emitEvent(EventProcessed, q, result.msg, &result)
+		s.stats.process(q, result)
+		s.workerConfig.Metrics.observeResult(q.url, result)
+
+		if result.attempts > 0 && result.disposition != dispositionDelete {
+			if err := s.workerConfig.AttemptStore.record(*result.msg.MessageId, result.attempts); err != nil {
+				s.logger.Errorf("Error while recording delivery attempts: %s", err)
+			}
+		}
+
+		if result.disposition == dispositionChangeVisibility {
+			changeVisibilityEntries = append(changeVisibilityEntries, types.ChangeMessageVisibilityBatchRequestEntry{
+				Id:                result.msg.MessageId,
+				ReceiptHandle:     result.msg.ReceiptHandle,
+				VisibilityTimeout: int32(result.visibilityTimeout),
+			})
+		}
+	}
+
+	s.deleteResults(q, results)
+
+	if len(changeVisibilityEntries) > 0 {
+		s.changeVisibility(q, changeVisibilityEntries)
+	}
+}
+
+// deleteResults deletes the messages of the results with dispositionDelete
+// from the queue, and returns the IDs of those that could not be deleted.
+func (s *Supervisor) deleteResults(q *queue, results []messageResult) map[string]bool {
+	deleteEntries := make([]types.DeleteMessageBatchRequestEntry, 0)
+	for _, result := range results {
+		if result.disposition == dispositionDelete {
+			deleteEntries = append(deleteEntries, types.DeleteMessageBatchRequestEntry{
+				Id:            result.msg.MessageId,
+				ReceiptHandle: result.msg.ReceiptHandle,
+			})
+		}
+	}
+
+	if len(deleteEntries) == 0 {
+		return nil
+	}
+
+	undeleted := make(map[string]bool)
+	for _, entry := range s.deleteMessages(q, deleteEntries) {
+		undeleted[*entry.Id] = true
+		s.delivered.add(*entry.Id)
+	}
+	s.recordDeleteResult(len(undeleted) == 0)
+
+	for _, entry := range deleteEntries {
+		if !undeleted[*entry.Id] {
+			s.emitEvent(EventDeleted, q, &types.Message{MessageId: entry.Id}, nil)
+			s.stats.delete()
+			s.workerConfig.Metrics.incDeleted(q.url)
+
+			if err := s.workerConfig.AttemptStore.forget(*entry.Id); err != nil {
+				s.logger.Errorf("Error while forgetting delivery attempts: %s", err)
+			}
+		}
+	}
+
+	for _, result := range results {
+		if result.disposition == dispositionDelete && !undeleted[*result.msg.MessageId] {
+			s.deleteS3Payload(result)
+		}
+	}
+
+	if s.workerConfig.AuditDeletes {
+		for _, result := range results {
+			if result.disposition == dispositionDelete && !undeleted[*result.msg.MessageId] {
+				s.auditDelete(result)
+			}
+		}
+	}
+
+	return undeleted
+}
+
+// deleteMessages deletes entries from the queue in batches of up to
+// maxBatchEntries, and returns the entries that could not be deleted.
+func (s *Supervisor) deleteMessages(q *queue, entries []types.DeleteMessageBatchRequestEntry) []types.DeleteMessageBatchRequestEntry {
+	var undeleted []types.DeleteMessageBatchRequestEntry
+
+	for start := 0; start < len(entries); start += maxBatchEntries {
+		end := start + maxBatchEntries
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		undeleted = append(undeleted, s.deleteMessageBatch(q, entries[start:end])...)
+	}
+
+	return undeleted
+}
+
+// deleteMessageBatch deletes entries from the queue, retrying entries that
+// failed up to DeleteMaxRetries times. Entries that were deleted by a previous
+// attempt are never re-submitted, nor are entries SQS blamed on the sender,
+// e.g. for an invalid receipt handle. The entries that could not be deleted
+// are returned.
+func (s *Supervisor) deleteMessageBatch(q *queue, entries []types.DeleteMessageBatchRequestEntry) []types.DeleteMessageBatchRequestEntry {
+	pending := entries
+	var rejected []types.DeleteMessageBatchRequestEntry
+
+	// codes holds the error code SQS last reported for each failed entry.
+	codes := make(map[string]string)
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(s.workerConfig.DeleteRetryDelay)
+		}
+
+		delInput := &sqs.DeleteMessageBatchInput{
+			Entries:  pending,
+			QueueUrl: aws.String(q.url),
+		}
+
+		output, err := s.sqs.DeleteMessageBatch(context.Background(), delInput)
+		if err != nil {
+			s.logger.Errorf("Error while deleting messages from SQS: %s", err)
+		} else {
+			pending = failedDeleteEntries(pending, output)
+			if output != nil {
+				senderFaults := make(map[string]bool)
+				for _, f := range output.Failed {
+					codes[aws.ToString(f.Id)] = aws.ToString(f.Code)
+					if f.SenderFault {
+						senderFaults[aws.ToString(f.Id)] = true
+					}
+				}
+
+				retryable := pending[:0:0]
+				for _, entry := range pending {
+					if senderFaults[*entry.Id] {
+						rejected = append(rejected, entry)
+					} else {
+						retryable = append(retryable, entry)
+					}
+				}
+				pending = retryable
+			}
+		}
+
+		if len(pending) == 0 {
+			break
+		}
+
+		if attempt >= s.workerConfig.DeleteMaxRetries {
+			break
+		}
+	}
+
+	pending = append(pending, rejected...)
+	if len(pending) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(pending))
+	for _, entry := range pending {
+		id := *entry.Id
+		if code, ok := codes[id]; ok {
+			id += " (" + code + ")"
+		}
+		ids = append(ids, id)
+	}
+
+	s.logger.WithField("deleteFailures", len(pending)).Errorf("Could not delete messages from SQS: %s", strings.Join(ids, ", "))
+	s.workerConfig.Metrics.addUndeleted(q.url, len(pending))
+
+	return pending
+}
+
+// recordDeleteResult tracks consecutive delete failures and pauses receiving
+// once DeleteFailureThreshold is reached.
+func (s *Supervisor) recordDeleteResult(ok bool) {
+	if s.workerConfig.DeleteFailureThreshold <= 0 {
+		return
+	}
+
+	s.deleteFailuresMu.Lock()
+	defer s.deleteFailuresMu.Unlock()
+
+	if ok {
+		if s.deleteFailures >= s.workerConfig.DeleteFailureThreshold {
+			s.logger.Info("Deleting messages succeeded, resuming receiving")
+		}
+
+		s.deleteFailures = 0
+		return
+	}
+
+	s.deleteFailures++
+	if s.deleteFailures >= s.workerConfig.DeleteFailureThreshold {
+		s.logger.Errorf("Deleting messages failed %d times in a row, pausing receiving for %s", s.deleteFailures, s.workerConfig.DeleteFailureBackoff)
+		s.deletePausedUntil = time.Now().Add(s.workerConfig.DeleteFailureBackoff)
+	}
+}
+
+// deletePause returns how long workers should wait before receiving again
+// because of repeated delete failures.
+func (s *Supervisor) deletePause() time.Duration {
+	s.deleteFailuresMu.Lock()
+	defer s.deleteFailuresMu.Unlock()
+
+	return time.Until(s.deletePausedUntil)
+}
+
+// failedDeleteEntries returns the entries that were not reported as
+// successfully deleted in output.
+func failedDeleteEntries(entries []types.DeleteMessageBatchRequestEntry, output *sqs.DeleteMessageBatchOutput) []types.DeleteMessageBatchRequestEntry {
+	if output == nil {
+		return nil
+	}
+
+	failed := make(map[string]bool, len(output.Failed))
+	for _, f := range output.Failed {
+		failed[aws.ToString(f.Id)] = true
+	}
+
+	remaining := make([]types.DeleteMessageBatchRequestEntry, 0, len(failed))
+	for _, entry := range entries {
+		if failed[*entry.Id] {
+			remaining = append(remaining, entry)
+		}
+	}
+
+	return remaining
+}
+
+// auditDelete logs a summary of a message's processing once it has been
+// deleted, so its lifecycle can be reconstructed from the logs.
+func (s *Supervisor) auditDelete(result messageResult) {
+	s.logger.WithFields(log.Fields{
+		"messageId":    aws.ToString(result.msg.MessageId),
+		"receiveCount": result.msg.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)],
+		"attempts":     result.attempts,
+		"durationMs":   result.duration.Milliseconds(),
+		"status":       result.status,
+		"httpStatus":   result.statusCode,
+	}).Info("Message deleted")
+}
+
+func (s *Supervisor) recordOutcome(q *queue, msg *types.Message, res *http.Response, err error, start time.Time) {
+	outcome := Outcome{
+		MessageID:  aws.ToString(msg.MessageId),
+		QueueURL:   q.url,
+		DurationMs: time.Since(start).Milliseconds(),
+		Timestamp:  time.Now(),
+	}
+
+	if err != nil {
+		outcome.Error = err.Error()
+	} else {
+		outcome.StatusCode = res.StatusCode
+		outcome.Success = s.successful(res.StatusCode)
+	}
+
+	s.workerConfig.Metrics.incDeliveries(q.url, outcome.StatusCode)
+	s.publishOutcome(outcome)
+}
+
+func (s *Supervisor) recordFirstDelivery() {
+	s.firstDeliveryOnce.Do(func() {
+		elapsed := time.Since(s.startedAt)
+
+		s.logger.Infof("First message delivered %s after startup", elapsed)
+		s.workerConfig.Metrics.setTimeToFirstDelivery(elapsed)
+	})
+}
+
+func (s *Supervisor) httpRequest(ctx context.Context, q *queue, msg *types.Message) (*http.Response, error) {
+	body, err := s.requestBody(q, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if max := s.workerConfig.HTTPMaxBodySize; max > 0 && len(body) > max {
+		return nil, &requestTooLargeError{size: len(body), max: max}
+	}
+
+	ep := q.acquireEndpoint()
+	defer ep.release()
+
+	if deadline, ok := s.requestDeadline(ctx); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	if s.workerConfig.HTTPTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.workerConfig.HTTPTimeout)
+		defer cancel()
+	}
+
+	url := s.requestURL(ep.url, msg)
+	req, err := http.NewRequestWithContext(ctx, s.httpMethod(), url, bytes.NewBufferString(body))
+	if err != nil {
+		return nil, fmt.Errorf("Error while creating HTTP request: %s", err)
+	}
+
+	// Extra headers go first so that every header set by the supervisor
+	// below, the message ID and HMAC headers among them, takes precedence.
+	for name, value := range s.workerConfig.ExtraHeaders {
+		req.Header.Set(name, value)
+	}
+	s.addMessageAttributesToHeader(msg, req.Header)
+
+	req.Header.Set("X-Aws-Sqsd-Msgid", *msg.MessageId)
+	req.Header.Set(messageSizeHeader, strconv.Itoa(len(aws.ToString(msg.Body))))
+	if groupID := messageGroupID(msg); len(groupID) > 0 {
+		req.Header.Set(messageGroupHeader, groupID)
+	}
+	if s.workerConfig.EmitSQSDHeaders {
+		addSQSDHeaders(q, msg, req.Header)
+	}
+	if env := s.snsEnvelope(msg); env != nil {
+		addSNSHeaders(env, req.Header)
+	}
+	if task, ok := s.cronTask(msg); ok {
+		addCronTaskHeaders(task, msg, req.Header)
+	}
+
+	if s.workerConfig.BodyEnvelope {
+		req.Header.Set("Content-Type", envelopeContentType)
+	} else if len(q.httpContentType) > 0 {
+		req.Header.Set("Content-Type", q.httpContentType)
+	}
+
+	if len(q.hmacSecretKey) > 0 {
+		if err := s.signRequest(req, url, body, q.hmacSecretKey); err != nil {
+			return nil, &signatureError{err: err}
+		}
+	}
+
+	if encoding := s.contentEncoding(msg); len(encoding) > 0 {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+
+	if len(s.workerConfig.HTTPAccept) > 0 {
+		req.Header.Set("Accept", s.workerConfig.HTTPAccept)
+	}
+
+	if len(s.workerConfig.RequestIDHeader) > 0 {
+		req.Header.Set(s.workerConfig.RequestIDHeader, s.requestID(msg))
+	}
+	if len(s.workerConfig.CorrelationIDHeader) > 0 {
+		req.Header.Set(s.workerConfig.CorrelationIDHeader, correlationID(msg, s.workerConfig.CorrelationIDAttributes))
+	}
+
+	if len(s.workerConfig.DeadlineHeader) > 0 {
+		deadline, ok := s.processingDeadline(ctx)
+		if s.workerConfig.VisibilityDeadline {
+			deadline, ok = ctx.Deadline()
+		}
+		if ok {
+			req.Header.Set(s.workerConfig.DeadlineHeader, deadline.UTC().Format(time.RFC3339Nano))
+		}
+	}
+
+	if len(s.workerConfig.RedeliveryHeader) > 0 {
+		req.Header.Set(s.workerConfig.RedeliveryHeader, strconv.FormatBool(isRedelivery(msg)))
+	}
+
+	var seg *xraySegment
+	if s.workerConfig.XRayEnabled {
+		seg = startXRaySegment(msg, q.url)
+		req.Header.Set(xrayTraceHeader, seg.header())
+	}
+
+	if sp := spanFromContext(ctx); sp != nil {
+		req.Header.Set(traceparentHeader, sp.traceparent())
+		if len(sp.traceState) > 0 {
+			req.Header.Set(tracestateHeader, sp.traceState)
+		}
+	}
+
+	if s.workerConfig.Authenticator != nil {
+		if err := s.workerConfig.Authenticator.Authenticate(req, body); err != nil {
+			return nil, &signatureError{err: err}
+		}
+	}
+
+	start := time.Now()
+	res, err := s.httpClient.Do(req)
+	s.workerConfig.Metrics.observeRequestDuration(q.url, time.Since(start))
+
+	if seg != nil {
+		seg.end(req, res, err)
+		s.sendXRaySegment(seg)
+	}
+
+	if err != nil {
+		return res, err
+	}
+
+	if s.workerConfig.HTTPMaxResponseBody > 0 {
+		if err := bufferResponseBody(res, s.workerConfig.HTTPMaxResponseBody); err != nil {
+			return nil, err
+		}
+
+		return res, nil
+	}
+
+	if s.workerConfig.HTTPErrorBodyLimit > 0 && !s.successful(res.StatusCode) {
+		captureResponseBody(res, s.workerConfig.HTTPErrorBodyLimit)
+		return res, nil
+	}
+
+	res.Body.Close()
+
+	return res, nil
+}
+
+// bodyMatchesMD5 reports whether the body of msg matches the MD5 computed by
+// SQS.
+func bodyMatchesMD5(msg *types.Message) bool {
+	sum := md5.Sum([]byte(aws.ToString(msg.Body)))
+	return hex.EncodeToString(sum[:]) == aws.ToString(msg.MD5OfBody)
+}
+
+// isStale reports whether msg was sent longer ago than DropOlderThan.
+func (s *Supervisor) isStale(msg *types.Message) bool {
+	if s.workerConfig.DropOlderThan <= 0 {
+		return false
+	}
+
+	sent, ok := sentTimestamp(msg)
+	return ok && time.Since(sent) > s.workerConfig.DropOlderThan
+}
+
+// isRedelivery reports whether msg has been received before.
+func isRedelivery(msg *types.Message) bool {
+	return receiveCount(msg) > 1
+}
+
+// addMessageAttributesToHeader copies the String and Number message
+// attributes of msg into header. Binary attributes are skipped.
+func (s *Supervisor) addMessageAttributesToHeader(msg *types.Message, header http.Header) {
+	prefix := s.workerConfig.AttributeHeaderPrefix
+	switch prefix {
+	case "":
+		prefix = DefaultAttributeHeaderPrefix
+	case NoAttributeHeaderPrefix:
+		prefix = ""
+	}
+
+	for k, v := range msg.MessageAttributes {
+		if v.StringValue == nil || strings.HasPrefix(aws.ToString(v.DataType), "Binary") {
+			s.logger.Debugf("Skipping %s attribute %s of message %s", aws.ToString(v.DataType), k, aws.ToString(msg.MessageId))
+			continue
+		}
+
+		header.Set(prefix+k, *v.StringValue)
+	}
+}
+
+// signRequest sets the HMAC header of req, a request to url with body. It
+// must be called once the Content-Type of req is set.
+func (s *Supervisor) signRequest(req *http.Request, url string, body string, secretKey []byte) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := hex.EncodeToString(randomBytes(16))
+
+	var signature string
+	switch {
+	case len(s.workerConfig.HMACSignatureFormat) > 0:
+		signature = strings.NewReplacer(
+			"{method}", req.Method,
+			"{url}", url,
+			"{path}", req.URL.RequestURI(),
+			"{content-type}", req.Header.Get("Content-Type"),
+			"{timestamp}", timestamp,
+			"{nonce}", nonce,
+			"{body}", body,
+		).Replace(s.workerConfig.HMACSignatureFormat)
+	case s.workerConfig.HMACSignatureMode == SignatureBodyOnly:
+		signature = body
+	default:
+		signature = strings.Join([]string{fmt.Sprintf("%s %s\n", req.Method, url), body}, "")
+	}
+
+	if s.workerConfig.HMACReplayProtection {
+		req.Header.Set(hmacTimestampHeader, timestamp)
+		req.Header.Set(hmacNonceHeader, nonce)
+		if len(s.workerConfig.HMACSignatureFormat) == 0 {
+			signature = timestamp + "\n" + nonce + "\n" + signature
+		}
+	}
+
+	hmac, err := makeHMAC(s.workerConfig.HMACAlgorithm, signature, secretKey)
+	if err != nil {
+		return err
+	}
+
+	if len(s.workerConfig.HMACHeaderFormat) > 0 {
+		hmac = strings.NewReplacer(
+			"{signature}", hmac,
+			"{timestamp}", timestamp,
+			"{nonce}", nonce,
+		).Replace(s.workerConfig.HMACHeaderFormat)
+	}
+
+	header := s.workerConfig.HTTPHMACHeader
+	if len(header) == 0 {
+		header = DefaultHMACHeader
+	}
+	req.Header.Set(header, hmac)
+
+	return nil
+}
+
+func makeHMAC(algorithm HMACAlgorithm, signature string, secretKey []byte) (string, error) {
+	mac := hmac.New(algorithm.hash(), secretKey)
+
+	_, err := mac.Write([]byte(signature))
+	if err != nil {
+		return "", fmt.Errorf("Error while writing HMAC: %s", err)
+	}
+
+	return hex.EncodeToString(mac.Sum(nil)), nil
 }
@@ -1,32 +1,42 @@
 package supervisor
 
 import (
+	"context"
 	"crypto/hmac"
+	"crypto/md5"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/sqs"
-	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 )
 
 type mockSQS struct {
-	sqsiface.SQSAPI
-
 	receiveMessageFunc               func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error)
 	deleteMessageBatchFunc           func(*sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error)
 	changeMessageVisibilityBatchFunc func(*sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error)
+	changeMessageVisibilityFunc      func(*sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error)
+	sendMessageFunc                  func(*sqs.SendMessageInput) (*sqs.SendMessageOutput, error)
 }
 
-func (m *mockSQS) ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+func (m *mockSQS) ReceiveMessage(ctx context.Context, input *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
 	if m.receiveMessageFunc != nil {
 		return m.receiveMessageFunc(input)
 	}
@@ -34,7 +44,7 @@ func (m *mockSQS) ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMe
 	return nil, nil
 }
 
-func (m *mockSQS) DeleteMessageBatch(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+func (m *mockSQS) DeleteMessageBatch(ctx context.Context, input *sqs.DeleteMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageBatchOutput, error) {
 	if m.deleteMessageBatchFunc != nil {
 		return m.deleteMessageBatchFunc(input)
 	}
@@ -42,7 +52,7 @@ func (m *mockSQS) DeleteMessageBatch(input *sqs.DeleteMessageBatchInput) (*sqs.D
 	return nil, nil
 }
 
-func (m *mockSQS) ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+func (m *mockSQS) ChangeMessageVisibilityBatch(ctx context.Context, input *sqs.ChangeMessageVisibilityBatchInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
 	if m.changeMessageVisibilityBatchFunc != nil {
 		return m.changeMessageVisibilityBatchFunc(input)
 	}
@@ -50,6 +60,22 @@ func (m *mockSQS) ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilit
 	return nil, nil
 }
 
+func (m *mockSQS) ChangeMessageVisibility(ctx context.Context, input *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	if m.changeMessageVisibilityFunc != nil {
+		return m.changeMessageVisibilityFunc(input)
+	}
+
+	return nil, nil
+}
+
+func (m *mockSQS) SendMessage(ctx context.Context, input *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	if m.sendMessageFunc != nil {
+		return m.sendMessageFunc(input)
+	}
+
+	return nil, nil
+}
+
 func TestSupervisorSuccess(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
@@ -68,7 +94,7 @@ func TestSupervisorSuccess(t *testing.T) {
 
 	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
 		return &sqs.ReceiveMessageOutput{
-			Messages: []*sqs.Message{{
+			Messages: []types.Message{{
 				Body:          aws.String("message 1"),
 				MessageId:     aws.String("m1"),
 				ReceiptHandle: aws.String("r1"),
@@ -126,12 +152,12 @@ func TestSupervisorHTTPError(t *testing.T) {
 			supervisor.Shutdown()
 
 			return &sqs.ReceiveMessageOutput{
-				Messages: []*sqs.Message{},
+				Messages: []types.Message{},
 			}, nil
 		}
 
 		return &sqs.ReceiveMessageOutput{
-			Messages: []*sqs.Message{{
+			Messages: []types.Message{{
 				Body:          aws.String("message 1"),
 				MessageId:     aws.String("m1"),
 				ReceiptHandle: aws.String("r1"),
@@ -192,11 +218,17 @@ func TestSupervisorHMAC(t *testing.T) {
 
 	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
 
+	receiveCount := 0
 	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
-		defer supervisor.Shutdown()
+		receiveCount++
+
+		if receiveCount > 1 {
+			supervisor.Shutdown()
+			return &sqs.ReceiveMessageOutput{}, nil
+		}
 
 		return &sqs.ReceiveMessageOutput{
-			Messages: []*sqs.Message{{
+			Messages: []types.Message{{
 				Body:          aws.String("message 1"),
 				MessageId:     aws.String("m1"),
 				ReceiptHandle: aws.String("r1"),
@@ -215,6 +247,222 @@ func TestSupervisorHMAC(t *testing.T) {
 	assert.True(t, hmacSuccess)
 }
 
+func TestSupervisorHMACBodyOnly(t *testing.T) {
+	var header http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:           ts.URL,
+		HTTPHMACHeader:    "X-Signature-SHA256",
+		HMACSecretKey:     []byte("foobar"),
+		HMACSignatureMode: SignatureBodyOnly,
+	})
+
+	_, err := supervisor.httpRequest(context.Background(), supervisor.queues[0], &types.Message{
+		Body:      aws.String("message 1"),
+		MessageId: aws.String("m1"),
+	})
+	assert.NoError(t, err)
+
+	expected, _ := makeHMAC(HMACSHA256, "message 1", []byte("foobar"))
+	assert.Equal(t, expected, header.Get("X-Signature-SHA256"))
+}
+
+func TestSupervisorHMACDefaultHeader(t *testing.T) {
+	var header http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:           ts.URL,
+		HMACSecretKey:     []byte("foobar"),
+		HMACSignatureMode: SignatureBodyOnly,
+	})
+
+	_, err := supervisor.httpRequest(context.Background(), supervisor.queues[0], &types.Message{
+		Body:      aws.String("message 1"),
+		MessageId: aws.String("m1"),
+	})
+	assert.NoError(t, err)
+
+	expected, _ := makeHMAC(HMACSHA256, "message 1", []byte("foobar"))
+	assert.Equal(t, expected, header.Get(DefaultHMACHeader))
+}
+
+func TestSupervisorHMACFormat(t *testing.T) {
+	var header http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	msg := &types.Message{Body: aws.String("message"), MessageId: aws.String("m1")}
+
+	// Stripe-style signatures sign the timestamp ahead of the body.
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:             ts.URL + "/events?source=sqs",
+		HTTPContentType:     "application/json",
+		HTTPHMACHeader:      "Stripe-Signature",
+		HMACSecretKey:       []byte("foobar"),
+		HMACSignatureFormat: "{timestamp}.{method} {path} {content-type}.{body}",
+		HMACHeaderFormat:    "t={timestamp},v1={signature}",
+	})
+
+	_, err := supervisor.httpRequest(context.Background(), supervisor.queues[0], msg)
+	assert.NoError(t, err)
+
+	var timestamp, signature string
+	for _, part := range strings.Split(header.Get("Stripe-Signature"), ",") {
+		if strings.HasPrefix(part, "t=") {
+			timestamp = strings.TrimPrefix(part, "t=")
+		} else if strings.HasPrefix(part, "v1=") {
+			signature = strings.TrimPrefix(part, "v1=")
+		}
+	}
+
+	expected, _ := makeHMAC(HMACSHA256, timestamp+".POST /events?source=sqs application/json.message", []byte("foobar"))
+	assert.NotEmpty(t, timestamp)
+	assert.Equal(t, expected, signature)
+	assert.Empty(t, header.Get("X-Sqsd-Signature-Timestamp"))
+
+	// GitHub-style signatures prefix the HMAC of the body with its algorithm,
+	// and replay protection only sends the headers with a format.
+	supervisor = NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:              ts.URL,
+		HTTPHMACHeader:       "X-Hub-Signature-256",
+		HMACSecretKey:        []byte("foobar"),
+		HMACSignatureFormat:  "{body}",
+		HMACHeaderFormat:     "sha256={signature}",
+		HMACReplayProtection: true,
+	})
+
+	_, err = supervisor.httpRequest(context.Background(), supervisor.queues[0], msg)
+	assert.NoError(t, err)
+
+	expected, _ = makeHMAC(HMACSHA256, "message", []byte("foobar"))
+	assert.Equal(t, "sha256="+expected, header.Get("X-Hub-Signature-256"))
+	assert.NotEmpty(t, header.Get("X-Sqsd-Signature-Timestamp"))
+	assert.Len(t, header.Get("X-Sqsd-Signature-Nonce"), 32)
+}
+
+func TestSupervisorHMACReplayProtection(t *testing.T) {
+	var headers []http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	// Supervisors sign with their own settings side by side.
+	sha256Supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:        ts.URL + "/a",
+		HTTPHMACHeader: "X-Signature",
+		HMACSecretKey:  []byte("foo"),
+	})
+	sha512Supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:              ts.URL + "/b",
+		HTTPHMACHeader:       "X-Signature",
+		HMACSecretKey:        []byte("bar"),
+		HMACAlgorithm:        HMACSHA512,
+		HMACReplayProtection: true,
+	})
+
+	msg := &types.Message{Body: aws.String("message"), MessageId: aws.String("m1")}
+	for _, supervisor := range []*Supervisor{sha512Supervisor, sha256Supervisor, sha512Supervisor} {
+		_, err := supervisor.httpRequest(context.Background(), supervisor.queues[0], msg)
+		assert.NoError(t, err)
+	}
+	if !assert.Len(t, headers, 3) {
+		return
+	}
+
+	timestamp := headers[0].Get("X-Sqsd-Signature-Timestamp")
+	nonce := headers[0].Get("X-Sqsd-Signature-Nonce")
+	assert.Len(t, nonce, 32)
+	assert.NotEqual(t, nonce, headers[2].Get("X-Sqsd-Signature-Nonce"))
+
+	expected, _ := makeHMAC(HMACSHA512, fmt.Sprintf("%s\n%s\nPOST %s/b\nmessage", timestamp, nonce, ts.URL), []byte("bar"))
+	assert.Equal(t, expected, headers[0].Get("X-Signature"))
+	assert.Len(t, expected, 128)
+
+	expected, _ = makeHMAC(HMACSHA256, fmt.Sprintf("POST %s/a\nmessage", ts.URL), []byte("foo"))
+	assert.Equal(t, expected, headers[1].Get("X-Signature"))
+	assert.Empty(t, headers[1].Get("X-Sqsd-Signature-Timestamp"))
+}
+
+func TestSupervisorMessageLogFields(t *testing.T) {
+	var requestID string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = r.Header.Get("X-Request-Id")
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) == "fail" {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("upstream unavailable"))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	logger, hook := test.NewNullLogger()
+	logger.SetLevel(log.DebugLevel)
+	supervisor := NewSupervisor(log.NewEntry(logger), &mockSQS{}, &http.Client{}, WorkerConfig{
+		QueueURL:            "https://queue.url/jobs",
+		HTTPURL:             ts.URL,
+		RequestIDHeader:     "X-Request-Id",
+		CorrelationIDHeader: "X-Correlation-Id",
+		HTTPErrorBodyLimit:  8,
+	})
+
+	for _, tt := range []struct {
+		body         string
+		level        log.Level
+		httpStatus   int
+		responseBody interface{}
+	}{
+		{body: "ok", level: log.DebugLevel, httpStatus: http.StatusOK},
+		{body: "fail", level: log.ErrorLevel, httpStatus: http.StatusBadGateway, responseBody: "upstream"},
+	} {
+		supervisor.processMessage(context.Background(), supervisor.queues[0], &types.Message{
+			Body:          aws.String(tt.body),
+			MessageId:     aws.String("m-" + tt.body),
+			ReceiptHandle: aws.String("r1"),
+			Attributes: map[string]string{
+				string(types.MessageSystemAttributeNameApproximateReceiveCount): "3",
+			},
+		})
+
+		var entry *log.Entry
+		for _, e := range hook.AllEntries() {
+			if e.Data["messageId"] == "m-"+tt.body {
+				entry = e
+			}
+		}
+
+		if assert.NotNil(t, entry, tt.body) {
+			assert.Equal(t, tt.level, entry.Level, tt.body)
+			assert.Equal(t, "m-"+tt.body, entry.Data["messageId"], tt.body)
+			assert.Equal(t, "https://queue.url/jobs", entry.Data["queue"], tt.body)
+			assert.Equal(t, 3, entry.Data["receiveCount"], tt.body)
+			assert.Equal(t, tt.httpStatus, entry.Data["httpStatus"], tt.body)
+			assert.Equal(t, tt.responseBody, entry.Data["responseBody"], tt.body)
+			assert.Equal(t, "m-"+tt.body, requestID, tt.body)
+			assert.Equal(t, requestID, entry.Data["requestId"], tt.body)
+			assert.NotEmpty(t, entry.Data["correlationId"], tt.body)
+			assert.Contains(t, entry.Data, "durationMs", tt.body)
+		}
+	}
+}
+
 func TestSupervisorTooManyRequests(t *testing.T) {
 	delayTime := time.Duration(1 * time.Hour)
 	requestCount := 0
@@ -236,7 +484,7 @@ func TestSupervisorTooManyRequests(t *testing.T) {
 	}
 
 	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
-		return &sqs.ReceiveMessageOutput{Messages: []*sqs.Message{{
+		return &sqs.ReceiveMessageOutput{Messages: []types.Message{{
 			Body:          aws.String("message 1"),
 			MessageId:     aws.String("m1"),
 			ReceiptHandle: aws.String("r1"),
@@ -264,8 +512,8 @@ func TestSupervisorTooManyRequests(t *testing.T) {
 
 		assert.Len(t, input.Entries, 3)
 		for _, entry := range input.Entries {
-			VisibilityTimeout := *entry.VisibilityTimeout
-			timeoutDiff := int64(delayTime.Seconds()) - VisibilityTimeout
+			VisibilityTimeout := entry.VisibilityTimeout
+			timeoutDiff := int32(delayTime.Seconds()) - VisibilityTimeout
 			assert.True(t, timeoutDiff >= 0)
 			assert.True(t, timeoutDiff < 5)
 		}
@@ -304,12 +552,12 @@ func TestSupervisorTooManyRequestsBadRetryAfter(t *testing.T) {
 			supervisor.Shutdown()
 
 			return &sqs.ReceiveMessageOutput{
-				Messages: []*sqs.Message{},
+				Messages: []types.Message{},
 			}, nil
 		}
 
 		return &sqs.ReceiveMessageOutput{
-			Messages: []*sqs.Message{{
+			Messages: []types.Message{{
 				Body:          aws.String("message 1"),
 				MessageId:     aws.String("m1"),
 				ReceiptHandle: aws.String("r1"),
@@ -338,3 +586,1168 @@ func TestSupervisorTooManyRequestsBadRetryAfter(t *testing.T) {
 	supervisor.Start(1)
 	supervisor.Wait()
 }
+
+func TestSupervisorNilReceiveOutput(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{}
+
+	supervisor := NewSupervisor(log.NewEntry(logger), mockSQS, &http.Client{}, config)
+
+	receiveCount := 0
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		receiveCount++
+
+		if receiveCount == 2 {
+			supervisor.Shutdown()
+		}
+
+		return nil, nil
+	}
+
+	assert.NotPanics(t, func() {
+		supervisor.Start(1)
+		supervisor.Wait()
+	})
+
+	warnings := 0
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == log.WarnLevel {
+			warnings++
+		}
+	}
+	assert.Equal(t, 2, warnings)
+}
+
+func TestSupervisorTimeToFirstDelivery(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	metrics := NewMetrics(prometheus.NewRegistry())
+	config := WorkerConfig{
+		HTTPURL: ts.URL,
+		Metrics: metrics,
+	}
+
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String("message 1"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+			}},
+		}, nil
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		defer supervisor.Shutdown()
+
+		return nil, nil
+	}
+
+	assert.Zero(t, testutil.ToFloat64(metrics.timeToFirstDelivery))
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	assert.True(t, testutil.ToFloat64(metrics.timeToFirstDelivery) >= 0.01)
+}
+
+func TestSupervisorDeleteRetriesPartialFailures(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		HTTPURL:          ts.URL,
+		DeleteMaxRetries: 3,
+	}
+
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String("message 1"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+			}, {
+				Body:          aws.String("message 2"),
+				MessageId:     aws.String("m2"),
+				ReceiptHandle: aws.String("r2"),
+			}, {
+				Body:          aws.String("message 3"),
+				MessageId:     aws.String("m3"),
+				ReceiptHandle: aws.String("r3"),
+			}},
+		}, nil
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	// Each attempt deletes the first submitted entry and fails the rest.
+	deleted := map[string]int{}
+	attempts := 0
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		attempts++
+
+		output := &sqs.DeleteMessageBatchOutput{}
+		for i, entry := range input.Entries {
+			if i == 0 {
+				deleted[*entry.Id]++
+				output.Successful = append(output.Successful, types.DeleteMessageBatchResultEntry{Id: entry.Id})
+				continue
+			}
+
+			output.Failed = append(output.Failed, types.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("InternalError")})
+		}
+
+		if len(output.Failed) == 0 {
+			supervisor.Shutdown()
+		}
+
+		return output, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, map[string]int{"m1": 1, "m2": 1, "m3": 1}, deleted)
+}
+
+func TestSupervisorDeleteSplitsBatches(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	mockSQS := &mockSQS{}
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{})
+
+	var sizes []int
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		sizes = append(sizes, len(input.Entries))
+
+		output := &sqs.DeleteMessageBatchOutput{}
+		for _, entry := range input.Entries {
+			if *entry.Id == "m12" {
+				output.Failed = append(output.Failed, types.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("InternalError")})
+				continue
+			}
+
+			output.Successful = append(output.Successful, types.DeleteMessageBatchResultEntry{Id: entry.Id})
+		}
+
+		return output, nil
+	}
+
+	var entries []types.DeleteMessageBatchRequestEntry
+	for i := 0; i < 23; i++ {
+		entries = append(entries, types.DeleteMessageBatchRequestEntry{
+			Id:            aws.String(fmt.Sprintf("m%d", i)),
+			ReceiptHandle: aws.String(fmt.Sprintf("r%d", i)),
+		})
+	}
+
+	undeleted := supervisor.deleteMessages(supervisor.queues[0], entries)
+
+	assert.Equal(t, []int{10, 10, 3}, sizes)
+	if assert.Len(t, undeleted, 1) {
+		assert.Equal(t, "m12", *undeleted[0].Id)
+	}
+}
+
+func TestSupervisorDeleteSkipsSenderFaults(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	mockSQS := &mockSQS{}
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+		DeleteMaxRetries: 2,
+	})
+
+	attempts := map[string]int{}
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		output := &sqs.DeleteMessageBatchOutput{}
+		for _, entry := range input.Entries {
+			attempts[*entry.Id]++
+
+			switch *entry.Id {
+			case "invalid":
+				output.Failed = append(output.Failed, types.BatchResultErrorEntry{
+					Id:          entry.Id,
+					Code:        aws.String("ReceiptHandleIsInvalid"),
+					SenderFault: true,
+				})
+			case "flaky":
+				output.Failed = append(output.Failed, types.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("InternalError")})
+			default:
+				output.Successful = append(output.Successful, types.DeleteMessageBatchResultEntry{Id: entry.Id})
+			}
+		}
+
+		return output, nil
+	}
+
+	undeleted := supervisor.deleteMessages(supervisor.queues[0], []types.DeleteMessageBatchRequestEntry{
+		{Id: aws.String("ok"), ReceiptHandle: aws.String("r1")},
+		{Id: aws.String("invalid"), ReceiptHandle: aws.String("r2")},
+		{Id: aws.String("flaky"), ReceiptHandle: aws.String("r3")},
+	})
+
+	assert.Equal(t, map[string]int{"ok": 1, "invalid": 1, "flaky": 3}, attempts)
+	assert.Len(t, undeleted, 2)
+}
+
+type fakePublisher struct {
+	sync.Mutex
+	outcomes []Outcome
+}
+
+func (p *fakePublisher) Publish(outcome Outcome) error {
+	p.Lock()
+	defer p.Unlock()
+
+	p.outcomes = append(p.outcomes, outcome)
+
+	return nil
+}
+
+func TestSupervisorPublishesOutcomes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) == "fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	publisher := &fakePublisher{}
+	config := WorkerConfig{
+		QueueURL:         "https://queue.url",
+		HTTPURL:          ts.URL,
+		OutcomePublisher: publisher,
+	}
+
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String("ok"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+			}, {
+				Body:          aws.String("fail"),
+				MessageId:     aws.String("m2"),
+				ReceiptHandle: aws.String("r2"),
+			}},
+		}, nil
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		defer supervisor.Shutdown()
+
+		return nil, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	if assert.Len(t, publisher.outcomes, 2) {
+		assert.Equal(t, "m1", publisher.outcomes[0].MessageID)
+		assert.Equal(t, "https://queue.url", publisher.outcomes[0].QueueURL)
+		assert.True(t, publisher.outcomes[0].Success)
+		assert.Equal(t, http.StatusOK, publisher.outcomes[0].StatusCode)
+
+		assert.Equal(t, "m2", publisher.outcomes[1].MessageID)
+		assert.False(t, publisher.outcomes[1].Success)
+		assert.Equal(t, http.StatusInternalServerError, publisher.outcomes[1].StatusCode)
+	}
+}
+
+func TestSupervisorBodyFilter(t *testing.T) {
+	for _, action := range []FilterAction{FilterActionDelete, FilterActionLeave} {
+		t.Run(string(action), func(t *testing.T) {
+			var delivered []string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				delivered = append(delivered, string(body))
+
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer ts.Close()
+
+			log.SetOutput(ioutil.Discard)
+			logger := log.WithFields(log.Fields{})
+			mockSQS := &mockSQS{}
+			config := WorkerConfig{
+				HTTPURL:          ts.URL,
+				BodyFilter:       regexp.MustCompile(`^order\.`),
+				BodyFilterAction: action,
+			}
+
+			mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+				return &sqs.ReceiveMessageOutput{
+					Messages: []types.Message{{
+						Body:          aws.String("order.created"),
+						MessageId:     aws.String("m1"),
+						ReceiptHandle: aws.String("r1"),
+					}, {
+						Body:          aws.String("heartbeat"),
+						MessageId:     aws.String("m2"),
+						ReceiptHandle: aws.String("r2"),
+					}},
+				}, nil
+			}
+
+			supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+			var deleted []string
+			mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+				defer supervisor.Shutdown()
+
+				for _, entry := range input.Entries {
+					deleted = append(deleted, *entry.Id)
+				}
+
+				return nil, nil
+			}
+
+			supervisor.Start(1)
+			supervisor.Wait()
+
+			assert.Equal(t, []string{"order.created"}, delivered)
+			if action == FilterActionDelete {
+				assert.Equal(t, []string{"m1", "m2"}, deleted)
+			} else {
+				assert.Equal(t, []string{"m1"}, deleted)
+			}
+		})
+	}
+}
+
+func TestSupervisorSuppressesDuplicateAfterDeleteFailure(t *testing.T) {
+	requestCount := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		HTTPURL:         ts.URL,
+		DuplicateWindow: time.Minute,
+	}
+
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String("message 1"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+			}},
+		}, nil
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	deleteCount := 0
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		deleteCount++
+
+		if deleteCount == 1 {
+			return nil, errors.New("access denied")
+		}
+
+		supervisor.Shutdown()
+
+		return nil, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	assert.Equal(t, 1, requestCount)
+	assert.Equal(t, 2, deleteCount)
+}
+
+func TestSupervisorAuditDeletes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	logger, hook := test.NewNullLogger()
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		HTTPURL:      ts.URL,
+		AuditDeletes: true,
+	}
+
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String("message 1"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+			}, {
+				Body:          aws.String("message 2"),
+				MessageId:     aws.String("m2"),
+				ReceiptHandle: aws.String("r2"),
+			}},
+		}, nil
+	}
+
+	supervisor := NewSupervisor(log.NewEntry(logger), mockSQS, &http.Client{}, config)
+
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		defer supervisor.Shutdown()
+
+		return &sqs.DeleteMessageBatchOutput{
+			Failed: []types.BatchResultErrorEntry{{Id: aws.String("m2")}},
+		}, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	var audits []*log.Entry
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Message deleted" {
+			audits = append(audits, entry)
+		}
+	}
+
+	if assert.Len(t, audits, 1) {
+		assert.Equal(t, "m1", audits[0].Data["messageId"])
+		assert.Equal(t, 1, audits[0].Data["attempts"])
+		assert.Equal(t, "delivered", audits[0].Data["status"])
+		assert.Equal(t, http.StatusAccepted, audits[0].Data["httpStatus"])
+		assert.Contains(t, audits[0].Data, "durationMs")
+	}
+}
+
+func TestSupervisorPausesOnDeleteFailures(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		HTTPURL:                ts.URL,
+		DeleteFailureThreshold: 2,
+		DeleteFailureBackoff:   100 * time.Millisecond,
+	}
+
+	var receivedAt []time.Time
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		receivedAt = append(receivedAt, time.Now())
+
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String("message 1"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+			}},
+		}, nil
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	deleteCount := 0
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		deleteCount++
+
+		switch deleteCount {
+		case 1, 2:
+			return nil, errors.New("access denied")
+		case 4:
+			supervisor.Shutdown()
+		}
+
+		return nil, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	if assert.Len(t, receivedAt, 4) {
+		assert.True(t, receivedAt[1].Sub(receivedAt[0]) < 50*time.Millisecond)
+		assert.True(t, receivedAt[2].Sub(receivedAt[1]) >= 100*time.Millisecond)
+		assert.True(t, receivedAt[3].Sub(receivedAt[2]) < 50*time.Millisecond)
+	}
+}
+
+func TestSupervisorBatchConcurrency(t *testing.T) {
+	var (
+		mu          sync.Mutex
+		inFlight    int
+		maxInFlight int
+		allArrived  = make(chan struct{})
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		if inFlight == 4 {
+			close(allArrived)
+		}
+		mu.Unlock()
+
+		select {
+		case <-allArrived:
+		case <-time.After(time.Second):
+		}
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		body, _ := ioutil.ReadAll(r.Body)
+		if string(body) == "message 3" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		HTTPURL:          ts.URL,
+		BatchConcurrency: 4,
+	}
+
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		messages := make([]types.Message, 0, 4)
+		for i := 1; i <= 4; i++ {
+			messages = append(messages, types.Message{
+				Body:          aws.String(fmt.Sprintf("message %d", i)),
+				MessageId:     aws.String(fmt.Sprintf("m%d", i)),
+				ReceiptHandle: aws.String(fmt.Sprintf("r%d", i)),
+			})
+		}
+
+		return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	var deleted []string
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		defer supervisor.Shutdown()
+
+		for _, entry := range input.Entries {
+			deleted = append(deleted, *entry.Id)
+		}
+
+		return nil, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	assert.Equal(t, 4, maxInFlight)
+	assert.Equal(t, []string{"m1", "m2", "m4"}, deleted)
+}
+
+func TestSupervisorRedeliveryHeader(t *testing.T) {
+	var headers []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header.Get("X-Sqsd-Redelivery"))
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		HTTPURL:          ts.URL,
+		RedeliveryHeader: "X-Sqsd-Redelivery",
+	}
+
+	receiveCount := 0
+	mockSQS.receiveMessageFunc = func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		assert.Contains(t, input.MessageSystemAttributeNames, types.MessageSystemAttributeNameApproximateReceiveCount)
+		receiveCount++
+
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String("message 1"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+				Attributes: map[string]string{
+					string(types.MessageSystemAttributeNameApproximateReceiveCount): fmt.Sprint(receiveCount),
+				},
+			}},
+		}, nil
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		if receiveCount == 2 {
+			supervisor.Shutdown()
+		}
+
+		return nil, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	assert.Equal(t, []string{"false", "true"}, headers)
+}
+
+func TestSupervisorStartupDelay(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		StartupDelay: 100 * time.Millisecond,
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	var receivedAt time.Time
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		defer supervisor.Shutdown()
+
+		receivedAt = time.Now()
+
+		return &sqs.ReceiveMessageOutput{}, nil
+	}
+
+	startedAt := time.Now()
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	assert.True(t, receivedAt.Sub(startedAt) >= 100*time.Millisecond, "received after %s", receivedAt.Sub(startedAt))
+}
+
+func TestSupervisorShutdownDuringStartupDelay(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		StartupDelay: time.Minute,
+	}
+
+	received := false
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		received = true
+
+		return &sqs.ReceiveMessageOutput{}, nil
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+	supervisor.Start(1)
+	supervisor.Shutdown()
+	supervisor.Wait()
+
+	assert.False(t, received)
+}
+
+func TestSupervisorDropsStaleMessages(t *testing.T) {
+	var delivered []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		delivered = append(delivered, string(body))
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	metrics := NewMetrics(prometheus.NewRegistry())
+	config := WorkerConfig{
+		QueueURL:      "https://sqs.us-east-1.amazonaws.com/123456789012/orders",
+		HTTPURL:       ts.URL,
+		DropOlderThan: time.Hour,
+		Metrics:       metrics,
+	}
+
+	sentAt := func(d time.Duration) map[string]string {
+		ms := time.Now().Add(-d).UnixNano() / int64(time.Millisecond)
+		return map[string]string{
+			string(types.MessageSystemAttributeNameSentTimestamp): fmt.Sprint(ms),
+		}
+	}
+
+	mockSQS.receiveMessageFunc = func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		assert.Contains(t, input.MessageSystemAttributeNames, types.MessageSystemAttributeNameSentTimestamp)
+
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String("stale"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+				Attributes:    sentAt(2 * time.Hour),
+			}, {
+				Body:          aws.String("fresh"),
+				MessageId:     aws.String("m2"),
+				ReceiptHandle: aws.String("r2"),
+				Attributes:    sentAt(time.Minute),
+			}},
+		}, nil
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	var deleted []string
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		defer supervisor.Shutdown()
+
+		for _, entry := range input.Entries {
+			deleted = append(deleted, *entry.Id)
+		}
+
+		return nil, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	assert.Equal(t, []string{"fresh"}, delivered)
+	assert.Equal(t, []string{"m1", "m2"}, deleted)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.dropped.WithLabelValues("orders", "stale")))
+}
+
+func TestSupervisorVerifyMD5(t *testing.T) {
+	var delivered []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		delivered = append(delivered, string(body))
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		HTTPURL:   ts.URL,
+		VerifyMD5: true,
+	}
+
+	bodyMD5 := func(body string) *string {
+		sum := md5.Sum([]byte(body))
+		return aws.String(hex.EncodeToString(sum[:]))
+	}
+
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String("message 1"),
+				MD5OfBody:     bodyMD5("message 1"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+			}, {
+				Body:          aws.String("message 2 tampered"),
+				MD5OfBody:     bodyMD5("message 2"),
+				MessageId:     aws.String("m2"),
+				ReceiptHandle: aws.String("r2"),
+			}},
+		}, nil
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	var deleted []string
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		defer supervisor.Shutdown()
+
+		for _, entry := range input.Entries {
+			deleted = append(deleted, *entry.Id)
+		}
+
+		return nil, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	assert.Equal(t, []string{"message 1"}, delivered)
+	assert.Equal(t, []string{"m1"}, deleted)
+}
+
+func TestSupervisorVerifyMD5ErrorQueue(t *testing.T) {
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	mockSQS := &mockSQS{}
+	var sent []string
+	mockSQS.sendMessageFunc = func(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+		assert.Equal(t, "https://error.queue", *input.QueueUrl)
+		sent = append(sent, *input.MessageBody)
+		return &sqs.SendMessageOutput{}, nil
+	}
+
+	sum := md5.Sum([]byte("message"))
+	message := &types.Message{
+		Body:          aws.String("message tampered"),
+		MD5OfBody:     aws.String(hex.EncodeToString(sum[:])),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+	}
+
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+		HTTPURL:       ts.URL,
+		VerifyMD5:     true,
+		ErrorQueueURL: "https://error.queue",
+	})
+
+	ctx, cancel := supervisor.inFlightContext(time.Now())
+	defer cancel()
+	result := supervisor.processMessage(ctx, supervisor.queues[0], message)
+
+	assert.Equal(t, dispositionDelete, result.disposition)
+	assert.Equal(t, "error-queue", result.status)
+	assert.Equal(t, FailureCorrupt, result.reason)
+	assert.Equal(t, []string{"message tampered"}, sent)
+	assert.Zero(t, requests.Load())
+}
+
+func TestSupervisorMaxInFlightBatches(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		HTTPURL:            ts.URL,
+		MaxInFlightBatches: 2,
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	var (
+		mu       sync.Mutex
+		receives int
+	)
+	receiveCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return receives
+	}
+
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		mu.Lock()
+		receives++
+		n := receives
+		mu.Unlock()
+
+		if n > 3 {
+			supervisor.Shutdown()
+			return &sqs.ReceiveMessageOutput{}, nil
+		}
+
+		id := fmt.Sprintf("m%d", n)
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String(id),
+				MessageId:     aws.String(id),
+				ReceiptHandle: aws.String(id),
+			}},
+		}, nil
+	}
+
+	supervisor.Start(1)
+
+	assert.Eventually(t, func() bool { return receiveCount() == 2 }, time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, receiveCount())
+
+	release <- struct{}{}
+	assert.Eventually(t, func() bool { return receiveCount() == 3 }, time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 3, receiveCount())
+
+	close(release)
+	supervisor.Wait()
+
+	assert.Equal(t, 4, receiveCount())
+}
+
+func TestSupervisorAttributeHeaders(t *testing.T) {
+	attributes := map[string]types.MessageAttributeValue{
+		"Job-Type": {DataType: aws.String("String"), StringValue: aws.String("resize")},
+		"Priority": {DataType: aws.String("Number"), StringValue: aws.String("5")},
+		"Payload":  {DataType: aws.String("Binary"), BinaryValue: []byte{0x1}},
+	}
+
+	tests := []struct {
+		prefix string
+		want   map[string]string
+	}{
+		{"", map[string]string{"X-Aws-Sqsd-Attr-Job-Type": "resize", "X-Aws-Sqsd-Attr-Priority": "5"}},
+		{"X-Attr-", map[string]string{"X-Attr-Job-Type": "resize", "X-Attr-Priority": "5"}},
+		{NoAttributeHeaderPrefix, map[string]string{"Job-Type": "resize", "Priority": "5"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			logger, hook := test.NewNullLogger()
+			logger.SetLevel(log.DebugLevel)
+
+			supervisor := NewSupervisor(log.NewEntry(logger), &mockSQS{}, &http.Client{}, WorkerConfig{AttributeHeaderPrefix: tt.prefix})
+
+			header := http.Header{}
+			supervisor.addMessageAttributesToHeader(&types.Message{MessageId: aws.String("m1"), MessageAttributes: attributes}, header)
+
+			assert.Len(t, header, len(tt.want))
+			for name, value := range tt.want {
+				assert.Equal(t, value, header.Get(name))
+			}
+
+			if assert.Len(t, hook.AllEntries(), 1) {
+				assert.Equal(t, log.DebugLevel, hook.LastEntry().Level)
+			}
+		})
+	}
+}
+
+func TestSupervisorExtraHeaders(t *testing.T) {
+	var header http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), &mockSQS{}, &http.Client{}, WorkerConfig{
+		HTTPURL:         ts.URL,
+		HTTPContentType: "application/json",
+		HTTPHMACHeader:  "hmac",
+		HMACSecretKey:   []byte("foobar"),
+		ExtraHeaders: map[string]string{
+			"Authorization":    "Bearer abc",
+			"X-Source":         "sqsd",
+			"hmac":             "forged",
+			"Content-Type":     "text/plain",
+			"X-Aws-Sqsd-Msgid": "forged",
+		},
+	})
+
+	_, err := supervisor.httpRequest(context.Background(), supervisor.queues[0], &types.Message{
+		Body:          aws.String("message"),
+		MessageId:     aws.String("m1"),
+		ReceiptHandle: aws.String("r1"),
+	})
+	assert.NoError(t, err)
+
+	expectedHMAC, _ := makeHMAC(HMACSHA256, fmt.Sprintf("POST %s\nmessage", ts.URL), []byte("foobar"))
+	assert.Equal(t, "Bearer abc", header.Get("Authorization"))
+	assert.Equal(t, "sqsd", header.Get("X-Source"))
+	assert.Equal(t, expectedHMAC, header.Get("hmac"))
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	assert.Equal(t, []string{"m1"}, header.Values("X-Aws-Sqsd-Msgid"))
+}
+
+func TestSupervisorHTTPTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		HTTPURL:     ts.URL,
+		HTTPTimeout: 20 * time.Millisecond,
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	receiveCount := 0
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		receiveCount++
+
+		if receiveCount > 1 {
+			supervisor.Shutdown()
+			return &sqs.ReceiveMessageOutput{}, nil
+		}
+
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String("message"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+			}},
+		}, nil
+	}
+
+	deleted := 0
+	mockSQS.deleteMessageBatchFunc = func(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		deleted += len(input.Entries)
+		return &sqs.DeleteMessageBatchOutput{}, nil
+	}
+
+	start := time.Now()
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Zero(t, deleted)
+	assert.Equal(t, int64(1), supervisor.Report().FailureReasons[FailureHTTPTimeout])
+}
+
+func TestSupervisorReleasesBatchReceivedDuringShutdown(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		HTTPURL: ts.URL,
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		supervisor.Shutdown()
+
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String("message 1"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+			}},
+		}, nil
+	}
+
+	var released []types.ChangeMessageVisibilityBatchRequestEntry
+	mockSQS.changeMessageVisibilityBatchFunc = func(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+		released = append(released, input.Entries...)
+		return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	assert.Zero(t, requests)
+	if assert.Len(t, released, 1) {
+		assert.Equal(t, "r1", *released[0].ReceiptHandle)
+		assert.Equal(t, int32(0), released[0].VisibilityTimeout)
+	}
+}
+
+// longPollSQS blocks in ReceiveMessage until its context is done,
+// like a long poll without messages.
+type longPollSQS struct {
+	mockSQS
+	polling chan struct{}
+}
+
+func (m *longPollSQS) ReceiveMessage(ctx context.Context, input *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	m.polling <- struct{}{}
+	<-ctx.Done()
+	return nil, &aws.RequestCanceledError{Err: ctx.Err()}
+}
+
+func TestSupervisorShutdownAbortsLongPoll(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	sqsClient := &longPollSQS{polling: make(chan struct{}, 1)}
+	supervisor := NewSupervisor(log.NewEntry(logger), sqsClient, &http.Client{}, WorkerConfig{QueueWaitTime: 20})
+
+	supervisor.Start(1)
+	<-sqsClient.polling
+
+	stopped := make(chan struct{})
+	go func() {
+		supervisor.Wait()
+		close(stopped)
+	}()
+
+	supervisor.Shutdown()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown did not abort the long poll")
+	}
+
+	for _, entry := range hook.AllEntries() {
+		assert.NotEqual(t, log.ErrorLevel, entry.Level, entry.Message)
+	}
+}
+
+func TestSupervisorConcurrentShutdown(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		time.Sleep(time.Millisecond)
+		return &sqs.ReceiveMessageOutput{}, nil
+	}
+
+	supervisors := []*Supervisor{
+		NewSupervisor(logger, mockSQS, &http.Client{}, WorkerConfig{}),
+		NewSupervisor(logger, mockSQS, &http.Client{}, WorkerConfig{}),
+	}
+
+	for _, supervisor := range supervisors {
+		supervisor.Start(4)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	for _, supervisor := range supervisors {
+		wg.Add(1)
+		go func(supervisor *Supervisor) {
+			defer wg.Done()
+
+			supervisor.Shutdown()
+			supervisor.Wait()
+		}(supervisor)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,89 @@
+package supervisortest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/fterrag/simple-sqsd/supervisor"
+)
+
+// Delivery is a message delivered to a Recorder.
+type Delivery struct {
+	QueueURL string
+	Message  *types.Message
+	// StatusCode is the status code the message was answered with.
+	StatusCode int
+}
+
+// Recorder is a supervisor.Deliverer recording the messages it delivers, and
+// answering them with its handler as an HTTP worker would. It is safe for
+// concurrent use.
+type Recorder struct {
+	handler http.Handler
+
+	mu         sync.Mutex
+	deliveries []Delivery
+}
+
+var _ supervisor.Deliverer = (*Recorder)(nil)
+
+// NewRecorder returns a Recorder delivering messages to handler, or answering
+// them with 200 OK when handler is nil.
+func NewRecorder(handler http.Handler) *Recorder {
+	return &Recorder{handler: handler}
+}
+
+// NewStatusRecorder returns a Recorder answering every message with
+// statusCode.
+func NewStatusRecorder(statusCode int) *Recorder {
+	return NewRecorder(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statusCode)
+	}))
+}
+
+// Deliver serves a POST request of the body of msg, with its ID in the
+// X-Aws-Sqsd-Msgid header, to the handler of the recorder.
+func (r *Recorder) Deliver(ctx context.Context, queueURL string, msg *types.Message) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	if r.handler == nil {
+		rec.WriteHeader(http.StatusOK)
+	} else {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(aws.ToString(msg.Body))).WithContext(ctx)
+		req.Header.Set("X-Aws-Sqsd-Msgid", aws.ToString(msg.MessageId))
+		r.handler.ServeHTTP(rec, req)
+	}
+
+	res := rec.Result()
+
+	r.mu.Lock()
+	r.deliveries = append(r.deliveries, Delivery{QueueURL: queueURL, Message: msg, StatusCode: res.StatusCode})
+	r.mu.Unlock()
+
+	return res, nil
+}
+
+// Deliveries returns the messages delivered so far, in order.
+func (r *Recorder) Deliveries() []Delivery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Delivery(nil), r.deliveries...)
+}
+
+// Bodies returns the bodies of the messages delivered so far, in order.
+func (r *Recorder) Bodies() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bodies := make([]string, 0, len(r.deliveries))
+	for _, d := range r.deliveries {
+		bodies = append(bodies, aws.ToString(d.Message.Body))
+	}
+
+	return bodies
+}
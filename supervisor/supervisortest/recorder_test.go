@@ -0,0 +1,41 @@
+package supervisortest
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	msg := &types.Message{Body: aws.String("message"), MessageId: aws.String("m1")}
+
+	res, err := NewRecorder(nil).Deliver(context.Background(), testQueueURL, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	res, err = NewStatusRecorder(http.StatusServiceUnavailable).Deliver(context.Background(), testQueueURL, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+
+	rec := NewRecorder(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, "message", string(body))
+		assert.Equal(t, "m1", r.Header.Get("X-Aws-Sqsd-Msgid"))
+
+		w.Header().Set("X-Sqsd-Visibility-Timeout", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+
+	res, err = rec.Deliver(context.Background(), testQueueURL, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, "60", res.Header.Get("X-Sqsd-Visibility-Timeout"))
+
+	assert.Equal(t, []Delivery{{QueueURL: testQueueURL, Message: msg, StatusCode: http.StatusTooManyRequests}}, rec.Deliveries())
+	assert.Equal(t, []string{"message"}, rec.Bodies())
+}
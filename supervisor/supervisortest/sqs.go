@@ -0,0 +1,391 @@
+package supervisortest
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/fterrag/simple-sqsd/supervisor"
+)
+
+// defaultVisibilityTimeout is the SQS default, used when a receive doesn't set
+// a visibility timeout.
+const defaultVisibilityTimeout = 30 * time.Second
+
+// VisibilityChange is a change of the visibility timeout of a message made
+// through a FakeSQS.
+type VisibilityChange struct {
+	QueueURL  string
+	MessageID string
+	// Timeout is the visibility timeout set, in seconds.
+	Timeout int64
+}
+
+// fakeMessage is a message in a queue of a FakeSQS.
+type fakeMessage struct {
+	msg           *types.Message
+	receiptHandle string
+	receiveCount  int
+	visibleAt     time.Time
+}
+
+// FakeSQS is an in-memory SQS implementing supervisor.SQSClient. Queues are
+// created on first use by their URL. Messages received are invisible for the
+// visibility timeout of the receive, or until ExpireVisibility, and are kept
+// until deleted. It is safe for concurrent use.
+type FakeSQS struct {
+	mu       sync.Mutex
+	queues   map[string][]*fakeMessage
+	nextID   int
+	received int
+	deleted  map[string][]types.Message
+	changes  []VisibilityChange
+	// enqueued is closed and replaced whenever a message is enqueued, to wake
+	// up long polls.
+	enqueued chan struct{}
+}
+
+var _ supervisor.SQSClient = (*FakeSQS)(nil)
+
+// NewFakeSQS returns an empty FakeSQS.
+func NewFakeSQS() *FakeSQS {
+	return &FakeSQS{
+		queues:   make(map[string][]*fakeMessage),
+		deleted:  make(map[string][]types.Message),
+		enqueued: make(chan struct{}),
+	}
+}
+
+// Enqueue adds a message with body to the queue at queueURL and returns its
+// ID.
+func (f *FakeSQS) Enqueue(queueURL string, body string) string {
+	return f.EnqueueMessage(queueURL, &types.Message{Body: aws.String(body)})
+}
+
+// EnqueueMessage adds a copy of msg, e.g. with message attributes, to the
+// queue at queueURL and returns its ID. A message ID is generated unless msg
+// has one.
+func (f *FakeSQS) EnqueueMessage(queueURL string, msg *types.Message) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.enqueue(queueURL, msg, time.Time{})
+}
+
+// enqueue adds a copy of msg to the queue at queueURL, visible from
+// visibleAt, and returns its ID.
+func (f *FakeSQS) enqueue(queueURL string, msg *types.Message, visibleAt time.Time) string {
+	f.nextID++
+	m := *msg
+	if m.MessageId == nil {
+		m.MessageId = aws.String(fmt.Sprintf("message-%d", f.nextID))
+	}
+	sum := md5.Sum([]byte(aws.ToString(m.Body)))
+	m.MD5OfBody = aws.String(hex.EncodeToString(sum[:]))
+
+	m.Attributes = make(map[string]string, len(msg.Attributes)+1)
+	for name, value := range msg.Attributes {
+		m.Attributes[name] = value
+	}
+	if _, ok := m.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)]; !ok {
+		m.Attributes[string(types.MessageSystemAttributeNameSentTimestamp)] = strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	}
+
+	f.queues[queueURL] = append(f.queues[queueURL], &fakeMessage{msg: &m, visibleAt: visibleAt})
+
+	close(f.enqueued)
+	f.enqueued = make(chan struct{})
+
+	return aws.ToString(m.MessageId)
+}
+
+// Messages returns the messages left in the queue at queueURL, visible or
+// not, in the order they were enqueued.
+func (f *FakeSQS) Messages(queueURL string) []types.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	messages := make([]types.Message, 0, len(f.queues[queueURL]))
+	for _, m := range f.queues[queueURL] {
+		messages = append(messages, copyMessage(m.msg))
+	}
+
+	return messages
+}
+
+// Visible returns how many messages of the queue at queueURL can be received.
+func (f *FakeSQS) Visible(queueURL string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.visible(queueURL, time.Now())
+}
+
+func (f *FakeSQS) visible(queueURL string, now time.Time) int {
+	n := 0
+	for _, m := range f.queues[queueURL] {
+		if !m.visibleAt.After(now) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Received returns how many messages were received from every queue,
+// counting every receive of a message.
+func (f *FakeSQS) Received() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.received
+}
+
+// Deleted returns the messages deleted from the queue at queueURL, in the
+// order they were deleted.
+func (f *FakeSQS) Deleted(queueURL string) []types.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]types.Message(nil), f.deleted[queueURL]...)
+}
+
+// VisibilityChanges returns the changes of visibility timeout made, in order.
+func (f *FakeSQS) VisibilityChanges() []VisibilityChange {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]VisibilityChange(nil), f.changes...)
+}
+
+// ExpireVisibility makes every message of the queue at queueURL visible
+// again, as if their visibility timeouts had expired.
+func (f *FakeSQS) ExpireVisibility(queueURL string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, m := range f.queues[queueURL] {
+		m.visibleAt = time.Time{}
+	}
+
+	close(f.enqueued)
+	f.enqueued = make(chan struct{})
+}
+
+// ReceiveMessage receives up to MaxNumberOfMessages visible
+// messages, waiting up to WaitTimeSeconds for one to be enqueued when there
+// are none.
+func (f *FakeSQS) ReceiveMessage(ctx context.Context, input *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	queueURL := aws.ToString(input.QueueUrl)
+	deadline := time.Now().Add(time.Duration(input.WaitTimeSeconds) * time.Second)
+
+	for {
+		f.mu.Lock()
+		now := time.Now()
+		if f.visible(queueURL, now) > 0 || !now.Before(deadline) {
+			messages := f.receive(queueURL, input, now)
+			f.mu.Unlock()
+
+			return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+		}
+		enqueued := f.enqueued
+		f.mu.Unlock()
+
+		timer := time.NewTimer(deadline.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, &aws.RequestCanceledError{Err: ctx.Err()}
+		case <-enqueued:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// receive returns copies of the visible messages of the queue at queueURL
+// received with input, and hides them for its visibility timeout.
+func (f *FakeSQS) receive(queueURL string, input *sqs.ReceiveMessageInput, now time.Time) []types.Message {
+	max := int(input.MaxNumberOfMessages)
+	if max < 1 {
+		max = 1
+	}
+
+	visibility := defaultVisibilityTimeout
+	if input.VisibilityTimeout > 0 {
+		visibility = time.Duration(input.VisibilityTimeout) * time.Second
+	}
+
+	messages := make([]types.Message, 0, max)
+	for _, m := range f.queues[queueURL] {
+		if len(messages) == max {
+			break
+		}
+		if m.visibleAt.After(now) {
+			continue
+		}
+
+		m.receiveCount++
+		m.receiptHandle = fmt.Sprintf("%s#%d", aws.ToString(m.msg.MessageId), m.receiveCount)
+		m.visibleAt = now.Add(visibility)
+		if m.receiveCount == 1 {
+			m.msg.Attributes[string(types.MessageSystemAttributeNameApproximateFirstReceiveTimestamp)] = strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+		}
+		m.msg.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)] = strconv.Itoa(m.receiveCount)
+
+		msg := copyMessage(m.msg)
+		msg.ReceiptHandle = aws.String(m.receiptHandle)
+		messages = append(messages, msg)
+	}
+
+	f.received += len(messages)
+
+	return messages
+}
+
+// find returns the index of the message of the queue at queueURL received
+// with receiptHandle, or -1 when the receipt handle is not the latest one of
+// any message.
+func (f *FakeSQS) find(queueURL string, receiptHandle string) int {
+	for i, m := range f.queues[queueURL] {
+		if len(m.receiptHandle) > 0 && m.receiptHandle == receiptHandle {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// DeleteMessageBatch deletes the messages of the entries. Entries with an
+// unknown receipt handle fail.
+func (f *FakeSQS) DeleteMessageBatch(ctx context.Context, input *sqs.DeleteMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageBatchOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	queueURL := aws.ToString(input.QueueUrl)
+	output := &sqs.DeleteMessageBatchOutput{}
+	for _, entry := range input.Entries {
+		i := f.find(queueURL, aws.ToString(entry.ReceiptHandle))
+		if i < 0 {
+			output.Failed = append(output.Failed, invalidReceiptHandle(entry.Id))
+			continue
+		}
+
+		f.deleted[queueURL] = append(f.deleted[queueURL], *f.queues[queueURL][i].msg)
+		f.queues[queueURL] = append(f.queues[queueURL][:i], f.queues[queueURL][i+1:]...)
+		output.Successful = append(output.Successful, types.DeleteMessageBatchResultEntry{Id: entry.Id})
+	}
+
+	return output, nil
+}
+
+// ChangeMessageVisibility hides the message of input for its visibility
+// timeout from now.
+func (f *FakeSQS) ChangeMessageVisibility(ctx context.Context, input *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	queueURL := aws.ToString(input.QueueUrl)
+	if !f.changeVisibility(queueURL, aws.ToString(input.ReceiptHandle), int64(input.VisibilityTimeout)) {
+		return nil, &types.ReceiptHandleIsInvalid{Message: aws.String("the receipt handle is invalid")}
+	}
+
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+// ChangeMessageVisibilityBatch hides the messages of the entries for their
+// visibility timeouts from now. Entries with an unknown receipt handle fail.
+func (f *FakeSQS) ChangeMessageVisibilityBatch(ctx context.Context, input *sqs.ChangeMessageVisibilityBatchInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	queueURL := aws.ToString(input.QueueUrl)
+	output := &sqs.ChangeMessageVisibilityBatchOutput{}
+	for _, entry := range input.Entries {
+		if !f.changeVisibility(queueURL, aws.ToString(entry.ReceiptHandle), int64(entry.VisibilityTimeout)) {
+			output.Failed = append(output.Failed, invalidReceiptHandle(entry.Id))
+			continue
+		}
+
+		output.Successful = append(output.Successful, types.ChangeMessageVisibilityBatchResultEntry{Id: entry.Id})
+	}
+
+	return output, nil
+}
+
+func (f *FakeSQS) changeVisibility(queueURL string, receiptHandle string, timeout int64) bool {
+	i := f.find(queueURL, receiptHandle)
+	if i < 0 {
+		return false
+	}
+
+	m := f.queues[queueURL][i]
+	m.visibleAt = time.Now().Add(time.Duration(timeout) * time.Second)
+	f.changes = append(f.changes, VisibilityChange{
+		QueueURL:  queueURL,
+		MessageID: aws.ToString(m.msg.MessageId),
+		Timeout:   timeout,
+	})
+
+	if timeout == 0 {
+		close(f.enqueued)
+		f.enqueued = make(chan struct{})
+	}
+
+	return true
+}
+
+// SendMessage enqueues the message of input to its queue, e.g. for
+// the error queue of the supervisor. DelaySeconds is honoured.
+func (f *FakeSQS) SendMessage(ctx context.Context, input *sqs.SendMessageInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, &aws.RequestCanceledError{Err: err}
+	}
+
+	msg := &types.Message{
+		Body:              input.MessageBody,
+		MessageAttributes: input.MessageAttributes,
+	}
+	if input.MessageGroupId != nil {
+		msg.Attributes = map[string]string{string(types.MessageSystemAttributeNameMessageGroupId): aws.ToString(input.MessageGroupId)}
+	}
+
+	var visibleAt time.Time
+	if delay := input.DelaySeconds; delay > 0 {
+		visibleAt = time.Now().Add(time.Duration(delay) * time.Second)
+	}
+
+	f.mu.Lock()
+	id := f.enqueue(aws.ToString(input.QueueUrl), msg, visibleAt)
+	f.mu.Unlock()
+
+	return &sqs.SendMessageOutput{MessageId: aws.String(id)}, nil
+}
+
+// copyMessage returns a copy of msg with its own system attributes, which
+// receives of msg update.
+func copyMessage(msg *types.Message) types.Message {
+	m := *msg
+	m.Attributes = make(map[string]string, len(msg.Attributes))
+	for name, value := range msg.Attributes {
+		m.Attributes[name] = value
+	}
+
+	return m
+}
+
+func invalidReceiptHandle(id *string) types.BatchResultErrorEntry {
+	return types.BatchResultErrorEntry{
+		Id:          id,
+		Code:        aws.String("ReceiptHandleIsInvalid"),
+		Message:     aws.String("the receipt handle is invalid"),
+		SenderFault: true,
+	}
+}
@@ -0,0 +1,150 @@
+package supervisortest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const testQueueURL = "https://sqs.us-east-1.amazonaws.com/123456789012/queue"
+
+func TestFakeSQSReceive(t *testing.T) {
+	fake := NewFakeSQS()
+	id := fake.Enqueue(testQueueURL, "message 1")
+	fake.Enqueue(testQueueURL, "message 2")
+
+	output, err := fake.ReceiveMessage(context.Background(), &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(testQueueURL),
+		MaxNumberOfMessages: 1,
+	})
+	assert.NoError(t, err)
+	if !assert.Len(t, output.Messages, 1) {
+		return
+	}
+
+	msg := output.Messages[0]
+	assert.Equal(t, id, aws.ToString(msg.MessageId))
+	assert.Equal(t, "message 1", aws.ToString(msg.Body))
+	assert.Equal(t, "1", msg.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+	assert.NotEmpty(t, aws.ToString(msg.MD5OfBody))
+	assert.Equal(t, 1, fake.Visible(testQueueURL))
+
+	// Received messages stay invisible until their visibility expires.
+	output, err = fake.ReceiveMessage(context.Background(), &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(testQueueURL),
+		MaxNumberOfMessages: 10,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, output.Messages, 1)
+	assert.Equal(t, 0, fake.Visible(testQueueURL))
+
+	fake.ExpireVisibility(testQueueURL)
+	output, err = fake.ReceiveMessage(context.Background(), &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(testQueueURL),
+		MaxNumberOfMessages: 10,
+	})
+	assert.NoError(t, err)
+	if !assert.Len(t, output.Messages, 2) {
+		return
+	}
+	assert.Equal(t, "2", output.Messages[0].Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+	assert.NotEqual(t, aws.ToString(msg.ReceiptHandle), aws.ToString(output.Messages[0].ReceiptHandle))
+	assert.Equal(t, 4, fake.Received())
+
+	// Only the latest receipt handle of a message deletes it.
+	deleted, err := fake.DeleteMessageBatch(context.Background(), &sqs.DeleteMessageBatchInput{
+		QueueUrl: aws.String(testQueueURL),
+		Entries: []types.DeleteMessageBatchRequestEntry{
+			{Id: aws.String("stale"), ReceiptHandle: msg.ReceiptHandle},
+			{Id: aws.String("latest"), ReceiptHandle: output.Messages[0].ReceiptHandle},
+		},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, deleted.Failed, 1) {
+		assert.Equal(t, "stale", aws.ToString(deleted.Failed[0].Id))
+	}
+	assert.Len(t, deleted.Successful, 1)
+	assert.Len(t, fake.Messages(testQueueURL), 1)
+	if assert.Len(t, fake.Deleted(testQueueURL), 1) {
+		assert.Equal(t, id, aws.ToString(fake.Deleted(testQueueURL)[0].MessageId))
+	}
+}
+
+func TestFakeSQSChangeMessageVisibility(t *testing.T) {
+	fake := NewFakeSQS()
+	id := fake.Enqueue(testQueueURL, "message")
+
+	output, _ := fake.ReceiveMessage(context.Background(), &sqs.ReceiveMessageInput{QueueUrl: aws.String(testQueueURL)})
+	if !assert.Len(t, output.Messages, 1) {
+		return
+	}
+
+	changed, err := fake.ChangeMessageVisibilityBatch(context.Background(), &sqs.ChangeMessageVisibilityBatchInput{
+		QueueUrl: aws.String(testQueueURL),
+		Entries: []types.ChangeMessageVisibilityBatchRequestEntry{{
+			Id:                aws.String("1"),
+			ReceiptHandle:     output.Messages[0].ReceiptHandle,
+			VisibilityTimeout: 0,
+		}},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, changed.Successful, 1)
+	assert.Equal(t, 1, fake.Visible(testQueueURL))
+	assert.Equal(t, []VisibilityChange{{QueueURL: testQueueURL, MessageID: id, Timeout: 0}}, fake.VisibilityChanges())
+
+	_, err = fake.ChangeMessageVisibility(context.Background(), &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(testQueueURL),
+		ReceiptHandle:     aws.String("unknown"),
+		VisibilityTimeout: 60,
+	})
+	assert.Error(t, err)
+}
+
+func TestFakeSQSLongPoll(t *testing.T) {
+	fake := NewFakeSQS()
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		fake.Enqueue(testQueueURL, "message")
+	}()
+
+	output, err := fake.ReceiveMessage(context.Background(), &sqs.ReceiveMessageInput{
+		QueueUrl:        aws.String(testQueueURL),
+		WaitTimeSeconds: 20,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, output.Messages, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err = fake.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:        aws.String(testQueueURL),
+		WaitTimeSeconds: 20,
+	})
+	assert.Error(t, err)
+}
+
+func TestFakeSQSSendMessage(t *testing.T) {
+	fake := NewFakeSQS()
+
+	_, err := fake.SendMessage(context.Background(), &sqs.SendMessageInput{
+		QueueUrl:     aws.String(testQueueURL),
+		MessageBody:  aws.String("delayed"),
+		DelaySeconds: 60,
+	})
+	assert.NoError(t, err)
+
+	if assert.Len(t, fake.Messages(testQueueURL), 1) {
+		assert.Equal(t, "delayed", aws.ToString(fake.Messages(testQueueURL)[0].Body))
+	}
+	assert.Equal(t, 0, fake.Visible(testQueueURL))
+}
@@ -0,0 +1,61 @@
+// Package supervisortest provides an in-memory SQS and a recording deliverer
+// to test programs embedding a supervisor without AWS.
+package supervisortest
+
+import (
+	"context"
+	"time"
+
+	"github.com/fterrag/simple-sqsd/supervisor"
+)
+
+// drainInterval is the wait between checks of whether a supervisor drained
+// its queues.
+const drainInterval = 10 * time.Millisecond
+
+// Drain starts s with workers, waits until every message it received from
+// fake was processed and none of its queues has a visible message left, then
+// shuts s down and waits for it to stop. Messages left invisible, e.g. after
+// a failed delivery, are not waited for: see FakeSQS.ExpireVisibility. It
+// returns the error of ctx when ctx is done first.
+func Drain(ctx context.Context, s *supervisor.Supervisor, fake *FakeSQS, workers int) error {
+	// fake may have been received from before, e.g. by another supervisor.
+	before := fake.Received()
+	s.StartContext(ctx, workers)
+
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+
+	for !drained(s, fake, before) {
+		select {
+		case <-ctx.Done():
+			s.Wait()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	s.Shutdown()
+	s.Wait()
+
+	return nil
+}
+
+// drained reports whether s processed every message received from fake after
+// the first before ones, and has no visible message left to receive. Receives made while it checks are caught by the number of
+// messages received from fake changing.
+func drained(s *supervisor.Supervisor, fake *FakeSQS, before int) bool {
+	received := fake.Received()
+	stats := s.Stats()
+	if int64(received-before) != stats.Received || stats.InFlight > 0 || stats.AwaitingAck > 0 {
+		return false
+	}
+
+	for queueURL := range stats.Queues {
+		if fake.Visible(queueURL) > 0 {
+			return false
+		}
+	}
+
+	return fake.Received() == received
+}
@@ -0,0 +1,89 @@
+package supervisortest
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fterrag/simple-sqsd/supervisor"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSupervisor(fake *FakeSQS, rec *Recorder) *supervisor.Supervisor {
+	log.SetOutput(ioutil.Discard)
+
+	return supervisor.New(fake, nil,
+		supervisor.WithLogger(log.WithFields(log.Fields{})),
+		supervisor.WithDeliverer(rec),
+		supervisor.WithWorkerConfig(supervisor.WorkerConfig{
+			QueueURL:         testQueueURL,
+			QueueMaxMessages: 10,
+			QueueWaitTime:    1,
+		}),
+	)
+}
+
+func TestDrain(t *testing.T) {
+	fake := NewFakeSQS()
+	for _, body := range []string{"message 1", "message 2", "message 3"} {
+		fake.Enqueue(testQueueURL, body)
+	}
+
+	rec := NewRecorder(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	assert.NoError(t, Drain(ctx, newTestSupervisor(fake, rec), fake, 1))
+	assert.ElementsMatch(t, []string{"message 1", "message 2", "message 3"}, rec.Bodies())
+	assert.Len(t, fake.Deleted(testQueueURL), 3)
+	assert.Empty(t, fake.Messages(testQueueURL))
+}
+
+func TestDrainRedelivery(t *testing.T) {
+	fake := NewFakeSQS()
+	id := fake.Enqueue(testQueueURL, "message")
+
+	var attempts atomic.Int32
+	rec := NewRecorder(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.Header().Set("X-Sqsd-Visibility-Timeout", "300")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	assert.NoError(t, Drain(ctx, newTestSupervisor(fake, rec), fake, 1))
+	assert.Empty(t, fake.Deleted(testQueueURL))
+	assert.Equal(t, []VisibilityChange{{QueueURL: testQueueURL, MessageID: id, Timeout: 300}}, fake.VisibilityChanges())
+
+	// A supervisor only runs once: the redelivery is drained by another one.
+	fake.ExpireVisibility(testQueueURL)
+	assert.NoError(t, Drain(ctx, newTestSupervisor(fake, rec), fake, 1))
+	if assert.Len(t, fake.Deleted(testQueueURL), 1) {
+		assert.Equal(t, "2", fake.Deleted(testQueueURL)[0].Attributes["ApproximateReceiveCount"])
+	}
+	assert.Len(t, rec.Deliveries(), 2)
+}
+
+func TestDrainContextDone(t *testing.T) {
+	fake := NewFakeSQS()
+	fake.Enqueue(testQueueURL, "message")
+
+	s := newTestSupervisor(fake, NewRecorder(nil))
+	s.Pause()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, Drain(ctx, s, fake, 1))
+	assert.Len(t, fake.Messages(testQueueURL), 1)
+}
@@ -0,0 +1,103 @@
+package supervisor
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// requestLimiter is a token bucket shared by all the workers of a supervisor.
+// It lets through perSecond requests per second on average, and bursts of up
+// to burst requests. With maxConcurrent, it also bounds how many requests are
+// in flight at the same time. A nil *requestLimiter never limits.
+type requestLimiter struct {
+	interval time.Duration
+	burst    int
+
+	mu sync.Mutex
+	// next is when the bucket would be empty again if no more requests were
+	// made. Requests wait while it is more than burst intervals away.
+	next time.Time
+
+	slots chan struct{}
+}
+
+// newRequestLimiter returns nil when neither perSecond nor maxConcurrent is
+// positive.
+func newRequestLimiter(perSecond float64, burst int, maxConcurrent int) *requestLimiter {
+	if perSecond <= 0 && maxConcurrent <= 0 {
+		return nil
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	l := &requestLimiter{burst: burst}
+	if perSecond > 0 {
+		l.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+	if maxConcurrent > 0 {
+		l.slots = make(chan struct{}, maxConcurrent)
+	}
+
+	return l
+}
+
+// acquire blocks until a request may be made, or ctx is done. Every
+// successful acquire must be followed by a release.
+func (l *requestLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	if l.interval > 0 {
+		if err := l.wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+func (l *requestLimiter) release() {
+	if l == nil || l.slots == nil {
+		return
+	}
+
+	<-l.slots
+}
+
+// wait takes a token from the bucket, waiting for one if it is empty.
+func (l *requestLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now) - time.Duration(l.burst-1)*l.interval
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
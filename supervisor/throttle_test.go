@@ -0,0 +1,117 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestLimiterRate(t *testing.T) {
+	limiter := newRequestLimiter(100, 3, 0)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, limiter.acquire(context.Background()))
+		limiter.release()
+	}
+	assert.True(t, time.Since(start) < 5*time.Millisecond, "the burst waited")
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, limiter.acquire(context.Background()))
+		limiter.release()
+	}
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 45*time.Millisecond, "5 requests past the burst took %s", elapsed)
+}
+
+func TestRequestLimiterCanceled(t *testing.T) {
+	limiter := newRequestLimiter(1, 1, 1)
+	assert.NoError(t, limiter.acquire(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, limiter.acquire(ctx))
+}
+
+func TestRequestLimiterDisabled(t *testing.T) {
+	assert.Nil(t, newRequestLimiter(0, 5, 0))
+
+	var limiter *requestLimiter
+	assert.NoError(t, limiter.acquire(context.Background()))
+	limiter.release()
+}
+
+func TestSupervisorMaxConcurrentRequests(t *testing.T) {
+	var (
+		mu          sync.Mutex
+		inFlight    int
+		maxInFlight int
+		requests    atomic.Int32
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		requests.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	mockSQS := &mockSQS{}
+	supervisor := NewSupervisor(log.WithFields(log.Fields{}), mockSQS, &http.Client{}, WorkerConfig{
+		HTTPURL:               ts.URL,
+		BatchConcurrency:      10,
+		MaxConcurrentRequests: 2,
+	})
+
+	var receiveCount atomic.Int32
+	mockSQS.receiveMessageFunc = func(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		receive := receiveCount.Add(1)
+		if receive > 2 {
+			supervisor.Shutdown()
+			return &sqs.ReceiveMessageOutput{}, nil
+		}
+
+		messages := make([]types.Message, 0, 5)
+		for i := 0; i < 5; i++ {
+			messages = append(messages, types.Message{
+				Body:          aws.String("message"),
+				MessageId:     aws.String(fmt.Sprintf("m%d-%d", receive, i)),
+				ReceiptHandle: aws.String("r"),
+			})
+		}
+		return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+	}
+	mockSQS.deleteMessageBatchFunc = func(*sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+		return &sqs.DeleteMessageBatchOutput{}, nil
+	}
+
+	supervisor.Start(3)
+	supervisor.Wait()
+
+	assert.Equal(t, int32(10), requests.Load())
+	assert.Equal(t, 2, maxInFlight)
+}
@@ -0,0 +1,110 @@
+package supervisor
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// maxBatchEntries is the most entries SQS accepts in a single batch call.
+	maxBatchEntries = 10
+
+	defaultVisibilityBatchConcurrency = 4
+)
+
+// changeVisibility changes the visibility of entries in batches of
+// maxBatchEntries, with at most VisibilityBatchConcurrency batches in flight.
+// With a concurrency of 1, batches are sent in order. Entries that fail within
+// a batch are retried one by one. The entries that still could not be changed
+// are returned.
+func (s *Supervisor) changeVisibility(q *queue, entries []types.ChangeMessageVisibilityBatchRequestEntry) []types.ChangeMessageVisibilityBatchRequestEntry {
+	concurrency := s.workerConfig.VisibilityBatchConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultVisibilityBatchConcurrency
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, concurrency)
+		failed []types.ChangeMessageVisibilityBatchRequestEntry
+	)
+
+	for start := 0; start < len(entries); start += maxBatchEntries {
+		end := start + maxBatchEntries
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(batch []types.ChangeMessageVisibilityBatchRequestEntry) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			batchFailed := s.changeVisibilityBatch(q, batch)
+
+			mu.Lock()
+			failed = append(failed, batchFailed...)
+			mu.Unlock()
+		}(entries[start:end])
+	}
+
+	wg.Wait()
+
+	if len(failed) > 0 {
+		ids := make([]string, 0, len(failed))
+		for _, entry := range failed {
+			ids = append(ids, *entry.Id)
+		}
+
+		s.logger.Errorf("Could not change visibility on messages from SQS: %s", strings.Join(ids, ", "))
+	}
+
+	return failed
+}
+
+func (s *Supervisor) changeVisibilityBatch(q *queue, batch []types.ChangeMessageVisibilityBatchRequestEntry) []types.ChangeMessageVisibilityBatchRequestEntry {
+	output, err := s.sqs.ChangeMessageVisibilityBatch(context.Background(), &sqs.ChangeMessageVisibilityBatchInput{
+		Entries:  batch,
+		QueueUrl: aws.String(q.url),
+	})
+	if err != nil {
+		s.logger.Errorf("Error while changing visibility on messages from SQS: %s", err)
+		return batch
+	}
+
+	if output == nil || len(output.Failed) == 0 {
+		return nil
+	}
+
+	retry := make(map[string]bool, len(output.Failed))
+	for _, f := range output.Failed {
+		retry[aws.ToString(f.Id)] = true
+	}
+
+	var failed []types.ChangeMessageVisibilityBatchRequestEntry
+	for _, entry := range batch {
+		if !retry[*entry.Id] {
+			continue
+		}
+
+		_, err := s.sqs.ChangeMessageVisibility(context.Background(), &sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          aws.String(q.url),
+			ReceiptHandle:     entry.ReceiptHandle,
+			VisibilityTimeout: entry.VisibilityTimeout,
+		})
+		if err != nil {
+			s.logger.Errorf("Error while changing visibility on message %s: %s", *entry.Id, err)
+			failed = append(failed, entry)
+		}
+	}
+
+	return failed
+}
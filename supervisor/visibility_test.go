@@ -0,0 +1,97 @@
+package supervisor
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorChangeVisibilityBatches(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		QueueURL:                   "https://sqs.us-east-1.amazonaws.com/123456789012/queue",
+		VisibilityBatchConcurrency: 2,
+	}
+
+	var (
+		mu          sync.Mutex
+		batchSizes  []int
+		inFlight    int
+		maxInFlight int
+		retried     []string
+	)
+	mockSQS.changeMessageVisibilityBatchFunc = func(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+		mu.Lock()
+		batchSizes = append(batchSizes, len(input.Entries))
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		output := &sqs.ChangeMessageVisibilityBatchOutput{}
+		for _, entry := range input.Entries {
+			if *entry.Id == "m7" || *entry.Id == "m13" {
+				output.Failed = append(output.Failed, types.BatchResultErrorEntry{Id: entry.Id})
+			}
+		}
+
+		return output, nil
+	}
+	mockSQS.changeMessageVisibilityFunc = func(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+		assert.Equal(t, int32(30), input.VisibilityTimeout)
+
+		mu.Lock()
+		retried = append(retried, *input.ReceiptHandle)
+		mu.Unlock()
+
+		if *input.ReceiptHandle == "r13" {
+			return nil, errors.New("receipt handle expired")
+		}
+
+		return nil, nil
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	entries := make([]types.ChangeMessageVisibilityBatchRequestEntry, 0, 25)
+	for i := 0; i < 25; i++ {
+		entries = append(entries, types.ChangeMessageVisibilityBatchRequestEntry{
+			Id:                aws.String(fmt.Sprintf("m%d", i)),
+			ReceiptHandle:     aws.String(fmt.Sprintf("r%d", i)),
+			VisibilityTimeout: 30,
+		})
+	}
+
+	failed := supervisor.changeVisibility(supervisor.queues[0], entries)
+
+	sort.Ints(batchSizes)
+	assert.Equal(t, []int{5, 10, 10}, batchSizes)
+	assert.Equal(t, 2, maxInFlight)
+
+	sort.Strings(retried)
+	assert.Equal(t, []string{"r13", "r7"}, retried)
+
+	if assert.Len(t, failed, 1) {
+		assert.Equal(t, "m13", *failed[0].Id)
+	}
+}
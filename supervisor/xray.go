@@ -0,0 +1,159 @@
+package supervisor
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	xrayTraceHeader           = "X-Amzn-Trace-Id"
+	defaultXRayDaemonAddress  = "127.0.0.1:2000"
+	xraySegmentName           = "simple-sqsd"
+	xrayDaemonHeader          = `{"format": "json", "version": 1}` + "\n"
+	xrayTraceHeaderAttribute  = "AWSTraceHeader"
+	xrayTraceHeaderRootPrefix = "Root="
+)
+
+// xraySegment is the subset of the X-Ray segment document emitted for each
+// delivery.
+type xraySegment struct {
+	Name      string       `json:"name"`
+	ID        string       `json:"id"`
+	TraceID   string       `json:"trace_id"`
+	ParentID  string       `json:"parent_id,omitempty"`
+	StartTime float64      `json:"start_time"`
+	EndTime   float64      `json:"end_time"`
+	Error     bool         `json:"error,omitempty"`
+	Fault     bool         `json:"fault,omitempty"`
+	HTTP      *xrayHTTP    `json:"http,omitempty"`
+	AWS       *xrayAWSMeta `json:"aws,omitempty"`
+}
+
+type xrayHTTP struct {
+	Request  xrayHTTPRequest   `json:"request"`
+	Response *xrayHTTPResponse `json:"response,omitempty"`
+}
+
+type xrayHTTPRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+type xrayHTTPResponse struct {
+	Status int `json:"status"`
+}
+
+type xrayAWSMeta struct {
+	Operation string `json:"operation"`
+	QueueURL  string `json:"queue_url"`
+	MessageID string `json:"message_id"`
+}
+
+// startXRaySegment starts a segment for msg, continuing the trace from the
+// message's AWSTraceHeader system attribute when the producer set one.
+func startXRaySegment(msg *types.Message, queueURL string) *xraySegment {
+	seg := &xraySegment{
+		Name:      xraySegmentName,
+		ID:        randomHex(8),
+		StartTime: unixSeconds(time.Now()),
+		AWS: &xrayAWSMeta{
+			Operation: "ReceiveMessage",
+			QueueURL:  queueURL,
+			MessageID: aws.ToString(msg.MessageId),
+		},
+	}
+
+	for _, part := range strings.Split(msg.Attributes[xrayTraceHeaderAttribute], ";") {
+		switch {
+		case strings.HasPrefix(part, xrayTraceHeaderRootPrefix):
+			seg.TraceID = strings.TrimPrefix(part, xrayTraceHeaderRootPrefix)
+		case strings.HasPrefix(part, "Parent="):
+			seg.ParentID = strings.TrimPrefix(part, "Parent=")
+		}
+	}
+
+	if len(seg.TraceID) == 0 {
+		seg.TraceID = newXRayTraceID(time.Now())
+	}
+
+	return seg
+}
+
+// header returns the X-Amzn-Trace-Id value that makes the worker's segment a
+// child of seg.
+func (seg *xraySegment) header() string {
+	return fmt.Sprintf("Root=%s;Parent=%s;Sampled=1", seg.TraceID, seg.ID)
+}
+
+func (seg *xraySegment) end(req *http.Request, res *http.Response, err error) {
+	seg.EndTime = unixSeconds(time.Now())
+	seg.HTTP = &xrayHTTP{
+		Request: xrayHTTPRequest{
+			Method: req.Method,
+			URL:    req.URL.String(),
+		},
+	}
+
+	if err != nil {
+		seg.Fault = true
+		return
+	}
+
+	seg.HTTP.Response = &xrayHTTPResponse{Status: res.StatusCode}
+	seg.Error = res.StatusCode >= 400 && res.StatusCode < 500
+	seg.Fault = res.StatusCode >= 500
+}
+
+// sendXRaySegment emits seg to the X-Ray daemon over UDP.
+func (s *Supervisor) sendXRaySegment(seg *xraySegment) {
+	addr := s.workerConfig.XRayDaemonAddress
+	if len(addr) == 0 {
+		addr = defaultXRayDaemonAddress
+	}
+
+	doc, err := json.Marshal(seg)
+	if err != nil {
+		s.logger.Errorf("Error while encoding X-Ray segment: %s", err)
+		return
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		s.logger.Errorf("Error while connecting to the X-Ray daemon: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(append([]byte(xrayDaemonHeader), doc...)); err != nil {
+		s.logger.Errorf("Error while sending X-Ray segment: %s", err)
+	}
+}
+
+// newXRayTraceID returns a trace ID in the 1-<epoch>-<random> X-Ray format.
+func newXRayTraceID(now time.Time) string {
+	return fmt.Sprintf("1-%08x-%s", now.Unix(), randomHex(12))
+}
+
+func randomHex(n int) string {
+	return hex.EncodeToString(randomBytes(n))
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+
+	return b
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
@@ -0,0 +1,98 @@
+package supervisor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSupervisorXRay(t *testing.T) {
+	daemon, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.Close()
+
+	var headers []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header.Get(xrayTraceHeader))
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	log.SetOutput(ioutil.Discard)
+	logger := log.WithFields(log.Fields{})
+	mockSQS := &mockSQS{}
+	config := WorkerConfig{
+		HTTPURL:           ts.URL,
+		XRayEnabled:       true,
+		XRayDaemonAddress: daemon.LocalAddr().String(),
+	}
+
+	supervisor := NewSupervisor(logger, mockSQS, &http.Client{}, config)
+
+	receiveCount := 0
+	mockSQS.receiveMessageFunc = func(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+		receiveCount++
+
+		assert.Contains(t, input.MessageSystemAttributeNames, types.MessageSystemAttributeNameAWSTraceHeader)
+
+		if receiveCount > 1 {
+			supervisor.Shutdown()
+			return &sqs.ReceiveMessageOutput{}, nil
+		}
+
+		return &sqs.ReceiveMessageOutput{
+			Messages: []types.Message{{
+				Body:          aws.String("message 1"),
+				MessageId:     aws.String("m1"),
+				ReceiptHandle: aws.String("r1"),
+			}, {
+				Body:          aws.String("message 2"),
+				MessageId:     aws.String("m2"),
+				ReceiptHandle: aws.String("r2"),
+				Attributes: map[string]string{
+					xrayTraceHeaderAttribute: "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1",
+				},
+			}},
+		}, nil
+	}
+
+	supervisor.Start(1)
+	supervisor.Wait()
+
+	if assert.Len(t, headers, 2) {
+		assert.Regexp(t, `^Root=1-[0-9a-f]{8}-[0-9a-f]{24};Parent=[0-9a-f]{16};Sampled=1$`, headers[0])
+		assert.Regexp(t, `^Root=1-5759e988-bd862e3fe1be46a994272793;Parent=[0-9a-f]{16};Sampled=1$`, headers[1])
+	}
+
+	daemon.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 64*1024)
+	for i := 0; i < 2; i++ {
+		n, _, err := daemon.ReadFrom(buf)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		parts := strings.SplitN(string(buf[:n]), "\n", 2)
+		assert.JSONEq(t, `{"format": "json", "version": 1}`, parts[0])
+
+		var seg xraySegment
+		assert.NoError(t, json.Unmarshal([]byte(parts[1]), &seg))
+		assert.Equal(t, xraySegmentName, seg.Name)
+		assert.True(t, seg.EndTime >= seg.StartTime)
+		assert.Equal(t, http.StatusOK, seg.HTTP.Response.Status)
+	}
+}